@@ -0,0 +1,74 @@
+// Command import loads chat history from a Slack workspace export or a
+// Telegram Desktop JSON export. It can be run again on the same export: the
+// messages imported before are skipped.
+package main
+
+import (
+	"chat/internal/config"
+	"chat/internal/service/cipher"
+	"chat/internal/service/importer"
+	"chat/internal/service/search"
+	"chat/internal/storage"
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+)
+
+func main() {
+	slackPath := flag.String("slack", "", "path to a Slack workspace export ZIP")
+	slackToken := flag.String("slack-token", os.Getenv("SLACK_TOKEN"), "Slack token to download the files of the export")
+	telegramPath := flag.String("telegram", "", "path to result.json of a Telegram Desktop export")
+	owner := flag.String("owner", "", "username owning the imported chats and posting for authors without an account")
+	usersPath := flag.String("users", "", "JSON file mapping source user ids or names to usernames")
+	flag.Parse()
+
+	if *owner == "" || (*slackPath == "") == (*telegramPath == "") {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	usernames := make(map[string]string)
+	if *usersPath != "" {
+		data, err := os.ReadFile(*usersPath)
+		if err != nil {
+			log.Fatalf("os.ReadFile: %v", err)
+		}
+		if err := json.Unmarshal(data, &usernames); err != nil {
+			log.Fatalf("json.Unmarshal: %s: %v", *usersPath, err)
+		}
+	}
+
+	cfg, err := config.NewConfig()
+	if err != nil {
+		log.Fatalf("config.NewConfig: %v", err)
+	}
+
+	storage, err := storage.NewStorage(cfg)
+	if err != nil {
+		log.Fatalf("storage.NewStorage: %v", err)
+	}
+	defer storage.Close()
+
+	im, err := importer.New(storage, cipher.NewService(cfg), search.NewService(cfg), *owner, usernames)
+	if err != nil {
+		log.Fatalf("importer.New: %v", err)
+	}
+
+	importChat := func(chat importer.Chat) error {
+		log.Printf("importing %s (%d messages)", chat.Name, len(chat.Messages))
+		return im.Import(chat)
+	}
+	if *slackPath != "" {
+		err = importer.ReadSlack(*slackPath, *slackToken, importChat)
+	} else {
+		err = importer.ReadTelegram(*telegramPath, importChat)
+	}
+
+	stats := im.Stats
+	log.Printf("chats: %d, messages: %d, already imported: %d, without account: %d, files: %d, missing files: %d",
+		stats.Chats, stats.Messages, stats.Duplicates, stats.Unmapped, stats.Files, stats.MissingFiles)
+	if err != nil {
+		log.Fatalf("import: %v", err)
+	}
+}
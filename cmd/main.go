@@ -4,8 +4,11 @@ import (
 	"chat/internal/app"
 	"chat/internal/config"
 	"chat/internal/service/cipher"
+	"chat/internal/service/email"
 	"chat/internal/service/memory"
+	"chat/internal/service/webhook"
 	"chat/internal/storage"
+	"context"
 	"log"
 )
 
@@ -24,11 +27,23 @@ func main() {
 	memory := memory.NewService(cfg)
 	cipher := cipher.NewService(cfg)
 
+	webhooks := webhook.NewService(cfg, storage, cipher)
+	go webhooks.Run(context.Background())
+
 	app, err := app.NewApp(cfg, storage, memory, cipher)
 	if err != nil {
 		log.Fatalf("app.NewApp: %v", err)
 	}
 
+	go app.RunScheduler(context.Background())
+	go app.RunReaper(context.Background())
+	go app.RunRetention(context.Background())
+
+	// Email digests are sent only if an SMTP server is configured
+	if emails := email.NewService(cfg, storage, memory); emails != nil {
+		go emails.Run(context.Background())
+	}
+
 	err = app.Run()
 	if err != nil {
 		log.Fatalf("app.Run: %v", err)
@@ -0,0 +1,18 @@
+// Command vapid generates a VAPID key pair for the web_push section of the
+// config.
+package main
+
+import (
+	"chat/internal/service/webpush"
+	"fmt"
+	"log"
+)
+
+func main() {
+	publicKey, privateKey, err := webpush.GenerateVAPIDKeys()
+	if err != nil {
+		log.Fatalf("webpush.GenerateVAPIDKeys: %v", err)
+	}
+
+	fmt.Printf("web_push:\n  vapid_public_key: %q\n  vapid_private_key: %q\n", publicKey, privateKey)
+}
@@ -79,9 +79,15 @@ const ChatList = () => {
                     <div>
                       <span>{chat.Name}</span>
                       <small className="text-muted ms-2">
-                        ({chat.IsPrivate ? 'Личный' : 'Групповой'})
+                        ({{ private: 'Личный', group: 'Групповой', channel: 'Канал' }[chat.Type]})
                       </small>
+                      {chat.Muted && (
+                        <small className="text-muted ms-2" title="Уведомления отключены">без звука</small>
+                      )}
                     </div>
+                    {chat.UnreadMentionCount > 0 && (
+                      <span className="badge bg-danger ms-auto me-1">@{chat.UnreadMentionCount}</span>
+                    )}
                     {chat.UnreadMessageCount > 0 && (
                       <span className="badge unread-badge">{chat.UnreadMessageCount}</span>
                     )}
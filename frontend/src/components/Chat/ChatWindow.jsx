@@ -15,6 +15,7 @@ const ChatWindow = () => {
   const [username, setUsername] = useState('');
   const messagesEndRef = useRef(null);
   const wsRef = useRef(null);
+  const mutedRef = useRef(false);
 
   // Scroll to bottom of messages
   const scrollToBottom = () => {
@@ -28,7 +29,7 @@ const ChatWindow = () => {
         const response = await get(`/chat/${chatId}`);
 
         if (response.success) {
-          const { chat, messages, members, user_id, username } = response.data;
+          const { chat, messages, members, muted, user_id, username } = response.data;
           
           // Format messages for display
           const formattedMessages = messages && messages.length > 0 
@@ -40,6 +41,8 @@ const ChatWindow = () => {
                   name: msg.File.Name,
                   url: `/api/files/${msg.ID}`
                 } : null,
+                deleted: Boolean(msg.DeletedAt),
+                deletedBy: msg.DeletedByUsername,
                 isCurrentUser: msg.UserID === user_id
               }))
             : [];
@@ -53,6 +56,7 @@ const ChatWindow = () => {
               }))
             : [];
           
+          mutedRef.current = Boolean(muted);
           setChat(chat);
           setMessages(formattedMessages);
           setParticipants(formattedParticipants);
@@ -93,12 +97,15 @@ const ChatWindow = () => {
         
         if (msg.action === 'delete') {
           console.log('Deleting message with ID:', msg.id);
-          // Remove deleted message
-          setMessages(prev => {
-            const filtered = prev.filter(m => m.id !== parseInt(msg.id));
-            console.log('Messages after deletion:', filtered);
-            return filtered;
-          });
+          if (msg.message) {
+            // Deleted message stays in history as a placeholder
+            setMessages(prev => prev.map(m =>
+              m.id === parseInt(msg.id) ? { ...m, content: '', file: null, deleted: true } : m
+            ));
+          } else {
+            // Expired messages are removed completely
+            setMessages(prev => prev.filter(m => m.id !== parseInt(msg.id)));
+          }
         } else if (msg.action === 'edit') {
           console.log('Editing message with ID:', msg.id, 'New content:', msg.content);
           // Update edited message
@@ -109,6 +116,17 @@ const ChatWindow = () => {
             console.log('Messages after edit:', updated);
             return updated;
           });
+        } else if (msg.action === 'mention') {
+          // Mentions are shown even for muted chats
+          if (Notification.permission === 'granted') {
+            new Notification(`${msg.username} упомянул вас`, {
+              body: msg.content,
+              icon: 'https://cdn4.iconfinder.com/data/icons/glyphs/24/icons_notifications-1024.png'
+            });
+          }
+        } else if (msg.action) {
+          // Other chat events are not rendered as messages
+          console.log('Chat event:', msg.action);
         } else {
           // Add new message
           const newMessage = {
@@ -125,8 +143,9 @@ const ChatWindow = () => {
           console.log('Adding new message:', newMessage);
           setMessages(prev => [...prev, newMessage]);
           
-          // Show browser notification if message is not from current user
-          if (msg.UserID !== currentUserId && Notification.permission === 'granted') {
+          // Show browser notification if message is not from current user,
+          // unless the chat is muted
+          if (msg.UserID !== currentUserId && !mutedRef.current && Notification.permission === 'granted') {
             new Notification(msg.Username, { 
               body: msg.Content,
               icon: 'https://cdn4.iconfinder.com/data/icons/glyphs/24/icons_notifications-1024.png'
@@ -208,8 +227,10 @@ const ChatWindow = () => {
       });
 
       if (response.success) {
-        // Remove message locally
-        setMessages(prev => prev.filter(msg => msg.id !== messageId));
+        // Replace message with a placeholder locally
+        setMessages(prev => prev.map(msg =>
+          msg.id === messageId ? { ...msg, content: '', file: null, deleted: true } : msg
+        ));
       } else {
         console.error('Failed to delete message:', response.message);
       }
@@ -248,7 +269,12 @@ const ChatWindow = () => {
                       className={`message ${message.isCurrentUser ? 'message-mine' : 'message-other'}`}
                       data-id={message.id}
                     >
-                      <strong>{message.username}:</strong> {message.content}
+                      <strong>{message.username}:</strong>{' '}
+                      {message.deleted ? (
+                        <em className="text-muted">
+                          Сообщение удалено{message.deletedBy ? ` (${message.deletedBy})` : ''}
+                        </em>
+                      ) : message.content}
                       
                       {message.file && (
                         <div className="file-attachment">
@@ -258,7 +284,7 @@ const ChatWindow = () => {
                         </div>
                       )}
                       
-                      {message.isCurrentUser && (
+                      {message.isCurrentUser && !message.deleted && (
                         <div className="message-actions">
                           <button
                             className="btn btn-sm btn-outline-secondary me-1"
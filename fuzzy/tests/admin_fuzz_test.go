@@ -0,0 +1,224 @@
+package tests
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"chat/internal/domain"
+)
+
+// SearchUsers finds users like the database does, except that query is
+// matched literally rather than as a pattern
+func (s *chatStorage) SearchUsers(query string, limit int, offset int) ([]domain.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	users := []domain.User{}
+	for id := 1; id <= len(s.users); id++ {
+		user := *s.users[id]
+		fullName := user.Surname + " " + user.Name + " " + user.Patronymic
+		if strings.Contains(strings.ToLower(user.Username), strings.ToLower(query)) ||
+			strings.Contains(strings.ToLower(fullName), strings.ToLower(query)) {
+			user.Password = ""
+			users = append(users, user)
+		}
+	}
+	return users, nil
+}
+
+func (s *chatStorage) SetUserAdmin(userID int, isAdmin bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, ok := s.users[userID]
+	if !ok || user.DeletedAt != nil {
+		return sql.ErrNoRows
+	}
+	user.IsAdmin = isAdmin
+	return nil
+}
+
+func (s *chatStorage) InsertPasswordResetToken(token domain.PasswordResetToken) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if user, ok := s.users[token.UserID]; !ok || user.DeletedAt != nil {
+		return 0, sql.ErrNoRows
+	}
+	s.resetTokens = append(s.resetTokens, token)
+	return len(s.resetTokens), nil
+}
+
+func (s *chatStorage) GetInstanceStats() (domain.InstanceStats, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stats := domain.InstanceStats{Users: len(s.users), Messages: len(s.messages), Chats: map[domain.ChatType]int{}}
+	for _, user := range s.users {
+		if user.IsAdmin {
+			stats.Admins++
+		}
+	}
+	for _, chat := range s.chats {
+		stats.Chats[chat.Type]++
+	}
+	return stats, nil
+}
+
+// passwordResetTokens returns the reset tokens in the order they were issued
+func (s *chatStorage) passwordResetTokens() []domain.PasswordResetToken {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]domain.PasswordResetToken(nil), s.resetTokens...)
+}
+
+// user returns a copy of the user as it is stored
+func (s *chatStorage) user(userID int) domain.User {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return *s.users[userID]
+}
+
+// auditEvents returns the audit events of the action in the order they were
+// recorded
+func (s *chatStorage) auditEvents(action string) []domain.AuditEvent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var events []domain.AuditEvent
+	for _, event := range s.audit {
+		if event.Action == action {
+			events = append(events, event)
+		}
+	}
+	return events
+}
+
+func FuzzAdminAPI(f *testing.F) {
+	// Add seed corpus
+	f.Add("")
+	f.Add("mem")
+	f.Add("Иванов")
+	f.Add("nobody")
+	f.Add("%")
+
+	f.Fuzz(func(t *testing.T, query string) {
+		// Initialize test dependencies
+		storage := newChatStorage()
+		admin := storage.addUser(t, domain.User{Username: "admin", IsAdmin: true})
+		member := storage.addUser(t, domain.User{Username: "member", Name: "Иван", Surname: "Иванов"})
+		group := storage.addChat(domain.Chat{Name: "group", Type: domain.ChatTypeGroup}, map[int]domain.ChatRole{
+			member.ID: domain.ChatRoleOwner,
+		})
+		messageID, _ := storage.InsertMessage(domain.Message{ChatID: group.ID, UserID: member.ID, Username: "member", Content: "spam"})
+		_, server := newChatServer(t, storage)
+		adminCookie, memberCookie := login(t, server, "admin"), login(t, server, "member")
+
+		// Test the admin API without being signed in and as a member
+		requests := []struct {
+			method string
+			path   string
+			body   interface{}
+		}{
+			{http.MethodGet, "/api/admin/users", nil},
+			{http.MethodGet, "/api/admin/stats", nil},
+			{http.MethodPost, fmt.Sprintf("/api/admin/users/%d/deactivate", admin.ID), nil},
+			{http.MethodPut, fmt.Sprintf("/api/admin/users/%d/admin", member.ID), map[string]bool{"is_admin": true}},
+			{http.MethodPost, fmt.Sprintf("/api/admin/users/%d/reset-password", admin.ID), nil},
+			{http.MethodDelete, fmt.Sprintf("/api/admin/messages/%d", messageID), nil},
+		}
+		for _, request := range requests {
+			if status, _, _ := apiRequest(t, server, nil, request.method, request.path, request.body); status != http.StatusUnauthorized {
+				t.Errorf("%s %s without a session got status %d", request.method, request.path, status)
+			}
+			if status, _, _ := apiRequest(t, server, memberCookie, request.method, request.path, request.body); status != http.StatusForbidden {
+				t.Errorf("%s %s as a member got status %d", request.method, request.path, status)
+			}
+		}
+		if storage.user(member.ID).IsAdmin || len(storage.passwordResetTokens()) != 0 || storage.chatMessages(group.ID)[0].DeletedAt != nil {
+			t.Fatalf("Admin API changed data for a non-administrator")
+		}
+
+		// Test searching users, which never shows password hashes
+		status, response, _ := apiRequest(t, server, adminCookie, http.MethodGet, "/api/admin/users?q="+url.QueryEscape(query), nil)
+		found, _ := storage.SearchUsers(query, 50, 0)
+		data, _ := response.Data.(map[string]interface{})
+		users, _ := data["users"].([]interface{})
+		if status != http.StatusOK || len(users) != len(found) {
+			t.Fatalf("Searching %q got status %d and %v, want %d users", query, status, data, len(found))
+		}
+		for _, item := range users {
+			if info, _ := item.(map[string]interface{}); info["password"] != nil || info["Password"] != nil {
+				t.Errorf("Password hash was listed: %v", info)
+			}
+		}
+
+		status, response, _ = apiRequest(t, server, adminCookie, http.MethodGet, "/api/admin/stats", nil)
+		data, _ = response.Data.(map[string]interface{})
+		stats, _ := data["stats"].(map[string]interface{})
+		if status != http.StatusOK || stats["Users"] != float64(2) || stats["Admins"] != float64(1) || stats["Messages"] != float64(1) {
+			t.Errorf("Unexpected stats with status %d: %v", status, data)
+		}
+
+		// Test granting the role, and administrators not revoking their own
+		adminPath := fmt.Sprintf("/api/admin/users/%d/admin", member.ID)
+		if status, response, _ := apiRequest(t, server, adminCookie, http.MethodPut, adminPath, map[string]bool{"is_admin": true}); status != http.StatusOK || !storage.user(member.ID).IsAdmin {
+			t.Errorf("Failed to grant the administrator role with status %d: %s", status, response.Message)
+		}
+		ownPath := fmt.Sprintf("/api/admin/users/%d/admin", admin.ID)
+		if status, _, _ := apiRequest(t, server, adminCookie, http.MethodPut, ownPath, map[string]bool{"is_admin": false}); status != http.StatusBadRequest || !storage.user(admin.ID).IsAdmin {
+			t.Errorf("Administrator revoked their own role with status %d", status)
+		}
+		if status, _, _ := apiRequest(t, server, adminCookie, http.MethodPut, "/api/admin/users/99/admin", map[string]bool{"is_admin": true}); status != http.StatusNotFound {
+			t.Errorf("Unknown user was promoted with status %d", status)
+		}
+
+		// Test resetting a password
+		resetPath := fmt.Sprintf("/api/admin/users/%d/reset-password", member.ID)
+		status, response, _ = apiRequest(t, server, adminCookie, http.MethodPost, resetPath, nil)
+		data, _ = response.Data.(map[string]interface{})
+		tokens := storage.passwordResetTokens()
+		if status != http.StatusOK || len(tokens) != 1 {
+			t.Fatalf("Failed to reset the password with status %d: %s", status, response.Message)
+		}
+		token := tokens[0]
+		if data["token"] != token.Token || token.Token == "" || token.UserID != member.ID || token.CreatedBy != admin.ID ||
+			!token.ExpiresAt.After(time.Now().Add(23*time.Hour)) {
+			t.Errorf("Unexpected reset token %+v returned as %v", token, data)
+		}
+		if status, _, _ := apiRequest(t, server, adminCookie, http.MethodPost, "/api/admin/users/99/reset-password", nil); status != http.StatusNotFound {
+			t.Errorf("Password of an unknown user was reset with status %d", status)
+		}
+
+		// Test deleting a message in a chat the administrator is not a member of
+		messagePath := fmt.Sprintf("/api/admin/messages/%d", messageID)
+		if status, response, _ := apiRequest(t, server, adminCookie, http.MethodDelete, messagePath, nil); status != http.StatusOK {
+			t.Fatalf("Failed to delete the message with status %d: %s", status, response.Message)
+		}
+		if message := storage.chatMessages(group.ID)[0]; message.DeletedAt == nil || message.DeletedBy != admin.ID {
+			t.Errorf("Unexpected tombstone %+v", message)
+		}
+		if events := storage.webhookEvents(domain.WebhookEventMessageDelete); len(events) != 1 || events[0].Payload["deleted_by"] != "admin" {
+			t.Errorf("Unexpected delete webhook events %+v", events)
+		}
+		if status, _, _ := apiRequest(t, server, adminCookie, http.MethodDelete, messagePath, nil); status != http.StatusNotFound {
+			t.Errorf("Tombstone was deleted again with status %d", status)
+		}
+
+		// Verify every successful action was audited with its target
+		for action, target := range map[string]string{
+			domain.AuditUserAdminChanged: "user:" + strconv.Itoa(member.ID),
+			domain.AuditPasswordReset:    "user:" + strconv.Itoa(member.ID),
+			domain.AuditMessageDeleted:   "message:" + strconv.Itoa(messageID),
+		} {
+			events := storage.auditEvents(action)
+			if len(events) != 1 || events[0].ActorID != admin.ID || events[0].Target != target {
+				t.Errorf("Unexpected %s audit events %+v", action, events)
+			}
+		}
+		if events := storage.auditEvents(domain.AuditMessageDeleted); len(events) == 1 && events[0].ChatID != group.ID {
+			t.Errorf("Deletion was audited for chat %d", events[0].ChatID)
+		}
+	})
+}
@@ -0,0 +1,62 @@
+package tests
+
+import (
+	"testing"
+	"time"
+
+	"chat/internal/domain"
+)
+
+func FuzzAuditChain(f *testing.F) {
+	// Add seed corpus
+	f.Add("admin", domain.AuditLoginSucceeded, `{"reason":"wrong password"}`, "127.0.0.1", 1, int64(1700000000000000))
+	f.Add("", "", "", "", 0, int64(0))
+	f.Add("пользователь", domain.AuditMessageDeleted, "\xff\xfe", "::1", 999, int64(-1))
+
+	f.Fuzz(func(t *testing.T, actor, action, details, ip string, chatID int, micros int64) {
+		// Build a chain of events the way storage appends them
+		createdAt := time.UnixMicro(micros).UTC()
+		var events []domain.AuditEvent
+		prevHash := ""
+		for i := 0; i < 3; i++ {
+			event := domain.AuditEvent{
+				ID:            int64(i + 1),
+				CreatedAt:     createdAt,
+				ActorID:       i,
+				ActorUsername: actor,
+				Action:        action,
+				ChatID:        chatID,
+				Details:       []byte(details),
+				IP:            ip,
+				PrevHash:      prevHash,
+			}
+			event.Hash = event.ComputeHash()
+			prevHash = event.Hash
+			events = append(events, event)
+		}
+
+		// Verify intact chain
+		if i, ok := domain.VerifyAuditChain("", events); !ok {
+			t.Fatalf("Intact chain reported broken at %d", i)
+		}
+
+		// Changing any event must be detected at that event
+		tampered := append([]domain.AuditEvent(nil), events...)
+		tampered[1].Details = append([]byte(details), 'x')
+		if i, ok := domain.VerifyAuditChain("", tampered); ok || i != 1 {
+			t.Errorf("Changed details not detected: ok=%v at %d", ok, i)
+		}
+
+		tampered = append([]domain.AuditEvent(nil), events...)
+		tampered[2].ActorUsername = actor + "x"
+		if i, ok := domain.VerifyAuditChain("", tampered); ok || i != 2 {
+			t.Errorf("Changed actor not detected: ok=%v at %d", ok, i)
+		}
+
+		// Removing an event must break the chain after it
+		removed := []domain.AuditEvent{events[0], events[2]}
+		if i, ok := domain.VerifyAuditChain("", removed); ok || i != 1 {
+			t.Errorf("Removed event not detected: ok=%v at %d", ok, i)
+		}
+	})
+}
@@ -0,0 +1,146 @@
+package tests
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"chat/internal/domain"
+)
+
+func (s *chatStorage) InsertScheduledMessage(message domain.ScheduledMessage) (int, error) {
+	return 1, nil
+}
+
+// SearchPublicChats lists public chats of the type, or of both types, like the
+// database does, except that query is matched literally rather than as a
+// pattern
+func (s *chatStorage) SearchPublicChats(userID int, chatType domain.ChatType, query string, limit int, offset int) ([]domain.DirectoryChat, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	chats := []domain.DirectoryChat{}
+	for id := 1; id <= len(s.chats); id++ {
+		chat := *s.chats[id]
+		if !chat.IsPublic || chat.Type == domain.ChatTypePrivate || (chatType != "" && chat.Type != chatType) ||
+			!strings.Contains(strings.ToLower(chat.Name+" "+chat.Topic+" "+chat.Description), strings.ToLower(query)) {
+			continue
+		}
+		_, isMember := s.members[id][userID]
+		chats = append(chats, domain.DirectoryChat{Chat: chat, MemberCount: len(s.members[id]), IsMember: isMember})
+	}
+	return chats, nil
+}
+
+func FuzzChannelPosting(f *testing.F) {
+	// Add seed corpus
+	f.Add("Release 2.0 is out")
+	f.Add("")
+	f.Add("//not a command")
+	f.Add("@all please read")
+	f.Add("привет всем")
+
+	f.Fuzz(func(t *testing.T, content string) {
+		// Socket messages are JSON, which carries UTF-8 text only, and
+		// commands have their own checks
+		if _, _, isCommand := expectedCommand(content); isCommand || !utf8.ValidString(content) {
+			t.Skip()
+		}
+
+		// Initialize test dependencies
+		storage := newChatStorage()
+		owner := storage.addUser(t, domain.User{Username: "owner"})
+		admin := storage.addUser(t, domain.User{Username: "admin"})
+		reader := storage.addUser(t, domain.User{Username: "reader"})
+		channel := storage.addChat(domain.Chat{Name: "announcements", Type: domain.ChatTypeChannel, IsPublic: true}, map[int]domain.ChatRole{
+			owner.ID: domain.ChatRoleOwner, admin.ID: domain.ChatRoleAdmin,
+		})
+		group := storage.addChat(domain.Chat{Name: "group", Type: domain.ChatTypeGroup, IsPublic: true}, map[int]domain.ChatRole{
+			owner.ID: domain.ChatRoleOwner, admin.ID: domain.ChatRoleMember, reader.ID: domain.ChatRoleMember,
+		})
+		hidden := storage.addChat(domain.Chat{Name: "hidden", Type: domain.ChatTypeChannel}, map[int]domain.ChatRole{
+			owner.ID: domain.ChatRoleOwner,
+		})
+		original, _ := storage.InsertMessage(domain.Message{ChatID: group.ID, UserID: owner.ID, Username: "owner", Content: "to forward"})
+		_, server := newChatServer(t, storage)
+		adminCookie, readerCookie := login(t, server, "admin"), login(t, server, "reader")
+
+		// Test finding channels in the directory
+		status, response, _ := apiRequest(t, server, readerCookie, http.MethodGet, "/api/directory?type=channel", nil)
+		data, _ := response.Data.(map[string]interface{})
+		listed, _ := data["chats"].([]interface{})
+		if status != http.StatusOK || len(listed) != 1 {
+			t.Fatalf("Listing channels got status %d and %v", status, data)
+		}
+		if info, _ := listed[0].(map[string]interface{}); info["ID"] != float64(channel.ID) || info["MemberCount"] != float64(2) || info["IsMember"] != false {
+			t.Errorf("Unexpected channel in the directory: %v", info)
+		}
+		if status, _, _ := apiRequest(t, server, readerCookie, http.MethodGet, "/api/directory?type=private", nil); status != http.StatusBadRequest {
+			t.Errorf("Listing private chats got status %d", status)
+		}
+
+		// Test subscribing to the channel
+		for _, chatID := range []int{hidden.ID, 99} {
+			path := fmt.Sprintf("/api/chat/%d/join", chatID)
+			if status, _, _ := apiRequest(t, server, readerCookie, http.MethodPost, path, nil); status != http.StatusNotFound {
+				t.Errorf("Joining channel %d got status %d", chatID, status)
+			}
+		}
+		status, response, _ = apiRequest(t, server, readerCookie, http.MethodPost, fmt.Sprintf("/api/chat/%d/join", channel.ID), nil)
+		if status != http.StatusOK || storage.role(channel.ID, reader.ID) != domain.ChatRoleMember {
+			t.Fatalf("Failed to subscribe to the channel with status %d: %s", status, response.Message)
+		}
+		if len(storage.webhookEvents(domain.WebhookEventMemberJoined)) != 1 || len(storage.chatMessages(channel.ID)) != 0 {
+			t.Errorf("Subscription was announced with a message or not at all")
+		}
+
+		// Test posting as a reader
+		readerConn := dialChat(t, server, readerCookie, channel.ID)
+		if err := readerConn.WriteJSON(map[string]string{"Content": content}); err != nil {
+			t.Fatalf("Failed to send message: %v", err)
+		}
+		notification := readUntil(t, readerConn, func(n map[string]interface{}) bool { return n["action"] == "ephemeral" })
+		if notification["content"] != "Only channel admins can post here" {
+			t.Errorf("Reader posting got %v", notification)
+		}
+
+		schedulePath := fmt.Sprintf("/api/chat/%d/scheduled", channel.ID)
+		schedule := map[string]interface{}{"content": content + " later", "send_at": time.Now().Add(time.Hour)}
+		if status, _, _ := apiRequest(t, server, readerCookie, http.MethodPost, schedulePath, schedule); status != http.StatusForbidden {
+			t.Errorf("Reader scheduled a message with status %d", status)
+		}
+
+		forward := map[string]interface{}{"from_chat_id": group.ID, "to_chat_id": channel.ID, "message_ids": []int{original}}
+		if status, _, _ := apiRequest(t, server, readerCookie, http.MethodPost, "/api/forward", forward); status != http.StatusForbidden {
+			t.Errorf("Reader forwarded a message with status %d", status)
+		}
+		if len(storage.chatMessages(channel.ID)) != 0 {
+			t.Errorf("Reader posted in the channel")
+		}
+
+		// Test posting as an admin, seen by the reader
+		posted := content
+		if strings.HasPrefix(content, "//") {
+			posted = content[1:]
+		}
+		if reply := sendCommand(t, dialChat(t, server, adminCookie, channel.ID), content); reply["Content"] != posted {
+			t.Errorf("Admin failed to post: %v", reply)
+		}
+		notification = readUntil(t, readerConn, func(n map[string]interface{}) bool { return n["ID"] != nil })
+		if notification["Content"] != posted || notification["Username"] != "admin" {
+			t.Errorf("Reader got %v instead of the admin's message", notification)
+		}
+
+		if status, response, _ := apiRequest(t, server, adminCookie, http.MethodPost, schedulePath, schedule); status != http.StatusCreated {
+			t.Errorf("Admin failed to schedule a message with status %d: %s", status, response.Message)
+		}
+		if status, response, _ := apiRequest(t, server, adminCookie, http.MethodPost, "/api/forward", forward); status != http.StatusOK {
+			t.Errorf("Admin failed to forward a message with status %d: %s", status, response.Message)
+		}
+		if messages := storage.chatMessages(channel.ID); len(messages) != 2 || messages[1].ForwardedFrom == nil {
+			t.Errorf("Unexpected channel messages %+v", messages)
+		}
+	})
+}
@@ -0,0 +1,655 @@
+package tests
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"chat/internal/app"
+	"chat/internal/config"
+	"chat/internal/domain"
+	"chat/internal/service/memory"
+
+	"github.com/gorilla/websocket"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const testPassword = "correct horse battery staple"
+
+// chatStorage keeps users, chats and messages in memory for tests that drive
+// the app through its router. Methods no test needs are left to the embedded
+// interface and panic if called.
+type chatStorage struct {
+	app.Storage
+
+	mu       sync.Mutex
+	users    map[int]*domain.User
+	chats    map[int]*domain.Chat
+	members  map[int]map[int]domain.ChatRole
+	messages map[int]*domain.Message
+	invites  []*domain.ChatInvite
+	audit    []domain.AuditEvent
+	events   []webhookEvent // In the order they were emitted
+	lastID   int            // ID of the last inserted message
+
+	resetTokens []domain.PasswordResetToken
+	reserved    map[string]bool // Usernames of deleted users
+	webhooks    []domain.Webhook
+
+	attachments      map[int]*domain.Attachment
+	lastAttachmentID int
+}
+
+// webhookEvent is an event queued for the webhooks of a chat
+type webhookEvent struct {
+	ChatID  int
+	Event   string
+	Payload map[string]interface{}
+}
+
+func newChatStorage() *chatStorage {
+	return &chatStorage{
+		users:    make(map[int]*domain.User),
+		chats:    make(map[int]*domain.Chat),
+		members:  make(map[int]map[int]domain.ChatRole),
+		messages: make(map[int]*domain.Message),
+
+		attachments: make(map[int]*domain.Attachment),
+	}
+}
+
+// addUser creates a user who signs in with testPassword
+func (s *chatStorage) addUser(t *testing.T, user domain.User) domain.User {
+	hash, err := bcrypt.GenerateFromPassword([]byte(testPassword), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("Failed to hash password: %v", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user.ID = len(s.users) + 1
+	user.Password = string(hash)
+	s.users[user.ID] = &user
+	return user
+}
+
+// addChat creates a chat with the given members
+func (s *chatStorage) addChat(chat domain.Chat, members map[int]domain.ChatRole) domain.Chat {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	chat.ID = len(s.chats) + 1
+	s.chats[chat.ID] = &chat
+	s.members[chat.ID] = members
+	return chat
+}
+
+// chat returns a copy of the chat as it is stored
+func (s *chatStorage) chat(chatID int) domain.Chat {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return *s.chats[chatID]
+}
+
+// role returns the role of the user in the chat, empty if not a member
+func (s *chatStorage) role(chatID int, userID int) domain.ChatRole {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.members[chatID][userID]
+}
+
+// chatMessages returns copies of the messages stored in the chat
+func (s *chatStorage) chatMessages(chatID int) []domain.Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var messages []domain.Message
+	for id := 1; id <= s.lastID; id++ {
+		if message, ok := s.messages[id]; ok && message.ChatID == chatID {
+			messages = append(messages, *message)
+		}
+	}
+	return messages
+}
+
+func (s *chatStorage) findUser(username string) (*domain.User, error) {
+	for _, user := range s.users {
+		if user.Username == username {
+			return user, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (s *chatStorage) GetUserByUsername(username string) (domain.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, err := s.findUser(username)
+	if err != nil {
+		return domain.User{}, err
+	}
+	return *user, nil
+}
+
+func (s *chatStorage) GetUserIDByUsername(username string) (int, error) {
+	user, err := s.GetUserByUsername(username)
+	return user.ID, err
+}
+
+func (s *chatStorage) GetUserByID(id int) (domain.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, ok := s.users[id]
+	if !ok {
+		return domain.User{}, sql.ErrNoRows
+	}
+	return *user, nil
+}
+
+func (s *chatStorage) UpdateUserStatus(username string, status string) error {
+	return nil
+}
+
+func (s *chatStorage) InsertAuditEvent(event domain.AuditEvent) (domain.AuditEvent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	event.ID = int64(len(s.audit) + 1)
+	s.audit = append(s.audit, event)
+	return event, nil
+}
+
+func (s *chatStorage) GetChatByID(chatID int) (*domain.Chat, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	chat, ok := s.chats[chatID]
+	if !ok {
+		return nil, nil
+	}
+	copied := *chat
+	return &copied, nil
+}
+
+func (s *chatStorage) UpdateChat(chat domain.Chat) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.chats[chat.ID] = &chat
+	return nil
+}
+
+func (s *chatStorage) InsertAttachment(file domain.File, uploaderID int) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastAttachmentID++
+	s.attachments[s.lastAttachmentID] = &domain.Attachment{ID: s.lastAttachmentID, File: file, UploaderID: uploaderID, CreatedAt: time.Now()}
+	return s.lastAttachmentID, nil
+}
+
+func (s *chatStorage) GetAttachmentByID(attachmentID int) (domain.Attachment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	attachment, ok := s.attachments[attachmentID]
+	if !ok {
+		return domain.Attachment{}, sql.ErrNoRows
+	}
+	return *attachment, nil
+}
+
+func (s *chatStorage) DeleteAttachment(attachmentID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.attachments, attachmentID)
+	return nil
+}
+
+func (s *chatStorage) GetChatMemberRole(chatID int, userID int) (domain.ChatRole, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	role, ok := s.members[chatID][userID]
+	if !ok {
+		return "", sql.ErrNoRows
+	}
+	return role, nil
+}
+
+func (s *chatStorage) IsChatMember(chatID int, userID int) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.members[chatID][userID]
+	return ok, nil
+}
+
+func (s *chatStorage) GetChatMembersByChatID(chatID int) ([]domain.ChatMember, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var members []domain.ChatMember
+	for userID, role := range s.members[chatID] {
+		members = append(members, domain.ChatMember{User: *s.users[userID], Role: role})
+	}
+	return members, nil
+}
+
+func (s *chatStorage) AddUserToChat(chatID int, userID int, role domain.ChatRole) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.members[chatID][userID] = role
+	return nil
+}
+
+func (s *chatStorage) RemoveUserFromChat(chatID int, userID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.members[chatID], userID)
+	return nil
+}
+
+func (s *chatStorage) InsertMessage(message domain.Message) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastID++
+	message.ID = s.lastID
+	if message.CreatedAt.IsZero() {
+		message.CreatedAt = time.Now()
+	}
+	s.messages[message.ID] = &message
+	return message.ID, nil
+}
+
+// GetMessageByID hides expired messages like the database query does
+func (s *chatStorage) GetMessageByID(messageID string, message *domain.Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id, _ := strconv.Atoi(messageID)
+	stored, ok := s.messages[id]
+	if !ok || (stored.ExpiresAt != nil && !stored.ExpiresAt.After(time.Now())) {
+		return sql.ErrNoRows
+	}
+	*message = *stored
+	return nil
+}
+
+func (s *chatStorage) InsertMessageMentions(chatID int, messageID int, authorID int, usernames []string, all bool) ([]int, error) {
+	return nil, nil
+}
+
+func (s *chatStorage) IndexMessage(messageID int, tokens []string) error {
+	return nil
+}
+
+func (s *chatStorage) EnqueueWebhookEvent(chatID int, event string, payload string) error {
+	var decoded struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err := json.Unmarshal([]byte(payload), &decoded); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, webhookEvent{ChatID: chatID, Event: event, Payload: decoded.Data})
+	return nil
+}
+
+// webhookEvents returns the webhook events of the kind that were emitted
+func (s *chatStorage) webhookEvents(event string) []webhookEvent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var events []webhookEvent
+	for _, emitted := range s.events {
+		if emitted.Event == event {
+			events = append(events, emitted)
+		}
+	}
+	return events
+}
+
+// newChatServer starts the app on top of the storage with the test config,
+// changed by configure if given
+func newChatServer(t *testing.T, storage app.Storage, configure ...func(cfg *config.Config)) (*app.App, *httptest.Server) {
+	os.Setenv(config.ConfigPathEnvKey, "../../config.yaml")
+	cfg, err := config.NewConfig()
+	if err != nil {
+		t.Fatalf("Failed to create config: %v", err)
+	}
+	for _, change := range configure {
+		change(cfg)
+	}
+	application, err := app.NewApp(cfg, storage, memory.NewService(cfg), plainCipher{})
+	if err != nil {
+		t.Fatalf("Failed to create app: %v", err)
+	}
+	server := httptest.NewServer(application.GetRouter())
+	t.Cleanup(server.Close)
+	return application, server
+}
+
+// login signs the user in and returns the session cookie
+func login(t *testing.T, server *httptest.Server, username string) *http.Cookie {
+	status, _, cookies := apiRequest(t, server, nil, http.MethodPost, "/api/login", map[string]string{
+		"username": username,
+		"password": testPassword,
+	})
+	if status != http.StatusOK || len(cookies) == 0 {
+		t.Fatalf("Login of %s failed with status %d", username, status)
+	}
+	return cookies[0]
+}
+
+// apiRequest sends a JSON request to the API with the session cookie, if
+// any, and decodes the response
+func apiRequest(t *testing.T, server *httptest.Server, cookie *http.Cookie, method string, path string, body interface{}) (int, app.APIResponse, []*http.Cookie) {
+	var payload bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&payload).Encode(body); err != nil {
+			t.Fatalf("Failed to encode request: %v", err)
+		}
+	}
+	req, err := http.NewRequest(method, server.URL+path, &payload)
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s failed: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+
+	var response app.APIResponse
+	json.NewDecoder(resp.Body).Decode(&response)
+	return resp.StatusCode, response, resp.Cookies()
+}
+
+// fetch downloads the path with the session cookie and returns the body as is
+func fetch(t *testing.T, server *httptest.Server, cookie *http.Cookie, path string) (int, []byte) {
+	req, err := http.NewRequest(http.MethodGet, server.URL+path, nil)
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+	req.AddCookie(cookie)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET %s failed: %v", path, err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, data
+}
+
+// dialChat opens the chat socket with the session cookie
+func dialChat(t *testing.T, server *httptest.Server, cookie *http.Cookie, chatID int) *websocket.Conn {
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws/chat/" + strconv.Itoa(chatID)
+	conn, _, err := websocket.DefaultDialer.Dial(url, http.Header{"Cookie": {cookie.String()}})
+	if err != nil {
+		t.Fatalf("Failed to open chat %d: %v", chatID, err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// readUntil reads from the socket until a notification matches
+func readUntil(t *testing.T, conn *websocket.Conn, match func(map[string]interface{}) bool) map[string]interface{} {
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		var notification map[string]interface{}
+		if err := conn.ReadJSON(&notification); err != nil {
+			t.Fatalf("Failed to read notification: %v", err)
+		}
+		if match(notification) {
+			return notification
+		}
+	}
+}
+
+// readUntilMarker posts the marker to the chat and reads the socket until
+// the marker comes back, failing if a notification with the action arrives
+// before it. Notifications are delivered in order, so anything sent to the
+// chat earlier would have shown up by then.
+func readUntilMarker(t *testing.T, conn *websocket.Conn, action string, marker string) {
+	if err := conn.WriteJSON(map[string]string{"Content": marker}); err != nil {
+		t.Fatalf("Failed to send %q: %v", marker, err)
+	}
+	readUntil(t, conn, func(notification map[string]interface{}) bool {
+		if notification["action"] == action {
+			t.Errorf("Unexpected %s notification %v", action, notification)
+		}
+		return notification["Content"] == marker
+	})
+}
+
+// sendCommand sends the content to the chat and returns the reply shown to
+// the sender only, or the message posted to the chat
+func sendCommand(t *testing.T, conn *websocket.Conn, content string) map[string]interface{} {
+	if err := conn.WriteJSON(map[string]string{"Content": content}); err != nil {
+		t.Fatalf("Failed to send %q: %v", content, err)
+	}
+	return readUntil(t, conn, func(notification map[string]interface{}) bool {
+		return notification["action"] == "ephemeral" || notification["ID"] != nil
+	})
+}
+
+// expectedCommand tells how the chat treats the content: as a command with
+// its name and arguments, or as a message to post
+func expectedCommand(content string) (string, string, bool) {
+	if strings.HasPrefix(content, "//") || len(content) < 2 || content[0] != '/' {
+		return "", "", false
+	}
+	name, args, _ := strings.Cut(content[1:], " ")
+	valid := strings.IndexFunc(name, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_' && r != '-'
+	}) < 0
+	return strings.ToLower(name), strings.TrimSpace(args), valid && name != ""
+}
+
+func FuzzCommandParse(f *testing.F) {
+	// Add seed corpus
+	f.Add("/echo hello world")
+	f.Add("/ECHO   spaced out  ")
+	f.Add("/echo")
+	f.Add("//echo posted as is")
+	f.Add("/unknown-command_2 args")
+	f.Add("/echo.txt is a file name")
+	f.Add("/ echo")
+	f.Add("/")
+	f.Add("plain message")
+	f.Add("/приветствие мир")
+
+	f.Fuzz(func(t *testing.T, content string) {
+		// Socket messages are JSON, which carries UTF-8 text only
+		if !utf8.ValidString(content) {
+			t.Skip()
+		}
+
+		// Initialize test dependencies
+		storage := newChatStorage()
+		alice := storage.addUser(t, domain.User{Username: "alice"})
+		chat := storage.addChat(domain.Chat{Name: "general", Type: domain.ChatTypeGroup}, map[int]domain.ChatRole{
+			alice.ID: domain.ChatRoleMember,
+		})
+		application, server := newChatServer(t, storage)
+
+		// Built-in commands are replaced so that none of them changes the chat
+		calls := make(chan app.CommandContext, 1)
+		for _, name := range []string{"echo", "help", "me", "topic", "invite", "leave"} {
+			application.RegisterCommand(app.Command{
+				Name: name,
+				Handler: func(ctx app.CommandContext) (app.CommandReply, error) {
+					calls <- ctx
+					return app.CommandReply{Content: fmt.Sprintf("ran /%s %s", ctx.Name, ctx.Args)}, nil
+				},
+			})
+		}
+		conn := dialChat(t, server, login(t, server, "alice"), chat.ID)
+
+		// Test sending the content
+		reply := sendCommand(t, conn, content)
+
+		// Verify the content ran a command or was posted
+		name, args, isCommand := expectedCommand(content)
+		switch {
+		case !isCommand:
+			want := strings.TrimPrefix(content, "/")
+			if !strings.HasPrefix(content, "//") {
+				want = content
+			}
+			if reply["Content"] != want || len(storage.chatMessages(chat.ID)) != 1 {
+				t.Errorf("Content %q was not posted as %q: %v", content, want, reply)
+			}
+		case name == "echo" || name == "help" || name == "me" || name == "topic" || name == "invite" || name == "leave":
+			ctx := <-calls
+			if ctx.Name != name || ctx.Args != args || ctx.Username != "alice" || ctx.Role != domain.ChatRoleMember || ctx.Chat.ID != chat.ID {
+				t.Errorf("Command %q ran with %+v", content, ctx)
+			}
+			if reply["content"] != fmt.Sprintf("ran /%s %s", name, args) {
+				t.Errorf("Unexpected reply to %q: %v", content, reply)
+			}
+		default:
+			if reply["content"] != fmt.Sprintf("Unknown command /%s. Type /help for the list of commands", name) {
+				t.Errorf("Unexpected reply to unknown command %q: %v", content, reply)
+			}
+		}
+		if isCommand && len(storage.chatMessages(chat.ID)) != 0 {
+			t.Errorf("Command %q was stored as a message", content)
+		}
+	})
+}
+
+func FuzzCommandPermissions(f *testing.F) {
+	// Add seed corpus
+	f.Add("Release planning")
+	f.Add("")
+	f.Add("  padded topic  ")
+	f.Add(strings.Repeat("long topic ", 30))
+
+	f.Fuzz(func(t *testing.T, topic string) {
+		if !utf8.ValidString(topic) {
+			t.Skip()
+		}
+
+		// Initialize test dependencies
+		storage := newChatStorage()
+		owner := storage.addUser(t, domain.User{Username: "owner"})
+		member := storage.addUser(t, domain.User{Username: "member"})
+		carol := storage.addUser(t, domain.User{Username: "carol"})
+		roles := func() map[int]domain.ChatRole {
+			return map[int]domain.ChatRole{owner.ID: domain.ChatRoleOwner, member.ID: domain.ChatRoleMember}
+		}
+		group := storage.addChat(domain.Chat{Name: "group", Type: domain.ChatTypeGroup}, roles())
+		channel := storage.addChat(domain.Chat{Name: "channel", Type: domain.ChatTypeChannel}, roles())
+		private := storage.addChat(domain.Chat{Name: "private", Type: domain.ChatTypePrivate}, roles())
+		_, server := newChatServer(t, storage)
+		ownerCookie, memberCookie := login(t, server, "owner"), login(t, server, "member")
+		ownerGroup, memberGroup := dialChat(t, server, ownerCookie, group.ID), dialChat(t, server, memberCookie, group.ID)
+
+		// Other members' messages arrive in between, so only the reply counts
+		reply := func(conn *websocket.Conn, content string) interface{} {
+			if err := conn.WriteJSON(map[string]string{"Content": content}); err != nil {
+				t.Fatalf("Failed to send %q: %v", content, err)
+			}
+			return readUntil(t, conn, func(n map[string]interface{}) bool { return n["action"] == "ephemeral" })["content"]
+		}
+
+		// Test changing the topic
+		args := strings.TrimSpace(topic)
+		if args == "" {
+			if got := reply(memberGroup, "/topic "+topic); got != "No topic is set" {
+				t.Errorf("Unexpected reply to /topic without a topic: %v", got)
+			}
+		} else {
+			if got := reply(memberGroup, "/topic "+topic); got != "Only chat admins can change the topic" {
+				t.Errorf("Member changing the topic got %v", got)
+			}
+			if storage.chat(group.ID).Topic != "" {
+				t.Errorf("Member changed the topic")
+			}
+
+			if utf8.RuneCountInString(args) > 250 {
+				if got := reply(ownerGroup, "/topic "+topic); got != "Topic must be at most 250 characters long" || storage.chat(group.ID).Topic != "" {
+					t.Errorf("Too long topic was not refused: %v", got)
+				}
+			} else {
+				if err := ownerGroup.WriteJSON(map[string]string{"Content": "/topic " + topic}); err != nil {
+					t.Fatalf("Failed to send /topic: %v", err)
+				}
+				notification := readUntil(t, ownerGroup, func(n map[string]interface{}) bool { return n["action"] != nil })
+				if notification["action"] != "chat_updated" || storage.chat(group.ID).Topic != args {
+					t.Errorf("Owner failed to change the topic to %q: %v", args, notification)
+				}
+				messages := storage.chatMessages(group.ID)
+				if len(messages) != 1 || !messages[0].IsSystem || messages[0].Content != fmt.Sprintf("owner changed the topic to %q", args) {
+					t.Errorf("Topic change was not announced: %+v", messages)
+				}
+			}
+		}
+
+		// Test inviting users
+		if got := reply(memberGroup, "/invite @carol"); got != "Only chat admins can invite users" || storage.role(group.ID, carol.ID) != "" {
+			t.Errorf("Member inviting a user got %v", got)
+		}
+		if err := ownerGroup.WriteJSON(map[string]string{"Content": "/invite @carol"}); err != nil {
+			t.Fatalf("Failed to send /invite: %v", err)
+		}
+		readUntil(t, ownerGroup, func(n map[string]interface{}) bool { return n["action"] == "member_added" })
+		if storage.role(group.ID, carol.ID) != domain.ChatRoleMember {
+			t.Errorf("Owner failed to invite a user")
+		}
+		if got := reply(ownerGroup, "/invite @carol"); got != "@carol is already a member of this chat" {
+			t.Errorf("Inviting a member again got %v", got)
+		}
+
+		// Test posting in a channel
+		memberChannel := dialChat(t, server, memberCookie, channel.ID)
+		for _, content := range []string{"/me waves", "hello"} {
+			if got := reply(memberChannel, content); got != "Only channel admins can post here" {
+				t.Errorf("Member posting %q in a channel got %v", content, got)
+			}
+		}
+		if len(storage.chatMessages(channel.ID)) != 0 {
+			t.Errorf("Member posted in a channel")
+		}
+		if got := sendCommand(t, dialChat(t, server, ownerCookie, channel.ID), "/me waves"); got["Content"] != "* owner waves" {
+			t.Errorf("Owner failed to post an action in a channel: %v", got)
+		}
+
+		// Test private chats
+		ownerPrivate := dialChat(t, server, ownerCookie, private.ID)
+		if got := reply(ownerPrivate, "/topic x"); got != "Private chats have no topic" {
+			t.Errorf("Setting a topic of a private chat got %v", got)
+		}
+		if got := reply(ownerPrivate, "/invite @carol"); got != "Users can't be invited to private chats" {
+			t.Errorf("Inviting to a private chat got %v", got)
+		}
+		if got := reply(ownerPrivate, "/leave"); got != "You can't leave a private chat" {
+			t.Errorf("Leaving a private chat got %v", got)
+		}
+
+		// Test leaving the chat
+		if got := reply(ownerGroup, "/leave"); got != "Transfer ownership before leaving the chat" || storage.role(group.ID, owner.ID) != domain.ChatRoleOwner {
+			t.Errorf("Owner leaving a chat with members got %v", got)
+		}
+		if err := memberGroup.WriteJSON(map[string]string{"Content": "/leave"}); err != nil {
+			t.Fatalf("Failed to send /leave: %v", err)
+		}
+		memberGroup.SetReadDeadline(time.Now().Add(5 * time.Second))
+		for {
+			if _, _, err := memberGroup.ReadMessage(); err != nil {
+				break
+			}
+		}
+		if storage.role(group.ID, member.ID) != "" {
+			t.Errorf("Member failed to leave the chat")
+		}
+	})
+}
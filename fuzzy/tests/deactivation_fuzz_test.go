@@ -0,0 +1,264 @@
+package tests
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"chat/internal/config"
+	"chat/internal/domain"
+)
+
+func (s *chatStorage) InsertUser(user domain.User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user.ID = len(s.users) + 1
+	s.users[user.ID] = &user
+	return nil
+}
+
+func (s *chatStorage) GetAllOtherUsers(username string) ([]domain.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var users []domain.User
+	for id := 1; id <= len(s.users); id++ {
+		if user := s.users[id]; user.Username != username && user.DeactivatedAt == nil {
+			users = append(users, domain.User{ID: user.ID, Username: user.Username, Name: user.Name, Surname: user.Surname})
+		}
+	}
+	return users, nil
+}
+
+// SetUserDeactivated ends all sessions of the user on deactivation like the
+// database does
+func (s *chatStorage) SetUserDeactivated(userID int, deactivated bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, ok := s.users[userID]
+	if !ok || user.DeletedAt != nil {
+		return sql.ErrNoRows
+	}
+	if !deactivated {
+		user.DeactivatedAt = nil
+		return nil
+	}
+	if user.DeactivatedAt == nil {
+		now := time.Now()
+		user.DeactivatedAt = &now
+	}
+	user.SessionVersion++
+	user.Status = "offline"
+	return nil
+}
+
+func (s *chatStorage) PromoteAdmins(usernames []string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var promoted []string
+	for _, user := range s.users {
+		for _, username := range usernames {
+			if user.Username == username && user.DeletedAt == nil {
+				user.IsAdmin = true
+				promoted = append(promoted, username)
+			}
+		}
+	}
+	return promoted, nil
+}
+
+func (s *chatStorage) UsernameReserved(username string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.reserved[username], nil
+}
+
+// DeleteUser anonymizes the user and reserves the username. With purge the
+// messages of the user become tombstones deleted by deletedBy.
+func (s *chatStorage) DeleteUser(userID int, purge bool, deletedBy int) ([]domain.Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, ok := s.users[userID]
+	if !ok || user.DeletedAt != nil {
+		return nil, sql.ErrNoRows
+	}
+	if s.reserved == nil {
+		s.reserved = make(map[string]bool)
+	}
+	s.reserved[user.Username] = true
+
+	now := time.Now()
+	*user = domain.User{
+		ID:             user.ID,
+		Username:       fmt.Sprintf("deleted-%d", user.ID),
+		Name:           "Deleted user",
+		Status:         "offline",
+		DeactivatedAt:  &now,
+		DeletedAt:      &now,
+		SessionVersion: user.SessionVersion + 1,
+	}
+
+	var purged []domain.Message
+	for id := 1; purge && id <= s.lastID; id++ {
+		if message, ok := s.messages[id]; ok && message.UserID == userID && message.DeletedAt == nil {
+			message.Content = ""
+			message.DeletedAt = &now
+			message.DeletedBy = deletedBy
+			purged = append(purged, domain.Message{ID: message.ID, ChatID: message.ChatID})
+		}
+	}
+	return purged, nil
+}
+
+// otherUsers returns the usernames the user can start a chat with
+func otherUsers(t *testing.T, server *httptest.Server, cookie *http.Cookie) []string {
+	status, response, _ := apiRequest(t, server, cookie, http.MethodGet, "/api/create_private_chat", nil)
+	if status != http.StatusOK {
+		t.Fatalf("Failed to list users with status %d: %s", status, response.Message)
+	}
+	data, _ := response.Data.(map[string]interface{})
+	list, _ := data["users"].([]interface{})
+	var usernames []string
+	for _, item := range list {
+		user, _ := item.(map[string]interface{})
+		usernames = append(usernames, fmt.Sprint(user["Username"]))
+	}
+	return usernames
+}
+
+func FuzzUserDeactivation(f *testing.F) {
+	// Add seed corpus
+	f.Add("newcomer", false)
+	f.Add("leaver", true)
+	f.Add("deleted-7", false)
+	f.Add("ghost", true)
+	f.Add("member", false)
+	f.Add("", false)
+
+	f.Fuzz(func(t *testing.T, username string, purge bool) {
+		// Initialize test dependencies. Config admins are promoted once at
+		// startup, so a listed name without an account grants nothing later.
+		storage := newChatStorage()
+		admin := storage.addUser(t, domain.User{Username: "admin"})
+		member := storage.addUser(t, domain.User{Username: "member"})
+		leaver := storage.addUser(t, domain.User{Username: "leaver"})
+		group := storage.addChat(domain.Chat{Name: "group", Type: domain.ChatTypeGroup}, map[int]domain.ChatRole{
+			admin.ID: domain.ChatRoleOwner, member.ID: domain.ChatRoleMember, leaver.ID: domain.ChatRoleMember,
+		})
+		storage.InsertMessage(domain.Message{ChatID: group.ID, UserID: member.ID, Username: "member", Content: "hello"})
+		for i := 0; i < 2; i++ {
+			storage.InsertMessage(domain.Message{ChatID: group.ID, UserID: leaver.ID, Username: "leaver", Content: "bye"})
+		}
+		_, server := newChatServer(t, storage, func(cfg *config.Config) {
+			cfg.Admins = []string{"admin", "ghost"}
+		})
+		if !storage.user(admin.ID).IsAdmin || storage.user(member.ID).IsAdmin {
+			t.Fatalf("Config admins were not promoted at startup")
+		}
+		adminCookie, memberCookie := login(t, server, "admin"), login(t, server, "member")
+		memberConn := dialChat(t, server, memberCookie, group.ID)
+
+		// Test deactivating an account, which ends its sessions and connections
+		deactivatePath := fmt.Sprintf("/api/admin/users/%d/deactivate", member.ID)
+		if status, _, _ := apiRequest(t, server, adminCookie, http.MethodPost, fmt.Sprintf("/api/admin/users/%d/deactivate", admin.ID), nil); status != http.StatusBadRequest {
+			t.Errorf("Administrator deactivated themselves with status %d", status)
+		}
+		if status, response, _ := apiRequest(t, server, adminCookie, http.MethodPost, deactivatePath, nil); status != http.StatusOK {
+			t.Fatalf("Failed to deactivate the user with status %d: %s", status, response.Message)
+		}
+		memberConn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		for {
+			if _, _, err := memberConn.ReadMessage(); err != nil {
+				if strings.Contains(err.Error(), "timeout") {
+					t.Errorf("Connection of a deactivated user stayed open")
+				}
+				break
+			}
+		}
+		if status, _, _ := apiRequest(t, server, memberCookie, http.MethodGet, "/api/create_private_chat", nil); status != http.StatusUnauthorized {
+			t.Errorf("Session of a deactivated user got status %d", status)
+		}
+		status, response, _ := apiRequest(t, server, nil, http.MethodPost, "/api/login", map[string]string{"username": "member", "password": testPassword})
+		if status != http.StatusForbidden || response.Message != "Account is deactivated" {
+			t.Errorf("Deactivated user signed in with status %d: %s", status, response.Message)
+		}
+		for _, other := range otherUsers(t, server, adminCookie) {
+			if other == "member" {
+				t.Errorf("Deactivated user is offered for new chats")
+			}
+		}
+		if message := storage.chatMessages(group.ID)[0]; message.UserID != member.ID || message.Content != "hello" {
+			t.Errorf("Message of a deactivated user changed: %+v", message)
+		}
+
+		// Test reactivating the account, old sessions staying ended
+		if status, response, _ := apiRequest(t, server, adminCookie, http.MethodPost, fmt.Sprintf("/api/admin/users/%d/reactivate", member.ID), nil); status != http.StatusOK {
+			t.Fatalf("Failed to reactivate the user with status %d: %s", status, response.Message)
+		}
+		if status, _, _ := apiRequest(t, server, memberCookie, http.MethodGet, "/api/create_private_chat", nil); status != http.StatusUnauthorized {
+			t.Errorf("Session ended by deactivation got status %d", status)
+		}
+		login(t, server, "member")
+		if len(storage.auditEvents(domain.AuditUserDeactivated)) != 1 || len(storage.auditEvents(domain.AuditUserReactivated)) != 1 {
+			t.Errorf("Deactivation and reactivation were not audited")
+		}
+
+		// Test deleting an account, its messages purged if asked
+		leaverCookie := login(t, server, "leaver")
+		deletePath := fmt.Sprintf("/api/admin/users/%d?purge=%t", leaver.ID, purge)
+		if status, _, _ := apiRequest(t, server, adminCookie, http.MethodDelete, fmt.Sprintf("/api/admin/users/%d", admin.ID), nil); status != http.StatusBadRequest {
+			t.Errorf("Administrator deleted themselves with status %d", status)
+		}
+		status, response, _ = apiRequest(t, server, adminCookie, http.MethodDelete, deletePath, nil)
+		if status != http.StatusOK {
+			t.Fatalf("Failed to delete the user with status %d: %s", status, response.Message)
+		}
+		purged := 0
+		if purge {
+			purged = 2
+		}
+		data, _ := response.Data.(map[string]interface{})
+		if data["purged_messages"] != float64(purged) || len(storage.webhookEvents(domain.WebhookEventMessageDelete)) != purged {
+			t.Errorf("Deleting with purge %t got %v", purge, data)
+		}
+		for _, message := range storage.chatMessages(group.ID)[1:] {
+			if message.UserID != leaver.ID || (message.DeletedAt != nil) != purge {
+				t.Errorf("Unexpected message of a deleted user with purge %t: %+v", purge, message)
+			}
+		}
+		if user := storage.user(leaver.ID); user.Username != fmt.Sprintf("deleted-%d", leaver.ID) || user.DeletedAt == nil {
+			t.Errorf("Deleted user was not anonymized: %+v", user)
+		}
+		if status, _, _ := apiRequest(t, server, leaverCookie, http.MethodGet, "/api/create_private_chat", nil); status != http.StatusUnauthorized {
+			t.Errorf("Session of a deleted user got status %d", status)
+		}
+		if status, _, _ := apiRequest(t, server, adminCookie, http.MethodDelete, deletePath, nil); status != http.StatusNotFound {
+			t.Errorf("Deleted user was deleted again with status %d", status)
+		}
+
+		// Test registering, which refuses taken, reserved and deleted names
+		status, response, _ = apiRequest(t, server, nil, http.MethodPost, "/api/register", map[string]string{
+			"username": username, "password": testPassword, "name": "New", "surname": "User",
+		})
+		switch {
+		case username == "" || strings.HasPrefix(username, "deleted-"):
+			if status != http.StatusBadRequest {
+				t.Errorf("Username %q was registered with status %d", username, status)
+			}
+		case username == "admin" || username == "member" || username == "leaver":
+			if status != http.StatusConflict {
+				t.Errorf("Taken username %q was registered with status %d", username, status)
+			}
+		default:
+			if status != http.StatusCreated {
+				t.Fatalf("Failed to register %q with status %d: %s", username, status, response.Message)
+			}
+			if registered, _ := storage.GetUserByUsername(username); registered.IsAdmin {
+				t.Errorf("%q registered as an administrator", username)
+			}
+		}
+	})
+}
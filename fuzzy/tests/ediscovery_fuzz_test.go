@@ -0,0 +1,265 @@
+package tests
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"chat/internal/config"
+	"chat/internal/domain"
+)
+
+// ediscoveryStorage keeps eDiscovery exports and finds the messages in their
+// scope like the database does, leaving out the membership periods and the
+// time window
+type ediscoveryStorage struct {
+	*chatStorage
+
+	exports []domain.EDiscoveryExport
+}
+
+func (s *ediscoveryStorage) InsertEDiscoveryExport(export domain.EDiscoveryExport) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	export.ID = len(s.exports) + 1
+	s.exports = append(s.exports, export)
+	return export.ID, nil
+}
+
+func (s *ediscoveryStorage) FinishEDiscoveryExport(export domain.EDiscoveryExport) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	export.CompletedAt = &now
+	s.exports[export.ID-1] = export
+	return nil
+}
+
+func (s *ediscoveryStorage) GetEDiscoveryExports() ([]domain.EDiscoveryExport, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.exports), nil
+}
+
+func (s *ediscoveryStorage) GetEDiscoveryExport(exportID int) (domain.EDiscoveryExport, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if exportID < 1 || exportID > len(s.exports) {
+		return domain.EDiscoveryExport{}, sql.ErrNoRows
+	}
+	return s.exports[exportID-1], nil
+}
+
+func (s *ediscoveryStorage) ForEachEDiscoveryMessage(export domain.EDiscoveryExport, fn func(domain.Message, string) error) error {
+	s.mu.Lock()
+	var messages []domain.Message
+	var chatNames []string
+	for id := 1; id <= s.lastID; id++ {
+		message, ok := s.messages[id]
+		if ok && (slices.Contains(export.UserIDs, message.UserID) || slices.Contains(export.ChatIDs, message.ChatID)) {
+			messages = append(messages, *message)
+			chatNames = append(chatNames, s.chats[message.ChatID].Name)
+		}
+	}
+	s.mu.Unlock()
+
+	for i, message := range messages {
+		if err := fn(message, chatNames[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// waitForExport waits until the export is no longer running
+func (s *ediscoveryStorage) waitForExport(t *testing.T, exportID int) domain.EDiscoveryExport {
+	for i := 0; i < 100; i++ {
+		export, err := s.GetEDiscoveryExport(exportID)
+		if err == nil && export.Status != domain.EDiscoveryExportStatusRunning {
+			return export
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatalf("Export %d never finished", exportID)
+	return domain.EDiscoveryExport{}
+}
+
+// zipFiles returns the files of a ZIP archive by their paths, in archive order
+func zipFiles(t *testing.T, data []byte) ([]string, map[string][]byte) {
+	reader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("Failed to open archive: %v", err)
+	}
+	var paths []string
+	files := make(map[string][]byte)
+	for _, file := range reader.File {
+		r, err := file.Open()
+		if err != nil {
+			t.Fatalf("Failed to open %s: %v", file.Name, err)
+		}
+		files[file.Name], _ = io.ReadAll(r)
+		r.Close()
+		paths = append(paths, file.Name)
+	}
+	return paths, files
+}
+
+func FuzzEDiscoveryExport(f *testing.F) {
+	// Add seed corpus
+	f.Add("contract attached", "contract.pdf")
+	f.Add("", "../../etc/passwd")
+	f.Add("договор", "..")
+	f.Add("\xff", "C:\\Users\\report.txt")
+
+	f.Fuzz(func(t *testing.T, content string, fileName string) {
+		// Initialize test dependencies
+		storage := &ediscoveryStorage{chatStorage: newChatStorage()}
+		admin := storage.addUser(t, domain.User{Username: "admin", IsAdmin: true})
+		author := storage.addUser(t, domain.User{Username: "author"})
+		member := storage.addUser(t, domain.User{Username: "member"})
+		outsider := storage.addUser(t, domain.User{Username: "outsider"})
+		group := storage.addChat(domain.Chat{Name: "deal", Type: domain.ChatTypeGroup}, map[int]domain.ChatRole{
+			author.ID: domain.ChatRoleOwner, member.ID: domain.ChatRoleMember,
+		})
+		other := storage.addChat(domain.Chat{Name: "other", Type: domain.ChatTypeGroup}, map[int]domain.ChatRole{
+			outsider.ID: domain.ChatRoleOwner,
+		})
+		fileData := []byte("signed contract")
+		attachmentID, _ := storage.InsertAttachment(domain.File{
+			Name: fileName, Data: "data:application/octet-stream;base64," + base64.StdEncoding.EncodeToString(fileData),
+		}, author.ID)
+		deletedAt := time.Now()
+		ids := []int{}
+		for _, message := range []domain.Message{
+			{ChatID: group.ID, UserID: author.ID, Username: "author", Content: content, AttachmentID: attachmentID, File: domain.File{Name: fileName}},
+			{ChatID: group.ID, UserID: member.ID, Username: "member", Content: "agreed"},
+			{ChatID: other.ID, UserID: outsider.ID, Username: "outsider", Content: "unrelated"},
+			{ChatID: group.ID, UserID: member.ID, Username: "member", Content: "held", DeletedAt: &deletedAt, DeletedBy: member.ID, DeletedByUsername: "member"},
+		} {
+			id, _ := storage.InsertMessage(message)
+			ids = append(ids, id)
+		}
+		_, server := newChatServer(t, storage, func(cfg *config.Config) {
+			cfg.EDiscovery.ExportDir = t.TempDir()
+		})
+		adminCookie, memberCookie := login(t, server, "admin"), login(t, server, "member")
+		exportsPath := "/api/admin/ediscovery/exports"
+
+		// Test exporting without being an admin
+		if status, _, _ := apiRequest(t, server, memberCookie, http.MethodPost, exportsPath, map[string][]int{"chat_ids": {group.ID}}); status != http.StatusForbidden {
+			t.Errorf("Member started an export with status %d", status)
+		}
+
+		// Test exporting the chat
+		status, response, _ := apiRequest(t, server, adminCookie, http.MethodPost, exportsPath, map[string][]int{"chat_ids": {group.ID}})
+		data, _ := response.Data.(map[string]interface{})
+		exportID, _ := data["export_id"].(float64)
+		if status != http.StatusAccepted || exportID == 0 {
+			t.Fatalf("Failed to start the export with status %d: %s", status, response.Message)
+		}
+		export := storage.waitForExport(t, int(exportID))
+		if export.Status != domain.EDiscoveryExportStatusCompleted || export.MessageCount != 3 || export.RequestedBy != admin.ID {
+			t.Fatalf("Unexpected export %+v", export)
+		}
+		downloadPath := fmt.Sprintf("%s/%d/download", exportsPath, export.ID)
+		if status, _ := fetch(t, server, memberCookie, downloadPath); status != http.StatusForbidden {
+			t.Errorf("Member downloaded the export with status %d", status)
+		}
+		if status, _ := fetch(t, server, adminCookie, fmt.Sprintf("%s/%d/download", exportsPath, export.ID+1)); status != http.StatusNotFound {
+			t.Errorf("Missing export was downloaded with status %d", status)
+		}
+		status, archive := fetch(t, server, adminCookie, downloadPath)
+		if status != http.StatusOK {
+			t.Fatalf("Failed to download the export with status %d", status)
+		}
+		if sum := sha256.Sum256(archive); hex.EncodeToString(sum[:]) != export.SHA256 {
+			t.Errorf("Archive checksum %x doesn't match the recorded %s", sum, export.SHA256)
+		}
+
+		// Verify the messages of the chat are in the archive, deleted ones
+		// included, with the attachment stored inside the archive
+		paths, files := zipFiles(t, archive)
+		var exported []struct {
+			ID         int    `json:"id"`
+			ChatName   string `json:"chat_name"`
+			Username   string `json:"username"`
+			Content    string `json:"content"`
+			Attachment string `json:"attachment"`
+			DeletedBy  string `json:"deleted_by"`
+		}
+		if err := json.Unmarshal(files["messages.json"], &exported); err != nil || len(exported) != 3 {
+			t.Fatalf("Unexpected messages.json: %v\n%s", err, files["messages.json"])
+		}
+		var want string
+		encoded, _ := json.Marshal(content)
+		json.Unmarshal(encoded, &want)
+		if exported[0].ID != ids[0] || exported[0].Content != want || exported[0].ChatName != "deal" || exported[0].Username != "author" {
+			t.Errorf("Unexpected first message %+v", exported[0])
+		}
+		if exported[1].ID != ids[1] || exported[2].ID != ids[3] || exported[2].Content != "held" || exported[2].DeletedBy != "member" {
+			t.Errorf("Unexpected messages %+v", exported[1:])
+		}
+		attachment := exported[0].Attachment
+		if !strings.HasPrefix(attachment, fmt.Sprintf("attachments/%d/", attachmentID)) || strings.Count(attachment, "/") != 2 || strings.HasSuffix(attachment, "/..") {
+			t.Errorf("Attachment stored at %q", attachment)
+		}
+		if !bytes.Equal(files[attachment], fileData) {
+			t.Errorf("Unexpected attachment content %q", files[attachment])
+		}
+
+		// Verify the manifest lists every other file with its checksum and
+		// that its signature checks out with the published key
+		var manifest struct {
+			ExportID     int   `json:"export_id"`
+			ChatIDs      []int `json:"chat_ids"`
+			MessageCount int   `json:"message_count"`
+			Files        []struct {
+				Path   string `json:"path"`
+				Size   int64  `json:"size"`
+				SHA256 string `json:"sha256"`
+			} `json:"files"`
+			PublicKey string `json:"public_key"`
+		}
+		if err := json.Unmarshal(files["manifest.json"], &manifest); err != nil {
+			t.Fatalf("Failed to decode the manifest: %v", err)
+		}
+		if manifest.ExportID != export.ID || !slices.Equal(manifest.ChatIDs, []int{group.ID}) || manifest.MessageCount != 3 {
+			t.Errorf("Unexpected manifest %+v", manifest)
+		}
+		if want := []string{"messages.json", attachment, "manifest.json", "manifest.sig"}; !slices.Equal(paths, want) || len(manifest.Files) != 2 {
+			t.Errorf("Archive has files %v and the manifest lists %+v", paths, manifest.Files)
+		}
+		for _, file := range manifest.Files {
+			sum := sha256.Sum256(files[file.Path])
+			if file.Size != int64(len(files[file.Path])) || file.SHA256 != hex.EncodeToString(sum[:]) {
+				t.Errorf("Manifest entry %+v doesn't match the archive", file)
+			}
+		}
+		_, response, _ = apiRequest(t, server, adminCookie, http.MethodGet, exportsPath, nil)
+		data, _ = response.Data.(map[string]interface{})
+		if data["public_key"] != manifest.PublicKey {
+			t.Errorf("Manifest key %s isn't the published %v", manifest.PublicKey, data["public_key"])
+		}
+		publicKey, _ := base64.StdEncoding.DecodeString(manifest.PublicKey)
+		signature, _ := base64.StdEncoding.DecodeString(strings.TrimSpace(string(files["manifest.sig"])))
+		if len(publicKey) != ed25519.PublicKeySize || !ed25519.Verify(publicKey, files["manifest.json"], signature) {
+			t.Fatalf("Manifest signature doesn't verify")
+		}
+		tampered := bytes.Replace(files["manifest.json"], []byte(`"message_count": 3`), []byte(`"message_count": 2`), 1)
+		if ed25519.Verify(publicKey, tampered, signature) {
+			t.Errorf("Signature verifies an altered manifest")
+		}
+	})
+}
@@ -0,0 +1,65 @@
+package tests
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+	"unicode/utf8"
+
+	"chat/internal/domain"
+)
+
+func FuzzMessageEdit(f *testing.F) {
+	// Add seed corpus
+	f.Add("fixed a typo")
+	f.Add("")
+	f.Add("привет")
+
+	f.Fuzz(func(t *testing.T, content string) {
+		// JSON carries UTF-8 text only
+		if !utf8.ValidString(content) {
+			t.Skip()
+		}
+
+		// Initialize test dependencies
+		storage := newChatStorage()
+		author := storage.addUser(t, domain.User{Username: "author"})
+		member := storage.addUser(t, domain.User{Username: "member"})
+		roles := map[int]domain.ChatRole{author.ID: domain.ChatRoleOwner, member.ID: domain.ChatRoleMember}
+		group := storage.addChat(domain.Chat{Name: "group", Type: domain.ChatTypeGroup}, roles)
+		other := storage.addChat(domain.Chat{Name: "other", Type: domain.ChatTypeGroup}, roles)
+		messageID, _ := storage.InsertMessage(domain.Message{ChatID: group.ID, UserID: author.ID, Username: "author", Content: "original"})
+		_, server := newChatServer(t, storage)
+		authorCookie, memberCookie := login(t, server, "author"), login(t, server, "member")
+		groupConn, otherConn := dialChat(t, server, memberCookie, group.ID), dialChat(t, server, memberCookie, other.ID)
+		edit := func(cookie *http.Cookie, messageID int, chatID int) int {
+			status, _, _ := apiRequest(t, server, cookie, http.MethodPost, "/api/edit-message", map[string]string{
+				"message_id": strconv.Itoa(messageID), "chat_id": strconv.Itoa(chatID), "content": content,
+			})
+			return status
+		}
+
+		// Test editing a message of another member
+		if status := edit(memberCookie, messageID, group.ID); status != http.StatusForbidden {
+			t.Errorf("Member edited a message of another member with status %d", status)
+		}
+
+		// Test editing an own message while naming another chat, which must
+		// not reach that chat
+		if status := edit(authorCookie, messageID, other.ID); status != http.StatusOK {
+			t.Fatalf("Author failed to edit a message with status %d", status)
+		}
+		if message := storage.chatMessages(group.ID)[0]; message.Content != content {
+			t.Errorf("Message content is %q, want %q", message.Content, content)
+		}
+		notification := readUntil(t, groupConn, func(n map[string]interface{}) bool { return n["action"] == "edit" })
+		if notification["id"] != strconv.Itoa(messageID) || notification["content"] != content {
+			t.Errorf("Unexpected edit notification %v", notification)
+		}
+		readUntilMarker(t, otherConn, "edit", "marker")
+		events := storage.webhookEvents(domain.WebhookEventMessageEdit)
+		if len(events) != 1 || events[0].ChatID != group.ID || events[0].Payload["content"] != content {
+			t.Errorf("Unexpected edit webhook events %+v", events)
+		}
+	})
+}
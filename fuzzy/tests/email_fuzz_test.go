@@ -0,0 +1,142 @@
+package tests
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"os"
+	"strings"
+	"testing"
+
+	"chat/internal/config"
+	"chat/internal/domain"
+	"chat/internal/service/email"
+)
+
+// smtpStandIn accepts mail on a local port like MailHog and passes every
+// received message to the channel
+func smtpStandIn(t *testing.T) (net.Listener, <-chan []byte) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+
+	received := make(chan []byte, 1)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				text := textproto.NewConn(conn)
+				text.PrintfLine("220 localhost SMTP stand-in")
+				for {
+					line, err := text.ReadLine()
+					if err != nil {
+						return
+					}
+					switch command := strings.ToUpper(strings.Fields(line + " ")[0]); command {
+					case "EHLO", "HELO", "MAIL", "RCPT", "RSET", "NOOP":
+						text.PrintfLine("250 OK")
+					case "DATA":
+						text.PrintfLine("354 End data with <CR><LF>.<CR><LF>")
+						data, err := io.ReadAll(text.DotReader())
+						if err != nil {
+							return
+						}
+						received <- data
+						text.PrintfLine("250 OK")
+					case "QUIT":
+						text.PrintfLine("221 Bye")
+						return
+					default:
+						text.PrintfLine("502 Command not implemented")
+					}
+				}
+			}()
+		}
+	}()
+	return listener, received
+}
+
+func FuzzEmailDigest(f *testing.F) {
+	// Add seed corpus
+	f.Add("ivanov", "Общий чат", 3, 1)
+	f.Add("", "", 0, 0)
+	f.Add("user\r\nBcc: victim@example.com", "Chat\r\n\r\nInjected body", 1000000, -1)
+	f.Add("пользователь", "=?utf-8?q?=41?= =00 .\r\n.\r\n", 1, 0)
+
+	f.Fuzz(func(t *testing.T, username, chatName string, unread, mentions int) {
+		// Initialize test dependencies
+		os.Setenv(config.ConfigPathEnvKey, "../../config.yaml")
+		cfg, err := config.NewConfig()
+		if err != nil {
+			t.Fatalf("Failed to create config: %v", err)
+		}
+		listener, received := smtpStandIn(t)
+		defer listener.Close()
+
+		host, port, _ := net.SplitHostPort(listener.Addr().String())
+		cfg.Email.SMTPHost = host
+		cfg.Email.SMTPPort = port
+		cfg.Email.Username = ""
+		cfg.Email.BaseURL = "http://chat.test/"
+		emailService := email.NewService(cfg, nil, nil)
+
+		chat := domain.UserChat{UnreadMessageCount: unread, UnreadMentionCount: mentions}
+		chat.Name = chatName
+		digest := domain.EmailDigest{
+			Settings: domain.EmailSettings{
+				Username:         username,
+				Email:            "user@example.com",
+				UnsubscribeToken: "0123456789abcdef",
+			},
+			Chats: []domain.UserChat{chat},
+		}
+
+		// Test sending the digest
+		if err := emailService.Send(digest); err != nil {
+			t.Fatalf("Failed to send digest: %v", err)
+		}
+
+		// Verify the received message
+		message, err := mail.ReadMessage(bufio.NewReader(strings.NewReader(string(<-received))))
+		if err != nil {
+			t.Fatalf("Failed to parse message: %v", err)
+		}
+		if to := message.Header.Get("To"); to != "user@example.com" {
+			t.Errorf("Message sent to %q", to)
+		}
+		if bcc := message.Header.Get("Bcc"); bcc != "" {
+			t.Errorf("Header injected into message: Bcc %q", bcc)
+		}
+		unsubscribeURL := "http://chat.test" + email.UnsubscribePath + "?token=0123456789abcdef"
+		if header := message.Header.Get("List-Unsubscribe"); header != "<"+unsubscribeURL+">" {
+			t.Errorf("Unexpected List-Unsubscribe header %q", header)
+		}
+		subject, err := new(mime.WordDecoder).DecodeHeader(message.Header.Get("Subject"))
+		if err != nil || !strings.Contains(subject, fmt.Sprint(unread)) {
+			t.Errorf("Unexpected subject %q: %v", subject, err)
+		}
+
+		body, err := io.ReadAll(quotedprintable.NewReader(message.Body))
+		if err != nil {
+			t.Fatalf("Failed to decode body: %v", err)
+		}
+		name := strings.Join(strings.Fields(chatName), " ")
+		line := fmt.Sprintf("- %s: сообщений %d, упоминаний %d", name, unread, mentions)
+		if !strings.Contains(string(body), line) {
+			t.Errorf("Body doesn't list the chat as %q:\n%s", line, body)
+		}
+		if !strings.Contains(string(body), unsubscribeURL) {
+			t.Errorf("Body doesn't contain the unsubscribe link:\n%s", body)
+		}
+	})
+}
@@ -0,0 +1,174 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"chat/internal/domain"
+)
+
+// zeroUnit matches a unit of a formatted lifetime that has no value
+var zeroUnit = regexp.MustCompile(`(^|[a-z])0[hms]`)
+
+func (s *chatStorage) SetChatMessageTTL(chatID int, ttl int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.chats[chatID].MessageTTL = ttl
+	return nil
+}
+
+// reaperStorage deletes expired messages like the database does and counts
+// the batches the reaper asks for
+type reaperStorage struct {
+	*chatStorage
+
+	batchMu sync.Mutex
+	batches []int // Number of messages deleted by every call
+}
+
+func (s *reaperStorage) DeleteExpiredMessages(limit int) ([]domain.Message, error) {
+	s.mu.Lock()
+	var deleted []domain.Message
+	for id := 1; id <= s.lastID && len(deleted) < limit; id++ {
+		message, ok := s.messages[id]
+		if ok && message.ExpiresAt != nil && !message.ExpiresAt.After(time.Now()) {
+			deleted = append(deleted, domain.Message{ID: message.ID, ChatID: message.ChatID})
+			delete(s.messages, id)
+		}
+	}
+	s.mu.Unlock()
+
+	s.batchMu.Lock()
+	defer s.batchMu.Unlock()
+	s.batches = append(s.batches, len(deleted))
+	return deleted, nil
+}
+
+func (s *reaperStorage) batchCount() int {
+	s.batchMu.Lock()
+	defer s.batchMu.Unlock()
+	return len(s.batches)
+}
+
+func FuzzMessageTTL(f *testing.F) {
+	// Add seed corpus
+	f.Add(90, 3)
+	f.Add(3600, 0)
+	f.Add(5400, 100)
+	f.Add(3630, 32)
+	f.Add(30*24*60*60, 250)
+	f.Add(0, 1)
+	f.Add(-1, 0)
+	f.Add(30*24*60*60+1, 0)
+
+	f.Fuzz(func(t *testing.T, ttl int, expired int) {
+		// Initialize test dependencies
+		storage := &reaperStorage{chatStorage: newChatStorage()}
+		owner := storage.addUser(t, domain.User{Username: "owner"})
+		member := storage.addUser(t, domain.User{Username: "member"})
+		group := storage.addChat(domain.Chat{Name: "group", Type: domain.ChatTypeGroup}, map[int]domain.ChatRole{
+			owner.ID: domain.ChatRoleOwner, member.ID: domain.ChatRoleMember,
+		})
+		application, server := newChatServer(t, storage)
+		ownerCookie := login(t, server, "owner")
+		ttlPath := fmt.Sprintf("/api/chat/%d/ttl", group.ID)
+
+		// Test setting the lifetime without the permission
+		if status, _, _ := apiRequest(t, server, login(t, server, "member"), http.MethodPut, ttlPath, map[string]int{"message_ttl": ttl}); status != http.StatusForbidden {
+			t.Errorf("Member set the message lifetime with status %d", status)
+		}
+
+		// Test setting the lifetime
+		status, response, _ := apiRequest(t, server, ownerCookie, http.MethodPut, ttlPath, map[string]int{"message_ttl": ttl})
+		messages := storage.chatMessages(group.ID)
+		switch {
+		case ttl < 0 || ttl > 30*24*60*60:
+			if status != http.StatusBadRequest || response.Message != "Message lifetime must be between 1 second and 720h" {
+				t.Errorf("Invalid lifetime %d was set with status %d: %s", ttl, status, response.Message)
+			}
+		case ttl == 0:
+			if status != http.StatusOK || len(messages) != 0 {
+				t.Errorf("Keeping the lifetime off got status %d and %d messages", status, len(messages))
+			}
+		default:
+			if status != http.StatusOK || storage.chat(group.ID).MessageTTL != ttl || len(messages) != 1 {
+				t.Fatalf("Failed to set lifetime %d with status %d: %s", ttl, status, response.Message)
+			}
+
+			// Verify the announcement renders the lifetime without zero units
+			formatted, found := strings.CutPrefix(messages[0].Content, "owner set messages to disappear after ")
+			duration, err := time.ParseDuration(formatted)
+			if !found || err != nil || duration != time.Duration(ttl)*time.Second || zeroUnit.MatchString(formatted) {
+				t.Errorf("Lifetime %d was announced as %q", ttl, messages[0].Content)
+			}
+		}
+
+		// Test the lifetime of new messages: the shorter of the chat and
+		// message ones applies
+		conn := dialChat(t, server, ownerCookie, group.ID)
+		if err := conn.WriteJSON(map[string]interface{}{"Content": "secret", "TTL": 60}); err != nil {
+			t.Fatalf("Failed to send message: %v", err)
+		}
+		posted := readUntil(t, conn, func(n map[string]interface{}) bool { return n["Content"] == "secret" })
+		lifetime := 60
+		if chatTTL := storage.chat(group.ID).MessageTTL; chatTTL > 0 && chatTTL < lifetime {
+			lifetime = chatTTL
+		}
+		expiresAt, err := time.Parse(time.RFC3339Nano, fmt.Sprint(posted["ExpiresAt"]))
+		if err != nil || time.Until(expiresAt) > time.Duration(lifetime)*time.Second || time.Until(expiresAt) < time.Duration(lifetime)*time.Second-time.Minute {
+			t.Errorf("Message with lifetime %ds expires at %v", lifetime, posted["ExpiresAt"])
+		}
+
+		// Test the reaper: expired messages are deleted in batches and the
+		// deletion is announced, the rest is kept
+		if expired < 0 || expired > 300 {
+			return
+		}
+		kept := len(storage.chatMessages(group.ID))
+		past := time.Now().Add(-time.Second)
+		for i := 0; i < expired; i++ {
+			storage.InsertMessage(domain.Message{ChatID: group.ID, UserID: member.ID, Content: "gone", ExpiresAt: &past})
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			application.RunReaper(ctx)
+			close(done)
+		}()
+		// Clients that can't keep up with a burst are dropped, so the socket
+		// is only checked when the deletions fit in its buffer
+		if expired <= 32 {
+			for i := 0; i < expired; i++ {
+				readUntil(t, conn, func(n map[string]interface{}) bool { return n["action"] == "delete" })
+			}
+		}
+		for deadline := time.Now().Add(5 * time.Second); storage.batchCount() < expired/100+1 && time.Now().Before(deadline); {
+			time.Sleep(10 * time.Millisecond)
+		}
+		cancel()
+		<-done
+
+		if storage.batchCount() != expired/100+1 {
+			t.Errorf("Reaper deleted %d messages in batches %v", expired, storage.batches)
+		}
+		if remaining := len(storage.chatMessages(group.ID)); remaining != kept {
+			t.Errorf("Reaper left %d messages, want %d", remaining, kept)
+		}
+		events := storage.webhookEvents(domain.WebhookEventMessageDelete)
+		if len(events) != expired {
+			t.Errorf("Reaper announced %d of %d deletions to webhooks", len(events), expired)
+		}
+		for _, event := range events {
+			if event.Payload["expired"] != true {
+				t.Errorf("Deletion announced without the expired flag: %v", event.Payload)
+			}
+		}
+	})
+}
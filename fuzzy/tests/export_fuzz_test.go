@@ -0,0 +1,210 @@
+package tests
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"chat/internal/domain"
+)
+
+// exportStorage pages through chat history like the database does and
+// records the size of every page the export asked for
+type exportStorage struct {
+	*chatStorage
+
+	pages []int
+}
+
+func (s *exportStorage) GetChatMessagesAfter(chatID int, afterTime time.Time, afterID int, limit int) ([]domain.Message, error) {
+	var messages []domain.Message
+	for _, message := range s.chatMessages(chatID) {
+		if message.CreatedAt.After(afterTime) || (message.CreatedAt.Equal(afterTime) && message.ID > afterID) {
+			messages = append(messages, message)
+		}
+	}
+	slices.SortStableFunc(messages, func(a, b domain.Message) int {
+		if c := a.CreatedAt.Compare(b.CreatedAt); c != 0 {
+			return c
+		}
+		return a.ID - b.ID
+	})
+	if len(messages) > limit {
+		messages = messages[:limit]
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pages = append(s.pages, len(messages))
+	return messages, nil
+}
+
+func FuzzChatExport(f *testing.F) {
+	// Add seed corpus
+	f.Add("json", "see the plan", uint16(3))
+	f.Add("html", "<script>alert(1)</script> & \"quotes\"", uint16(500))
+	f.Add("txt", "многострочное\nсообщение", uint16(1001))
+	f.Add("", "default format", uint16(0))
+	f.Add("pdf", "unknown format", uint16(1))
+
+	f.Fuzz(func(t *testing.T, format string, content string, count uint16) {
+		if !utf8.ValidString(content) || strings.ContainsRune(content, 0) {
+			return
+		}
+		filler := int(count) % 1200
+
+		// Initialize test dependencies
+		storage := &exportStorage{chatStorage: newChatStorage()}
+		author := storage.addUser(t, domain.User{Username: "author"})
+		member := storage.addUser(t, domain.User{Username: "member"})
+		storage.addUser(t, domain.User{Username: "outsider"})
+		group := storage.addChat(domain.Chat{Name: "planning", Type: domain.ChatTypeGroup}, map[int]domain.ChatRole{
+			author.ID: domain.ChatRoleOwner, member.ID: domain.ChatRoleMember,
+		})
+		fileData := []byte("step 1, step 2")
+		shared, _ := storage.InsertAttachment(domain.File{Name: "plan.txt", Data: "data:text/plain;base64," + base64.StdEncoding.EncodeToString(fileData)}, author.ID)
+		removed, _ := storage.InsertAttachment(domain.File{Name: "secret.txt", Data: "data:text/plain;base64,c2VjcmV0"}, member.ID)
+		// Every two messages share a timestamp, so the ID orders them
+		start := time.Now().Add(-time.Hour).Truncate(time.Second)
+		deletedAt := time.Now()
+		var ids []int
+		for i, message := range append([]domain.Message{
+			{UserID: author.ID, Username: "author", Content: content, AttachmentID: shared, File: domain.File{Name: "plan.txt"}},
+			{UserID: member.ID, Username: "member", Content: content, AttachmentID: shared, File: domain.File{Name: "plan.txt"},
+				ForwardedFrom: &domain.MessageOrigin{ChatID: group.ID, UserID: author.ID, Username: "author"}},
+			{UserID: member.ID, Username: "member", Content: "kept under hold", AttachmentID: removed, File: domain.File{Name: "secret.txt"},
+				DeletedAt: &deletedAt, DeletedBy: member.ID},
+			{UserID: author.ID, Username: "author", Content: "author renamed the chat", IsSystem: true},
+		}, make([]domain.Message, filler)...) {
+			if i >= 4 {
+				message = domain.Message{UserID: member.ID, Username: "member", Content: fmt.Sprintf("message %d", i)}
+			}
+			message.ChatID = group.ID
+			message.CreatedAt = start.Add(time.Duration(i/2) * time.Second)
+			id, _ := storage.InsertMessage(message)
+			ids = append(ids, id)
+		}
+		_, server := newChatServer(t, storage)
+		memberCookie, outsiderCookie := login(t, server, "member"), login(t, server, "outsider")
+		exportPath := fmt.Sprintf("/api/chat/%d/export?format=%s", group.ID, url.QueryEscape(format))
+
+		// Test exporting a chat the user isn't in
+		if status, _ := fetch(t, server, outsiderCookie, exportPath); status == http.StatusOK {
+			t.Errorf("Non-member exported the chat")
+		}
+
+		// Test exporting in the requested format, json by default
+		transcriptNames := map[string]string{"json": "messages.json", "html": "transcript.html", "txt": "transcript.txt"}
+		status, archive := fetch(t, server, memberCookie, exportPath)
+		transcriptName, ok := transcriptNames[format]
+		if format == "" {
+			transcriptName, ok = "messages.json", true
+		}
+		if !ok {
+			if status != http.StatusBadRequest {
+				t.Errorf("Format %q was exported with status %d", format, status)
+			}
+			return
+		}
+		if status != http.StatusOK {
+			t.Fatalf("Failed to export as %q with status %d", format, status)
+		}
+		if storage.auditCount(domain.AuditChatExported) != 1 {
+			t.Errorf("Export was not audited")
+		}
+
+		// Verify the history was read page by page
+		total := len(ids)
+		if want := total/500 + 1; len(storage.pages) != want || slices.Max(storage.pages) > 500 {
+			t.Errorf("History of %d messages was read in pages %v", total, storage.pages)
+		}
+
+		// Verify the archive holds the transcript and the shared attachment
+		// once, but not the file of the deleted message
+		attachment := fmt.Sprintf("attachments/%d/plan.txt", shared)
+		paths, files := zipFiles(t, archive)
+		if !slices.Equal(paths, []string{transcriptName, attachment}) {
+			t.Fatalf("Archive has files %v", paths)
+		}
+		if string(files[attachment]) != string(fileData) {
+			t.Errorf("Unexpected attachment content %q", files[attachment])
+		}
+		transcript := string(files[transcriptName])
+
+		switch transcriptName {
+		case "messages.json":
+			var exported struct {
+				Chat     map[string]interface{} `json:"chat"`
+				Messages []struct {
+					ID            int                   `json:"id"`
+					Content       string                `json:"content"`
+					IsSystem      bool                  `json:"is_system"`
+					Attachment    string                `json:"attachment"`
+					ForwardedFrom *domain.MessageOrigin `json:"forwarded_from"`
+					Deleted       bool                  `json:"deleted"`
+				} `json:"messages"`
+			}
+			if err := json.Unmarshal(files[transcriptName], &exported); err != nil || len(exported.Messages) != total {
+				t.Fatalf("Unexpected messages.json with %d messages: %v", len(exported.Messages), err)
+			}
+			if exported.Chat["name"] != "planning" {
+				t.Errorf("Unexpected chat %v", exported.Chat)
+			}
+			for i, message := range exported.Messages {
+				if message.ID != ids[i] {
+					t.Fatalf("Message %d is %d, want %d", i, message.ID, ids[i])
+				}
+			}
+			first, forwarded, tombstone, system := exported.Messages[0], exported.Messages[1], exported.Messages[2], exported.Messages[3]
+			if first.Content != content || first.Attachment != attachment || forwarded.Attachment != attachment || forwarded.ForwardedFrom == nil {
+				t.Errorf("Unexpected messages %+v and %+v", first, forwarded)
+			}
+			if !tombstone.Deleted || tombstone.Content != "" || tombstone.Attachment != "" {
+				t.Errorf("Deleted message was exported as %+v", tombstone)
+			}
+			if !system.IsSystem {
+				t.Errorf("System message was exported as %+v", system)
+			}
+
+		case "transcript.html":
+			if got := strings.Count(transcript, `<div class="message`); got != total {
+				t.Errorf("Transcript has %d messages, want %d", got, total)
+			}
+			_, rest, _ := strings.Cut(transcript, `<div class="content">`)
+			rendered, _, _ := strings.Cut(rest, "</div>")
+			if html.UnescapeString(rendered) != content {
+				t.Errorf("Content %q was rendered as %q", content, rendered)
+			}
+			if !strings.Contains(transcript, `<div class="message deleted">`) || !strings.Contains(transcript, "<div>Message deleted</div>") {
+				t.Errorf("Deleted message is missing from the transcript")
+			}
+
+		case "transcript.txt":
+			if !strings.HasPrefix(transcript, "planning\n========\n\n") {
+				t.Errorf("Unexpected transcript header %q", transcript[:min(len(transcript), 30)])
+			}
+			stamp := start.Format(time.DateTime)
+			for _, line := range []string{
+				fmt.Sprintf("[%s] author: %s [%s]\n", stamp, content, attachment),
+				fmt.Sprintf("[%s] member: %s (forwarded from author) [%s]\n", stamp, content, attachment),
+				"member: (message deleted)\n",
+				"author: * author renamed the chat\n",
+			} {
+				if !strings.Contains(transcript, line) {
+					t.Errorf("Transcript lacks %q", line)
+				}
+			}
+			if filler > 0 && !strings.Contains(transcript, fmt.Sprintf("member: message %d\n", total-1)) {
+				t.Errorf("Transcript lacks the last message")
+			}
+		}
+	})
+}
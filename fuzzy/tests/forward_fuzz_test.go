@@ -0,0 +1,161 @@
+package tests
+
+import (
+	"encoding/base64"
+	"net/http"
+	"strconv"
+	"testing"
+
+	"chat/internal/domain"
+)
+
+// forwardStorage keeps files in attachments shared by messages like the
+// database does
+type forwardStorage struct {
+	*chatStorage
+}
+
+// InsertMessage stores a new file as an attachment and the message only
+// refers to it
+func (s *forwardStorage) InsertMessage(message domain.Message) (int, error) {
+	if message.AttachmentID == 0 && message.File.Data != "" {
+		attachmentID, err := s.InsertAttachment(message.File, message.UserID)
+		if err != nil {
+			return 0, err
+		}
+		message.AttachmentID = attachmentID
+	}
+	message.File = domain.File{}
+	return s.chatStorage.InsertMessage(message)
+}
+
+func (s *forwardStorage) GetMessageByID(messageID string, message *domain.Message) error {
+	if err := s.chatStorage.GetMessageByID(messageID, message); err != nil {
+		return err
+	}
+	if attachment, err := s.GetAttachmentByID(message.AttachmentID); err == nil {
+		message.File = attachment.File
+	}
+	return nil
+}
+
+// DeleteMessage deletes the attachment once no message refers to it
+func (s *forwardStorage) DeleteMessage(messageID string, deletedBy int) error {
+	var message domain.Message
+	if err := s.chatStorage.GetMessageByID(messageID, &message); err != nil {
+		return err
+	}
+	if err := s.chatStorage.DeleteMessage(messageID, deletedBy); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, other := range s.messages {
+		if other.AttachmentID == message.AttachmentID {
+			return nil
+		}
+	}
+	delete(s.attachments, message.AttachmentID)
+	return nil
+}
+
+func FuzzForwardAttachment(f *testing.F) {
+	// Add seed corpus
+	f.Add("report attached", []byte("quarterly numbers"))
+	f.Add("", []byte{0, 1, 2, 255})
+	f.Add("отчёт", []byte("x"))
+
+	f.Fuzz(func(t *testing.T, content string, data []byte) {
+		if len(data) == 0 {
+			return
+		}
+
+		// Initialize test dependencies
+		storage := &forwardStorage{chatStorage: newChatStorage()}
+		author := storage.addUser(t, domain.User{Username: "author"})
+		forwarder := storage.addUser(t, domain.User{Username: "forwarder"})
+		reader := storage.addUser(t, domain.User{Username: "reader"})
+		source := storage.addChat(domain.Chat{Name: "source", Type: domain.ChatTypeGroup}, map[int]domain.ChatRole{
+			author.ID: domain.ChatRoleOwner, forwarder.ID: domain.ChatRoleMember,
+		})
+		target := storage.addChat(domain.Chat{Name: "target", Type: domain.ChatTypeGroup}, map[int]domain.ChatRole{
+			forwarder.ID: domain.ChatRoleOwner, reader.ID: domain.ChatRoleMember,
+		})
+		file := domain.File{Name: "report.bin", Data: "data:application/octet-stream;base64," + base64.StdEncoding.EncodeToString(data)}
+		original, _ := storage.InsertMessage(domain.Message{ChatID: source.ID, UserID: author.ID, Username: "author", Content: content, File: file})
+		_, server := newChatServer(t, storage)
+		authorCookie, forwarderCookie, readerCookie := login(t, server, "author"), login(t, server, "forwarder"), login(t, server, "reader")
+		filePath := func(messageID int) string {
+			return "/api/files/" + strconv.Itoa(messageID)
+		}
+		forward := func(cookie *http.Cookie, messageID int) (int, int) {
+			status, response, _ := apiRequest(t, server, cookie, http.MethodPost, "/api/forward", map[string]interface{}{
+				"from_chat_id": source.ID, "to_chat_id": target.ID, "message_ids": []int{messageID},
+			})
+			data, _ := response.Data.(map[string]interface{})
+			ids, _ := data["message_ids"].([]interface{})
+			if len(ids) != 1 {
+				return status, 0
+			}
+			id, _ := ids[0].(float64)
+			return status, int(id)
+		}
+
+		// Test forwarding by a user who isn't in the target chat
+		if status, _ := forward(authorCookie, original); status != http.StatusForbidden {
+			t.Errorf("Message was forwarded to a foreign chat with status %d", status)
+		}
+
+		// Test forwarding, which shares the attachment instead of copying it
+		status, copied := forward(forwarderCookie, original)
+		if status != http.StatusOK || copied == 0 {
+			t.Fatalf("Failed to forward the message with status %d", status)
+		}
+		var stored, copy domain.Message
+		storage.GetMessageByID(strconv.Itoa(original), &stored)
+		storage.GetMessageByID(strconv.Itoa(copied), &copy)
+		if copy.AttachmentID != stored.AttachmentID || len(storage.attachments) != 1 {
+			t.Errorf("Forwarded file was copied: attachment %d of %d, %d attachments", copy.AttachmentID, stored.AttachmentID, len(storage.attachments))
+		}
+		if origin := copy.ForwardedFrom; origin == nil || origin.MessageID != original || origin.ChatID != source.ID || origin.Username != "author" {
+			t.Errorf("Unexpected origin %+v", copy.ForwardedFrom)
+		}
+		if status, _ := fetch(t, server, readerCookie, filePath(original)); status != http.StatusForbidden {
+			t.Errorf("Non-member downloaded the original file with status %d", status)
+		}
+		if status, got := fetch(t, server, readerCookie, filePath(copied)); status != http.StatusOK || string(got) != string(data) {
+			t.Errorf("Unexpected forwarded file with status %d", status)
+		}
+
+		// Test deleting the original, which keeps the file of the copy
+		deleteRequest := func(cookie *http.Cookie, messageID int, chatID int) int {
+			status, _, _ := apiRequest(t, server, cookie, http.MethodPost, "/api/delete-message", map[string]string{
+				"message_id": strconv.Itoa(messageID), "chat_id": strconv.Itoa(chatID),
+			})
+			return status
+		}
+		if status := deleteRequest(authorCookie, original, source.ID); status != http.StatusOK {
+			t.Fatalf("Failed to delete the original with status %d", status)
+		}
+		if status, _ := fetch(t, server, forwarderCookie, filePath(original)); status != http.StatusNotFound {
+			t.Errorf("File of the deleted original was served with status %d", status)
+		}
+		if status, got := fetch(t, server, readerCookie, filePath(copied)); status != http.StatusOK || string(got) != string(data) {
+			t.Errorf("Deleting the original took the file of the copy, status %d", status)
+		}
+		if status, _ := forward(forwarderCookie, original); status != http.StatusNotFound {
+			t.Errorf("Deleted message was forwarded with status %d", status)
+		}
+
+		// Test deleting the copy, after which nothing refers to the file
+		if status := deleteRequest(forwarderCookie, copied, target.ID); status != http.StatusOK {
+			t.Fatalf("Failed to delete the copy with status %d", status)
+		}
+		if _, err := storage.GetAttachmentByID(stored.AttachmentID); err == nil {
+			t.Errorf("Attachment %d outlived every message", stored.AttachmentID)
+		}
+		if status, _ := fetch(t, server, readerCookie, filePath(copied)); status != http.StatusNotFound {
+			t.Errorf("File of the deleted copy was served with status %d", status)
+		}
+	})
+}
@@ -0,0 +1,451 @@
+package tests
+
+import (
+	"archive/zip"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"chat/internal/domain"
+	"chat/internal/service/importer"
+)
+
+// importStorage keeps imported chats and messages in memory. ImportMessage
+// fails on call number failAt, like a database going away mid-import.
+type importStorage struct {
+	users    map[string]int
+	chats    map[string]int
+	messages map[string]domain.Message
+	calls    int
+	failAt   int
+}
+
+func newImportStorage(users map[string]int) *importStorage {
+	return &importStorage{
+		users:    users,
+		chats:    make(map[string]int),
+		messages: make(map[string]domain.Message),
+	}
+}
+
+func (s *importStorage) GetUserIDByUsername(username string) (int, error) {
+	if userID, ok := s.users[username]; ok {
+		return userID, nil
+	}
+	return 0, sql.ErrNoRows
+}
+
+func (s *importStorage) GetChatIDByUserIDs(firstID int, secondID int) (int, error) {
+	return 0, sql.ErrNoRows
+}
+
+func (s *importStorage) ImportChat(chat domain.Chat, importKey string, memberIDs []int) (int, error) {
+	if chatID, ok := s.chats[importKey]; ok {
+		return chatID, nil
+	}
+	s.chats[importKey] = len(s.chats) + 1
+	return s.chats[importKey], nil
+}
+
+func (s *importStorage) ImportMessage(message domain.Message, importKey string) (int, error) {
+	s.calls++
+	if s.calls == s.failAt {
+		return 0, errors.New("connection reset")
+	}
+	if _, ok := s.messages[importKey]; ok {
+		return 0, nil
+	}
+	message.ID = len(s.messages) + 1
+	s.messages[importKey] = message
+	return message.ID, nil
+}
+
+func (s *importStorage) MessageImported(importKey string) (bool, error) {
+	_, ok := s.messages[importKey]
+	return ok, nil
+}
+
+func (s *importStorage) IndexMessage(messageID int, tokens []string) error {
+	return nil
+}
+
+// writeZip writes the files to a ZIP archive in dir
+func writeZip(t *testing.T, dir string, files map[string]interface{}) string {
+	name := filepath.Join(dir, "export.zip")
+	out, err := os.Create(name)
+	if err != nil {
+		t.Fatalf("Failed to create archive: %v", err)
+	}
+	defer out.Close()
+
+	archive := zip.NewWriter(out)
+	for path, content := range files {
+		w, err := archive.Create(path)
+		if err != nil {
+			t.Fatalf("Failed to add %s: %v", path, err)
+		}
+		if err := json.NewEncoder(w).Encode(content); err != nil {
+			t.Fatalf("Failed to write %s: %v", path, err)
+		}
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatalf("Failed to close archive: %v", err)
+	}
+	return name
+}
+
+// slackTexts maps Slack markup in the seed corpus to the expected plain text
+var slackTexts = map[string]string{
+	"hello <@U1>":                         "hello @alice",
+	"<@U9> is not in the export":          "@U9 is not in the export",
+	"see <#C1|general> <!here>":           "see #general @all",
+	"<https://example.com|docs> &amp; co": "docs (https://example.com) & co",
+	"<https://example.com>":               "https://example.com",
+	"plain":                               "plain",
+}
+
+func FuzzSlackImport(f *testing.F) {
+	// Add seed corpus
+	for text := range slackTexts {
+		f.Add(text)
+	}
+	f.Add("")
+	f.Add("<<@U1|alice>> &lt;&#x41;")
+
+	f.Fuzz(func(t *testing.T, text string) {
+		// Exports are JSON, which carries UTF-8 text only
+		if !utf8.ValidString(text) {
+			t.Skip()
+		}
+
+		// Initialize test dependencies
+		downloads := make(chan string, 2)
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			downloads <- r.Header.Get("Authorization")
+			w.Write([]byte("file content"))
+		}))
+		defer server.Close()
+
+		users := []map[string]interface{}{
+			{"id": "U1", "name": "alice", "real_name": "Alice", "profile": map[string]string{"real_name": "Alice Smith"}},
+			{"id": "U2", "name": "bob", "profile": map[string]string{"display_name": "Bobby"}},
+		}
+		export := writeZip(t, t.TempDir(), map[string]interface{}{
+			"users.json": users,
+			"channels.json": []map[string]interface{}{
+				{"id": "C1", "name": "general", "members": []string{"U1", "U2"}, "purpose": map[string]string{"value": "Everything"}},
+			},
+			"dms.json": []map[string]interface{}{
+				{"id": "D1", "members": []string{"U1", "U2"}},
+			},
+			"general/2024-01-02.json": []map[string]interface{}{
+				{"type": "message", "user": "U2", "text": "second day", "ts": "1704153600.000000"},
+			},
+			"general/2024-01-01.json": []map[string]interface{}{
+				{"type": "message", "subtype": "channel_join", "user": "U2", "text": "<@U2> has joined", "ts": "1704067200.000000"},
+				{"type": "message", "user": "U1", "text": text, "ts": "1704067201.000250"},
+				{"type": "message", "subtype": "bot_message", "bot_id": "B1", "username": "deploybot", "text": "deployed", "ts": "1704067202.000000"},
+				{"type": "message", "subtype": "file_share", "user": "U2", "text": "", "ts": "1704067203.000000", "files": []map[string]string{
+					{"id": "F1", "name": "report.pdf", "url_private_download": server.URL + "/F1"},
+					{"id": "F2", "mode": "tombstone"},
+				}},
+			},
+			"D1/2024-01-01.json": []map[string]interface{}{
+				{"type": "message", "user": "U1", "text": "hi bob", "ts": "1704067300.000000"},
+			},
+		})
+
+		// Test reading the export
+		var chats []importer.Chat
+		err := importer.ReadSlack(export, "xoxb-token", func(chat importer.Chat) error {
+			chats = append(chats, chat)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Failed to read export: %v", err)
+		}
+
+		// Verify conversations
+		if len(chats) != 2 {
+			t.Fatalf("Read %d conversations, want 2", len(chats))
+		}
+		general, dm := chats[0], chats[1]
+		if general.Key != "slack:C1" || general.Name != "general" || general.Type != domain.ChatTypeGroup ||
+			!general.IsPublic || general.Description != "Everything" {
+			t.Errorf("Unexpected channel %+v", general)
+		}
+		if len(general.Members) != 2 || general.Members[0] != (importer.User{ID: "U1", Username: "alice", Name: "Alice Smith"}) ||
+			general.Members[1] != (importer.User{ID: "U2", Username: "bob", Name: "Bobby"}) {
+			t.Errorf("Unexpected channel members %+v", general.Members)
+		}
+		if dm.Key != "slack:D1" || dm.Type != domain.ChatTypePrivate || dm.IsPublic || len(dm.Messages) != 1 {
+			t.Errorf("Unexpected direct messages %+v", dm)
+		}
+
+		// Verify messages: joins are skipped, days are read in order
+		if len(general.Messages) != 4 {
+			t.Fatalf("Read %d channel messages, want 4", len(general.Messages))
+		}
+		message := general.Messages[0]
+		if message.Key != "slack:C1:1704067201.000250" || message.Author.Username != "alice" {
+			t.Errorf("Unexpected message %+v", message)
+		}
+		if want := time.Unix(1704067201, 250000).UTC(); !message.Time.Equal(want) {
+			t.Errorf("Message time %v, want %v", message.Time, want)
+		}
+		if want, ok := slackTexts[text]; ok && message.Text != want {
+			t.Errorf("Text %q read as %q, want %q", text, message.Text, want)
+		}
+		if !strings.ContainsAny(text, "<&") && message.Text != text {
+			t.Errorf("Plain text %q read as %q", text, message.Text)
+		}
+		if bot := general.Messages[1]; bot.Author.ID != "B1" || bot.Author.Name != "deploybot" {
+			t.Errorf("Unexpected bot author %+v", bot.Author)
+		}
+		if last := general.Messages[3]; last.Text != "second day" {
+			t.Errorf("Last message %q, want the one of the second day", last.Text)
+		}
+
+		// Verify files: deleted ones are skipped, the rest are downloaded
+		// with the token
+		attachments := general.Messages[2].Attachments
+		if len(attachments) != 1 || attachments[0].Name != "report.pdf" || attachments[0].Open == nil {
+			t.Fatalf("Unexpected attachments %+v", attachments)
+		}
+		data, err := attachments[0].Open()
+		if err != nil || string(data) != "file content" {
+			t.Errorf("Downloaded %q, %v", data, err)
+		}
+		if authorization := <-downloads; authorization != "Bearer xoxb-token" {
+			t.Errorf("Downloaded with Authorization %q", authorization)
+		}
+	})
+}
+
+func FuzzTelegramImport(f *testing.F) {
+	// Add seed corpus
+	f.Add("bold", int64(1704067200))
+	f.Add("", int64(0))
+	f.Add("\"quoted\" \\ and\nnew line", int64(-1))
+	f.Add("привет", int64(4102444800))
+
+	f.Fuzz(func(t *testing.T, text string, unixtime int64) {
+		// Exports are JSON, which carries UTF-8 text only
+		if !utf8.ValidString(text) {
+			t.Skip()
+		}
+
+		// Initialize test dependencies
+		dir := t.TempDir()
+		if err := os.MkdirAll(filepath.Join(dir, "photos"), 0o755); err != nil {
+			t.Fatalf("Failed to create photos: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "photos", "photo_1.jpg"), []byte("jpeg"), 0o644); err != nil {
+			t.Fatalf("Failed to write photo: %v", err)
+		}
+
+		chat := map[string]interface{}{
+			"id":   42,
+			"name": "Alice",
+			"type": "personal_chat",
+			"messages": []map[string]interface{}{
+				{"id": 1, "type": "service", "date": "2024-01-01T00:00:00", "action": "phone_call"},
+				{
+					"id": 2, "type": "message", "date": "2024-01-01T00:00:00",
+					"date_unixtime": fmt.Sprint(unixtime), "from": "Alice", "from_id": "user1",
+					"forwarded_from": "Bob",
+					"text":           []interface{}{"Hi ", map[string]string{"type": "bold", "text": text}},
+					"photo":          "photos/photo_1.jpg",
+					"file":           "(File not included. Change data exporting settings to download.)",
+					"file_name":      "doc.pdf",
+				},
+				{"id": 3, "type": "message", "date": "2024-01-02T10:00:00", "from": "Bob", "from_id": "user2", "text": text},
+			},
+		}
+		export := map[string]interface{}{
+			"chats": map[string]interface{}{
+				"list": []interface{}{
+					chat,
+					map[string]interface{}{"id": 7, "type": "saved_messages", "messages": []interface{}{}},
+				},
+			},
+		}
+		data, err := json.Marshal(export)
+		if err != nil {
+			t.Fatalf("Failed to encode export: %v", err)
+		}
+		resultPath := filepath.Join(dir, "result.json")
+		if err := os.WriteFile(resultPath, data, 0o644); err != nil {
+			t.Fatalf("Failed to write export: %v", err)
+		}
+
+		// Test reading the export
+		var chats []importer.Chat
+		err = importer.ReadTelegram(resultPath, func(chat importer.Chat) error {
+			chats = append(chats, chat)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Failed to read export: %v", err)
+		}
+
+		// Verify chats: saved messages are skipped
+		if len(chats) != 1 {
+			t.Fatalf("Read %d chats, want 1", len(chats))
+		}
+		imported := chats[0]
+		if imported.Key != "telegram:42" || imported.Type != domain.ChatTypePrivate || len(imported.Messages) != 2 {
+			t.Fatalf("Unexpected chat %+v", imported)
+		}
+
+		// Verify the message with formatting and files
+		message := imported.Messages[0]
+		if message.Key != "telegram:42:2" || message.Author != (importer.User{ID: "user1", Name: "Alice"}) {
+			t.Errorf("Unexpected message %+v", message)
+		}
+		if want := "Forwarded from Bob\nHi " + text; message.Text != want {
+			t.Errorf("Text %q, want %q", message.Text, want)
+		}
+		if want := time.Unix(unixtime, 0).UTC(); !message.Time.Equal(want) {
+			t.Errorf("Message time %v, want %v", message.Time, want)
+		}
+		if len(message.Attachments) != 2 {
+			t.Fatalf("Read %d attachments, want 2", len(message.Attachments))
+		}
+		photo, file := message.Attachments[0], message.Attachments[1]
+		if photo.Name != "photo_1.jpg" || photo.Open == nil {
+			t.Errorf("Unexpected photo %+v", photo)
+		} else if content, err := photo.Open(); err != nil || string(content) != "jpeg" {
+			t.Errorf("Read photo %q, %v", content, err)
+		}
+		if file.Name != "doc.pdf" || file.Open != nil {
+			t.Errorf("File not included in the export is readable: %+v", file)
+		}
+
+		// Verify the plain text message with a local date only
+		plain := imported.Messages[1]
+		if plain.Text != text || plain.Author.Name != "Bob" {
+			t.Errorf("Unexpected message %+v", plain)
+		}
+		if want := time.Date(2024, 1, 2, 10, 0, 0, 0, time.Local); !plain.Time.Equal(want) {
+			t.Errorf("Message time %v, want %v", plain.Time, want)
+		}
+	})
+}
+
+func FuzzImportResume(f *testing.F) {
+	// Add seed corpus: the failing ImportMessage call and the number of
+	// attachments of the message with files
+	f.Add(uint8(0), uint8(3))
+	f.Add(uint8(1), uint8(0))
+	f.Add(uint8(2), uint8(1))
+	f.Add(uint8(3), uint8(3))
+	f.Add(uint8(4), uint8(5))
+
+	f.Fuzz(func(t *testing.T, failAt uint8, attachments uint8) {
+		// Initialize test dependencies
+		attachments %= 6
+		opened := make(map[string]int)
+		message := importer.Message{
+			Key:    "test:1:2",
+			Author: importer.User{ID: "U2", Username: "bob"},
+			Text:   "files",
+			Time:   time.Date(2024, 1, 1, 10, 0, 1, 0, time.UTC),
+		}
+		for i := 0; i < int(attachments); i++ {
+			name := fmt.Sprintf("file%d.txt", i)
+			message.Attachments = append(message.Attachments, importer.Attachment{
+				Name: name,
+				Open: func() ([]byte, error) {
+					opened[name]++
+					return []byte(name), nil
+				},
+			})
+		}
+		chat := importer.Chat{
+			Key:  "test:1",
+			Name: "imported",
+			Type: domain.ChatTypeGroup,
+			Messages: []importer.Message{
+				message,
+				{Key: "test:1:1", Author: importer.User{Name: "Carol"}, Text: "hello", Time: message.Time.Add(-time.Second)},
+				{Key: "test:1:3", Author: importer.User{ID: "U1", Username: "alice"}, Text: "bye", Time: message.Time.Add(time.Second)},
+			},
+		}
+		parts := 2 + max(int(attachments), 1)
+
+		storage := newImportStorage(map[string]int{"admin": 1, "alice": 2, "bob": 3})
+		storage.failAt = int(failAt)
+		newImporter := func() *importer.Importer {
+			im, err := importer.New(storage, plainCipher{}, nil, "admin", nil)
+			if err != nil {
+				t.Fatalf("Failed to create importer: %v", err)
+			}
+			return im
+		}
+
+		// Test an import interrupted by a failure and a repeated one
+		err := newImporter().Import(chat)
+		if failAt > 0 && int(failAt) <= parts {
+			if err == nil {
+				t.Fatalf("Import succeeded despite failing call %d", failAt)
+			}
+		} else if err != nil {
+			t.Fatalf("Failed to import: %v", err)
+		}
+		firstRun := len(storage.messages)
+
+		resumed := newImporter()
+		if err := resumed.Import(chat); err != nil {
+			t.Fatalf("Failed to resume import: %v", err)
+		}
+
+		// Verify every part is imported once
+		if len(storage.messages) != parts {
+			t.Fatalf("Imported %d messages, want %d", len(storage.messages), parts)
+		}
+		if resumed.Stats.Messages != parts-firstRun {
+			t.Errorf("Resumed import added %d messages, want %d", resumed.Stats.Messages, parts-firstRun)
+		}
+		for i := 1; i < int(attachments); i++ {
+			key := fmt.Sprintf("%s:%d", message.Key, i)
+			part, ok := storage.messages[key]
+			name := fmt.Sprintf("file%d.txt", i)
+			if !ok || part.File.Name != name || part.UserID != 3 || !part.CreatedAt.Equal(message.Time) {
+				t.Errorf("Attachment %s imported as %+v", key, part)
+			}
+			if opened[name] > 2 {
+				t.Errorf("Attachment %s loaded %d times", name, opened[name])
+			}
+		}
+		if first := storage.messages[message.Key]; attachments > 0 && first.File.Name != "file0.txt" {
+			t.Errorf("Message imported with file %q, want file0.txt", first.File.Name)
+		}
+
+		// Verify authors without an account are posted by the owner
+		if unmapped := storage.messages["test:1:1"]; unmapped.UserID != 1 || unmapped.Content != "Carol: hello" {
+			t.Errorf("Unmapped message imported as %+v", unmapped)
+		}
+		if mapped := storage.messages["test:1:3"]; mapped.UserID != 2 || mapped.Content != "bye" {
+			t.Errorf("Mapped message imported as %+v", mapped)
+		}
+
+		// Test that a third import adds nothing
+		again := newImporter()
+		if err := again.Import(chat); err != nil {
+			t.Fatalf("Failed to repeat import: %v", err)
+		}
+		if again.Stats.Messages != 0 || again.Stats.Duplicates != 3 {
+			t.Errorf("Repeated import stats %+v", again.Stats)
+		}
+	})
+}
@@ -0,0 +1,191 @@
+package tests
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"chat/internal/domain"
+)
+
+func (s *chatStorage) InsertChatInvite(invite domain.ChatInvite) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	invite.ID = len(s.invites) + 1
+	s.invites = append(s.invites, &invite)
+	return invite.ID, nil
+}
+
+func (s *chatStorage) RevokeChatInvite(chatID int, inviteID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, invite := range s.invites {
+		if invite.ID == inviteID && invite.ChatID == chatID && invite.RevokedAt == nil {
+			now := time.Now()
+			invite.RevokedAt = &now
+			return nil
+		}
+	}
+	return sql.ErrNoRows
+}
+
+// RedeemChatInvite checks the invite the same way the database query does
+func (s *chatStorage) RedeemChatInvite(token string, userID int) (int, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, invite := range s.invites {
+		if invite.Token != token || invite.RevokedAt != nil ||
+			(invite.ExpiresAt != nil && !invite.ExpiresAt.After(time.Now())) ||
+			(invite.MaxUses != 0 && invite.Uses >= invite.MaxUses) {
+			continue
+		}
+		if _, ok := s.members[invite.ChatID][userID]; ok {
+			return invite.ChatID, false, nil
+		}
+		s.members[invite.ChatID][userID] = domain.ChatRoleMember
+		invite.Uses++
+		return invite.ChatID, true, nil
+	}
+	return 0, false, sql.ErrNoRows
+}
+
+// invite returns a copy of the invite with the given ID, which is its number
+// in the order of creation
+func (s *chatStorage) invite(inviteID int) (domain.ChatInvite, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if inviteID > len(s.invites) {
+		return domain.ChatInvite{}, false
+	}
+	return *s.invites[inviteID-1], true
+}
+
+// expireInvite makes the invite expire a second ago
+func (s *chatStorage) expireInvite(inviteID int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	past := time.Now().Add(-time.Second)
+	s.invites[inviteID-1].ExpiresAt = &past
+}
+
+func FuzzChatInvite(f *testing.F) {
+	// Add seed corpus
+	f.Add(0, 0)
+	f.Add(3600, 2)
+	f.Add(60, 5)
+	f.Add(-1, 1)
+	f.Add(1, -5)
+	f.Add(365*24*60*60+1, 0)
+	f.Add(1<<62, 1)
+
+	f.Fuzz(func(t *testing.T, expiresIn int, maxUses int) {
+		// Initialize test dependencies
+		storage := newChatStorage()
+		owner := storage.addUser(t, domain.User{Username: "owner"})
+		member := storage.addUser(t, domain.User{Username: "member"})
+		var outsiders []domain.User
+		for i := 1; i <= 3; i++ {
+			outsiders = append(outsiders, storage.addUser(t, domain.User{Username: fmt.Sprintf("outsider%d", i)}))
+		}
+		roles := map[int]domain.ChatRole{owner.ID: domain.ChatRoleOwner, member.ID: domain.ChatRoleMember}
+		group := storage.addChat(domain.Chat{Name: "group", Type: domain.ChatTypeGroup}, roles)
+		private := storage.addChat(domain.Chat{Name: "private", Type: domain.ChatTypePrivate}, map[int]domain.ChatRole{
+			owner.ID: domain.ChatRoleOwner, member.ID: domain.ChatRoleOwner,
+		})
+		_, server := newChatServer(t, storage)
+		ownerCookie, memberCookie := login(t, server, "owner"), login(t, server, "member")
+		invitesPath := fmt.Sprintf("/api/chat/%d/invites", group.ID)
+		request := map[string]int{"expires_in": expiresIn, "max_uses": maxUses}
+
+		// Test creating invites without the permission and for private chats
+		if status, _, _ := apiRequest(t, server, memberCookie, http.MethodPost, invitesPath, request); status != http.StatusForbidden {
+			t.Errorf("Member created an invite with status %d", status)
+		}
+		privatePath := fmt.Sprintf("/api/chat/%d/invites", private.ID)
+		if status, response, _ := apiRequest(t, server, ownerCookie, http.MethodPost, privatePath, request); status != http.StatusBadRequest {
+			t.Errorf("Invite to a private chat was created with status %d: %s", status, response.Message)
+		}
+
+		// Test creating the invite
+		status, response, _ := apiRequest(t, server, ownerCookie, http.MethodPost, invitesPath, request)
+		if expiresIn < 0 || maxUses < 0 || expiresIn > 365*24*60*60 {
+			if _, created := storage.invite(1); status != http.StatusBadRequest || created {
+				t.Errorf("Invalid invite was created with status %d: %s", status, response.Message)
+			}
+			return
+		}
+		if status != http.StatusCreated {
+			t.Fatalf("Failed to create invite with status %d: %s", status, response.Message)
+		}
+		invite, _ := storage.invite(1)
+		if invite.MaxUses != maxUses || (expiresIn == 0) != (invite.ExpiresAt == nil) ||
+			(invite.ExpiresAt != nil && !invite.ExpiresAt.After(time.Now())) {
+			t.Errorf("Unexpected invite %+v", invite)
+		}
+		joinPath := "/api/invites/" + invite.Token + "/join"
+
+		// Test redeeming an unknown token and an invite by a member
+		outsiderCookie := login(t, server, outsiders[0].Username)
+		if status, _, _ := apiRequest(t, server, outsiderCookie, http.MethodPost, "/api/invites/deadbeef/join", nil); status != http.StatusNotFound {
+			t.Errorf("Unknown invite was redeemed with status %d", status)
+		}
+		status, response, _ = apiRequest(t, server, memberCookie, http.MethodPost, joinPath, nil)
+		if invite, _ := storage.invite(1); status != http.StatusOK || response.Message != "You are already a member of this chat" || invite.Uses != 0 {
+			t.Errorf("Member redeeming an invite got status %d: %s", status, response.Message)
+		}
+
+		// Test joining up to the usage limit
+		joined := 0
+		for i, outsider := range outsiders {
+			status, response, _ := apiRequest(t, server, login(t, server, outsider.Username), http.MethodPost, joinPath, nil)
+			if maxUses == 0 || i < maxUses {
+				joined++
+				if status != http.StatusOK || response.Message != "Joined the chat" || storage.role(group.ID, outsider.ID) != domain.ChatRoleMember {
+					t.Errorf("%s failed to join with status %d: %s", outsider.Username, status, response.Message)
+				}
+			} else if status != http.StatusNotFound || storage.role(group.ID, outsider.ID) != "" {
+				t.Errorf("%s joined past the usage limit with status %d", outsider.Username, status)
+			}
+		}
+		if invite, _ := storage.invite(1); invite.Uses != joined {
+			t.Errorf("Invite was used %d times, want %d", invite.Uses, joined)
+		}
+
+		// Verify every join was announced
+		messages := storage.chatMessages(group.ID)
+		if len(messages) != joined || len(storage.webhookEvents(domain.WebhookEventMemberJoined)) != joined {
+			t.Errorf("%d joins were announced with %d messages", joined, len(messages))
+		}
+		for i, message := range messages {
+			if !message.IsSystem || message.Content != outsiders[i].Username+" joined the chat" {
+				t.Errorf("Unexpected announcement %+v", message)
+			}
+		}
+
+		// Test revoked and expired invites
+		late := storage.addUser(t, domain.User{Username: "late"})
+		lateCookie := login(t, server, "late")
+		for i, expire := range []bool{false, true} {
+			status, response, _ := apiRequest(t, server, ownerCookie, http.MethodPost, invitesPath, map[string]int{"expires_in": 3600})
+			if status != http.StatusCreated {
+				t.Fatalf("Failed to create invite with status %d: %s", status, response.Message)
+			}
+			invite, _ := storage.invite(2 + i)
+			if expire {
+				storage.expireInvite(invite.ID)
+			} else if status, _, _ := apiRequest(t, server, ownerCookie, http.MethodDelete, invitesPath+"/"+strconv.Itoa(invite.ID), nil); status != http.StatusOK {
+				t.Errorf("Failed to revoke invite with status %d", status)
+			}
+
+			if status, _, _ := apiRequest(t, server, lateCookie, http.MethodPost, "/api/invites/"+invite.Token+"/join", nil); status != http.StatusNotFound {
+				t.Errorf("Invite (expired: %t) was redeemed with status %d", expire, status)
+			}
+		}
+		if storage.role(group.ID, late.ID) != "" {
+			t.Errorf("Revoked or expired invite added a member")
+		}
+	})
+}
@@ -0,0 +1,191 @@
+package tests
+
+import (
+	"database/sql"
+	"errors"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"chat/internal/domain"
+	"chat/internal/storage"
+)
+
+// messageExists tells whether the row of the message is still in the table,
+// whatever its lifetime
+func messageExists(t *testing.T, db *sql.DB, messageID int) bool {
+	var exists bool
+	if err := db.QueryRow("SELECT EXISTS (SELECT 1 FROM messages WHERE id = $1)", messageID).Scan(&exists); err != nil {
+		t.Fatalf("Failed to read message %d: %v", messageID, err)
+	}
+	return exists
+}
+
+// drain runs a purge until it has nothing left and returns the IDs of the
+// messages it removed
+func drain(t *testing.T, purge func() ([]domain.Message, error)) []int {
+	var ids []int
+	for {
+		messages, err := purge()
+		if err != nil {
+			t.Fatalf("Purge failed: %v", err)
+		}
+		if len(messages) == 0 {
+			return ids
+		}
+		for _, message := range messages {
+			ids = append(ids, message.ID)
+		}
+	}
+}
+
+// heldMessages are messages of one author covering every way a message
+// can go away
+type heldMessages struct {
+	edited, deleted, expiring, old int
+}
+
+func insertHeldMessages(t *testing.T, store *storage.Storage, db *sql.DB, chatID int, author domain.User, content string) heldMessages {
+	insert := func(message domain.Message) int {
+		message.ChatID, message.UserID, message.Content = chatID, author.ID, content
+		id, err := store.InsertMessage(message)
+		if err != nil {
+			t.Fatalf("Failed to insert message: %v", err)
+		}
+		return id
+	}
+	expired := time.Now().Add(-24 * time.Hour)
+	messages := heldMessages{
+		edited:   insert(domain.Message{}),
+		deleted:  insert(domain.Message{File: domain.File{Name: "evidence.txt", Data: "data:text/plain;base64,ZXZpZGVuY2U="}}),
+		expiring: insert(domain.Message{ExpiresAt: &expired}),
+		old:      insert(domain.Message{}),
+	}
+	if _, err := db.Exec("UPDATE messages SET created_at = NOW() - INTERVAL '10 days' WHERE id = $1", messages.old); err != nil {
+		t.Fatalf("Failed to age message: %v", err)
+	}
+	return messages
+}
+
+func FuzzLegalHold(f *testing.F) {
+	// Add seed corpus
+	f.Add(uint8(0), "contract draft")
+	f.Add(uint8(1), "встреча в 10")
+	f.Add(uint8(2), "delete this later")
+
+	f.Fuzz(func(t *testing.T, scope uint8, content string) {
+		if content == "" || !utf8.ValidString(content) || strings.ContainsRune(content, 0) {
+			return
+		}
+
+		// Initialize test dependencies
+		storage, db := openTestDatabase(t)
+		admin := insertTestUser(t, storage, "legal")
+		author := insertTestUser(t, storage, "author")
+		custodian := insertTestUser(t, storage, "custodian")
+		bystander := insertTestUser(t, storage, "bystander")
+		chatID, _ := storage.InsertChat(domain.Chat{Name: "held " + author.Username, Type: domain.ChatTypeGroup, CreatorID: author.ID})
+		otherID, _ := storage.InsertChat(domain.Chat{Name: "free " + bystander.Username, Type: domain.ChatTypeGroup, CreatorID: bystander.ID})
+		storage.AddUserToChat(chatID, author.ID, domain.ChatRoleOwner)
+		storage.AddUserToChat(chatID, custodian.ID, domain.ChatRoleMember)
+		storage.AddUserToChat(otherID, bystander.ID, domain.ChatRoleOwner)
+		// Members joined before the old messages were posted
+		db.Exec("UPDATE chat_membership_periods SET joined_at = NOW() - INTERVAL '30 days' WHERE chat_id = $1", chatID)
+		oneDay := 1
+		storage.SetChatRetention(chatID, &oneDay)
+		storage.SetChatRetention(otherID, &oneDay)
+		held := insertHeldMessages(t, storage, db, chatID, author, content)
+		free := insertHeldMessages(t, storage, db, otherID, bystander, content)
+
+		// The hold covers the author, the chat, or a member of the chat when
+		// the messages were posted
+		hold := domain.LegalHold{Reason: "litigation", CreatedBy: admin.ID}
+		switch scope % 3 {
+		case 0:
+			hold.UserID = author.ID
+		case 1:
+			hold.ChatID = chatID
+		case 2:
+			hold.UserID = custodian.ID
+		}
+		holdID, err := storage.InsertLegalHold(hold)
+		if err != nil {
+			t.Fatalf("Failed to create legal hold: %v", err)
+		}
+		stored := func(messageID int) domain.Message {
+			var message domain.Message
+			if err := storage.GetMessageByID(strconv.Itoa(messageID), &message); err != nil {
+				t.Fatalf("Failed to read message %d: %v", messageID, err)
+			}
+			return message
+		}
+
+		// Test editing, which the hold refuses without touching the content
+		if err := storage.UpdateMessageContent(strconv.Itoa(held.edited), "rewritten"); !errors.Is(err, domain.ErrMessageHeld) {
+			t.Errorf("Held message was edited with error %v", err)
+		}
+		if got := stored(held.edited).Content; got == "rewritten" {
+			t.Errorf("Held message content changed")
+		}
+		if err := storage.UpdateMessageContent(strconv.Itoa(free.edited), "rewritten"); err != nil || stored(free.edited).Content != "rewritten" {
+			t.Errorf("Message without a hold wasn't edited: %v", err)
+		}
+		if err := storage.UpdateMessageContent("0", "rewritten"); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("Editing a missing message returned %v", err)
+		}
+
+		// Test deleting, which leaves a tombstone that keeps content and file
+		// for eDiscovery
+		if err := storage.DeleteMessage(strconv.Itoa(held.deleted), author.ID); err != nil {
+			t.Fatalf("Failed to delete the held message: %v", err)
+		}
+		if err := storage.DeleteMessage(strconv.Itoa(free.deleted), bystander.ID); err != nil {
+			t.Fatalf("Failed to delete the message without a hold: %v", err)
+		}
+		tombstone := stored(held.deleted)
+		if _, err := storage.GetAttachmentByID(tombstone.AttachmentID); tombstone.DeletedAt == nil || tombstone.Content == "" || tombstone.AttachmentID == 0 || err != nil {
+			t.Errorf("Held message lost its content or file on deletion: %+v, %v", tombstone, err)
+		}
+		if err := storage.UpdateMessageContent(strconv.Itoa(held.deleted), "rewritten"); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("Held tombstone was edited with error %v", err)
+		}
+		if tombstone := stored(free.deleted); tombstone.DeletedAt == nil || tombstone.Content != "" || tombstone.AttachmentID != 0 {
+			t.Errorf("Message without a hold kept its content on deletion: %+v", tombstone)
+		}
+
+		// Test the reaper and the retention purge, which skip held messages
+		reaped := drain(t, func() ([]domain.Message, error) { return storage.DeleteExpiredMessages(100) })
+		if slices.Contains(reaped, held.expiring) || !messageExists(t, db, held.expiring) {
+			t.Errorf("Reaper deleted a held message")
+		}
+		if messageExists(t, db, free.expiring) {
+			t.Errorf("Reaper kept an expired message without a hold")
+		}
+		purged := drain(t, func() ([]domain.Message, error) { return storage.PurgeExpiredMessages(0, false, 100) })
+		if slices.Contains(purged, held.old) || !messageExists(t, db, held.old) {
+			t.Errorf("Retention purged a held message")
+		}
+		if messageExists(t, db, free.old) {
+			t.Errorf("Retention kept an old message without a hold")
+		}
+
+		// Test releasing the hold, after which the messages go as usual
+		if err := storage.ReleaseLegalHold(holdID, admin.ID); err != nil {
+			t.Fatalf("Failed to release the hold: %v", err)
+		}
+		if err := storage.ReleaseLegalHold(holdID, admin.ID); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("Hold was released twice with error %v", err)
+		}
+		if err := storage.UpdateMessageContent(strconv.Itoa(held.edited), "rewritten"); err != nil {
+			t.Errorf("Released message wasn't edited: %v", err)
+		}
+		drain(t, func() ([]domain.Message, error) { return storage.DeleteExpiredMessages(100) })
+		drain(t, func() ([]domain.Message, error) { return storage.PurgeExpiredMessages(0, false, 100) })
+		if messageExists(t, db, held.expiring) || messageExists(t, db, held.old) {
+			t.Errorf("Released messages outlived their lifetime")
+		}
+	})
+}
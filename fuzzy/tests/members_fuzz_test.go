@@ -0,0 +1,128 @@
+package tests
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"chat/internal/domain"
+
+	"github.com/gorilla/websocket"
+)
+
+// expectClosed reads the socket until the server closes it
+func expectClosed(t *testing.T, conn *websocket.Conn) {
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			if strings.Contains(err.Error(), "timeout") {
+				t.Errorf("Connection stayed open")
+			}
+			return
+		}
+	}
+}
+
+func FuzzChatMembers(f *testing.F) {
+	// Add seed corpus
+	f.Add([]byte{4})
+	f.Add([]byte{4, 7, 4})
+	f.Add([]byte{5, 6})
+	f.Add([]byte{3, 99, 0, 7})
+	f.Add([]byte{})
+
+	f.Fuzz(func(t *testing.T, userIDs []byte) {
+		// Initialize test dependencies
+		storage := newChatStorage()
+		owner := storage.addUser(t, domain.User{Username: "owner"})
+		admin := storage.addUser(t, domain.User{Username: "admin"})
+		member := storage.addUser(t, domain.User{Username: "member"})
+		storage.addUser(t, domain.User{Username: "newcomer"})
+		now := time.Now()
+		storage.addUser(t, domain.User{Username: "deactivated", DeactivatedAt: &now})
+		storage.addUser(t, domain.User{Username: "deleted-6", DeactivatedAt: &now, DeletedAt: &now})
+		storage.addUser(t, domain.User{Username: "latecomer"})
+		group := storage.addChat(domain.Chat{Name: "group", Type: domain.ChatTypeGroup}, map[int]domain.ChatRole{
+			owner.ID: domain.ChatRoleOwner, admin.ID: domain.ChatRoleAdmin, member.ID: domain.ChatRoleMember,
+		})
+		_, server := newChatServer(t, storage)
+		ownerCookie, adminCookie, memberCookie := login(t, server, "owner"), login(t, server, "admin"), login(t, server, "member")
+		membersPath := fmt.Sprintf("/api/chat/%d/members", group.ID)
+
+		// Test adding members without the permission
+		ids := make([]int, len(userIDs))
+		for i, id := range userIDs {
+			ids[i] = int(id)
+		}
+		if status, _, _ := apiRequest(t, server, memberCookie, http.MethodPost, membersPath, map[string][]int{"user_ids": ids}); status != http.StatusForbidden {
+			t.Errorf("Member added members with status %d", status)
+		}
+
+		// Test adding members: only active users who aren't members yet join,
+		// each once
+		var want []int
+		for _, id := range ids {
+			if (id == 4 || id == 7) && !slices.Contains(want, id) {
+				want = append(want, id)
+			}
+		}
+		status, response, _ := apiRequest(t, server, adminCookie, http.MethodPost, membersPath, map[string][]int{"user_ids": ids})
+		if len(ids) == 0 {
+			if status != http.StatusBadRequest {
+				t.Errorf("Adding no members got status %d", status)
+			}
+		} else {
+			data, _ := response.Data.(map[string]interface{})
+			added, _ := data["user_ids"].([]interface{})
+			if status != http.StatusOK || len(added) != len(want) {
+				t.Fatalf("Adding %v got status %d and %v, want %v", ids, status, data, want)
+			}
+			for i, id := range want {
+				if added[i] != float64(id) || storage.role(group.ID, id) != domain.ChatRoleMember {
+					t.Errorf("User %d was not added: %v", id, added)
+				}
+			}
+		}
+		for _, id := range []int{5, 6} {
+			if storage.role(group.ID, id) != "" {
+				t.Errorf("Inactive user %d was added", id)
+			}
+		}
+		if events := storage.webhookEvents(domain.WebhookEventMemberJoined); len(events) != len(want) {
+			t.Errorf("%d joins were announced, want %d", len(events), len(want))
+		}
+
+		// Test removing members: only lower roles, and the removed member loses
+		// the socket right away
+		ownerPath := membersPath + "/" + strconv.Itoa(owner.ID)
+		if status, _, _ := apiRequest(t, server, adminCookie, http.MethodDelete, ownerPath, nil); status != http.StatusForbidden || storage.role(group.ID, owner.ID) == "" {
+			t.Errorf("Admin removed the owner with status %d", status)
+		}
+		adminPath := membersPath + "/" + strconv.Itoa(admin.ID)
+		if status, _, _ := apiRequest(t, server, memberCookie, http.MethodDelete, adminPath, nil); status != http.StatusForbidden || storage.role(group.ID, admin.ID) == "" {
+			t.Errorf("Member removed an admin with status %d", status)
+		}
+
+		memberConn := dialChat(t, server, memberCookie, group.ID)
+		memberPath := membersPath + "/" + strconv.Itoa(member.ID)
+		if status, response, _ := apiRequest(t, server, ownerCookie, http.MethodDelete, memberPath, nil); status != http.StatusOK {
+			t.Fatalf("Failed to remove the member with status %d: %s", status, response.Message)
+		}
+		if storage.role(group.ID, member.ID) != "" {
+			t.Errorf("Removed member is still in the chat")
+		}
+		expectClosed(t, memberConn)
+		url := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws/chat/" + strconv.Itoa(group.ID)
+		if conn, _, err := websocket.DefaultDialer.Dial(url, http.Header{"Cookie": {memberCookie.String()}}); err == nil {
+			defer conn.Close()
+			expectClosed(t, conn)
+		}
+		if messages := storage.chatMessages(group.ID); len(messages) == 0 || messages[len(messages)-1].Content != "owner removed member" {
+			t.Errorf("Removal was not announced: %+v", messages)
+		}
+	})
+}
@@ -0,0 +1,50 @@
+package tests
+
+import (
+	"strings"
+	"testing"
+
+	"chat/internal/service/mention"
+)
+
+func FuzzMentionParse(f *testing.F) {
+	// Add seed corpus
+	f.Add("hello @bob and @alice.")
+	f.Add("")
+	f.Add("@all, please read: mail me at user@example.com")
+	f.Add("@@@ @ @- @Вася @ВАСЯ (@john_doe) @bob.smith...")
+
+	f.Fuzz(func(t *testing.T, content string) {
+		// Test parsing
+		usernames, all := mention.Parse(content)
+
+		// Verify result
+		if len(usernames) > mention.MaxMentions {
+			t.Errorf("Parse returned %d usernames, limit is %d", len(usernames), mention.MaxMentions)
+		}
+
+		seen := make(map[string]bool)
+		for _, username := range usernames {
+			if username == "" || username == mention.All {
+				t.Errorf("Parse returned invalid username %q", username)
+			}
+			if username != strings.ToLower(username) {
+				t.Errorf("Parse returned username %q that is not lowercased", username)
+			}
+			if strings.ContainsAny(username, "@ \t\n") {
+				t.Errorf("Parse returned username %q with separators", username)
+			}
+			if seen[username] {
+				t.Errorf("Parse returned duplicate username %q", username)
+			}
+			seen[username] = true
+		}
+
+		if !strings.Contains(content, "@") && (len(usernames) > 0 || all) {
+			t.Errorf("Parse found mentions in %q without @", content)
+		}
+		if strings.HasPrefix(content, "@all ") && !all {
+			t.Errorf("Parse missed @all in %q", content)
+		}
+	})
+}
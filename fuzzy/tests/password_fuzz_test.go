@@ -0,0 +1,54 @@
+package tests
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"chat/internal/config"
+	"chat/internal/service/password"
+)
+
+func FuzzPasswordPolicy(f *testing.F) {
+	// Add seed corpus
+	f.Add("correct horse battery staple")
+	f.Add("")
+	f.Add("Password123")
+	f.Add("ЙЦУКЕНГШЩЗ")
+	f.Add(strings.Repeat("я", 40))
+	f.Add("\xff\xfe\x00\x00\x00\x00\x00\x00")
+
+	os.Setenv(config.ConfigPathEnvKey, "../../config.yaml")
+	cfg, err := config.NewConfig()
+	if err != nil {
+		f.Fatalf("Failed to create config: %v", err)
+	}
+	cfg.Password.CheckBreached = true
+	policy := password.NewPolicy(cfg)
+	if !errors.Is(policy.Validate("PassWord123"), password.ErrBreached) {
+		f.Fatalf("Breached password accepted")
+	}
+
+	f.Fuzz(func(t *testing.T, candidate string) {
+		// Test validation
+		err := policy.Validate(candidate)
+
+		// Verify the reason matches the password
+		length := utf8.RuneCountInString(candidate)
+		switch {
+		case length < policy.MinLength:
+			if !errors.Is(err, password.ErrTooShort) {
+				t.Errorf("Password of %d characters not rejected as too short: %v", length, err)
+			}
+		case len(candidate) > password.MaxLength:
+			if !errors.Is(err, password.ErrTooLong) {
+				t.Errorf("Password of %d bytes not rejected as too long: %v", len(candidate), err)
+			}
+		case err != nil && !errors.Is(err, password.ErrBreached):
+			t.Errorf("Password %q rejected for unexpected reason: %v", candidate, err)
+		}
+
+	})
+}
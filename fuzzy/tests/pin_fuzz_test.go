@@ -0,0 +1,183 @@
+package tests
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+
+	"chat/internal/domain"
+)
+
+// pinStorage keeps pinned messages like the database does
+type pinStorage struct {
+	*chatStorage
+
+	pins []domain.PinnedMessage // In the order they were pinned
+}
+
+func (s *pinStorage) PinMessage(chatID int, messageID int, userID int) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, pin := range s.pins {
+		if pin.ChatID == chatID && pin.ID == messageID {
+			return false, nil
+		}
+	}
+	message := *s.messages[messageID]
+	message.Username = s.users[message.UserID].Username
+	s.pins = append(s.pins, domain.PinnedMessage{
+		Message:          message,
+		PinnedBy:         userID,
+		PinnedByUsername: s.users[userID].Username,
+		PinnedAt:         time.Now(),
+	})
+	return true, nil
+}
+
+func (s *pinStorage) UnpinMessage(chatID int, messageID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, pin := range s.pins {
+		if pin.ChatID == chatID && pin.ID == messageID {
+			s.pins = slices.Delete(s.pins, i, i+1)
+			return nil
+		}
+	}
+	return sql.ErrNoRows
+}
+
+// GetPinnedMessages returns the pins of the chat, the most recently pinned
+// first
+func (s *pinStorage) GetPinnedMessages(chatID int) ([]domain.PinnedMessage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var pins []domain.PinnedMessage
+	for i := len(s.pins) - 1; i >= 0; i-- {
+		if s.pins[i].ChatID == chatID {
+			pins = append(pins, s.pins[i])
+		}
+	}
+	return pins, nil
+}
+
+// pinnedIDs lists the IDs of the pinned messages of the chat as the API
+// returns them
+func pinnedIDs(t *testing.T, status int, response map[string]interface{}) []int {
+	if status != http.StatusOK {
+		t.Fatalf("Failed to list pins with status %d", status)
+	}
+	var ids []int
+	list, _ := response["pins"].([]interface{})
+	for _, item := range list {
+		pin, _ := item.(map[string]interface{})
+		id, _ := pin["ID"].(float64)
+		ids = append(ids, int(id))
+	}
+	return ids
+}
+
+func FuzzPinnedMessages(f *testing.F) {
+	// Add seed corpus
+	f.Add([]byte{1, 2})
+	f.Add([]byte{2, 2, 1})
+	f.Add([]byte{3, 4, 5})
+	f.Add([]byte{0, 1, 255})
+	f.Add([]byte{})
+
+	f.Fuzz(func(t *testing.T, picks []byte) {
+		// Initialize test dependencies
+		storage := &pinStorage{chatStorage: newChatStorage()}
+		owner := storage.addUser(t, domain.User{Username: "owner"})
+		member := storage.addUser(t, domain.User{Username: "member"})
+		outsider := storage.addUser(t, domain.User{Username: "outsider"})
+		group := storage.addChat(domain.Chat{Name: "group", Type: domain.ChatTypeGroup}, map[int]domain.ChatRole{
+			owner.ID: domain.ChatRoleOwner, member.ID: domain.ChatRoleMember,
+		})
+		private := storage.addChat(domain.Chat{Name: "private", Type: domain.ChatTypePrivate}, map[int]domain.ChatRole{
+			member.ID: domain.ChatRoleMember, outsider.ID: domain.ChatRoleMember,
+		})
+		// Messages 1 and 2 can be pinned in the group, 3 is a tombstone, 4 is
+		// in another chat and 5 doesn't exist
+		storage.InsertMessage(domain.Message{ChatID: group.ID, UserID: member.ID, Content: "meeting at 10"})
+		storage.InsertMessage(domain.Message{ChatID: group.ID, UserID: owner.ID, Content: "https://example.com/wiki"})
+		deleted, _ := storage.InsertMessage(domain.Message{ChatID: group.ID, UserID: member.ID, Content: "oops"})
+		storage.DeleteMessage(fmt.Sprint(deleted), member.ID)
+		privateMessage, _ := storage.InsertMessage(domain.Message{ChatID: private.ID, UserID: member.ID, Content: "between us"})
+		_, server := newChatServer(t, storage)
+		ownerCookie, memberCookie, outsiderCookie := login(t, server, "owner"), login(t, server, "member"), login(t, server, "outsider")
+		memberConn := dialChat(t, server, memberCookie, group.ID)
+		pinsPath := fmt.Sprintf("/api/chat/%d/pins", group.ID)
+
+		// Test pinning without the permission and outside the chat
+		for _, cookie := range []*http.Cookie{memberCookie, outsiderCookie} {
+			if status, _, _ := apiRequest(t, server, cookie, http.MethodPost, pinsPath, map[string]int{"message_id": 1}); status == http.StatusOK {
+				t.Errorf("Message was pinned without the permission")
+			}
+		}
+		if status, _, _ := apiRequest(t, server, outsiderCookie, http.MethodGet, pinsPath, nil); status == http.StatusOK {
+			t.Errorf("Non-member listed the pins")
+		}
+
+		// Test pinning messages: only live messages of the chat are pinned,
+		// each once, and only the first pin is announced
+		var want []int
+		for _, pick := range picks {
+			messageID := int(pick % 6)
+			status, _, _ := apiRequest(t, server, ownerCookie, http.MethodPost, pinsPath, map[string]int{"message_id": messageID})
+			if messageID != 1 && messageID != 2 {
+				if status != http.StatusNotFound {
+					t.Errorf("Message %d was pinned with status %d", messageID, status)
+				}
+				continue
+			}
+			if status != http.StatusOK {
+				t.Fatalf("Failed to pin message %d with status %d", messageID, status)
+			}
+			if !slices.Contains(want, messageID) {
+				want = append([]int{messageID}, want...)
+				notification := readUntil(t, memberConn, func(n map[string]interface{}) bool { return n["action"] == "pin" })
+				if notification["message_id"] != float64(messageID) || notification["pinned"] != true || notification["pinned_by"] != "owner" {
+					t.Errorf("Unexpected pin notification %v", notification)
+				}
+			}
+		}
+		readUntilMarker(t, memberConn, "pin", "marker")
+		status, response, _ := apiRequest(t, server, memberCookie, http.MethodGet, pinsPath, nil)
+		data, _ := response.Data.(map[string]interface{})
+		if got := pinnedIDs(t, status, data); !slices.Equal(got, want) {
+			t.Errorf("Pinned %v, want %v, the latest first", got, want)
+		}
+
+		// Test unpinning, which members without the permission can't do either
+		for _, messageID := range want {
+			unpinPath := fmt.Sprintf("%s/%d", pinsPath, messageID)
+			if status, _, _ := apiRequest(t, server, memberCookie, http.MethodDelete, unpinPath, nil); status != http.StatusForbidden {
+				t.Errorf("Member unpinned a message with status %d", status)
+			}
+			if status, response, _ := apiRequest(t, server, ownerCookie, http.MethodDelete, unpinPath, nil); status != http.StatusOK {
+				t.Fatalf("Failed to unpin message %d with status %d: %s", messageID, status, response.Message)
+			}
+			notification := readUntil(t, memberConn, func(n map[string]interface{}) bool { return n["action"] == "pin" })
+			if notification["message_id"] != float64(messageID) || notification["pinned"] != false {
+				t.Errorf("Unexpected unpin notification %v", notification)
+			}
+			if status, _, _ := apiRequest(t, server, ownerCookie, http.MethodDelete, unpinPath, nil); status != http.StatusNotFound {
+				t.Errorf("Message %d was unpinned twice with status %d", messageID, status)
+			}
+		}
+		status, response, _ = apiRequest(t, server, memberCookie, http.MethodGet, pinsPath, nil)
+		data, _ = response.Data.(map[string]interface{})
+		if got := pinnedIDs(t, status, data); len(got) != 0 {
+			t.Errorf("Messages %v stayed pinned", got)
+		}
+
+		// Test pinning in a private chat, which both members may do
+		privatePath := fmt.Sprintf("/api/chat/%d/pins", private.ID)
+		if status, response, _ := apiRequest(t, server, outsiderCookie, http.MethodPost, privatePath, map[string]int{"message_id": privateMessage}); status != http.StatusOK {
+			t.Errorf("Failed to pin in a private chat with status %d: %s", status, response.Message)
+		}
+	})
+}
@@ -0,0 +1,238 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"chat/internal/config"
+	"chat/internal/domain"
+)
+
+func (s *chatStorage) SetChatRetention(chatID int, days *int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.chats[chatID].RetentionDays = days
+	return nil
+}
+
+// retentionDays returns the retention period of the chat in days
+func (s *chatStorage) retentionDays(chatID int, defaultDays int) int {
+	if chat := s.chats[chatID]; chat.RetentionDays != nil {
+		return *chat.RetentionDays
+	}
+	return defaultDays
+}
+
+// retentionExpired tells whether the message is past the retention period
+// of its chat, like the database condition does
+func (s *chatStorage) retentionExpired(message *domain.Message, defaultDays int) bool {
+	days := s.retentionDays(message.ChatID, defaultDays)
+	return days > 0 && message.CreatedAt.Before(time.Now().Add(-time.Duration(days)*24*time.Hour))
+}
+
+// retentionStorage purges and reports messages like the database does and
+// counts the calls of the retention job
+type retentionStorage struct {
+	*chatStorage
+
+	callMu  sync.Mutex
+	purges  int
+	reports int
+}
+
+func (s *retentionStorage) PurgeExpiredMessages(defaultDays int, archive bool, limit int) ([]domain.Message, error) {
+	s.mu.Lock()
+	var purged []domain.Message
+	for id := 1; id <= s.lastID && len(purged) < limit; id++ {
+		if message, ok := s.messages[id]; ok && s.retentionExpired(message, defaultDays) {
+			purged = append(purged, domain.Message{ID: message.ID, ChatID: message.ChatID})
+			delete(s.messages, id)
+		}
+	}
+	s.mu.Unlock()
+
+	s.callMu.Lock()
+	defer s.callMu.Unlock()
+	s.purges++
+	return purged, nil
+}
+
+func (s *retentionStorage) GetRetentionReport(defaultDays int, chatID int) ([]domain.RetentionReport, error) {
+	s.mu.Lock()
+	reports := []domain.RetentionReport{}
+	for id := 1; id <= len(s.chats); id++ {
+		if chatID != 0 && id != chatID {
+			continue
+		}
+		report := domain.RetentionReport{ChatID: id, ChatName: s.chats[id].Name, RetentionDays: s.retentionDays(id, defaultDays)}
+		for _, message := range s.messages {
+			if message.ChatID != id || !s.retentionExpired(message, defaultDays) {
+				continue
+			}
+			if report.MessageCount == 0 || message.CreatedAt.Before(report.OldestMessageAt) {
+				report.OldestMessageAt = message.CreatedAt
+			}
+			report.MessageCount++
+		}
+		if report.MessageCount > 0 {
+			reports = append(reports, report)
+		}
+	}
+	s.mu.Unlock()
+
+	s.callMu.Lock()
+	defer s.callMu.Unlock()
+	s.reports++
+	return reports, nil
+}
+
+// calls returns how many times the retention job purged and reported
+func (s *retentionStorage) calls() (int, int) {
+	s.callMu.Lock()
+	defer s.callMu.Unlock()
+	return s.purges, s.reports
+}
+
+func FuzzRetention(f *testing.F) {
+	// Add seed corpus
+	f.Add(uint8(0), int8(3), uint8(10), false)
+	f.Add(uint8(5), int8(-1), uint8(20), false)
+	f.Add(uint8(5), int8(0), uint8(20), false)
+	f.Add(uint8(1), int8(2), uint8(7), true)
+	f.Add(uint8(0), int8(-1), uint8(0), false)
+	f.Add(uint8(200), int8(1), uint8(30), false)
+
+	f.Fuzz(func(t *testing.T, defaultDays uint8, chatDays int8, count uint8, dryRun bool) {
+		// Initialize test dependencies
+		storage := &retentionStorage{chatStorage: newChatStorage()}
+		owner := storage.addUser(t, domain.User{Username: "owner"})
+		member := storage.addUser(t, domain.User{Username: "member"})
+		roles := map[int]domain.ChatRole{owner.ID: domain.ChatRoleOwner, member.ID: domain.ChatRoleMember}
+		group := storage.addChat(domain.Chat{Name: "group", Type: domain.ChatTypeGroup}, roles)
+		private := storage.addChat(domain.Chat{Name: "private", Type: domain.ChatTypePrivate}, roles)
+		application, server := newChatServer(t, storage, func(cfg *config.Config) {
+			cfg.Retention.DefaultDays = int(defaultDays)
+			cfg.Retention.DryRun = dryRun
+			cfg.Retention.BatchSize = 3
+		})
+		ownerCookie, memberCookie := login(t, server, "owner"), login(t, server, "member")
+		retentionPath := fmt.Sprintf("/api/chat/%d/retention", group.ID)
+
+		// Test retention settings without the permission and invalid ones
+		if status, _, _ := apiRequest(t, server, memberCookie, http.MethodGet, retentionPath, nil); status != http.StatusForbidden {
+			t.Errorf("Member read the retention report with status %d", status)
+		}
+		if status, _, _ := apiRequest(t, server, memberCookie, http.MethodPut, retentionPath, map[string]int{"retention_days": 1}); status != http.StatusForbidden {
+			t.Errorf("Member set the retention period with status %d", status)
+		}
+		if status, _, _ := apiRequest(t, server, ownerCookie, http.MethodPut, retentionPath, map[string]int{"retention_days": -1}); status != http.StatusBadRequest {
+			t.Errorf("Negative retention period was set with status %d", status)
+		}
+		privatePath := fmt.Sprintf("/api/chat/%d/retention", private.ID)
+		if status, _, _ := apiRequest(t, server, ownerCookie, http.MethodPut, privatePath, map[string]int{"retention_days": 1}); status != http.StatusBadRequest {
+			t.Errorf("Retention period of a private chat was set with status %d", status)
+		}
+
+		// Test setting the retention period, a negative one following the
+		// default policy
+		var days *int
+		effectiveDays := int(defaultDays)
+		if chatDays >= 0 {
+			days = new(int)
+			*days = int(chatDays)
+			effectiveDays = *days
+		}
+		status, response, _ := apiRequest(t, server, ownerCookie, http.MethodPut, retentionPath, map[string]*int{"retention_days": days})
+		if status != http.StatusOK {
+			t.Fatalf("Failed to set the retention period with status %d: %s", status, response.Message)
+		}
+		announcements := storage.chatMessages(group.ID)
+		switch {
+		case effectiveDays == int(defaultDays):
+			if len(announcements) != 0 {
+				t.Errorf("Unchanged retention period was announced: %+v", announcements)
+			}
+		case effectiveDays > 0:
+			if len(announcements) != 1 || announcements[0].Content != fmt.Sprintf("owner set the chat to keep messages for %d days", effectiveDays) {
+				t.Errorf("Unexpected announcement of %d days: %+v", effectiveDays, announcements)
+			}
+		default:
+			if len(announcements) != 1 || announcements[0].Content != "owner set the chat to keep messages forever" {
+				t.Errorf("Unexpected announcement of keeping messages forever: %+v", announcements)
+			}
+		}
+
+		// Messages are a day apart, the newest an hour old. The private chat
+		// follows the default policy.
+		count %= 40
+		expired, privateExpired := 0, 0
+		for age := 0; age < int(count); age++ {
+			createdAt := time.Now().Add(-time.Duration(age)*24*time.Hour - time.Hour)
+			storage.InsertMessage(domain.Message{ChatID: group.ID, UserID: member.ID, Content: "old", CreatedAt: createdAt})
+			storage.InsertMessage(domain.Message{ChatID: private.ID, UserID: member.ID, Content: "old", CreatedAt: createdAt})
+			if effectiveDays > 0 && age >= effectiveDays {
+				expired++
+			}
+			if defaultDays > 0 && age >= int(defaultDays) {
+				privateExpired++
+			}
+		}
+		total := len(storage.chatMessages(group.ID))
+
+		// Test the dry-run report
+		status, response, _ = apiRequest(t, server, ownerCookie, http.MethodGet, retentionPath, nil)
+		if status != http.StatusOK {
+			t.Fatalf("Failed to read the retention report with status %d: %s", status, response.Message)
+		}
+		data, _ := response.Data.(map[string]interface{})
+		report, _ := data["report"].(map[string]interface{})
+		if report["MessageCount"] != float64(expired) || report["RetentionDays"] != float64(effectiveDays) || data["default_days"] != float64(defaultDays) {
+			t.Errorf("Unexpected report with %d expired messages: %v", expired, data)
+		}
+
+		// Test the retention job, which only reports in dry-run mode
+		purgesBefore, reportsBefore := storage.calls()
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			application.RunRetention(ctx)
+			close(done)
+		}()
+		wantPurges, wantReports := purgesBefore+(expired+privateExpired)/3+1, reportsBefore
+		if dryRun {
+			wantPurges, wantReports = purgesBefore, reportsBefore+1
+		}
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); {
+			if purges, reports := storage.calls(); purges >= wantPurges && reports >= wantReports {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		cancel()
+		<-done
+
+		if purges, reports := storage.calls(); purges != wantPurges || reports != wantReports {
+			t.Errorf("Retention job purged %d times and reported %d times, want %d and %d", purges, reports, wantPurges, wantReports)
+		}
+		purged := expired
+		if dryRun {
+			purged = 0
+		}
+		if remaining := len(storage.chatMessages(group.ID)); remaining != total-purged {
+			t.Errorf("Retention left %d of %d messages, want %d", remaining, total, total-purged)
+		}
+		events := 0
+		for _, event := range storage.webhookEvents(domain.WebhookEventMessageDelete) {
+			if event.ChatID == group.ID && event.Payload["retention"] == true {
+				events++
+			}
+		}
+		if events != purged {
+			t.Errorf("Retention announced %d of %d purged messages", events, purged)
+		}
+	})
+}
@@ -0,0 +1,138 @@
+package tests
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+	"testing"
+
+	"chat/internal/domain"
+)
+
+func (s *chatStorage) SetChatMemberRole(chatID int, userID int, role domain.ChatRole) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.members[chatID][userID]; !ok {
+		return sql.ErrNoRows
+	}
+	s.members[chatID][userID] = role
+	return nil
+}
+
+// TransferChatOwnership makes newOwnerID the owner and demotes the previous
+// owner to admin
+func (s *chatStorage) TransferChatOwnership(chatID int, ownerID int, newOwnerID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.members[chatID][newOwnerID]; !ok {
+		return sql.ErrNoRows
+	}
+	s.members[chatID][newOwnerID] = domain.ChatRoleOwner
+	if s.members[chatID][ownerID] == domain.ChatRoleOwner {
+		s.members[chatID][ownerID] = domain.ChatRoleAdmin
+	}
+	return nil
+}
+
+func FuzzChatRoles(f *testing.F) {
+	// Add seed corpus
+	f.Add("admin")
+	f.Add("member")
+	f.Add("owner")
+	f.Add("")
+	f.Add("Admin")
+
+	f.Fuzz(func(t *testing.T, role string) {
+		// Initialize test dependencies
+		storage := newChatStorage()
+		owner := storage.addUser(t, domain.User{Username: "owner"})
+		admin := storage.addUser(t, domain.User{Username: "admin"})
+		member := storage.addUser(t, domain.User{Username: "member"})
+		promoted := storage.addUser(t, domain.User{Username: "promoted"})
+		outsider := storage.addUser(t, domain.User{Username: "outsider"})
+		group := storage.addChat(domain.Chat{Name: "group", Type: domain.ChatTypeGroup}, map[int]domain.ChatRole{
+			owner.ID: domain.ChatRoleOwner, admin.ID: domain.ChatRoleAdmin,
+			member.ID: domain.ChatRoleMember, promoted.ID: domain.ChatRoleMember,
+		})
+		messages := make(map[int]int)
+		for _, author := range []domain.User{owner, admin, member, promoted} {
+			messages[author.ID], _ = storage.InsertMessage(domain.Message{ChatID: group.ID, UserID: author.ID, Username: author.Username, Content: "hello"})
+		}
+		_, server := newChatServer(t, storage)
+		ownerCookie, adminCookie, memberCookie := login(t, server, "owner"), login(t, server, "admin"), login(t, server, "member")
+		rolePath := func(userID int) string {
+			return fmt.Sprintf("/api/chat/%d/members/%d/role", group.ID, userID)
+		}
+		transferPath := fmt.Sprintf("/api/chat/%d/transfer-ownership", group.ID)
+		deleteMessage := func(cookie *http.Cookie, authorID int) int {
+			status, _, _ := apiRequest(t, server, cookie, http.MethodPost, "/api/delete-message", map[string]string{
+				"message_id": strconv.Itoa(messages[authorID]), "chat_id": strconv.Itoa(group.ID),
+			})
+			return status
+		}
+
+		// Test changing roles, which only the owner may do
+		for _, cookie := range []*http.Cookie{adminCookie, memberCookie} {
+			if status, _, _ := apiRequest(t, server, cookie, http.MethodPost, rolePath(promoted.ID), map[string]string{"role": "admin"}); status != http.StatusForbidden {
+				t.Errorf("Role was changed by a non-owner with status %d", status)
+			}
+		}
+		if status, _, _ := apiRequest(t, server, ownerCookie, http.MethodPost, rolePath(owner.ID), map[string]string{"role": "member"}); status != http.StatusBadRequest {
+			t.Errorf("Owner changed their own role with status %d", status)
+		}
+		if status, _, _ := apiRequest(t, server, ownerCookie, http.MethodPost, rolePath(outsider.ID), map[string]string{"role": "admin"}); status != http.StatusNotFound {
+			t.Errorf("Role of a non-member was changed with status %d", status)
+		}
+		status, response, _ := apiRequest(t, server, ownerCookie, http.MethodPost, rolePath(promoted.ID), map[string]string{"role": role})
+		wantRole := domain.ChatRoleMember
+		if role == string(domain.ChatRoleAdmin) || role == string(domain.ChatRoleMember) {
+			wantRole = domain.ChatRole(role)
+			if status != http.StatusOK {
+				t.Errorf("Failed to set role %q with status %d: %s", role, status, response.Message)
+			}
+		} else if status != http.StatusBadRequest {
+			t.Errorf("Role %q was set with status %d", role, status)
+		}
+		if got := storage.role(group.ID, promoted.ID); got != wantRole {
+			t.Errorf("Member has role %q after setting %q", got, role)
+		}
+
+		// Test deleting messages of others, which needs a higher role
+		if status := deleteMessage(memberCookie, admin.ID); status != http.StatusForbidden {
+			t.Errorf("Member deleted a message of an admin with status %d", status)
+		}
+		if status := deleteMessage(adminCookie, owner.ID); status != http.StatusForbidden {
+			t.Errorf("Admin deleted a message of the owner with status %d", status)
+		}
+		if status := deleteMessage(adminCookie, promoted.ID); (status == http.StatusOK) != (wantRole == domain.ChatRoleMember) {
+			t.Errorf("Admin deleting a message of a %s got status %d", wantRole, status)
+		}
+		if status := deleteMessage(adminCookie, member.ID); status != http.StatusOK {
+			t.Errorf("Admin failed to delete a message of a member with status %d", status)
+		}
+
+		// Test transferring ownership, after which the previous owner is an admin
+		if status, _, _ := apiRequest(t, server, adminCookie, http.MethodPost, transferPath, map[string]int{"user_id": admin.ID}); status != http.StatusForbidden {
+			t.Errorf("Admin took ownership with status %d", status)
+		}
+		if status, _, _ := apiRequest(t, server, ownerCookie, http.MethodPost, transferPath, map[string]int{"user_id": outsider.ID}); status != http.StatusNotFound {
+			t.Errorf("Ownership went to a non-member with status %d", status)
+		}
+		if status, response, _ := apiRequest(t, server, ownerCookie, http.MethodPost, transferPath, map[string]int{"user_id": admin.ID}); status != http.StatusOK {
+			t.Fatalf("Failed to transfer ownership with status %d: %s", status, response.Message)
+		}
+		if storage.role(group.ID, admin.ID) != domain.ChatRoleOwner || storage.role(group.ID, owner.ID) != domain.ChatRoleAdmin {
+			t.Errorf("Unexpected roles after the transfer: %q and %q", storage.role(group.ID, admin.ID), storage.role(group.ID, owner.ID))
+		}
+		if status, _, _ := apiRequest(t, server, ownerCookie, http.MethodPost, rolePath(member.ID), map[string]string{"role": "admin"}); status != http.StatusForbidden {
+			t.Errorf("Previous owner changed a role with status %d", status)
+		}
+		if status := deleteMessage(ownerCookie, admin.ID); status != http.StatusForbidden {
+			t.Errorf("Previous owner deleted a message of the new owner with status %d", status)
+		}
+		if status := deleteMessage(adminCookie, owner.ID); status != http.StatusOK {
+			t.Errorf("New owner failed to delete a message of an admin with status %d", status)
+		}
+	})
+}
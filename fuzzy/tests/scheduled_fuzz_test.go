@@ -0,0 +1,315 @@
+package tests
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"chat/internal/config"
+	"chat/internal/domain"
+	"chat/internal/storage"
+)
+
+// openTestDatabase connects to the test database both through the storage
+// and directly, to check and arrange rows the storage doesn't expose
+func openTestDatabase(t *testing.T) (*storage.Storage, *sql.DB) {
+	os.Setenv(config.ConfigPathEnvKey, "../../config.yaml")
+	cfg, err := config.NewConfig()
+	if err != nil {
+		t.Fatalf("Failed to create config: %v", err)
+	}
+	store, err := storage.NewStorage(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	t.Cleanup(store.Close)
+	db, err := sql.Open("postgres", fmt.Sprintf("user=%s password=%s dbname=%s host=%s sslmode=disable",
+		cfg.DB.User, cfg.DB.Password, cfg.DB.Name, cfg.DB.Host))
+	if err != nil {
+		t.Fatalf("Failed to connect to the database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return store, db
+}
+
+// insertTestUser creates a user whose name starts with prefix and is unique
+// across runs against the same database
+func insertTestUser(t *testing.T, store *storage.Storage, prefix string) domain.User {
+	username := prefix + "-" + strconv.FormatInt(time.Now().UnixNano(), 36)
+	if err := store.InsertUser(domain.User{Username: username, Password: "password"}); err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	user, err := store.GetUserByUsername(username)
+	if err != nil {
+		t.Fatalf("Failed to read user: %v", err)
+	}
+	return user
+}
+
+// scheduledRow is a scheduled message as the database keeps it
+type scheduledRow struct {
+	Status    string
+	MessageID int
+	Delivered bool
+}
+
+// scheduledRows returns the scheduled messages of the chat by their IDs
+func scheduledRows(t *testing.T, db *sql.DB, chatID int) map[int]scheduledRow {
+	rows, err := db.Query(
+		"SELECT id, status, COALESCE(message_id, 0), delivered_at IS NOT NULL FROM scheduled_messages WHERE chat_id = $1",
+		chatID,
+	)
+	if err != nil {
+		t.Fatalf("Failed to read scheduled messages: %v", err)
+	}
+	defer rows.Close()
+
+	scheduled := make(map[int]scheduledRow)
+	for rows.Next() {
+		var id int
+		var row scheduledRow
+		if err := rows.Scan(&id, &row.Status, &row.MessageID, &row.Delivered); err != nil {
+			t.Fatalf("Failed to read scheduled messages: %v", err)
+		}
+		scheduled[id] = row
+	}
+	return scheduled
+}
+
+// runConcurrently calls run from several workers until it returns nothing and
+// collects everything they got. It fails if a worker blocks.
+func runConcurrently(t *testing.T, workers int, run func() ([]int, error)) []int {
+	var mu sync.Mutex
+	var got []int
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				ids, err := run()
+				if err != nil {
+					t.Errorf("Scheduler run failed: %v", err)
+					return
+				}
+				if len(ids) == 0 {
+					return
+				}
+				mu.Lock()
+				got = append(got, ids...)
+				mu.Unlock()
+			}
+		}()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("Scheduler runs blocked each other")
+	}
+	return got
+}
+
+// countOf returns how many times each of the IDs occurs in got
+func countOf(got []int) map[int]int {
+	counts := make(map[int]int)
+	for _, id := range got {
+		counts[id]++
+	}
+	return counts
+}
+
+func FuzzScheduledDelivery(f *testing.F) {
+	// Add seed corpus
+	f.Add(uint8(2), uint8(2))
+	f.Add(uint8(7), uint8(4))
+	f.Add(uint8(15), uint8(8))
+	f.Add(uint8(255), uint8(0))
+
+	f.Fuzz(func(t *testing.T, count uint8, workers uint8) {
+		// Initialize test dependencies
+		storage, db := openTestDatabase(t)
+		author := insertTestUser(t, storage, "scheduler")
+		chatID, err := storage.InsertChat(domain.Chat{Name: "scheduled " + author.Username, Type: domain.ChatTypeGroup, CreatorID: author.ID})
+		if err != nil {
+			t.Fatalf("Failed to create chat: %v", err)
+		}
+		storage.AddUserToChat(chatID, author.ID, domain.ChatRoleOwner)
+
+		n := 2 + int(count)%15
+		var scheduledIDs []int
+		for i := 0; i < n; i++ {
+			id, err := storage.InsertScheduledMessage(domain.ScheduledMessage{
+				ChatID: chatID, UserID: author.ID, Content: "due " + strconv.Itoa(i), SendAt: time.Now().Add(-24 * time.Hour),
+			})
+			if err != nil {
+				t.Fatalf("Failed to schedule a message: %v", err)
+			}
+			scheduledIDs = append(scheduledIDs, id)
+		}
+		future, _ := storage.InsertScheduledMessage(domain.ScheduledMessage{
+			ChatID: chatID, UserID: author.ID, Content: "tomorrow", SendAt: time.Now().Add(24 * time.Hour),
+		})
+		allowed := func(domain.ScheduledMessage) bool { return true }
+		sendDue := func(lease time.Duration) func() ([]int, error) {
+			return func() ([]int, error) { return storage.SendDueScheduledMessages(2, lease, allowed) }
+		}
+		claim := func() ([]int, error) { return storage.ClaimUndeliveredScheduledMessages(2, time.Minute) }
+
+		// Test overlapping scheduler runs while another server holds the first
+		// message: they skip it instead of waiting, and send the rest once
+		locked, err := db.Begin()
+		if err != nil {
+			t.Fatalf("Failed to begin transaction: %v", err)
+		}
+		if _, err := locked.Exec("SELECT id FROM scheduled_messages WHERE id = $1 FOR UPDATE", scheduledIDs[0]); err != nil {
+			t.Fatalf("Failed to lock the first message: %v", err)
+		}
+		sent := countOf(runConcurrently(t, 2+int(workers)%7, sendDue(time.Minute)))
+		rows := scheduledRows(t, db, chatID)
+		if rows[scheduledIDs[0]].Status != "pending" {
+			t.Errorf("Locked message was sent with status %q", rows[scheduledIDs[0]].Status)
+		}
+		for _, id := range scheduledIDs[1:] {
+			if row := rows[id]; row.Status != "sent" || row.MessageID == 0 || sent[row.MessageID] != 1 {
+				t.Errorf("Scheduled message %d was sent %d times: %+v", id, sent[row.MessageID], row)
+			}
+		}
+		locked.Rollback()
+
+		// Test sending the message once it's released, with a short lease
+		lease := 500 * time.Millisecond
+		sent = countOf(runConcurrently(t, 2, sendDue(lease)))
+		rows = scheduledRows(t, db, chatID)
+		first := rows[scheduledIDs[0]]
+		if first.Status != "sent" || sent[first.MessageID] != 1 {
+			t.Fatalf("Released message was sent %d times: %+v", sent[first.MessageID], first)
+		}
+		if rows[future].Status != "pending" {
+			t.Errorf("Message scheduled for tomorrow was sent")
+		}
+		var messages int
+		db.QueryRow("SELECT count(*) FROM messages WHERE chat_id = $1", chatID).Scan(&messages)
+		if messages != n {
+			t.Errorf("Chat has %d messages, want %d", messages, n)
+		}
+
+		// Test recovering after a crash between sending and delivering: the
+		// messages are claimed only once their lease runs out
+		if claimed := countOf(runConcurrently(t, 2, claim)); claimed[first.MessageID] != 0 {
+			t.Errorf("Message was claimed within its lease")
+		}
+		time.Sleep(lease + 100*time.Millisecond)
+		if claimed := countOf(runConcurrently(t, 2, claim)); claimed[first.MessageID] != 1 {
+			t.Errorf("Message with an expired lease was claimed %d times", claimed[first.MessageID])
+		}
+		if claimed := countOf(runConcurrently(t, 2, claim)); claimed[first.MessageID] != 0 {
+			t.Errorf("Claimed message was claimed again within the new lease")
+		}
+
+		// Test overlapping recovery runs once every lease has expired: each
+		// undelivered message goes to exactly one of them, and delivered ones
+		// to none
+		delivered := scheduledRows(t, db, chatID)[scheduledIDs[1]].MessageID
+		if err := storage.MarkScheduledMessageDelivered(delivered); err != nil {
+			t.Fatalf("Failed to mark the message delivered: %v", err)
+		}
+		if _, err := db.Exec(
+			"UPDATE scheduled_messages SET delivery_lease_until = NOW() - INTERVAL '1 second' WHERE chat_id = $1 AND status = 'sent' AND delivered_at IS NULL",
+			chatID,
+		); err != nil {
+			t.Fatalf("Failed to expire the leases: %v", err)
+		}
+		claimed := countOf(runConcurrently(t, 2+int(workers)%7, claim))
+		for id, row := range scheduledRows(t, db, chatID) {
+			want := 1
+			if row.Delivered || row.Status != "sent" {
+				want = 0
+			}
+			if claimed[row.MessageID] != want {
+				t.Errorf("Scheduled message %d was claimed %d times, want %d: %+v", id, claimed[row.MessageID], want, row)
+			}
+			if row.Status == "sent" {
+				storage.MarkScheduledMessageDelivered(row.MessageID)
+			}
+		}
+		db.Exec("UPDATE scheduled_messages SET delivery_lease_until = NOW() - INTERVAL '1 second' WHERE chat_id = $1", chatID)
+		claimed = countOf(runConcurrently(t, 2, claim))
+		for id, row := range scheduledRows(t, db, chatID) {
+			if claimed[row.MessageID] != 0 {
+				t.Errorf("Delivered scheduled message %d was claimed again", id)
+			}
+		}
+	})
+}
+
+func FuzzScheduledCleanup(f *testing.F) {
+	// Add seed corpus
+	f.Add(uint8(1), false)
+	f.Add(uint8(3), true)
+	f.Add(uint8(0), false)
+
+	f.Fuzz(func(t *testing.T, count uint8, purge bool) {
+		// Initialize test dependencies
+		storage, db := openTestDatabase(t)
+		author := insertTestUser(t, storage, "leaving")
+		admin := insertTestUser(t, storage, "admin")
+		chatID, err := storage.InsertChat(domain.Chat{Name: "scheduled " + author.Username, Type: domain.ChatTypeGroup, CreatorID: admin.ID})
+		if err != nil {
+			t.Fatalf("Failed to create chat: %v", err)
+		}
+		storage.AddUserToChat(chatID, admin.ID, domain.ChatRoleOwner)
+		storage.AddUserToChat(chatID, author.ID, domain.ChatRoleMember)
+		file := domain.File{Name: "slides.pdf", Data: "data:application/pdf;base64,JVBERi0="}
+
+		// Pending messages with files, one without, and one already sent
+		var pending []int
+		for i := 0; i < 1+int(count)%5; i++ {
+			attachmentID, err := storage.InsertAttachment(file, author.ID)
+			if err != nil {
+				t.Fatalf("Failed to store attachment: %v", err)
+			}
+			storage.InsertScheduledMessage(domain.ScheduledMessage{
+				ChatID: chatID, UserID: author.ID, Content: "later", AttachmentID: attachmentID, SendAt: time.Now().Add(24 * time.Hour),
+			})
+			pending = append(pending, attachmentID)
+		}
+		storage.InsertScheduledMessage(domain.ScheduledMessage{ChatID: chatID, UserID: author.ID, Content: "no file", SendAt: time.Now().Add(24 * time.Hour)})
+		sentAttachment, _ := storage.InsertAttachment(file, author.ID)
+		storage.InsertScheduledMessage(domain.ScheduledMessage{
+			ChatID: chatID, UserID: author.ID, Content: "now", AttachmentID: sentAttachment, SendAt: time.Now().Add(-24 * time.Hour),
+		})
+		if _, err := storage.SendDueScheduledMessages(100, time.Minute, func(domain.ScheduledMessage) bool { return true }); err != nil {
+			t.Fatalf("Failed to send due messages: %v", err)
+		}
+
+		// Test deleting the user, which drops pending messages with their files
+		if _, err := storage.DeleteUser(author.ID, purge, admin.ID); err != nil {
+			t.Fatalf("Failed to delete user: %v", err)
+		}
+		for id, row := range scheduledRows(t, db, chatID) {
+			if row.Status == "pending" {
+				t.Errorf("Scheduled message %d outlived its author", id)
+			}
+		}
+		for _, attachmentID := range pending {
+			if _, err := storage.GetAttachmentByID(attachmentID); err == nil {
+				t.Errorf("Attachment %d of a dropped scheduled message was kept", attachmentID)
+			}
+		}
+
+		// Verify the file of the sent message stays while the message does
+		if _, err := storage.GetAttachmentByID(sentAttachment); err != nil && !purge {
+			t.Errorf("Attachment of a sent message was deleted: %v", err)
+		}
+	})
+}
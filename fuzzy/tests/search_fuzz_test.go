@@ -0,0 +1,64 @@
+package tests
+
+import (
+	"os"
+	"testing"
+	"unicode"
+	"unicode/utf8"
+
+	"chat/internal/config"
+	"chat/internal/service/search"
+)
+
+func FuzzSearchTokenize(f *testing.F) {
+	// Add seed corpus
+	f.Add("Встреча в 10:00, ссылка: https://example.com/meet")
+	f.Add("")
+	f.Add("a b c DEPLOY deploy Deploy")
+	f.Add("\xff\xfe invalid utf-8 \x00")
+
+	os.Setenv(config.ConfigPathEnvKey, "../../config.yaml")
+	cfg, err := config.NewConfig()
+	if err != nil {
+		f.Fatalf("Failed to create config: %v", err)
+	}
+	cfg.Search.Enabled = true
+	searchService := search.NewService(cfg)
+
+	f.Fuzz(func(t *testing.T, text string) {
+		// Test tokenizing
+		words := search.Tokenize(text, search.MaxMessageTokens)
+
+		// Verify words
+		if len(words) > search.MaxMessageTokens {
+			t.Errorf("Tokenize returned %d words, limit is %d", len(words), search.MaxMessageTokens)
+		}
+		seen := make(map[string]bool)
+		for _, word := range words {
+			length := utf8.RuneCountInString(word)
+			if length < search.MinTokenLength || length > search.MaxTokenLength {
+				t.Errorf("Tokenize returned word %q of length %d", word, length)
+			}
+			for _, r := range word {
+				if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+					t.Errorf("Tokenize returned word %q with separator %q", word, r)
+				}
+			}
+			if seen[word] {
+				t.Errorf("Tokenize returned duplicate word %q", word)
+			}
+			seen[word] = true
+		}
+
+		// A message must be found by its own words
+		indexed := make(map[string]bool)
+		for _, token := range searchService.IndexTokens(text) {
+			indexed[token] = true
+		}
+		for _, token := range searchService.QueryTokens(text) {
+			if !indexed[token] {
+				t.Errorf("Query token of %q is missing from its index", text)
+			}
+		}
+	})
+}
@@ -0,0 +1,148 @@
+package tests
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"chat/internal/domain"
+)
+
+var (
+	pngAvatar = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR\x00\x00\x00\x01\x00\x00\x00\x01\x08\x06\x00\x00\x00\x1f\x15\xc4\x89")
+	gifAvatar = []byte("GIF89a\x01\x00\x01\x00\x00\x00\x00;")
+)
+
+// avatarFile returns the data as an avatar upload
+func avatarFile(name string, data []byte, mimeType string) domain.File {
+	return domain.File{Name: name, Data: "data:" + mimeType + ";base64," + base64.StdEncoding.EncodeToString(data)}
+}
+
+func FuzzChatSettings(f *testing.F) {
+	// Add seed corpus
+	f.Add("Renamed", "Release planning")
+	f.Add("  Padded  ", "")
+	f.Add("", "topic")
+	f.Add("   ", "topic")
+	f.Add(strings.Repeat("я", 101), "topic")
+	f.Add("name", strings.Repeat("x", 251))
+	f.Add("\xff", "\xfe")
+
+	f.Fuzz(func(t *testing.T, name string, topic string) {
+		// Initialize test dependencies
+		storage := newChatStorage()
+		owner := storage.addUser(t, domain.User{Username: "owner"})
+		admin := storage.addUser(t, domain.User{Username: "admin"})
+		member := storage.addUser(t, domain.User{Username: "member"})
+		group := storage.addChat(domain.Chat{Name: "group", Type: domain.ChatTypeGroup}, map[int]domain.ChatRole{
+			owner.ID: domain.ChatRoleOwner, admin.ID: domain.ChatRoleAdmin, member.ID: domain.ChatRoleMember,
+		})
+		private := storage.addChat(domain.Chat{Name: "private", Type: domain.ChatTypePrivate}, map[int]domain.ChatRole{
+			owner.ID: domain.ChatRoleMember, member.ID: domain.ChatRoleMember,
+		})
+		_, server := newChatServer(t, storage)
+		adminCookie, memberCookie := login(t, server, "admin"), login(t, server, "member")
+		memberConn := dialChat(t, server, memberCookie, group.ID)
+		chatPath := fmt.Sprintf("/api/chat/%d", group.ID)
+		avatarPath := chatPath + "/avatar"
+
+		// Test changing settings without the permission and of a private chat
+		if status, _, _ := apiRequest(t, server, memberCookie, http.MethodPut, chatPath, map[string]string{"name": "taken over"}); status != http.StatusForbidden {
+			t.Errorf("Member changed settings with status %d", status)
+		}
+		privatePath := fmt.Sprintf("/api/chat/%d", private.ID)
+		if status, _, _ := apiRequest(t, server, memberCookie, http.MethodPut, privatePath, map[string]string{"name": "renamed"}); status == http.StatusOK {
+			t.Errorf("Private chat was renamed with status %d", status)
+		}
+		if storage.chat(group.ID).Name != "group" || storage.chat(private.ID).Name != "private" {
+			t.Fatalf("Settings changed without the permission")
+		}
+
+		// Test renaming the chat and changing its topic. The server sees the
+		// strings as they come out of JSON.
+		var sent struct{ Name, Topic string }
+		encoded, _ := json.Marshal(map[string]string{"name": name, "topic": topic})
+		json.Unmarshal(encoded, &sent)
+		wantName, wantTopic := strings.TrimSpace(sent.Name), strings.TrimSpace(sent.Topic)
+		valid := wantName != "" && utf8.RuneCountInString(wantName) <= 100 && utf8.RuneCountInString(wantTopic) <= 250
+
+		status, response, _ := apiRequest(t, server, adminCookie, http.MethodPut, chatPath, map[string]string{"name": name, "topic": topic})
+		if !valid {
+			if status != http.StatusBadRequest {
+				t.Errorf("Name %q and topic %q were accepted with status %d", name, topic, status)
+			}
+			readUntilMarker(t, memberConn, "chat_updated", "marker")
+			wantName, wantTopic = "group", ""
+		} else {
+			if status != http.StatusOK {
+				t.Fatalf("Failed to update settings with status %d: %s", status, response.Message)
+			}
+			notification := readUntil(t, memberConn, func(n map[string]interface{}) bool { return n["action"] == "chat_updated" })
+			updated, _ := notification["chat"].(map[string]interface{})
+			if updated["Name"] != wantName || updated["Topic"] != wantTopic {
+				t.Errorf("Unexpected chat_updated notification %v", notification)
+			}
+			if messages := storage.chatMessages(group.ID); wantName != "group" && (len(messages) == 0 || !strings.HasPrefix(messages[0].Content, "admin renamed the chat")) {
+				t.Errorf("Rename was not announced: %+v", messages)
+			}
+		}
+		if chat := storage.chat(group.ID); chat.Name != wantName || chat.Topic != wantTopic {
+			t.Errorf("Stored name %q and topic %q, want %q and %q", chat.Name, chat.Topic, wantName, wantTopic)
+		}
+
+		// Test setting an avatar, and replacing it, which deletes the old file
+		avatarRequest := func(file domain.File) (int, string) {
+			status, response, _ := apiRequest(t, server, adminCookie, http.MethodPut, chatPath, map[string]domain.File{"avatar": file})
+			return status, response.Message
+		}
+		if status, _ := fetch(t, server, memberCookie, avatarPath); status != http.StatusNotFound {
+			t.Errorf("Chat without an avatar served one with status %d", status)
+		}
+		if status, message := avatarRequest(avatarFile("avatar.png", pngAvatar, "image/png")); status != http.StatusOK {
+			t.Fatalf("Failed to set the avatar with status %d: %s", status, message)
+		}
+		first := storage.chat(group.ID).AvatarID
+		if status, data := fetch(t, server, memberCookie, avatarPath); status != http.StatusOK || string(data) != string(pngAvatar) {
+			t.Errorf("Unexpected avatar with status %d", status)
+		}
+		if status, message := avatarRequest(avatarFile("avatar.gif", gifAvatar, "image/gif")); status != http.StatusOK {
+			t.Fatalf("Failed to replace the avatar with status %d: %s", status, message)
+		}
+		second := storage.chat(group.ID).AvatarID
+		if _, err := storage.GetAttachmentByID(first); second == first || err == nil {
+			t.Errorf("Replaced avatar %d was kept", first)
+		}
+		if status, data := fetch(t, server, memberCookie, avatarPath); status != http.StatusOK || string(data) != string(gifAvatar) {
+			t.Errorf("Unexpected replaced avatar with status %d", status)
+		}
+
+		// Test avatars that aren't images, which leave the current one
+		for _, file := range []domain.File{
+			avatarFile("notes.txt", []byte("not an image"), "text/plain"),
+			avatarFile("huge.png", append(pngAvatar, make([]byte, 1<<20)...), "image/png"),
+			{Name: "broken.png", Data: "not a data URL"},
+		} {
+			if status, _ := avatarRequest(file); status != http.StatusBadRequest {
+				t.Errorf("Avatar %s was accepted with status %d", file.Name, status)
+			}
+		}
+		if storage.chat(group.ID).AvatarID != second {
+			t.Errorf("Rejected avatar replaced the current one")
+		}
+
+		// Test removing the avatar
+		if status, message := avatarRequest(domain.File{}); status != http.StatusOK {
+			t.Fatalf("Failed to remove the avatar with status %d: %s", status, message)
+		}
+		if _, err := storage.GetAttachmentByID(second); storage.chat(group.ID).AvatarID != 0 || err == nil {
+			t.Errorf("Removed avatar was kept")
+		}
+		if status, _ := fetch(t, server, memberCookie, avatarPath); status != http.StatusNotFound {
+			t.Errorf("Removed avatar was served with status %d", status)
+		}
+	})
+}
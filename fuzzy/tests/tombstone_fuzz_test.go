@@ -0,0 +1,215 @@
+package tests
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+
+	"chat/internal/domain"
+)
+
+func (s *chatStorage) GetUsernameByMessageID(messageID int) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	message, ok := s.messages[messageID]
+	if !ok {
+		return "", sql.ErrNoRows
+	}
+	return s.users[message.UserID].Username, nil
+}
+
+func (s *chatStorage) GetMessagesByChatID(chatID int) ([]domain.Message, error) {
+	return s.chatMessages(chatID), nil
+}
+
+// DeleteMessage leaves a tombstone without content or attachment
+func (s *chatStorage) DeleteMessage(messageID string, deletedBy int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id, _ := strconv.Atoi(messageID)
+	message, ok := s.messages[id]
+	if !ok || message.DeletedAt != nil {
+		return sql.ErrNoRows
+	}
+	now := time.Now()
+	message.Content = ""
+	message.File = domain.File{}
+	message.AttachmentID = 0
+	message.DeletedAt = &now
+	message.DeletedBy = deletedBy
+	message.DeletedByUsername = s.users[deletedBy].Username
+	return nil
+}
+
+func (s *chatStorage) UpdateMessageContent(messageID string, content string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id, _ := strconv.Atoi(messageID)
+	message, ok := s.messages[id]
+	if !ok || message.DeletedAt != nil {
+		return sql.ErrNoRows
+	}
+	message.Content = content
+	return nil
+}
+
+// GetDeletedMessages lists tombstones of the chat, the latest deleted first
+func (s *chatStorage) GetDeletedMessages(chatID int, limit int, offset int) ([]domain.Message, error) {
+	messages := []domain.Message{}
+	for _, message := range s.chatMessages(chatID) {
+		if message.DeletedAt != nil {
+			messages = append(messages, message)
+		}
+	}
+	sort.SliceStable(messages, func(i, j int) bool { return messages[i].DeletedAt.After(*messages[j].DeletedAt) })
+	return messages, nil
+}
+
+func (s *chatStorage) IsChatMuted(chatID int, userID int) (bool, error) {
+	return false, nil
+}
+
+func (s *chatStorage) UpdateLastChatVisitTime(chatID int, userID int) error {
+	return nil
+}
+
+// auditCount returns how many audit events of the action were recorded
+func (s *chatStorage) auditCount(action string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	count := 0
+	for _, event := range s.audit {
+		if event.Action == action {
+			count++
+		}
+	}
+	return count
+}
+
+// historyMessages returns the messages of the chat as the chat handler shows
+// them to the user
+func historyMessages(response map[string]interface{}) []map[string]interface{} {
+	var messages []map[string]interface{}
+	list, _ := response["messages"].([]interface{})
+	for _, item := range list {
+		message, _ := item.(map[string]interface{})
+		messages = append(messages, message)
+	}
+	return messages
+}
+
+func FuzzTombstone(f *testing.F) {
+	// Add seed corpus
+	f.Add("temporary password: hunter2")
+	f.Add("")
+	f.Add("привет")
+
+	f.Fuzz(func(t *testing.T, content string) {
+		// Initialize test dependencies
+		storage := newChatStorage()
+		owner := storage.addUser(t, domain.User{Username: "owner"})
+		author := storage.addUser(t, domain.User{Username: "author"})
+		member := storage.addUser(t, domain.User{Username: "member"})
+		group := storage.addChat(domain.Chat{Name: "group", Type: domain.ChatTypeGroup}, map[int]domain.ChatRole{
+			owner.ID: domain.ChatRoleOwner, author.ID: domain.ChatRoleMember, member.ID: domain.ChatRoleMember,
+		})
+		other := storage.addChat(domain.Chat{Name: "other", Type: domain.ChatTypeGroup}, map[int]domain.ChatRole{
+			author.ID: domain.ChatRoleOwner, member.ID: domain.ChatRoleMember,
+		})
+		first, _ := storage.InsertMessage(domain.Message{ChatID: group.ID, UserID: author.ID, Username: "author", Content: content,
+			AttachmentID: 7, File: domain.File{Name: "secret.txt"}})
+		second, _ := storage.InsertMessage(domain.Message{ChatID: group.ID, UserID: author.ID, Username: "author", Content: content})
+		_, server := newChatServer(t, storage)
+		ownerCookie, authorCookie, memberCookie := login(t, server, "owner"), login(t, server, "author"), login(t, server, "member")
+		memberConn, otherConn := dialChat(t, server, memberCookie, group.ID), dialChat(t, server, memberCookie, other.ID)
+		deleteRequest := func(messageID int) map[string]string {
+			return map[string]string{"message_id": strconv.Itoa(messageID), "chat_id": strconv.Itoa(group.ID)}
+		}
+
+		// Test deleting a message of another member without the permission
+		if status, _, _ := apiRequest(t, server, memberCookie, http.MethodPost, "/api/delete-message", deleteRequest(first)); status != http.StatusForbidden {
+			t.Errorf("Member deleted a message of another member with status %d", status)
+		}
+
+		// Test deleting an own message while naming another chat
+		elsewhere := map[string]string{"message_id": strconv.Itoa(first), "chat_id": strconv.Itoa(other.ID)}
+		if status, response, _ := apiRequest(t, server, authorCookie, http.MethodPost, "/api/delete-message", elsewhere); status != http.StatusOK {
+			t.Fatalf("Author failed to delete a message with status %d: %s", status, response.Message)
+		}
+		var tombstone domain.Message
+		storage.GetMessageByID(strconv.Itoa(first), &tombstone)
+		if tombstone.DeletedAt == nil || tombstone.DeletedBy != author.ID || tombstone.Content != "" || tombstone.AttachmentID != 0 {
+			t.Errorf("Unexpected tombstone %+v", tombstone)
+		}
+
+		// Verify the deletion is sent along with the tombstone, without the
+		// deleter
+		notification := readUntil(t, memberConn, func(n map[string]interface{}) bool { return n["action"] == "delete" })
+		sent, _ := notification["message"].(map[string]interface{})
+		if notification["id"] != strconv.Itoa(first) || sent == nil || sent["DeletedAt"] == nil || sent["Content"] != "" ||
+			sent["DeletedBy"] != float64(0) || sent["DeletedByUsername"] != "" {
+			t.Errorf("Unexpected delete notification %v", notification)
+		}
+		readUntilMarker(t, otherConn, "delete", "marker")
+		events := storage.webhookEvents(domain.WebhookEventMessageDelete)
+		if len(events) != 1 || events[0].ChatID != group.ID || events[0].Payload["deleted_by"] != "author" {
+			t.Errorf("Unexpected delete webhook events %+v", events)
+		}
+
+		// Test changing a tombstone
+		if status, _, _ := apiRequest(t, server, authorCookie, http.MethodPost, "/api/delete-message", deleteRequest(first)); status != http.StatusNotFound {
+			t.Errorf("Tombstone was deleted again with status %d", status)
+		}
+		edit := map[string]string{"message_id": strconv.Itoa(first), "chat_id": strconv.Itoa(group.ID), "content": "restored"}
+		if status, _, _ := apiRequest(t, server, authorCookie, http.MethodPost, "/api/edit-message", edit); status != http.StatusNotFound {
+			t.Errorf("Tombstone was edited with status %d", status)
+		}
+
+		// Test deleting a message of another member as a chat admin
+		if status, response, _ := apiRequest(t, server, ownerCookie, http.MethodPost, "/api/delete-message", deleteRequest(second)); status != http.StatusOK {
+			t.Fatalf("Owner failed to delete a message with status %d: %s", status, response.Message)
+		}
+		if storage.auditCount(domain.AuditMessageDeleted) != 2 {
+			t.Errorf("Deletions were not audited")
+		}
+
+		// Verify the history shows tombstones, with the deleter only to chat
+		// admins
+		historyPath := fmt.Sprintf("/api/chat/%d", group.ID)
+		for _, viewer := range []struct {
+			cookie   *http.Cookie
+			deleters []string
+		}{
+			{memberCookie, []string{"", ""}},
+			{ownerCookie, []string{"author", "owner"}},
+		} {
+			status, response, _ := apiRequest(t, server, viewer.cookie, http.MethodGet, historyPath, nil)
+			data, _ := response.Data.(map[string]interface{})
+			messages := historyMessages(data)
+			if status != http.StatusOK || len(messages) != 2 {
+				t.Fatalf("Failed to read the history with status %d: %v", status, data)
+			}
+			for i, message := range messages {
+				file, _ := message["File"].(map[string]interface{})
+				if message["DeletedAt"] == nil || message["Content"] != "" || file["Name"] != "" || message["DeletedByUsername"] != viewer.deleters[i] {
+					t.Errorf("Unexpected tombstone in the history: %v", message)
+				}
+			}
+		}
+
+		// Test listing deleted messages
+		deletedPath := fmt.Sprintf("/api/chat/%d/deleted", group.ID)
+		if status, _, _ := apiRequest(t, server, memberCookie, http.MethodGet, deletedPath, nil); status != http.StatusForbidden {
+			t.Errorf("Member listed deleted messages with status %d", status)
+		}
+		status, response, _ := apiRequest(t, server, ownerCookie, http.MethodGet, deletedPath, nil)
+		data, _ := response.Data.(map[string]interface{})
+		if messages := historyMessages(data); status != http.StatusOK || len(messages) != 2 || messages[0]["DeletedByUsername"] != "owner" {
+			t.Errorf("Unexpected deleted messages with status %d: %v", status, data)
+		}
+	})
+}
@@ -0,0 +1,291 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"chat/internal/config"
+	"chat/internal/domain"
+	"chat/internal/service/webhook"
+	"chat/internal/utils"
+)
+
+func FuzzWebhookDelivery(f *testing.F) {
+	// Add seed corpus
+	f.Add(`{"event":"message.new","chat_id":1}`, "secret")
+	f.Add("", "")
+	f.Add(`{"event":"member.joined","data":{"username":"привет"}}`, "very long webhook secret value")
+
+	f.Fuzz(func(t *testing.T, payload, secret string) {
+		// Initialize test dependencies
+		os.Setenv(config.ConfigPathEnvKey, "../../config.yaml")
+		cfg, err := config.NewConfig()
+		if err != nil {
+			t.Fatalf("Failed to create config: %v", err)
+		}
+		publicOnly := webhook.NewService(cfg, nil, nil)
+		cfg.Webhooks.AllowPrivateEndpoints = true
+		webhookService := webhook.NewService(cfg, nil, nil)
+
+		// Receiver verifying the signature of every delivery
+		receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			timestamp, err := strconv.ParseInt(r.Header.Get(webhook.TimestampHeader), 10, 64)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			if r.Header.Get(webhook.SignatureHeader) != webhook.Sign(secret, timestamp, body) || string(body) != payload {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		defer receiver.Close()
+
+		delivery := domain.WebhookDelivery{
+			ID:      1,
+			Event:   domain.WebhookEventMessageNew,
+			Webhook: domain.Webhook{URL: receiver.URL, Secret: secret},
+		}
+
+		// Test that the receiver, listening in the server network, is only
+		// reached when allowed
+		if _, err := publicOnly.Send(context.Background(), delivery, []byte(payload)); !errors.Is(err, utils.ErrPrivateAddress) {
+			t.Errorf("Sent to local receiver %s: %v", receiver.URL, err)
+		}
+
+		// Test signed delivery
+		status, err := webhookService.Send(context.Background(), delivery, []byte(payload))
+
+		// Verify response
+		if err != nil || status != http.StatusNoContent {
+			t.Errorf("Webhook delivery failed with status %d: %v", status, err)
+		}
+
+		// A receiver rejecting the delivery must be reported as a failure
+		delivery.Webhook.Secret = secret + "-wrong"
+		if _, err := webhookService.Send(context.Background(), delivery, []byte(payload)); err == nil {
+			t.Errorf("Webhook delivery with a wrong signature succeeded")
+		}
+	})
+}
+
+// webhookStorage keeps a single delivery in memory and claims it like the
+// database does: a claim counts an attempt and leases the delivery by moving
+// its next attempt past the lease
+type webhookStorage struct {
+	mu          sync.Mutex
+	delivery    domain.WebhookDelivery
+	nextAttempt time.Time
+	leases      []time.Duration
+	delays      []time.Duration // Retry delays of failed attempts
+	final       bool
+}
+
+func (s *webhookStorage) ClaimWebhookDeliveries(limit int, lease time.Duration) ([]domain.WebhookDelivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.delivery.Status != domain.WebhookDeliveryPending || time.Now().Before(s.nextAttempt) {
+		return nil, nil
+	}
+	s.delivery.Attempts++
+	s.nextAttempt = time.Now().Add(lease)
+	s.leases = append(s.leases, lease)
+	return []domain.WebhookDelivery{s.delivery}, nil
+}
+
+func (s *webhookStorage) MarkWebhookDeliveryDelivered(deliveryID int, responseStatus int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.delivery.Status = domain.WebhookDeliveryDelivered
+	s.delivery.ResponseStatus = responseStatus
+	return nil
+}
+
+func (s *webhookStorage) MarkWebhookDeliveryFailed(deliveryID int, responseStatus int, lastError string, nextAttempt time.Time, final bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.delivery.ResponseStatus = responseStatus
+	s.delivery.LastError = lastError
+	s.delays = append(s.delays, time.Until(nextAttempt))
+	s.nextAttempt = nextAttempt
+	if final {
+		s.delivery.Status = domain.WebhookDeliveryFailed
+		s.final = true
+	}
+	return nil
+}
+
+func (s *webhookStorage) done() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.delivery.Status != domain.WebhookDeliveryPending
+}
+
+// plainCipher stores payloads as is
+type plainCipher struct{}
+
+func (plainCipher) Encrypt(plainText string) (string, error) {
+	return plainText, nil
+}
+
+func (plainCipher) Decrypt(cipherText string) (string, error) {
+	return cipherText, nil
+}
+
+func FuzzWebhookRetry(f *testing.F) {
+	// Add seed corpus
+	f.Add(uint8(0), uint8(3))
+	f.Add(uint8(2), uint8(8))
+	f.Add(uint8(3), uint8(3))
+	f.Add(uint8(7), uint8(2))
+
+	f.Fuzz(func(t *testing.T, failures, maxAttempts uint8) {
+		failures %= 8
+		maxAttempts = 1 + maxAttempts%8
+
+		// Initialize test dependencies
+		os.Setenv(config.ConfigPathEnvKey, "../../config.yaml")
+		cfg, err := config.NewConfig()
+		if err != nil {
+			t.Fatalf("Failed to create config: %v", err)
+		}
+		cfg.Webhooks.PollInterval = time.Millisecond
+		cfg.Webhooks.RetryBackoff = 2 * time.Millisecond
+		cfg.Webhooks.MaxAttempts = int(maxAttempts)
+		cfg.Webhooks.AllowPrivateEndpoints = true
+
+		// Receiver failing the first deliveries
+		var requests atomic.Int32
+		receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if requests.Add(1) <= int32(failures) {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		defer receiver.Close()
+
+		storage := &webhookStorage{delivery: domain.WebhookDelivery{
+			ID:      1,
+			Event:   domain.WebhookEventMessageNew,
+			Payload: `{"event":"message.new"}`,
+			Status:  domain.WebhookDeliveryPending,
+			Webhook: domain.Webhook{URL: receiver.URL, Secret: "secret"},
+		}}
+
+		// Two workers share the queue, the lease keeps them from sending the
+		// same attempt twice
+		ctx, cancel := context.WithCancel(context.Background())
+		var workers sync.WaitGroup
+		for range 2 {
+			workers.Add(1)
+			go func() {
+				defer workers.Done()
+				webhook.NewService(cfg, storage, plainCipher{}).Run(ctx)
+			}()
+		}
+		deadline := time.Now().Add(10 * time.Second)
+		for !storage.done() && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+		cancel()
+		workers.Wait()
+
+		// Verify the outcome
+		attempts := min(int(failures)+1, int(maxAttempts))
+		if int(requests.Load()) != attempts || storage.delivery.Attempts != attempts {
+			t.Fatalf("Made %d requests in %d attempts, want %d", requests.Load(), storage.delivery.Attempts, attempts)
+		}
+		if int(failures) < int(maxAttempts) {
+			if storage.delivery.Status != domain.WebhookDeliveryDelivered || storage.delivery.ResponseStatus != http.StatusNoContent {
+				t.Errorf("Delivery ended as %q with status %d", storage.delivery.Status, storage.delivery.ResponseStatus)
+			}
+		} else if !storage.final || storage.delivery.ResponseStatus != http.StatusServiceUnavailable {
+			t.Errorf("Delivery isn't marked as failed after %d attempts", attempts)
+		}
+
+		for _, lease := range storage.leases {
+			if lease < cfg.Webhooks.Timeout {
+				t.Errorf("Lease %v is shorter than a request", lease)
+			}
+		}
+		// The retry delay doubles with every failed attempt
+		for i, delay := range storage.delays {
+			backoff := cfg.Webhooks.RetryBackoff << i
+			if delay > backoff || delay < backoff/2 {
+				t.Errorf("Retry delay %v after attempt %d, want %v", delay, i+1, backoff)
+			}
+		}
+	})
+}
+
+func (s *chatStorage) InsertWebhook(webhook domain.Webhook) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.webhooks = append(s.webhooks, webhook)
+	return len(s.webhooks), nil
+}
+
+func FuzzWebhookURL(f *testing.F) {
+	// Add seed corpus
+	f.Add("https://example.com/hook")
+	f.Add("http://169.254.169.254/latest/meta-data")
+	f.Add("http://[::1]:8080/")
+	f.Add("ftp://example.com/")
+	f.Add("http://LOCALHOST./")
+
+	f.Fuzz(func(t *testing.T, rawURL string) {
+		// Initialize test dependencies
+		storage := newChatStorage()
+		owner := storage.addUser(t, domain.User{Username: "owner"})
+		group := storage.addChat(domain.Chat{Name: "group", Type: domain.ChatTypeGroup}, map[int]domain.ChatRole{
+			owner.ID: domain.ChatRoleOwner,
+		})
+		_, server := newChatServer(t, storage)
+		ownerCookie := login(t, server, "owner")
+		webhooksPath := fmt.Sprintf("/api/chat/%d/webhooks", group.ID)
+		create := func(rawURL string) (int, string) {
+			status, response, _ := apiRequest(t, server, ownerCookie, http.MethodPost, webhooksPath, map[string]interface{}{
+				"url": rawURL, "events": []string{domain.WebhookEventMessageNew},
+			})
+			return status, response.Message
+		}
+
+		// Test that hosts of the server network are refused
+		for _, private := range []string{
+			"http://127.0.0.1/hook", "https://10.0.0.1/hook", "http://169.254.169.254/latest/meta-data",
+			"http://[::1]/hook", "http://[::ffff:192.168.0.1]/hook", "http://localhost./hook", "http://100.64.0.1/hook",
+		} {
+			if status, message := create(private); status != http.StatusBadRequest {
+				t.Errorf("Webhook to %s was created with status %d: %s", private, status, message)
+			}
+		}
+		if status, message := create("https://example.com/hook"); status != http.StatusCreated {
+			t.Fatalf("Failed to create a webhook to a public host with status %d: %s", status, message)
+		}
+
+		// Verify no fuzzed URL gets a webhook into the server network
+		created := len(storage.webhooks)
+		if status, _ := create(rawURL); status == http.StatusCreated {
+			if target, err := url.Parse(storage.webhooks[created].URL); err != nil || utils.PrivateHost(target.Hostname()) {
+				t.Errorf("Webhook to %q was created", rawURL)
+			}
+		}
+	})
+}
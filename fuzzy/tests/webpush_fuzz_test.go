@@ -0,0 +1,264 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"chat/internal/config"
+	"chat/internal/domain"
+	"chat/internal/service/webpush"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// pushRequest is what the push service stand-in received
+type pushRequest struct {
+	header http.Header
+	body   []byte
+}
+
+// pushStandIn accepts pushes on a local port like a browser push service.
+// Subscriptions under /gone answer 410, as for a browser that unsubscribed.
+func pushStandIn() (*httptest.Server, <-chan pushRequest) {
+	received := make(chan pushRequest, 2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- pushRequest{header: r.Header, body: body}
+		if strings.HasPrefix(r.URL.Path, "/gone") {
+			w.WriteHeader(http.StatusGone)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	return server, received
+}
+
+// pushStorage keeps the subscriptions of a single user in memory
+type pushStorage struct {
+	subs    []domain.PushSubscription
+	deleted []string
+}
+
+func (s *pushStorage) GetPushSubscriptionsByUserID(userID int) ([]domain.PushSubscription, error) {
+	return s.subs, nil
+}
+
+func (s *pushStorage) DeletePushSubscriptionByEndpoint(endpoint string) error {
+	s.deleted = append(s.deleted, endpoint)
+	return nil
+}
+
+// decryptPush does what the browser does with an aes128gcm body
+func decryptPush(t *testing.T, key *ecdh.PrivateKey, auth []byte, body []byte) []byte {
+	if len(body) < 86 || body[20] != 65 {
+		t.Fatalf("Malformed body header of %d bytes", len(body))
+	}
+	salt := body[:16]
+	if rs := binary.BigEndian.Uint32(body[16:20]); rs != 4096 {
+		t.Errorf("Unexpected record size %d", rs)
+	}
+	serverKey, err := ecdh.P256().NewPublicKey(body[21:86])
+	if err != nil {
+		t.Fatalf("Invalid server key: %v", err)
+	}
+	secret, err := key.ECDH(serverKey)
+	if err != nil {
+		t.Fatalf("Failed to derive secret: %v", err)
+	}
+
+	expand := func(secret, salt, info []byte, length int) []byte {
+		out := make([]byte, length)
+		io.ReadFull(hkdf.New(sha256.New, secret, salt, info), out)
+		return out
+	}
+	info := append([]byte("WebPush: info\x00"), key.PublicKey().Bytes()...)
+	info = append(info, serverKey.Bytes()...)
+	ikm := expand(secret, auth, info, 32)
+	block, _ := aes.NewCipher(expand(ikm, salt, []byte("Content-Encoding: aes128gcm\x00"), 16))
+	gcm, _ := cipher.NewGCM(block)
+
+	plaintext, err := gcm.Open(nil, expand(ikm, salt, []byte("Content-Encoding: nonce\x00"), 12), body[86:], nil)
+	if err != nil {
+		t.Fatalf("Failed to decrypt payload: %v", err)
+	}
+	padding := bytes.TrimRight(plaintext, "\x00")
+	if len(padding) == 0 || padding[len(padding)-1] != 2 {
+		t.Fatalf("Payload doesn't end with the last record delimiter")
+	}
+	return padding[:len(padding)-1]
+}
+
+// verifyVAPID checks the ES256 signature of the VAPID token and returns its
+// claims
+func verifyVAPID(t *testing.T, authorization string, publicKey string) map[string]interface{} {
+	var token, key string
+	for _, param := range strings.Split(strings.TrimPrefix(authorization, "vapid "), ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+		switch name {
+		case "t":
+			token = value
+		case "k":
+			key = value
+		}
+	}
+	if key != publicKey {
+		t.Fatalf("Authorization carries key %q, want %q", key, publicKey)
+	}
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		t.Fatalf("Malformed token %q", token)
+	}
+	point, _ := base64.RawURLEncoding.DecodeString(key)
+	x, y := elliptic.Unmarshal(elliptic.P256(), point) //nolint:staticcheck // uncompressed point
+	signature, _ := base64.RawURLEncoding.DecodeString(parts[2])
+	hash := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if x == nil || len(signature) != 64 || !ecdsa.Verify(
+		&ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y},
+		hash[:],
+		new(big.Int).SetBytes(signature[:32]),
+		new(big.Int).SetBytes(signature[32:]),
+	) {
+		t.Fatalf("Invalid token signature")
+	}
+
+	claims := make(map[string]interface{})
+	data, _ := base64.RawURLEncoding.DecodeString(parts[1])
+	if err := json.Unmarshal(data, &claims); err != nil {
+		t.Fatalf("Failed to decode claims: %v", err)
+	}
+	return claims
+}
+
+func FuzzWebPush(f *testing.F) {
+	// Add seed corpus
+	f.Add([]byte(`{"chat_id":1,"message_id":2,"username":"ivanov","content":"Привет","mention":true}`))
+	f.Add([]byte(""))
+	f.Add([]byte("\x00\x02\x00"))
+	f.Add(bytes.Repeat([]byte("a"), webpush.MaxPayloadSize))
+	f.Add(bytes.Repeat([]byte("a"), webpush.MaxPayloadSize+1))
+
+	f.Fuzz(func(t *testing.T, payload []byte) {
+		// Initialize test dependencies
+		os.Setenv(config.ConfigPathEnvKey, "../../config.yaml")
+		cfg, err := config.NewConfig()
+		if err != nil {
+			t.Fatalf("Failed to create config: %v", err)
+		}
+		publicKey, privateKey, err := webpush.GenerateVAPIDKeys()
+		if err != nil {
+			t.Fatalf("Failed to generate VAPID keys: %v", err)
+		}
+		cfg.WebPush.VAPIDPublicKey = publicKey
+		cfg.WebPush.VAPIDPrivateKey = privateKey
+
+		server, received := pushStandIn()
+		defer server.Close()
+
+		browserKey, err := ecdh.P256().GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatalf("Failed to generate browser key: %v", err)
+		}
+		auth := make([]byte, 16)
+		rand.Read(auth)
+		sub := domain.PushSubscription{
+			Endpoint: server.URL + "/push/1",
+			P256dh:   base64.RawURLEncoding.EncodeToString(browserKey.PublicKey().Bytes()),
+			Auth:     base64.RawURLEncoding.EncodeToString(auth),
+		}
+		gone := sub
+		gone.Endpoint = server.URL + "/gone/1"
+
+		storage := &pushStorage{subs: []domain.PushSubscription{sub, gone}}
+
+		// Test that endpoints in the server network are refused
+		publicOnly, err := webpush.NewService(cfg, storage)
+		if err != nil {
+			t.Fatalf("Failed to create service: %v", err)
+		}
+		if _, err := publicOnly.Send(context.Background(), sub, nil); err == nil {
+			t.Errorf("Sent to local endpoint %s", sub.Endpoint)
+		}
+		for _, endpoint := range []string{
+			"https://127.0.0.1/push", "https://10.0.0.1/push", "https://169.254.169.254/latest",
+			"https://[::1]/push", "https://[::ffff:192.168.0.1]/push", "https://localhost./push",
+		} {
+			private := sub
+			private.Endpoint = endpoint
+			if err := publicOnly.ValidateSubscription(private); err == nil {
+				t.Errorf("Endpoint %s accepted", endpoint)
+			}
+		}
+		public := sub
+		public.Endpoint = "https://fcm.googleapis.com/fcm/send/1"
+		if err := publicOnly.ValidateSubscription(public); err != nil {
+			t.Errorf("Endpoint %s rejected: %v", public.Endpoint, err)
+		}
+
+		// The push service stand-in listens on a local port
+		cfg.WebPush.AllowPrivateEndpoints = true
+		pushService, err := webpush.NewService(cfg, storage)
+		if err != nil {
+			t.Fatalf("Failed to create service: %v", err)
+		}
+		if pushService.PublicKey() != publicKey {
+			t.Fatalf("Service public key %q, want %q", pushService.PublicKey(), publicKey)
+		}
+
+		// Test sending the payload
+		status, err := pushService.Send(context.Background(), sub, payload)
+		if len(payload) > webpush.MaxPayloadSize {
+			if err == nil {
+				t.Fatalf("Payload of %d bytes sent", len(payload))
+			}
+			return
+		}
+		if err != nil || status != http.StatusCreated {
+			t.Fatalf("Failed to send payload: %d %v", status, err)
+		}
+
+		// Verify the received push
+		req := <-received
+		if len(req.body) > 4096 {
+			t.Errorf("Body of %d bytes exceeds a record", len(req.body))
+		}
+		if decrypted := decryptPush(t, browserKey, auth, req.body); !bytes.Equal(decrypted, payload) {
+			t.Errorf("Decrypted %q, want %q", decrypted, payload)
+		}
+		if encoding := req.header.Get("Content-Encoding"); encoding != "aes128gcm" {
+			t.Errorf("Unexpected Content-Encoding %q", encoding)
+		}
+		if req.header.Get("TTL") == "" {
+			t.Errorf("TTL header is missing")
+		}
+		claims := verifyVAPID(t, req.header.Get("Authorization"), publicKey)
+		if claims["aud"] != server.URL || claims["sub"] != cfg.WebPush.Subject {
+			t.Errorf("Unexpected claims %v", claims)
+		}
+
+		// Test that subscriptions gone from the push service are deleted
+		pushService.Notify(context.Background(), 1, payload)
+		<-received
+		<-received
+		if len(storage.deleted) != 1 || storage.deleted[0] != gone.Endpoint {
+			t.Errorf("Deleted subscriptions %v, want %q", storage.deleted, gone.Endpoint)
+		}
+	})
+}
@@ -6,15 +6,71 @@ CREATE TABLE IF NOT EXISTS users (
     patronymic TEXT NOT NULL,
     password TEXT NOT NULL,
     status TEXT NOT NULL DEFAULT 'offline',
-    last_active TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+    last_active TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    is_admin BOOLEAN NOT NULL DEFAULT false,
+    deactivated_at TIMESTAMP,
+    deleted_at TIMESTAMP,
+    session_version INT NOT NULL DEFAULT 0
+);
+
+-- Usernames of deleted users, which can't be registered again
+CREATE TABLE IF NOT EXISTS reserved_usernames (
+    username TEXT PRIMARY KEY,
+    reserved_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS password_reset_tokens (
+    id SERIAL PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    token_hash TEXT NOT NULL UNIQUE,
+    created_by INT REFERENCES users(id) ON DELETE SET NULL,
+    expires_at TIMESTAMP NOT NULL,
+    used_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS email_settings (
+    user_id INT PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    email TEXT NOT NULL,
+    enabled BOOLEAN NOT NULL DEFAULT true,
+    mentions_only BOOLEAN NOT NULL DEFAULT false,
+    delay_minutes INT CHECK (delay_minutes > 0),
+    unsubscribe_token TEXT NOT NULL UNIQUE,
+    last_sent_at TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS push_subscriptions (
+    id SERIAL PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    endpoint TEXT NOT NULL UNIQUE,
+    p256dh TEXT NOT NULL,
+    auth TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS push_subscriptions_user_idx ON push_subscriptions (user_id);
+
+CREATE TABLE IF NOT EXISTS attachments (
+    id SERIAL PRIMARY KEY,
+    name TEXT NOT NULL,
+    data TEXT NOT NULL,
+    uploader_id INT REFERENCES users(id),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 );
 
 CREATE TABLE IF NOT EXISTS chats (
     id SERIAL PRIMARY KEY,
     name TEXT NOT NULL,
-    is_private BOOLEAN NOT NULL,
+    type TEXT NOT NULL DEFAULT 'group' CHECK (type IN ('private', 'group', 'channel')),
+    is_public BOOLEAN NOT NULL DEFAULT false,
+    topic TEXT NOT NULL DEFAULT '',
+    description TEXT NOT NULL DEFAULT '',
+    avatar_id INT REFERENCES attachments(id) ON DELETE SET NULL,
+    message_ttl INT NOT NULL DEFAULT 0 CHECK (message_ttl >= 0),
+    retention_days INT CHECK (retention_days >= 0),
     creator_id INT REFERENCES users(id),
-    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    import_key TEXT UNIQUE
 );
 
 CREATE TABLE IF NOT EXISTS messages (
@@ -23,13 +79,212 @@ CREATE TABLE IF NOT EXISTS messages (
     user_id INT REFERENCES users(id),
     content TEXT NOT NULL,
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-    file_name TEXT,
-    file_content TEXT
+    attachment_id INT REFERENCES attachments(id) ON DELETE SET NULL,
+    is_system BOOLEAN NOT NULL DEFAULT false,
+    forwarded_from_message_id INT REFERENCES messages(id) ON DELETE SET NULL,
+    forwarded_from_chat_id INT REFERENCES chats(id) ON DELETE SET NULL,
+    forwarded_from_user_id INT REFERENCES users(id) ON DELETE SET NULL,
+    expires_at TIMESTAMP,
+    deleted_at TIMESTAMP,
+    deleted_by INT REFERENCES users(id) ON DELETE SET NULL,
+    import_key TEXT UNIQUE
+);
+
+CREATE INDEX IF NOT EXISTS messages_expires_idx ON messages (expires_at) WHERE expires_at IS NOT NULL;
+CREATE INDEX IF NOT EXISTS messages_chat_created_idx ON messages (chat_id, created_at);
+
+CREATE TABLE IF NOT EXISTS messages_archive (
+    id INT PRIMARY KEY,
+    chat_id INT REFERENCES chats(id),
+    user_id INT REFERENCES users(id),
+    content TEXT NOT NULL,
+    created_at TIMESTAMP,
+    attachment_id INT REFERENCES attachments(id) ON DELETE SET NULL,
+    is_system BOOLEAN NOT NULL DEFAULT false,
+    forwarded_from_message_id INT,
+    forwarded_from_chat_id INT REFERENCES chats(id) ON DELETE SET NULL,
+    forwarded_from_user_id INT REFERENCES users(id) ON DELETE SET NULL,
+    deleted_at TIMESTAMP,
+    deleted_by INT REFERENCES users(id) ON DELETE SET NULL,
+    archived_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 );
 
 CREATE TABLE IF NOT EXISTS chat_users (
     chat_id INT REFERENCES chats(id),
     user_id INT REFERENCES users(id),
     last_chat_visit TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP, 
+    role TEXT NOT NULL DEFAULT 'member' CHECK (role IN ('owner', 'admin', 'member')),
+    muted BOOLEAN NOT NULL DEFAULT false,
     PRIMARY KEY (chat_id, user_id)
 );
+
+-- Membership history for legal holds and eDiscovery: chat_users only keeps
+-- current members
+CREATE TABLE IF NOT EXISTS chat_membership_periods (
+    chat_id INT NOT NULL REFERENCES chats(id) ON DELETE CASCADE,
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    joined_at TIMESTAMP NOT NULL,
+    left_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS chat_membership_periods_user_idx ON chat_membership_periods (user_id, chat_id);
+
+CREATE OR REPLACE FUNCTION chat_users_track_membership() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'INSERT' THEN
+        INSERT INTO chat_membership_periods (chat_id, user_id, joined_at) VALUES (NEW.chat_id, NEW.user_id, NOW());
+        RETURN NEW;
+    END IF;
+    UPDATE chat_membership_periods SET left_at = NOW()
+    WHERE chat_id = OLD.chat_id AND user_id = OLD.user_id AND left_at IS NULL;
+    RETURN OLD;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE TRIGGER chat_users_membership
+    AFTER INSERT OR DELETE ON chat_users
+    FOR EACH ROW EXECUTE FUNCTION chat_users_track_membership();
+
+CREATE TABLE IF NOT EXISTS message_mentions (
+    message_id INT REFERENCES messages(id) ON DELETE CASCADE,
+    chat_id INT REFERENCES chats(id) ON DELETE CASCADE,
+    user_id INT REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (message_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS message_mentions_user_idx ON message_mentions (user_id, created_at);
+
+CREATE TABLE IF NOT EXISTS message_search_tokens (
+    message_id INT REFERENCES messages(id) ON DELETE CASCADE,
+    token TEXT NOT NULL,
+    PRIMARY KEY (token, message_id)
+);
+
+CREATE INDEX IF NOT EXISTS message_search_tokens_message_idx ON message_search_tokens (message_id);
+
+CREATE TABLE IF NOT EXISTS pinned_messages (
+    chat_id INT REFERENCES chats(id) ON DELETE CASCADE,
+    message_id INT REFERENCES messages(id) ON DELETE CASCADE,
+    pinned_by INT REFERENCES users(id),
+    pinned_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (chat_id, message_id)
+);
+
+CREATE TABLE IF NOT EXISTS scheduled_messages (
+    id SERIAL PRIMARY KEY,
+    chat_id INT REFERENCES chats(id) ON DELETE CASCADE,
+    user_id INT REFERENCES users(id),
+    content TEXT NOT NULL,
+    attachment_id INT REFERENCES attachments(id) ON DELETE SET NULL,
+    send_at TIMESTAMP NOT NULL,
+    status TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'sent', 'canceled', 'failed')),
+    message_id INT REFERENCES messages(id) ON DELETE SET NULL,
+    delivered_at TIMESTAMP,
+    delivery_lease_until TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS scheduled_messages_due_idx ON scheduled_messages (send_at) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS scheduled_messages_undelivered_idx ON scheduled_messages (delivery_lease_until)
+    WHERE status = 'sent' AND delivered_at IS NULL;
+
+CREATE TABLE IF NOT EXISTS chat_invites (
+    id SERIAL PRIMARY KEY,
+    chat_id INT REFERENCES chats(id) ON DELETE CASCADE,
+    token TEXT NOT NULL UNIQUE,
+    creator_id INT REFERENCES users(id),
+    max_uses INT,
+    uses INT NOT NULL DEFAULT 0,
+    expires_at TIMESTAMP,
+    revoked_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS webhooks (
+    id SERIAL PRIMARY KEY,
+    chat_id INT REFERENCES chats(id) ON DELETE CASCADE,
+    creator_id INT REFERENCES users(id),
+    url TEXT NOT NULL,
+    secret TEXT NOT NULL,
+    events TEXT[] NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    id SERIAL PRIMARY KEY,
+    webhook_id INT REFERENCES webhooks(id) ON DELETE CASCADE,
+    event TEXT NOT NULL,
+    payload TEXT NOT NULL,
+    status TEXT NOT NULL DEFAULT 'pending',
+    attempts INT NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    response_status INT,
+    last_error TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    delivered_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS webhook_deliveries_pending_idx
+    ON webhook_deliveries (next_attempt_at) WHERE status = 'pending';
+
+CREATE TABLE IF NOT EXISTS legal_holds (
+    id SERIAL PRIMARY KEY,
+    user_id INT REFERENCES users(id),
+    chat_id INT REFERENCES chats(id),
+    reason TEXT NOT NULL,
+    created_by INT REFERENCES users(id),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    released_by INT REFERENCES users(id),
+    released_at TIMESTAMP,
+    CHECK ((user_id IS NULL) <> (chat_id IS NULL))
+);
+
+CREATE TABLE IF NOT EXISTS ediscovery_exports (
+    id SERIAL PRIMARY KEY,
+    requested_by INT REFERENCES users(id),
+    user_ids INT[] NOT NULL DEFAULT '{}',
+    chat_ids INT[] NOT NULL DEFAULT '{}',
+    from_time TIMESTAMP,
+    to_time TIMESTAMP,
+    status TEXT NOT NULL DEFAULT 'running' CHECK (status IN ('running', 'completed', 'failed')),
+    message_count INT NOT NULL DEFAULT 0,
+    sha256 TEXT NOT NULL DEFAULT '',
+    error TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    completed_at TIMESTAMP
+);
+
+-- Журнал аудита только дополняется: actor_id и chat_id без внешних ключей,
+-- чтобы удаление пользователей и чатов не меняло записи
+CREATE TABLE IF NOT EXISTS audit_events (
+    id BIGSERIAL PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    actor_id INT,
+    actor_username TEXT NOT NULL DEFAULT '',
+    action TEXT NOT NULL,
+    chat_id INT,
+    target TEXT NOT NULL DEFAULT '',
+    details JSON NOT NULL DEFAULT '{}',
+    ip TEXT NOT NULL DEFAULT '',
+    prev_hash TEXT NOT NULL,
+    hash TEXT NOT NULL UNIQUE
+);
+
+CREATE INDEX IF NOT EXISTS audit_events_created_idx ON audit_events (created_at);
+CREATE INDEX IF NOT EXISTS audit_events_actor_idx ON audit_events (actor_username, created_at);
+CREATE INDEX IF NOT EXISTS audit_events_chat_idx ON audit_events (chat_id, created_at) WHERE chat_id IS NOT NULL;
+
+CREATE OR REPLACE FUNCTION audit_events_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_events is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE TRIGGER audit_events_no_update
+    BEFORE UPDATE OR DELETE ON audit_events
+    FOR EACH ROW EXECUTE FUNCTION audit_events_append_only();
+
+CREATE OR REPLACE TRIGGER audit_events_no_truncate
+    BEFORE TRUNCATE ON audit_events
+    FOR EACH STATEMENT EXECUTE FUNCTION audit_events_append_only();
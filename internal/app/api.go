@@ -2,10 +2,14 @@ package app
 
 import (
 	"chat/internal/domain"
+	"chat/internal/utils"
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gorilla/mux"
@@ -36,21 +40,22 @@ type RegisterRequest struct {
 
 // Create chat request structure
 type CreateChatRequest struct {
-	Name    string `json:"name"`
-	UserIDs []int  `json:"user_ids"`
+	Name     string `json:"name"`
+	UserIDs  []int  `json:"user_ids"`
+	IsPublic bool   `json:"is_public"`
 }
 
 // Edit message request structure
 type EditMessageRequest struct {
 	MessageID string `json:"message_id"`
 	Content   string `json:"content"`
-	ChatID    string `json:"chat_id"`
+	ChatID    string `json:"chat_id"` // Ignored, the edit goes to the chat of the message
 }
 
 // Delete message request structure
 type DeleteMessageRequest struct {
 	MessageID string `json:"message_id"`
-	ChatID    string `json:"chat_id"`
+	ChatID    string `json:"chat_id"` // Ignored, the deletion goes to the chat of the message
 }
 
 // Send JSON response helper
@@ -74,6 +79,9 @@ func (a *App) apiLoginHandler(w http.ResponseWriter, r *http.Request) {
 	user, err := a.storage.GetUserByUsername(req.Username)
 	if err != nil {
 		log.Printf("apiLoginHandler: storage.GetUserByUsername: %v", err)
+		a.audit(r, domain.AuditEvent{ActorUsername: req.Username, Action: domain.AuditLoginFailed}, map[string]interface{}{
+			"reason": "unknown user",
+		})
 		sendJSONResponse(w, http.StatusUnauthorized, APIResponse{
 			Success: false,
 			Message: "Invalid credentials",
@@ -84,6 +92,9 @@ func (a *App) apiLoginHandler(w http.ResponseWriter, r *http.Request) {
 	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password))
 	if err != nil {
 		log.Printf("apiLoginHandler: bcrypt.CompareHashAndPassword: %v", err)
+		a.audit(r, actorEvent(user, domain.AuditLoginFailed), map[string]interface{}{
+			"reason": "wrong password",
+		})
 		sendJSONResponse(w, http.StatusUnauthorized, APIResponse{
 			Success: false,
 			Message: "Invalid credentials",
@@ -91,8 +102,33 @@ func (a *App) apiLoginHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if user.DeactivatedAt != nil {
+		a.audit(r, actorEvent(user, domain.AuditLoginFailed), map[string]interface{}{
+			"reason": "deactivated",
+		})
+		sendJSONResponse(w, http.StatusForbidden, APIResponse{
+			Success: false,
+			Message: "Account is deactivated",
+		})
+		return
+	}
+
+	// The id tells connections of this session from those of others
+	sessionID, err := utils.RandomToken(16)
+	if err != nil {
+		log.Printf("apiLoginHandler: utils.RandomToken: %v", err)
+		sendJSONResponse(w, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Message: "Error saving session",
+		})
+		return
+	}
+
 	session, _ := a.memory.GetSession(r, "session-name")
+	session.Values["session_id"] = sessionID
 	session.Values["username"] = req.Username
+	session.Values["user_id"] = user.ID
+	session.Values["session_version"] = user.SessionVersion
 	err = session.Save(r, w)
 	if err != nil {
 		log.Printf("apiLoginHandler: session.Save: %v", err)
@@ -103,6 +139,8 @@ func (a *App) apiLoginHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	a.audit(r, actorEvent(user, domain.AuditLoginSucceeded), nil)
+
 	err = a.storage.UpdateUserStatus(req.Username, "online")
 	if err != nil {
 		log.Printf("apiLoginHandler: storage.UpdateUserStatus: %v", err)
@@ -138,10 +176,30 @@ func (a *App) apiRegisterHandler(w http.ResponseWriter, r *http.Request) {
 		})
 		return
 	}
+	if !a.validatePassword(w, req.Password) {
+		return
+	}
 
-	// Check if username already exists
-	_, err := a.storage.GetUserByUsername(req.Username)
-	if err == nil {
+	if strings.HasPrefix(req.Username, deletedUsernamePrefix) {
+		sendJSONResponse(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: "Usernames starting with " + deletedUsernamePrefix + " are reserved",
+		})
+		return
+	}
+
+	// Check if username already exists or belonged to a deleted user
+	reserved, err := a.storage.UsernameReserved(req.Username)
+	if err != nil {
+		log.Printf("apiRegisterHandler: storage.UsernameReserved: %v", err)
+		sendJSONResponse(w, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Message: "Error processing request",
+		})
+		return
+	}
+	_, err = a.storage.GetUserByUsername(req.Username)
+	if err == nil || reserved {
 		sendJSONResponse(w, http.StatusConflict, APIResponse{
 			Success: false,
 			Message: "Username already exists",
@@ -181,6 +239,8 @@ func (a *App) apiRegisterHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	a.audit(r, domain.AuditEvent{ActorUsername: user.Username, Action: domain.AuditUserRegistered}, nil)
+
 	sendJSONResponse(w, http.StatusCreated, APIResponse{
 		Success: true,
 		Message: "User registered successfully",
@@ -191,11 +251,12 @@ func (a *App) apiRegisterHandler(w http.ResponseWriter, r *http.Request) {
 func (a *App) apiLogoutHandler(w http.ResponseWriter, r *http.Request) {
 	session, _ := a.memory.GetSession(r, "session-name")
 	username, ok := session.Values["username"].(string)
-	if ok {
+	if ok && a.isAuthenticated(r) {
 		err := a.storage.UpdateUserStatus(username, "offline")
 		if err != nil {
 			log.Printf("apiLogoutHandler: storage.UpdateUserStatus: %v", err)
 		}
+		a.audit(r, domain.AuditEvent{ActorUsername: username, Action: domain.AuditLogout}, nil)
 	}
 
 	session.Values["username"] = nil
@@ -249,12 +310,21 @@ func (a *App) apiChatsHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Count unread messages for each chat
+	// Count unread messages and mentions for each chat. Muted chats count
+	// only mentions.
 	for i, chat := range chats {
-		chats[i].UnreadMessageCount, err = a.storage.CountUnreadMessages(chat.ID, user.ID, chat.LastVisit)
+		if !chat.Muted {
+			chats[i].UnreadMessageCount, err = a.storage.CountUnreadMessages(chat.ID, user.ID, chat.LastVisit)
+			if err != nil {
+				log.Printf("apiChatsHandler: storage.CountUnreadMessages: %v", err)
+				chats[i].UnreadMessageCount = 0
+			}
+		}
+
+		chats[i].UnreadMentionCount, err = a.storage.CountUnreadMentions(chat.ID, user.ID, chat.LastVisit)
 		if err != nil {
-			log.Printf("apiChatsHandler: storage.CountUnreadMessages: %v", err)
-			chats[i].UnreadMessageCount = 0
+			log.Printf("apiChatsHandler: storage.CountUnreadMentions: %v", err)
+			chats[i].UnreadMentionCount = 0
 		}
 	}
 
@@ -305,7 +375,7 @@ func (a *App) apiChatHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	chat, err := a.storage.GetChatByID(chatID)
-	if err != nil {
+	if err != nil || chat == nil {
 		log.Printf("apiChatHandler: storage.GetChatByID: %v", err)
 		sendJSONResponse(w, http.StatusNotFound, APIResponse{
 			Success: false,
@@ -314,6 +384,23 @@ func (a *App) apiChatHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	isMember, err := a.storage.IsChatMember(chatID, user.ID)
+	if err != nil {
+		log.Printf("apiChatHandler: storage.IsChatMember: %v", err)
+		sendJSONResponse(w, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Message: "Error checking chat membership",
+		})
+		return
+	}
+	if !isMember {
+		sendJSONResponse(w, http.StatusForbidden, APIResponse{
+			Success: false,
+			Message: "You are not a member of this chat",
+		})
+		return
+	}
+
 	messages, err := a.storage.GetMessagesByChatID(chatID)
 	if err != nil {
 		log.Printf("apiChatHandler: storage.GetMessagesByChatID: %v", err)
@@ -324,8 +411,16 @@ func (a *App) apiChatHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Who deleted a message is shown only to those who may delete messages
+	canSeeDeleters := a.hasChatPermission(chatID, username, domain.PermissionDeleteMessages)
+
 	// Decrypt message content
 	for i := range messages {
+		if messages[i].DeletedAt != nil {
+			redactTombstone(&messages[i], canSeeDeleters)
+			continue
+		}
+
 		decryptedContent, err := a.cipher.Decrypt(messages[i].Content)
 		if err != nil {
 			log.Printf("apiChatHandler: cipher.Decrypt: %v", err)
@@ -345,6 +440,11 @@ func (a *App) apiChatHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	muted, err := a.storage.IsChatMuted(chatID, user.ID)
+	if err != nil {
+		log.Printf("apiChatHandler: storage.IsChatMuted: %v", err)
+	}
+
 	// Update last visit time
 	err = a.storage.UpdateLastChatVisitTime(chatID, user.ID)
 	if err != nil {
@@ -357,6 +457,7 @@ func (a *App) apiChatHandler(w http.ResponseWriter, r *http.Request) {
 			"chat":     chat,
 			"messages": messages,
 			"members":  members,
+			"muted":    muted,
 			"user_id":  user.ID,
 			"username": user.Username,
 		},
@@ -426,7 +527,7 @@ func (a *App) apiCreatePrivateChatHandler(w http.ResponseWriter, r *http.Request
 	// Create new chat
 	chat := domain.Chat{
 		Name:      otherUser.Username, // Use other user's username as chat name
-		IsPrivate: true,
+		Type:      domain.ChatTypePrivate,
 		CreatorID: currentUserID,
 		CreatedAt: time.Now(),
 	}
@@ -442,7 +543,7 @@ func (a *App) apiCreatePrivateChatHandler(w http.ResponseWriter, r *http.Request
 	}
 
 	// Add both users to the chat
-	err = a.storage.AddUserToChat(chatID, currentUserID)
+	err = a.storage.AddUserToChat(chatID, currentUserID, domain.ChatRoleMember)
 	if err != nil {
 		log.Printf("apiCreatePrivateChatHandler: storage.AddUserToChat (current): %v", err)
 		sendJSONResponse(w, http.StatusInternalServerError, APIResponse{
@@ -452,7 +553,7 @@ func (a *App) apiCreatePrivateChatHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	err = a.storage.AddUserToChat(chatID, req.UserID)
+	err = a.storage.AddUserToChat(chatID, req.UserID, domain.ChatRoleMember)
 	if err != nil {
 		log.Printf("apiCreatePrivateChatHandler: storage.AddUserToChat (other): %v", err)
 		sendJSONResponse(w, http.StatusInternalServerError, APIResponse{
@@ -462,6 +563,16 @@ func (a *App) apiCreatePrivateChatHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	a.audit(r, domain.AuditEvent{
+		ActorID:       currentUserID,
+		ActorUsername: username,
+		Action:        domain.AuditChatCreated,
+		ChatID:        chatID,
+	}, map[string]interface{}{
+		"type":    domain.ChatTypePrivate,
+		"user_id": req.UserID,
+	})
+
 	sendJSONResponse(w, http.StatusCreated, APIResponse{
 		Success: true,
 		Message: "Private chat created",
@@ -522,7 +633,8 @@ func (a *App) apiCreateGroupChatHandler(w http.ResponseWriter, r *http.Request)
 	// Create new chat
 	chat := domain.Chat{
 		Name:      req.Name,
-		IsPrivate: false,
+		Type:      domain.ChatTypeGroup,
+		IsPublic:  req.IsPublic,
 		CreatorID: currentUserID,
 		CreatedAt: time.Now(),
 	}
@@ -537,8 +649,8 @@ func (a *App) apiCreateGroupChatHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	// Add creator to the chat
-	err = a.storage.AddUserToChat(chatID, currentUserID)
+	// Add creator to the chat as its owner
+	err = a.storage.AddUserToChat(chatID, currentUserID, domain.ChatRoleOwner)
 	if err != nil {
 		log.Printf("apiCreateGroupChatHandler: storage.AddUserToChat (creator): %v", err)
 		sendJSONResponse(w, http.StatusInternalServerError, APIResponse{
@@ -549,13 +661,28 @@ func (a *App) apiCreateGroupChatHandler(w http.ResponseWriter, r *http.Request)
 	}
 
 	// Add selected users to the chat
+	added := make([]int, 0, len(req.UserIDs))
 	for _, userID := range req.UserIDs {
-		err = a.storage.AddUserToChat(chatID, userID)
+		err = a.storage.AddUserToChat(chatID, userID, domain.ChatRoleMember)
 		if err != nil {
 			log.Printf("apiCreateGroupChatHandler: storage.AddUserToChat (user %d): %v", userID, err)
 			// Continue adding other users even if one fails
+			continue
 		}
-	}
+		added = append(added, userID)
+	}
+
+	a.audit(r, domain.AuditEvent{
+		ActorID:       currentUserID,
+		ActorUsername: username,
+		Action:        domain.AuditChatCreated,
+		ChatID:        chatID,
+	}, map[string]interface{}{
+		"type":      domain.ChatTypeGroup,
+		"name":      chat.Name,
+		"is_public": chat.IsPublic,
+		"user_ids":  added,
+	})
 
 	sendJSONResponse(w, http.StatusCreated, APIResponse{
 		Success: true,
@@ -648,8 +775,33 @@ func (a *App) apiEditMessageHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Шифруем новый текст так же, как при отправке сообщения
+	encryptedContent, err := a.cipher.Encrypt(req.Content)
+	if err != nil {
+		log.Printf("apiEditMessageHandler: cipher.Encrypt: %v", err)
+		sendJSONResponse(w, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Message: "Error updating message",
+		})
+		return
+	}
+
 	// Update message content
-	err = a.storage.UpdateMessageContent(req.MessageID, req.Content)
+	err = a.storage.UpdateMessageContent(req.MessageID, encryptedContent)
+	if errors.Is(err, sql.ErrNoRows) {
+		sendJSONResponse(w, http.StatusNotFound, APIResponse{
+			Success: false,
+			Message: "Message not found",
+		})
+		return
+	}
+	if errors.Is(err, domain.ErrMessageHeld) {
+		sendJSONResponse(w, http.StatusConflict, APIResponse{
+			Success: false,
+			Message: "Message is under legal hold and can't be edited",
+		})
+		return
+	}
 	if err != nil {
 		log.Printf("apiEditMessageHandler: storage.UpdateMessageContent: %v", err)
 		sendJSONResponse(w, http.StatusInternalServerError, APIResponse{
@@ -680,33 +832,29 @@ func (a *App) apiEditMessageHandler(w http.ResponseWriter, r *http.Request) {
 		message.Content = decryptedContent
 	}
 
-	// Get the chat ID from the request
-	chatID, err := strconv.Atoi(req.ChatID)
-	if err != nil {
-		log.Printf("apiEditMessageHandler: strconv.Atoi(req.ChatID): %v", err)
-		chatID = message.ChatID // Fallback to the message's chat ID
-	}
+	a.indexMessage(message.ID, message.Content, message.File.Name)
 
-	// Broadcast the edit to all clients in the chat
-	clients := a.memory.GetClientsByChatID(chatID)
-	log.Printf("apiEditMessageHandler: Broadcasting edit to %d clients in chat %d", len(clients), chatID)
+	a.audit(r, domain.AuditEvent{
+		ActorID:       message.UserID,
+		ActorUsername: username,
+		Action:        domain.AuditMessageEdited,
+		ChatID:        message.ChatID,
+		Target:        "message:" + req.MessageID,
+	}, nil)
+
+	a.emitWebhookEvent(message.ChatID, domain.WebhookEventMessageEdit, map[string]interface{}{
+		"message_id": message.ID,
+		"user_id":    message.UserID,
+		"username":   username,
+		"content":    req.Content,
+	})
 
-	editMessage := map[string]interface{}{
+	// Broadcast the edit to all clients in the chat
+	a.broadcastToChat(message.ChatID, map[string]interface{}{
 		"action":  "edit",
 		"id":      req.MessageID,
 		"content": decryptedContent,
-	}
-
-	for _, client := range clients {
-		log.Printf("apiEditMessageHandler: Sending edit message to client %d", client.UserID)
-		err := client.Conn.WriteJSON(editMessage)
-		if err != nil {
-			log.Printf("apiEditMessageHandler: client.Conn.WriteJSON: %v", err)
-			// Continue with other clients even if one fails
-		} else {
-			log.Printf("apiEditMessageHandler: Successfully sent edit message to client %d", client.UserID)
-		}
-	}
+	})
 
 	sendJSONResponse(w, http.StatusOK, APIResponse{
 		Success: true,
@@ -760,14 +908,6 @@ func (a *App) apiDeleteMessageHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if messageAuthor != username {
-		sendJSONResponse(w, http.StatusForbidden, APIResponse{
-			Success: false,
-			Message: "You can only delete your own messages",
-		})
-		return
-	}
-
 	// Get message details before deleting it
 	var message domain.Message
 	err = a.storage.GetMessageByID(req.MessageID, &message)
@@ -776,8 +916,46 @@ func (a *App) apiDeleteMessageHandler(w http.ResponseWriter, r *http.Request) {
 		// Continue with deletion even if we can't get the message details
 	}
 
-	// Delete the message
-	err = a.storage.DeleteMessage(req.MessageID)
+	userID, err := a.storage.GetUserIDByUsername(username)
+	if err != nil {
+		log.Printf("apiDeleteMessageHandler: storage.GetUserIDByUsername: %v", err)
+		sendJSONResponse(w, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Message: "Error retrieving user",
+		})
+		return
+	}
+
+	// Chat admins may delete messages of members with a lower role
+	if messageAuthor != username {
+		role, _ := a.memberRole(message.ChatID, userID)
+		if !role.Can(domain.PermissionDeleteMessages) {
+			sendJSONResponse(w, http.StatusForbidden, APIResponse{
+				Success: false,
+				Message: "You can only delete your own messages",
+			})
+			return
+		}
+
+		authorRole, _ := a.memberRole(message.ChatID, message.UserID)
+		if !role.Outranks(authorRole) {
+			sendJSONResponse(w, http.StatusForbidden, APIResponse{
+				Success: false,
+				Message: "You can only delete messages of members with a lower role",
+			})
+			return
+		}
+	}
+
+	// Delete the message, leaving a tombstone in its place
+	err = a.storage.DeleteMessage(req.MessageID, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		sendJSONResponse(w, http.StatusNotFound, APIResponse{
+			Success: false,
+			Message: "Message not found",
+		})
+		return
+	}
 	if err != nil {
 		log.Printf("apiDeleteMessageHandler: storage.DeleteMessage: %v", err)
 		sendJSONResponse(w, http.StatusInternalServerError, APIResponse{
@@ -787,33 +965,31 @@ func (a *App) apiDeleteMessageHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Get the chat ID from the request or from the message
-	chatID, err := strconv.Atoi(req.ChatID)
-	if err != nil && message.ChatID > 0 {
-		log.Printf("apiDeleteMessageHandler: strconv.Atoi(req.ChatID): %v", err)
-		chatID = message.ChatID // Fallback to the message's chat ID
+	var tombstone domain.Message
+	err = a.storage.GetMessageByID(req.MessageID, &tombstone)
+	if err != nil {
+		log.Printf("apiDeleteMessageHandler: storage.GetMessageByID: %v", err)
 	}
 
-	// Broadcast the deletion to all clients in the chat
-	if chatID > 0 {
-		clients := a.memory.GetClientsByChatID(chatID)
-		log.Printf("apiDeleteMessageHandler: Broadcasting delete to %d clients in chat %d", len(clients), chatID)
-
-		deleteMessage := map[string]interface{}{
-			"action": "delete",
-			"id":     req.MessageID,
-		}
+	a.audit(r, domain.AuditEvent{
+		ActorID:       userID,
+		ActorUsername: username,
+		Action:        domain.AuditMessageDeleted,
+		ChatID:        tombstone.ChatID,
+		Target:        "message:" + req.MessageID,
+	}, map[string]interface{}{
+		"author": messageAuthor,
+	})
 
-		for _, client := range clients {
-			log.Printf("apiDeleteMessageHandler: Sending delete message to client %d", client.UserID)
-			err := client.Conn.WriteJSON(deleteMessage)
-			if err != nil {
-				log.Printf("apiDeleteMessageHandler: client.Conn.WriteJSON: %v", err)
-				// Continue with other clients even if one fails
-			} else {
-				log.Printf("apiDeleteMessageHandler: Successfully sent delete message to client %d", client.UserID)
-			}
-		}
+	// Broadcast the deletion to all clients in the chat
+	if message.ChatID > 0 {
+		a.announceMessageDeleted(message.ChatID, messageID, map[string]interface{}{
+			"message_id": messageID,
+			"user_id":    message.UserID,
+			"username":   messageAuthor,
+			"deleted_by": username,
+			"deleted_at": tombstone.DeletedAt,
+		}, &tombstone)
 	}
 
 	sendJSONResponse(w, http.StatusOK, APIResponse{
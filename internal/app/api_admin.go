@@ -0,0 +1,414 @@
+package app
+
+import (
+	"chat/internal/domain"
+	"chat/internal/utils"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// defaultResetTokenTTL is how long a password reset token is valid unless
+// set in the config
+const defaultResetTokenTTL = 24 * time.Hour
+
+// deletedUsernamePrefix starts the usernames deleted accounts are renamed to
+const deletedUsernamePrefix = "deleted-"
+
+// SetUserAdminRequest grants or revokes server administration
+type SetUserAdminRequest struct {
+	IsAdmin bool `json:"is_admin"`
+}
+
+// adminUserInfo describes an account for administrators
+func (a *App) adminUserInfo(user domain.User) map[string]interface{} {
+	return map[string]interface{}{
+		"user_id":        user.ID,
+		"username":       user.Username,
+		"full_name":      user.Surname + " " + user.Name + " " + user.Patronymic,
+		"status":         user.Status,
+		"last_active":    user.LastActive,
+		"is_admin":       user.IsAdmin,
+		"deactivated_at": user.DeactivatedAt,
+		"deleted_at":     user.DeletedAt,
+	}
+}
+
+// API Admin Users handler lists accounts, optionally searching by username
+// or full name
+func (a *App) apiAdminUsersHandler(w http.ResponseWriter, r *http.Request) {
+	if _, ok := a.adminUser(w, r, "apiAdminUsersHandler"); !ok {
+		return
+	}
+
+	limit, offset := pagination(r, 50, 200)
+	users, err := a.storage.SearchUsers(r.URL.Query().Get("q"), limit, offset)
+	if err != nil {
+		log.Printf("apiAdminUsersHandler: storage.SearchUsers: %v", err)
+		sendJSONResponse(w, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Message: "Error retrieving users",
+		})
+		return
+	}
+
+	infos := make([]map[string]interface{}, 0, len(users))
+	for _, user := range users {
+		infos = append(infos, a.adminUserInfo(user))
+	}
+
+	sendJSONResponse(w, http.StatusOK, APIResponse{
+		Success: true,
+		Data: map[string]interface{}{
+			"users": infos,
+		},
+	})
+}
+
+// API Deactivate User handler disables an account, ending its sessions and
+// connections
+func (a *App) apiDeactivateUserHandler(w http.ResponseWriter, r *http.Request) {
+	a.setUserDeactivated(w, r, true, "apiDeactivateUserHandler")
+}
+
+// API Reactivate User handler enables a deactivated account again
+func (a *App) apiReactivateUserHandler(w http.ResponseWriter, r *http.Request) {
+	a.setUserDeactivated(w, r, false, "apiReactivateUserHandler")
+}
+
+func (a *App) setUserDeactivated(w http.ResponseWriter, r *http.Request, deactivated bool, caller string) {
+	admin, ok := a.adminUser(w, r, caller)
+	if !ok {
+		return
+	}
+
+	userID := utils.Atoi(mux.Vars(r)["user_id"])
+	if deactivated && userID == admin.ID {
+		sendJSONResponse(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: "You cannot deactivate yourself",
+		})
+		return
+	}
+
+	err := a.storage.SetUserDeactivated(userID, deactivated)
+	if errors.Is(err, sql.ErrNoRows) {
+		sendJSONResponse(w, http.StatusNotFound, APIResponse{
+			Success: false,
+			Message: "User not found",
+		})
+		return
+	}
+	if err != nil {
+		log.Printf("%s: storage.SetUserDeactivated: %v", caller, err)
+		sendJSONResponse(w, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Message: "Error updating user",
+		})
+		return
+	}
+
+	action, message := domain.AuditUserReactivated, "User reactivated"
+	if deactivated {
+		action, message = domain.AuditUserDeactivated, "User deactivated"
+		a.memory.DisconnectUserClients(userID)
+	}
+	event := actorEvent(admin, action)
+	event.Target = fmt.Sprintf("user:%d", userID)
+	a.audit(r, event, nil)
+
+	sendJSONResponse(w, http.StatusOK, APIResponse{
+		Success: true,
+		Message: message,
+	})
+}
+
+// API Delete User handler anonymizes an account for good. With purge=true
+// messages and files of the user are deleted too.
+func (a *App) apiDeleteUserHandler(w http.ResponseWriter, r *http.Request) {
+	admin, ok := a.adminUser(w, r, "apiDeleteUserHandler")
+	if !ok {
+		return
+	}
+
+	userID := utils.Atoi(mux.Vars(r)["user_id"])
+	if userID == admin.ID {
+		sendJSONResponse(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: "You cannot delete yourself",
+		})
+		return
+	}
+
+	var purge bool
+	if value := r.URL.Query().Get("purge"); value != "" {
+		var err error
+		purge, err = strconv.ParseBool(value)
+		if err != nil {
+			sendJSONResponse(w, http.StatusBadRequest, APIResponse{
+				Success: false,
+				Message: "Invalid purge value",
+			})
+			return
+		}
+	}
+
+	messages, err := a.storage.DeleteUser(userID, purge, admin.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		sendJSONResponse(w, http.StatusNotFound, APIResponse{
+			Success: false,
+			Message: "User not found",
+		})
+		return
+	}
+	if err != nil {
+		log.Printf("apiDeleteUserHandler: storage.DeleteUser: %v", err)
+		sendJSONResponse(w, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Message: "Error deleting user",
+		})
+		return
+	}
+	a.memory.DisconnectUserClients(userID)
+
+	event := actorEvent(admin, domain.AuditUserDeleted)
+	event.Target = fmt.Sprintf("user:%d", userID)
+	a.audit(r, event, map[string]interface{}{
+		"purge":    purge,
+		"messages": len(messages),
+	})
+
+	for _, message := range messages {
+		var tombstone domain.Message
+		err := a.storage.GetMessageByID(strconv.Itoa(message.ID), &tombstone)
+		if err != nil {
+			log.Printf("apiDeleteUserHandler: storage.GetMessageByID: %v", err)
+		}
+		a.announceMessageDeleted(message.ChatID, message.ID, map[string]interface{}{
+			"message_id": message.ID,
+			"user_id":    userID,
+			"deleted_by": admin.Username,
+			"deleted_at": tombstone.DeletedAt,
+		}, &tombstone)
+	}
+
+	sendJSONResponse(w, http.StatusOK, APIResponse{
+		Success: true,
+		Message: "User deleted",
+		Data: map[string]interface{}{
+			"purged_messages": len(messages),
+		},
+	})
+}
+
+// API Set User Admin handler grants or revokes server administration.
+// Administrators listed in the config keep their access regardless.
+func (a *App) apiSetUserAdminHandler(w http.ResponseWriter, r *http.Request) {
+	admin, ok := a.adminUser(w, r, "apiSetUserAdminHandler")
+	if !ok {
+		return
+	}
+
+	var req SetUserAdminRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendJSONResponse(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: "Invalid request format",
+		})
+		return
+	}
+
+	userID := utils.Atoi(mux.Vars(r)["user_id"])
+	if !req.IsAdmin && userID == admin.ID {
+		sendJSONResponse(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: "You cannot revoke your own administrator role",
+		})
+		return
+	}
+
+	err := a.storage.SetUserAdmin(userID, req.IsAdmin)
+	if errors.Is(err, sql.ErrNoRows) {
+		sendJSONResponse(w, http.StatusNotFound, APIResponse{
+			Success: false,
+			Message: "User not found",
+		})
+		return
+	}
+	if err != nil {
+		log.Printf("apiSetUserAdminHandler: storage.SetUserAdmin: %v", err)
+		sendJSONResponse(w, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Message: "Error updating user",
+		})
+		return
+	}
+
+	event := actorEvent(admin, domain.AuditUserAdminChanged)
+	event.Target = fmt.Sprintf("user:%d", userID)
+	a.audit(r, event, map[string]interface{}{
+		"is_admin": req.IsAdmin,
+	})
+
+	sendJSONResponse(w, http.StatusOK, APIResponse{
+		Success: true,
+		Message: "Administrator role updated",
+	})
+}
+
+// API Reset User Password handler issues a one-time token the user can set
+// a new password with. The token is returned only in this response and
+// replaces earlier unused ones.
+func (a *App) apiResetUserPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	admin, ok := a.adminUser(w, r, "apiResetUserPasswordHandler")
+	if !ok {
+		return
+	}
+
+	token, err := utils.RandomToken(32)
+	if err != nil {
+		log.Printf("apiResetUserPasswordHandler: utils.RandomToken: %v", err)
+		sendJSONResponse(w, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Message: "Error processing request",
+		})
+		return
+	}
+
+	ttl := a.cfg.Password.ResetTokenTTL
+	if ttl <= 0 {
+		ttl = defaultResetTokenTTL
+	}
+	resetToken := domain.PasswordResetToken{
+		UserID:    utils.Atoi(mux.Vars(r)["user_id"]),
+		Token:     token,
+		CreatedBy: admin.ID,
+		ExpiresAt: time.Now().Add(ttl),
+	}
+
+	_, err = a.storage.InsertPasswordResetToken(resetToken)
+	if errors.Is(err, sql.ErrNoRows) {
+		sendJSONResponse(w, http.StatusNotFound, APIResponse{
+			Success: false,
+			Message: "User not found",
+		})
+		return
+	}
+	if err != nil {
+		log.Printf("apiResetUserPasswordHandler: storage.InsertPasswordResetToken: %v", err)
+		sendJSONResponse(w, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Message: "Error creating reset token",
+		})
+		return
+	}
+
+	event := actorEvent(admin, domain.AuditPasswordReset)
+	event.Target = fmt.Sprintf("user:%d", resetToken.UserID)
+	a.audit(r, event, map[string]interface{}{
+		"expires_at": resetToken.ExpiresAt,
+	})
+
+	sendJSONResponse(w, http.StatusOK, APIResponse{
+		Success: true,
+		Message: "Password reset token created",
+		Data: map[string]interface{}{
+			"token":      resetToken.Token,
+			"expires_at": resetToken.ExpiresAt,
+		},
+	})
+}
+
+// API Admin Delete Message handler deletes a message in any chat, leaving
+// a tombstone like a deletion by its author
+func (a *App) apiAdminDeleteMessageHandler(w http.ResponseWriter, r *http.Request) {
+	admin, ok := a.adminUser(w, r, "apiAdminDeleteMessageHandler")
+	if !ok {
+		return
+	}
+
+	messageID := mux.Vars(r)["message_id"]
+	var message domain.Message
+	err := a.storage.GetMessageByID(messageID, &message)
+	if err == nil {
+		err = a.storage.DeleteMessage(messageID, admin.ID)
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		sendJSONResponse(w, http.StatusNotFound, APIResponse{
+			Success: false,
+			Message: "Message not found",
+		})
+		return
+	}
+	if err != nil {
+		log.Printf("apiAdminDeleteMessageHandler: storage.DeleteMessage: %v", err)
+		sendJSONResponse(w, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Message: "Error deleting message",
+		})
+		return
+	}
+
+	var tombstone domain.Message
+	err = a.storage.GetMessageByID(messageID, &tombstone)
+	if err != nil {
+		log.Printf("apiAdminDeleteMessageHandler: storage.GetMessageByID: %v", err)
+	}
+
+	event := actorEvent(admin, domain.AuditMessageDeleted)
+	event.ChatID = message.ChatID
+	event.Target = "message:" + messageID
+	a.audit(r, event, map[string]interface{}{
+		"author": message.Username,
+		"admin":  true,
+	})
+
+	id, _ := strconv.Atoi(messageID)
+	a.announceMessageDeleted(message.ChatID, id, map[string]interface{}{
+		"message_id": id,
+		"user_id":    message.UserID,
+		"username":   message.Username,
+		"deleted_by": admin.Username,
+		"deleted_at": tombstone.DeletedAt,
+	}, &tombstone)
+
+	sendJSONResponse(w, http.StatusOK, APIResponse{
+		Success: true,
+		Message: "Message deleted",
+		Data: map[string]interface{}{
+			"message_id": messageID,
+		},
+	})
+}
+
+// API Instance Stats handler shows how many users, chats, messages and
+// attachments the server has
+func (a *App) apiInstanceStatsHandler(w http.ResponseWriter, r *http.Request) {
+	if _, ok := a.adminUser(w, r, "apiInstanceStatsHandler"); !ok {
+		return
+	}
+
+	stats, err := a.storage.GetInstanceStats()
+	if err != nil {
+		log.Printf("apiInstanceStatsHandler: storage.GetInstanceStats: %v", err)
+		sendJSONResponse(w, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Message: "Error retrieving stats",
+		})
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, APIResponse{
+		Success: true,
+		Data: map[string]interface{}{
+			"stats": stats,
+		},
+	})
+}
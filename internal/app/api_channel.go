@@ -0,0 +1,99 @@
+package app
+
+import (
+	"chat/internal/domain"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Create channel request structure
+type CreateChannelRequest struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	IsPublic    bool   `json:"is_public"`
+}
+
+// API Create Channel handler
+func (a *App) apiCreateChannelHandler(w http.ResponseWriter, r *http.Request) {
+	if !a.isAuthenticated(r) {
+		sendJSONResponse(w, http.StatusUnauthorized, APIResponse{
+			Success: false,
+			Message: "Not authenticated",
+		})
+		return
+	}
+
+	var req CreateChannelRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendJSONResponse(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: "Invalid request format",
+		})
+		return
+	}
+
+	user, err := a.currentUser(r)
+	if err != nil {
+		log.Printf("apiCreateChannelHandler: storage.GetUserByUsername: %v", err)
+		sendJSONResponse(w, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Message: "Error retrieving user",
+		})
+		return
+	}
+
+	chat := domain.Chat{
+		Name:        strings.TrimSpace(req.Name),
+		Type:        domain.ChatTypeChannel,
+		Description: strings.TrimSpace(req.Description),
+		IsPublic:    req.IsPublic,
+		CreatorID:   user.ID,
+		CreatedAt:   time.Now(),
+	}
+	if message := validateChatSettings(chat); message != "" {
+		sendJSONResponse(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: message,
+		})
+		return
+	}
+
+	chat.ID, err = a.storage.InsertChat(chat)
+	if err != nil {
+		log.Printf("apiCreateChannelHandler: storage.InsertChat: %v", err)
+		sendJSONResponse(w, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Message: "Error creating channel",
+		})
+		return
+	}
+
+	err = a.storage.AddUserToChat(chat.ID, user.ID, domain.ChatRoleOwner)
+	if err != nil {
+		log.Printf("apiCreateChannelHandler: storage.AddUserToChat: %v", err)
+		sendJSONResponse(w, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Message: "Error adding creator to channel",
+		})
+		return
+	}
+
+	event := actorEvent(user, domain.AuditChatCreated)
+	event.ChatID = chat.ID
+	a.audit(r, event, map[string]interface{}{
+		"type":      domain.ChatTypeChannel,
+		"name":      chat.Name,
+		"is_public": chat.IsPublic,
+	})
+
+	sendJSONResponse(w, http.StatusCreated, APIResponse{
+		Success: true,
+		Message: "Channel created",
+		Data: map[string]interface{}{
+			"chat_id": chat.ID,
+		},
+	})
+}
@@ -0,0 +1,222 @@
+package app
+
+import (
+	"chat/internal/domain"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	maxChatNameLength        = 100
+	maxChatTopicLength       = 250
+	maxChatDescriptionLength = 2000
+	maxAvatarSize            = 1 << 20
+)
+
+// Update chat request structure. Omitted fields are left unchanged, an
+// avatar without data removes the current one.
+type UpdateChatRequest struct {
+	Name        *string      `json:"name"`
+	Topic       *string      `json:"topic"`
+	Description *string      `json:"description"`
+	Avatar      *domain.File `json:"avatar"`
+	IsPublic    *bool        `json:"is_public"`
+}
+
+// API Update Chat handler
+func (a *App) apiUpdateChatHandler(w http.ResponseWriter, r *http.Request) {
+	chat, user, ok := a.permittedChat(w, r, "apiUpdateChatHandler", domain.PermissionEditChat)
+	if !ok {
+		return
+	}
+
+	var req UpdateChatRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendJSONResponse(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: "Invalid request format",
+		})
+		return
+	}
+
+	if chat.Type == domain.ChatTypePrivate {
+		sendJSONResponse(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: "Private chats have no settings",
+		})
+		return
+	}
+
+	updated := *chat
+	if req.Name != nil {
+		updated.Name = strings.TrimSpace(*req.Name)
+	}
+	if req.Topic != nil {
+		updated.Topic = strings.TrimSpace(*req.Topic)
+	}
+	if req.Description != nil {
+		updated.Description = strings.TrimSpace(*req.Description)
+	}
+	if req.IsPublic != nil {
+		updated.IsPublic = *req.IsPublic
+	}
+	if message := validateChatSettings(updated); message != "" {
+		sendJSONResponse(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: message,
+		})
+		return
+	}
+
+	if req.Avatar != nil {
+		updated.AvatarID = 0
+		if req.Avatar.Data != "" {
+			if message := validateAvatar(*req.Avatar); message != "" {
+				sendJSONResponse(w, http.StatusBadRequest, APIResponse{
+					Success: false,
+					Message: message,
+				})
+				return
+			}
+
+			avatarID, err := a.storage.InsertAttachment(*req.Avatar, user.ID)
+			if err != nil {
+				log.Printf("apiUpdateChatHandler: storage.InsertAttachment: %v", err)
+				sendJSONResponse(w, http.StatusInternalServerError, APIResponse{
+					Success: false,
+					Message: "Error saving avatar",
+				})
+				return
+			}
+			updated.AvatarID = avatarID
+		}
+	}
+
+	err := a.updateChat(chat, &updated, user.User)
+	if err != nil {
+		log.Printf("apiUpdateChatHandler: updateChat: %v", err)
+		// The new avatar isn't referenced by the chat
+		if updated.AvatarID != 0 && updated.AvatarID != chat.AvatarID {
+			if err := a.storage.DeleteAttachment(updated.AvatarID); err != nil {
+				log.Printf("apiUpdateChatHandler: storage.DeleteAttachment: %v", err)
+			}
+		}
+		sendJSONResponse(w, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Message: "Error updating chat",
+		})
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, APIResponse{
+		Success: true,
+		Message: "Chat updated",
+		Data: map[string]interface{}{
+			"chat": updated,
+		},
+	})
+}
+
+// API Chat Avatar handler
+func (a *App) apiChatAvatarHandler(w http.ResponseWriter, r *http.Request) {
+	chat, _, ok := a.memberChat(w, r, "apiChatAvatarHandler")
+	if !ok {
+		return
+	}
+
+	if chat.AvatarID == 0 {
+		http.Error(w, "Avatar not found", http.StatusNotFound)
+		return
+	}
+
+	avatar, err := a.storage.GetAttachmentByID(chat.AvatarID)
+	if err != nil {
+		log.Printf("apiChatAvatarHandler: storage.GetAttachmentByID: %v", err)
+		http.Error(w, "Avatar not found", http.StatusNotFound)
+		return
+	}
+
+	serveFile(w, avatar.File)
+}
+
+// updateChat saves new chat settings made by actor, announces them in the
+// chat and lets open clients refresh the chat header.
+func (a *App) updateChat(chat *domain.Chat, updated *domain.Chat, actor domain.User) error {
+	err := a.storage.UpdateChat(*updated)
+	if err != nil {
+		return fmt.Errorf("storage.UpdateChat: %w", err)
+	}
+
+	if chat.AvatarID != 0 && chat.AvatarID != updated.AvatarID {
+		err = a.storage.DeleteAttachment(chat.AvatarID)
+		if err != nil {
+			log.Printf("updateChat: storage.DeleteAttachment: %v", err)
+		}
+	}
+
+	var changes []string
+	if updated.Name != chat.Name {
+		changes = append(changes, fmt.Sprintf("renamed the chat to %q", updated.Name))
+	}
+	if updated.Topic != chat.Topic {
+		changes = append(changes, fmt.Sprintf("changed the topic to %q", updated.Topic))
+	}
+	if updated.Description != chat.Description {
+		changes = append(changes, "changed the description")
+	}
+	if updated.AvatarID != chat.AvatarID {
+		changes = append(changes, "changed the avatar")
+	}
+	if updated.IsPublic != chat.IsPublic {
+		if updated.IsPublic {
+			changes = append(changes, "listed the chat in the directory")
+		} else {
+			changes = append(changes, "removed the chat from the directory")
+		}
+	}
+	if len(changes) > 0 {
+		a.postSystemMessage(chat.ID, actor, actor.Username+" "+strings.Join(changes, ", "))
+	}
+
+	a.broadcastToChat(chat.ID, map[string]interface{}{
+		"action": "chat_updated",
+		"chat":   updated,
+	})
+	return nil
+}
+
+// validateChatSettings returns a message describing the first invalid
+// setting, or an empty string.
+func validateChatSettings(chat domain.Chat) string {
+	switch {
+	case chat.Name == "":
+		return "Chat name is required"
+	case utf8.RuneCountInString(chat.Name) > maxChatNameLength:
+		return fmt.Sprintf("Chat name must be at most %d characters long", maxChatNameLength)
+	case utf8.RuneCountInString(chat.Topic) > maxChatTopicLength:
+		return fmt.Sprintf("Topic must be at most %d characters long", maxChatTopicLength)
+	case utf8.RuneCountInString(chat.Description) > maxChatDescriptionLength:
+		return fmt.Sprintf("Description must be at most %d characters long", maxChatDescriptionLength)
+	}
+	return ""
+}
+
+// validateAvatar returns a message describing why the file can't be used as
+// an avatar, or an empty string.
+func validateAvatar(file domain.File) string {
+	data, err := decodeDataURL(file.Data)
+	if err != nil {
+		return "Avatar must be a base64 data URL"
+	}
+	if len(data) > maxAvatarSize {
+		return "Avatar must be at most 1 MB"
+	}
+	if !strings.HasPrefix(http.DetectContentType(data), "image/") {
+		return "Avatar must be an image"
+	}
+	return ""
+}
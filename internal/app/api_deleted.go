@@ -0,0 +1,55 @@
+package app
+
+import (
+	"chat/internal/domain"
+	"log"
+	"net/http"
+)
+
+const (
+	deletedMessagesDefaultLimit = 50
+	deletedMessagesMaxLimit     = 200
+)
+
+// API Deleted Messages handler lists the tombstones of the chat with who
+// deleted each message and when
+func (a *App) apiDeletedMessagesHandler(w http.ResponseWriter, r *http.Request) {
+	chat, _, ok := a.permittedChat(w, r, "apiDeletedMessagesHandler", domain.PermissionDeleteMessages)
+	if !ok {
+		return
+	}
+
+	limit, offset := pagination(r, deletedMessagesDefaultLimit, deletedMessagesMaxLimit)
+	messages, err := a.storage.GetDeletedMessages(chat.ID, limit, offset)
+	if err != nil {
+		log.Printf("apiDeletedMessagesHandler: storage.GetDeletedMessages: %v", err)
+		sendJSONResponse(w, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Message: "Error retrieving deleted messages",
+		})
+		return
+	}
+
+	for i := range messages {
+		redactTombstone(&messages[i], true)
+	}
+
+	sendJSONResponse(w, http.StatusOK, APIResponse{
+		Success: true,
+		Data: map[string]interface{}{
+			"messages": messages,
+		},
+	})
+}
+
+// redactTombstone strips what a deleted message may still hold for legal
+// hold from what members see, along with who deleted it unless showDeleter
+func redactTombstone(message *domain.Message, showDeleter bool) {
+	message.Content = ""
+	message.File = domain.File{}
+	message.AttachmentID = 0
+	if !showDeleter {
+		message.DeletedBy = 0
+		message.DeletedByUsername = ""
+	}
+}
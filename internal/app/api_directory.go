@@ -0,0 +1,157 @@
+package app
+
+import (
+	"chat/internal/domain"
+	"chat/internal/utils"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	directoryDefaultLimit = 50
+	directoryMaxLimit     = 200
+)
+
+// API Directory handler searches public group chats and channels, or only
+// the ones of the requested type
+func (a *App) apiDirectoryHandler(w http.ResponseWriter, r *http.Request) {
+	if !a.isAuthenticated(r) {
+		sendJSONResponse(w, http.StatusUnauthorized, APIResponse{
+			Success: false,
+			Message: "Not authenticated",
+		})
+		return
+	}
+
+	user, err := a.currentUser(r)
+	if err != nil {
+		log.Printf("apiDirectoryHandler: storage.GetUserByUsername: %v", err)
+		sendJSONResponse(w, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Message: "Error retrieving user",
+		})
+		return
+	}
+
+	chatType := domain.ChatType(r.URL.Query().Get("type"))
+	if chatType != "" && chatType != domain.ChatTypeGroup && chatType != domain.ChatTypeChannel {
+		sendJSONResponse(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: "Type must be either group or channel",
+		})
+		return
+	}
+
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	limit, offset := pagination(r, directoryDefaultLimit, directoryMaxLimit)
+
+	chats, err := a.storage.SearchPublicChats(user.ID, chatType, query, limit, offset)
+	if err != nil {
+		log.Printf("apiDirectoryHandler: storage.SearchPublicChats: %v", err)
+		sendJSONResponse(w, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Message: "Error retrieving directory",
+		})
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, APIResponse{
+		Success: true,
+		Data: map[string]interface{}{
+			"chats":  chats,
+			"limit":  limit,
+			"offset": offset,
+		},
+	})
+}
+
+// API Join Chat handler lets the current user join a public chat
+func (a *App) apiJoinChatHandler(w http.ResponseWriter, r *http.Request) {
+	if !a.isAuthenticated(r) {
+		sendJSONResponse(w, http.StatusUnauthorized, APIResponse{
+			Success: false,
+			Message: "Not authenticated",
+		})
+		return
+	}
+
+	user, err := a.currentUser(r)
+	if err != nil {
+		log.Printf("apiJoinChatHandler: storage.GetUserByUsername: %v", err)
+		sendJSONResponse(w, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Message: "Error retrieving user",
+		})
+		return
+	}
+
+	chat, err := a.storage.GetChatByID(utils.Atoi(mux.Vars(r)["id"]))
+	if err != nil {
+		log.Printf("apiJoinChatHandler: storage.GetChatByID: %v", err)
+		sendJSONResponse(w, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Message: "Error retrieving chat",
+		})
+		return
+	}
+	// Закрытые чаты не раскрываются: для них ответ такой же, как для несуществующих
+	if chat == nil || !chat.IsPublic || chat.Type == domain.ChatTypePrivate {
+		sendJSONResponse(w, http.StatusNotFound, APIResponse{
+			Success: false,
+			Message: "Chat not found",
+		})
+		return
+	}
+
+	err = a.joinChat(chat, user)
+	if err != nil {
+		log.Printf("apiJoinChatHandler: joinChat: %v", err)
+		sendJSONResponse(w, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Message: "Error joining chat",
+		})
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, APIResponse{
+		Success: true,
+		Message: "Joined the chat",
+		Data: map[string]interface{}{
+			"chat_id": chat.ID,
+		},
+	})
+}
+
+// joinChat adds the user to the chat on their own behalf. Joining a chat the
+// user is already a member of does nothing.
+func (a *App) joinChat(chat *domain.Chat, user domain.User) error {
+	isMember, err := a.storage.IsChatMember(chat.ID, user.ID)
+	if err != nil {
+		return fmt.Errorf("storage.IsChatMember: %w", err)
+	}
+	if isMember {
+		return nil
+	}
+	return a.addChatMember(chat, user, user)
+}
+
+// pagination reads the limit and offset query parameters, falling back to
+// defaultLimit and capping the limit at maxLimit
+func pagination(r *http.Request, defaultLimit int, maxLimit int) (int, int) {
+	limit := utils.Atoi(r.URL.Query().Get("limit"))
+	if limit <= 0 {
+		limit = defaultLimit
+	}
+	if limit > maxLimit {
+		limit = maxLimit
+	}
+	offset := utils.Atoi(r.URL.Query().Get("offset"))
+	if offset < 0 {
+		offset = 0
+	}
+	return limit, offset
+}
@@ -0,0 +1,168 @@
+package app
+
+import (
+	"chat/internal/domain"
+	"chat/internal/utils"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/gorilla/mux"
+)
+
+// Create eDiscovery export request structure. Messages written by any of
+// the users or in any of the chats are exported; from and to accept a date
+// or an RFC 3339 time and may be omitted.
+type CreateEDiscoveryExportRequest struct {
+	UserIDs []int  `json:"user_ids"`
+	ChatIDs []int  `json:"chat_ids"`
+	From    string `json:"from"`
+	To      string `json:"to"`
+}
+
+// API eDiscovery Exports handler
+func (a *App) apiEDiscoveryExportsHandler(w http.ResponseWriter, r *http.Request) {
+	if _, ok := a.adminUser(w, r, "apiEDiscoveryExportsHandler"); !ok {
+		return
+	}
+
+	exports, err := a.storage.GetEDiscoveryExports()
+	if err != nil {
+		log.Printf("apiEDiscoveryExportsHandler: storage.GetEDiscoveryExports: %v", err)
+		sendJSONResponse(w, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Message: "Error retrieving exports",
+		})
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, APIResponse{
+		Success: true,
+		Data: map[string]interface{}{
+			"exports":    exports,
+			"public_key": a.signer.PublicKey(),
+		},
+	})
+}
+
+// API Create eDiscovery Export handler starts an export in the background.
+// Its progress is visible in the export list.
+func (a *App) apiCreateEDiscoveryExportHandler(w http.ResponseWriter, r *http.Request) {
+	admin, ok := a.adminUser(w, r, "apiCreateEDiscoveryExportHandler")
+	if !ok {
+		return
+	}
+
+	var req CreateEDiscoveryExportRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendJSONResponse(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: "Invalid request format",
+		})
+		return
+	}
+
+	if len(req.UserIDs) == 0 && len(req.ChatIDs) == 0 {
+		sendJSONResponse(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: "At least one user or chat is required",
+		})
+		return
+	}
+
+	from, err := parseSearchTime(req.From, false)
+	if err != nil {
+		sendJSONResponse(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: "Invalid from time",
+		})
+		return
+	}
+	to, err := parseSearchTime(req.To, true)
+	if err != nil {
+		sendJSONResponse(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: "Invalid to time",
+		})
+		return
+	}
+
+	export := domain.EDiscoveryExport{
+		RequestedBy: admin.ID,
+		UserIDs:     req.UserIDs,
+		ChatIDs:     req.ChatIDs,
+		From:        from,
+		To:          to,
+		Status:      domain.EDiscoveryExportStatusRunning,
+	}
+	export.ID, err = a.storage.InsertEDiscoveryExport(export)
+	if err != nil {
+		log.Printf("apiCreateEDiscoveryExportHandler: storage.InsertEDiscoveryExport: %v", err)
+		sendJSONResponse(w, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Message: "Error creating export",
+		})
+		return
+	}
+	event := actorEvent(admin, domain.AuditEDiscoveryExportRequested)
+	event.Target = fmt.Sprintf("ediscovery_export:%d", export.ID)
+	a.audit(r, event, map[string]interface{}{
+		"user_ids": export.UserIDs,
+		"chat_ids": export.ChatIDs,
+		"from":     export.From,
+		"to":       export.To,
+	})
+
+	go a.runEDiscoveryExport(export, admin.Username)
+
+	sendJSONResponse(w, http.StatusAccepted, APIResponse{
+		Success: true,
+		Message: "Export started",
+		Data: map[string]interface{}{
+			"export_id": export.ID,
+		},
+	})
+}
+
+// API Download eDiscovery Export handler
+func (a *App) apiDownloadEDiscoveryExportHandler(w http.ResponseWriter, r *http.Request) {
+	admin, ok := a.adminUser(w, r, "apiDownloadEDiscoveryExportHandler")
+	if !ok {
+		return
+	}
+
+	exportID := utils.Atoi(mux.Vars(r)["export_id"])
+	export, err := a.storage.GetEDiscoveryExport(exportID)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && export.Status != domain.EDiscoveryExportStatusCompleted) {
+		http.Error(w, "Export not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("apiDownloadEDiscoveryExportHandler: storage.GetEDiscoveryExport: %v", err)
+		http.Error(w, "Error retrieving export", http.StatusInternalServerError)
+		return
+	}
+
+	file, err := os.Open(a.ediscoveryExportPath(export.ID))
+	if err != nil {
+		log.Printf("apiDownloadEDiscoveryExportHandler: os.Open: %v", err)
+		http.Error(w, "Export not found", http.StatusNotFound)
+		return
+	}
+	defer file.Close()
+
+	event := actorEvent(admin, domain.AuditEDiscoveryExportDownloaded)
+	event.Target = fmt.Sprintf("ediscovery_export:%d", export.ID)
+	a.audit(r, event, map[string]interface{}{
+		"sha256": export.SHA256,
+	})
+
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=ediscovery-%d.zip", export.ID))
+	w.Header().Set("Content-Type", "application/zip")
+	io.Copy(w, file)
+}
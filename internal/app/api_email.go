@@ -0,0 +1,245 @@
+package app
+
+import (
+	"chat/internal/domain"
+	"chat/internal/utils"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+	"net/mail"
+)
+
+// maxEmailDelayMinutes limits how long users may postpone their digests
+const maxEmailDelayMinutes = 7 * 24 * 60
+
+// Email settings request structure
+type EmailSettingsRequest struct {
+	Email        string `json:"email"`
+	Enabled      bool   `json:"enabled"`
+	MentionsOnly bool   `json:"mentions_only"`
+	DelayMinutes int    `json:"delay_minutes"` // 0 means the server default
+}
+
+// API Email Settings handler shows the email notification preferences of
+// the current user
+func (a *App) apiEmailSettingsHandler(w http.ResponseWriter, r *http.Request) {
+	if !a.isAuthenticated(r) {
+		sendJSONResponse(w, http.StatusUnauthorized, APIResponse{
+			Success: false,
+			Message: "Not authenticated",
+		})
+		return
+	}
+
+	user, err := a.currentUser(r)
+	if err != nil {
+		log.Printf("apiEmailSettingsHandler: storage.GetUserByUsername: %v", err)
+		sendJSONResponse(w, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Message: "Error retrieving user",
+		})
+		return
+	}
+
+	settings, err := a.storage.GetEmailSettings(user.ID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		log.Printf("apiEmailSettingsHandler: storage.GetEmailSettings: %v", err)
+		sendJSONResponse(w, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Message: "Error retrieving email settings",
+		})
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, APIResponse{
+		Success: true,
+		Data: map[string]interface{}{
+			"email":         settings.Email,
+			"enabled":       settings.Enabled,
+			"mentions_only": settings.MentionsOnly,
+			"delay_minutes": settings.DelayMinutes,
+			"last_sent_at":  settings.LastSentAt,
+			"available":     a.cfg.Email.SMTPHost != "",
+		},
+	})
+}
+
+// API Update Email Settings handler saves the email notification
+// preferences of the current user
+func (a *App) apiUpdateEmailSettingsHandler(w http.ResponseWriter, r *http.Request) {
+	if !a.isAuthenticated(r) {
+		sendJSONResponse(w, http.StatusUnauthorized, APIResponse{
+			Success: false,
+			Message: "Not authenticated",
+		})
+		return
+	}
+
+	var req EmailSettingsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendJSONResponse(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: "Invalid request format",
+		})
+		return
+	}
+
+	// Only a bare address, without a display name
+	address, err := mail.ParseAddress(req.Email)
+	if err != nil || address.Address != req.Email {
+		sendJSONResponse(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: "Invalid email address",
+		})
+		return
+	}
+	if req.DelayMinutes < 0 || req.DelayMinutes > maxEmailDelayMinutes {
+		sendJSONResponse(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: "Delay must be between 0 and 10080 minutes",
+		})
+		return
+	}
+
+	user, err := a.currentUser(r)
+	if err != nil {
+		log.Printf("apiUpdateEmailSettingsHandler: storage.GetUserByUsername: %v", err)
+		sendJSONResponse(w, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Message: "Error retrieving user",
+		})
+		return
+	}
+
+	// The token is kept if the user already has settings
+	token, err := utils.RandomToken(16)
+	if err != nil {
+		log.Printf("apiUpdateEmailSettingsHandler: utils.RandomToken: %v", err)
+		sendJSONResponse(w, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Message: "Error processing request",
+		})
+		return
+	}
+
+	err = a.storage.UpsertEmailSettings(domain.EmailSettings{
+		UserID:           user.ID,
+		Email:            req.Email,
+		Enabled:          req.Enabled,
+		MentionsOnly:     req.MentionsOnly,
+		DelayMinutes:     req.DelayMinutes,
+		UnsubscribeToken: token,
+	})
+	if err != nil {
+		log.Printf("apiUpdateEmailSettingsHandler: storage.UpsertEmailSettings: %v", err)
+		sendJSONResponse(w, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Message: "Error saving email settings",
+		})
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, APIResponse{
+		Success: true,
+		Message: "Email settings saved",
+	})
+}
+
+// unsubscribePageTemplate asks to confirm unsubscribing and reports the
+// result. Mail scanners follow links in incoming mail, so the link itself
+// changes nothing.
+var unsubscribePageTemplate = template.Must(template.New("unsubscribe").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Отписка от уведомлений</title>
+<style>
+body { font-family: sans-serif; max-width: 480px; margin: 4em auto; color: #222; text-align: center; }
+</style>
+</head>
+<body>
+<p>{{.Message}}</p>
+{{if .Confirm}}<form method="post" action="?token={{.Token}}">
+<input type="hidden" name="confirm" value="1">
+<button type="submit">Отписаться</button>
+</form>{{end}}
+</body>
+</html>
+`))
+
+// renderUnsubscribePage writes the unsubscribe page with the status
+func renderUnsubscribePage(w http.ResponseWriter, status int, message string, token string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	err := unsubscribePageTemplate.Execute(w, map[string]interface{}{
+		"Message": message,
+		"Token":   token,
+		"Confirm": token != "",
+	})
+	if err != nil {
+		log.Printf("renderUnsubscribePage: template.Execute: %v", err)
+	}
+}
+
+// Email Unsubscribe Page handler asks to confirm unsubscribing by the link
+// in a digest
+func (a *App) emailUnsubscribePageHandler(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	exists, err := a.storage.UnsubscribeTokenExists(token)
+	if err != nil {
+		log.Printf("emailUnsubscribePageHandler: storage.UnsubscribeTokenExists: %v", err)
+		renderUnsubscribePage(w, http.StatusInternalServerError, "Не удалось проверить ссылку, попробуйте позже.", "")
+		return
+	}
+	if !exists {
+		renderUnsubscribePage(w, http.StatusNotFound, "Ссылка для отписки недействительна.", "")
+		return
+	}
+
+	renderUnsubscribePage(w, http.StatusOK, "Отписаться от уведомлений по почте?", token)
+}
+
+// API Email Unsubscribe handler turns off email notifications by the token
+// from the link in a digest. It needs no session, so that the confirmation
+// page and the one-click List-Unsubscribe POST work from any mail client.
+// The confirmation form gets a page in response, mail clients get JSON.
+func (a *App) apiEmailUnsubscribeHandler(w http.ResponseWriter, r *http.Request) {
+	fromPage := r.PostFormValue("confirm") != ""
+
+	err := a.storage.UnsubscribeEmail(r.URL.Query().Get("token"))
+	if errors.Is(err, sql.ErrNoRows) {
+		if fromPage {
+			renderUnsubscribePage(w, http.StatusNotFound, "Ссылка для отписки недействительна.", "")
+			return
+		}
+		sendJSONResponse(w, http.StatusNotFound, APIResponse{
+			Success: false,
+			Message: "Unknown unsubscribe link",
+		})
+		return
+	}
+	if err != nil {
+		log.Printf("apiEmailUnsubscribeHandler: storage.UnsubscribeEmail: %v", err)
+		if fromPage {
+			renderUnsubscribePage(w, http.StatusInternalServerError, "Не удалось отписаться, попробуйте позже.", "")
+			return
+		}
+		sendJSONResponse(w, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Message: "Error updating email settings",
+		})
+		return
+	}
+
+	if fromPage {
+		renderUnsubscribePage(w, http.StatusOK, "Вы отписались от уведомлений по почте.", "")
+		return
+	}
+	sendJSONResponse(w, http.StatusOK, APIResponse{
+		Success: true,
+		Message: "You are unsubscribed from email notifications",
+	})
+}
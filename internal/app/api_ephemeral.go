@@ -0,0 +1,129 @@
+package app
+
+import (
+	"chat/internal/domain"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// maxMessageTTL is the longest lifetime a disappearing message may have
+const maxMessageTTL = 30 * 24 * 60 * 60
+
+// Set message TTL request structure. Zero turns disappearing messages off.
+type SetMessageTTLRequest struct {
+	MessageTTL int `json:"message_ttl"` // seconds
+}
+
+// API Set Message TTL handler sets how long new messages in the chat live.
+// Any member may change it in a private chat, elsewhere it is a chat setting.
+func (a *App) apiSetMessageTTLHandler(w http.ResponseWriter, r *http.Request) {
+	chat, user, ok := a.memberChat(w, r, "apiSetMessageTTLHandler")
+	if !ok {
+		return
+	}
+
+	if chat.Type != domain.ChatTypePrivate && !user.Role.Can(domain.PermissionEditChat) {
+		sendJSONResponse(w, http.StatusForbidden, APIResponse{
+			Success: false,
+			Message: "You don't have permission to do this in this chat",
+		})
+		return
+	}
+
+	var req SetMessageTTLRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendJSONResponse(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: "Invalid request format",
+		})
+		return
+	}
+
+	if req.MessageTTL != 0 {
+		if message := validateMessageTTL(req.MessageTTL); message != "" {
+			sendJSONResponse(w, http.StatusBadRequest, APIResponse{
+				Success: false,
+				Message: message,
+			})
+			return
+		}
+	}
+
+	if req.MessageTTL != chat.MessageTTL {
+		err := a.storage.SetChatMessageTTL(chat.ID, req.MessageTTL)
+		if err != nil {
+			log.Printf("apiSetMessageTTLHandler: storage.SetChatMessageTTL: %v", err)
+			sendJSONResponse(w, http.StatusInternalServerError, APIResponse{
+				Success: false,
+				Message: "Error updating chat",
+			})
+			return
+		}
+
+		updated := *chat
+		updated.MessageTTL = req.MessageTTL
+		if updated.MessageTTL > 0 {
+			a.postSystemMessage(chat.ID, user.User, fmt.Sprintf("%s set messages to disappear after %s", user.Username, formatTTL(updated.MessageTTL)))
+		} else {
+			a.postSystemMessage(chat.ID, user.User, user.Username+" turned off disappearing messages")
+		}
+		a.broadcastToChat(chat.ID, map[string]interface{}{
+			"action": "chat_updated",
+			"chat":   updated,
+		})
+	}
+
+	sendJSONResponse(w, http.StatusOK, APIResponse{
+		Success: true,
+		Message: "Message lifetime updated",
+		Data: map[string]interface{}{
+			"message_ttl": req.MessageTTL,
+		},
+	})
+}
+
+// messageExpiry returns when a message posted now should disappear: after
+// its own ttl or the chat one, whichever is shorter. A zero ttl means none.
+func (a *App) messageExpiry(chatID int, ttl int) (*time.Time, error) {
+	chat, err := a.storage.GetChatByID(chatID)
+	if err != nil {
+		return nil, fmt.Errorf("storage.GetChatByID: %w", err)
+	}
+	if chat != nil && chat.MessageTTL > 0 && (ttl == 0 || chat.MessageTTL < ttl) {
+		ttl = chat.MessageTTL
+	}
+	if ttl == 0 {
+		return nil, nil
+	}
+
+	expiresAt := time.Now().UTC().Add(time.Duration(ttl) * time.Second)
+	return &expiresAt, nil
+}
+
+// validateMessageTTL returns a message describing why ttl can't be used as
+// a message lifetime, or an empty string
+func validateMessageTTL(ttl int) string {
+	if ttl < 1 || ttl > maxMessageTTL {
+		return fmt.Sprintf("Message lifetime must be between 1 second and %s", formatTTL(maxMessageTTL))
+	}
+	return ""
+}
+
+// formatTTL renders a lifetime in seconds without zero units, e.g. "1h30m"
+func formatTTL(ttl int) string {
+	var s strings.Builder
+	for _, unit := range []struct {
+		seconds int
+		name    string
+	}{{60 * 60, "h"}, {60, "m"}, {1, "s"}} {
+		if ttl >= unit.seconds {
+			fmt.Fprintf(&s, "%d%s", ttl/unit.seconds, unit.name)
+			ttl %= unit.seconds
+		}
+	}
+	return s.String()
+}
@@ -0,0 +1,118 @@
+package app
+
+import (
+	"chat/internal/domain"
+	"chat/internal/service/archive"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+)
+
+// API Export Chat handler streams the chat history as a ZIP archive with a
+// transcript in the requested format (json, html or txt) and attachments
+func (a *App) apiExportChatHandler(w http.ResponseWriter, r *http.Request) {
+	chat, member, ok := a.memberChat(w, r, "apiExportChatHandler")
+	if !ok {
+		return
+	}
+
+	formatName := r.URL.Query().Get("format")
+	if formatName == "" {
+		formatName = "json"
+	}
+	format, ok := transcriptFormats[formatName]
+	if !ok {
+		sendJSONResponse(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: "Format must be json, html or txt",
+		})
+		return
+	}
+
+	event := actorEvent(member.User, domain.AuditChatExported)
+	event.ChatID = chat.ID
+	a.audit(r, event, map[string]interface{}{
+		"format": formatName,
+	})
+
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=chat-%d-%s.zip", chat.ID, formatName))
+	w.Header().Set("Content-Type", "application/zip")
+
+	// Ответ уже начат, поэтому ошибки только записываются в лог, а клиент
+	// получает оборванный архив
+	zw := archive.NewWriter(w)
+	if err := a.exportChat(zw, chat, format.fileName, format.newWriter); err != nil {
+		log.Printf("apiExportChatHandler: exportChat: %v", err)
+		return
+	}
+	if err := zw.Close(); err != nil {
+		log.Printf("apiExportChatHandler: archive.Close: %v", err)
+	}
+}
+
+// exportChat writes the transcript of the chat to the archive followed by
+// the attachments it refers to
+func (a *App) exportChat(zw *archive.Writer, chat *domain.Chat, fileName string, newWriter func(w io.Writer) transcriptWriter) error {
+	file, err := zw.Create(fileName)
+	if err != nil {
+		return err
+	}
+
+	transcript := newWriter(file)
+	if err := transcript.Begin(chat); err != nil {
+		return err
+	}
+
+	attachments := make(map[int]string)
+	err = a.forEachChatMessage(chat.ID, func(message domain.Message) error {
+		exported := exportedMessage{
+			ID:            message.ID,
+			UserID:        message.UserID,
+			Username:      message.Username,
+			Content:       message.Content,
+			CreatedAt:     message.CreatedAt,
+			IsSystem:      message.IsSystem,
+			ForwardedFrom: message.ForwardedFrom,
+			Deleted:       message.DeletedAt != nil,
+		}
+		if message.AttachmentID != 0 {
+			exported.Attachment = attachmentPath(message.AttachmentID, message.File.Name)
+			attachments[message.AttachmentID] = exported.Attachment
+		}
+		return transcript.Message(exported)
+	})
+	if err != nil {
+		return fmt.Errorf("forEachChatMessage: %w", err)
+	}
+	if err := transcript.End(); err != nil {
+		return err
+	}
+
+	return a.writeAttachments(zw, attachments)
+}
+
+// writeAttachments adds the attachments to the archive at the given paths,
+// loading one at a time
+func (a *App) writeAttachments(zw *archive.Writer, attachments map[int]string) error {
+	for attachmentID, path := range attachments {
+		attachment, err := a.storage.GetAttachmentByID(attachmentID)
+		if err != nil {
+			return fmt.Errorf("storage.GetAttachmentByID: %w", err)
+		}
+		data, err := decodeDataURL(attachment.File.Data)
+		if err != nil {
+			log.Printf("writeAttachments: decodeDataURL: attachment %d: %v", attachmentID, err)
+			continue
+		}
+
+		w, err := zw.Create(path)
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
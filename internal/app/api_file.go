@@ -3,14 +3,16 @@ package app
 import (
 	"chat/internal/domain"
 	"encoding/base64"
+	"errors"
 	"fmt"
+	"log"
 	"net/http"
 	"strings"
 
 	"github.com/gorilla/mux"
 )
 
-// API File handler
+// API File handler serves the attachment of a message to members of its chat
 func (a *App) apiFileHandler(w http.ResponseWriter, r *http.Request) {
 	if !a.isAuthenticated(r) {
 		sendJSONResponse(w, http.StatusUnauthorized, APIResponse{
@@ -24,20 +26,60 @@ func (a *App) apiFileHandler(w http.ResponseWriter, r *http.Request) {
 
 	var message domain.Message
 	err := a.storage.GetMessageByID(messageID, &message)
-	if err != nil {
+	if err != nil || message.File.Data == "" || message.DeletedAt != nil {
 		http.Error(w, "File not found", http.StatusNotFound)
 		return
 	}
 
+	user, err := a.currentUser(r)
+	if err != nil {
+		log.Printf("apiFileHandler: storage.GetUserByUsername: %v", err)
+		sendJSONResponse(w, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Message: "Error retrieving user",
+		})
+		return
+	}
+
+	// Files of a chat are only for its current members
+	if _, ok := a.memberRole(message.ChatID, user.ID); !ok {
+		sendJSONResponse(w, http.StatusForbidden, APIResponse{
+			Success: false,
+			Message: "You are not a member of this chat",
+		})
+		return
+	}
+
+	event := actorEvent(user, domain.AuditFileDownloaded)
+	event.ChatID = message.ChatID
+	event.Target = "message:" + messageID
+	a.audit(r, event, map[string]interface{}{
+		"file_name": message.File.Name,
+	})
+
+	serveFile(w, message.File)
+}
+
+// serveFile sends a file stored as a base64 data URL as a download
+func serveFile(w http.ResponseWriter, file domain.File) {
 	// Decode base64 data
-	data, err := base64.StdEncoding.DecodeString(strings.Split(message.File.Data, ",")[1])
+	data, err := decodeDataURL(file.Data)
 	if err != nil {
 		http.Error(w, "Invalid file data", http.StatusInternalServerError)
 		return
 	}
 
 	// Set headers
-	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s", message.File.Name))
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s", file.Name))
 	w.Header().Set("Content-Type", http.DetectContentType(data))
 	w.Write(data)
 }
+
+// decodeDataURL returns the content of a "data:...;base64,..." URL
+func decodeDataURL(dataURL string) ([]byte, error) {
+	_, encoded, found := strings.Cut(dataURL, ",")
+	if !found {
+		return nil, errors.New("not a data URL")
+	}
+	return base64.StdEncoding.DecodeString(encoded)
+}
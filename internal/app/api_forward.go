@@ -0,0 +1,196 @@
+package app
+
+import (
+	"chat/internal/domain"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+)
+
+const maxForwardedMessages = 100
+
+// Forward messages request structure
+type ForwardMessagesRequest struct {
+	FromChatID int   `json:"from_chat_id"`
+	ToChatID   int   `json:"to_chat_id"`
+	MessageIDs []int `json:"message_ids"`
+}
+
+// API Forward Messages handler copies messages to another chat of the user,
+// keeping the original author and chat
+func (a *App) apiForwardMessagesHandler(w http.ResponseWriter, r *http.Request) {
+	if !a.isAuthenticated(r) {
+		sendJSONResponse(w, http.StatusUnauthorized, APIResponse{
+			Success: false,
+			Message: "Not authenticated",
+		})
+		return
+	}
+
+	var req ForwardMessagesRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendJSONResponse(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: "Invalid request format",
+		})
+		return
+	}
+
+	if len(req.MessageIDs) == 0 || len(req.MessageIDs) > maxForwardedMessages {
+		sendJSONResponse(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: fmt.Sprintf("Select from 1 to %d messages to forward", maxForwardedMessages),
+		})
+		return
+	}
+
+	user, err := a.currentUser(r)
+	if err != nil {
+		log.Printf("apiForwardMessagesHandler: storage.GetUserByUsername: %v", err)
+		sendJSONResponse(w, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Message: "Error retrieving user",
+		})
+		return
+	}
+
+	fromChat, _, ok := a.forwardChat(w, req.FromChatID, user)
+	if !ok {
+		return
+	}
+	toChat, toRole, ok := a.forwardChat(w, req.ToChatID, user)
+	if !ok {
+		return
+	}
+	if !canPost(toChat, toRole) {
+		sendJSONResponse(w, http.StatusForbidden, APIResponse{
+			Success: false,
+			Message: "Only channel admins can post here",
+		})
+		return
+	}
+
+	// Сообщения пересылаются в том порядке, в котором были написаны
+	messageIDs := append([]int(nil), req.MessageIDs...)
+	sort.Ints(messageIDs)
+
+	var originals []domain.Message
+	for i, messageID := range messageIDs {
+		if i > 0 && messageID == messageIDs[i-1] {
+			continue
+		}
+
+		var message domain.Message
+		err := a.storage.GetMessageByID(strconv.Itoa(messageID), &message)
+		if err != nil || message.ChatID != fromChat.ID || message.IsSystem || message.DeletedAt != nil {
+			sendJSONResponse(w, http.StatusNotFound, APIResponse{
+				Success: false,
+				Message: fmt.Sprintf("Message %d not found", messageID),
+			})
+			return
+		}
+
+		// Исчезающие сообщения не должны переживать свой срок в другом чате
+		if message.ExpiresAt != nil {
+			sendJSONResponse(w, http.StatusBadRequest, APIResponse{
+				Success: false,
+				Message: fmt.Sprintf("Message %d is disappearing and can't be forwarded", messageID),
+			})
+			return
+		}
+
+		message.Content, err = a.cipher.Decrypt(message.Content)
+		if err != nil {
+			log.Printf("apiForwardMessagesHandler: cipher.Decrypt: %v", err)
+			sendJSONResponse(w, http.StatusInternalServerError, APIResponse{
+				Success: false,
+				Message: "Error reading message",
+			})
+			return
+		}
+		originals = append(originals, message)
+	}
+
+	forwardedIDs := make([]int, 0, len(originals))
+	for _, original := range originals {
+		origin := original.ForwardedFrom
+		if origin == nil {
+			origin = &domain.MessageOrigin{
+				MessageID: original.ID,
+				ChatID:    fromChat.ID,
+				ChatName:  fromChat.Name,
+				UserID:    original.UserID,
+				Username:  original.Username,
+			}
+		}
+
+		// Файл не копируется: пересланное сообщение ссылается на то же вложение
+		forwarded, err := a.postMessage(domain.Message{
+			ChatID:        toChat.ID,
+			UserID:        user.ID,
+			Username:      user.Username,
+			Content:       original.Content,
+			File:          original.File,
+			AttachmentID:  original.AttachmentID,
+			ForwardedFrom: origin,
+		})
+		if err != nil {
+			log.Printf("apiForwardMessagesHandler: postMessage: %v", err)
+			sendJSONResponse(w, http.StatusInternalServerError, APIResponse{
+				Success: false,
+				Message: "Error forwarding messages",
+				Data: map[string]interface{}{
+					"message_ids": forwardedIDs,
+				},
+			})
+			return
+		}
+		forwardedIDs = append(forwardedIDs, forwarded.ID)
+	}
+
+	sendJSONResponse(w, http.StatusOK, APIResponse{
+		Success: true,
+		Message: "Messages forwarded",
+		Data: map[string]interface{}{
+			"message_ids": forwardedIDs,
+		},
+	})
+}
+
+// forwardChat loads a chat taking part in forwarding and makes sure the user
+// is its member. On failure the response is already written.
+func (a *App) forwardChat(w http.ResponseWriter, chatID int, user domain.User) (*domain.Chat, domain.ChatRole, bool) {
+	chat, err := a.storage.GetChatByID(chatID)
+	if err != nil || chat == nil {
+		log.Printf("apiForwardMessagesHandler: storage.GetChatByID: %v", err)
+		sendJSONResponse(w, http.StatusNotFound, APIResponse{
+			Success: false,
+			Message: "Chat not found",
+		})
+		return nil, "", false
+	}
+
+	role, err := a.storage.GetChatMemberRole(chat.ID, user.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		sendJSONResponse(w, http.StatusForbidden, APIResponse{
+			Success: false,
+			Message: "You are not a member of this chat",
+		})
+		return nil, "", false
+	}
+	if err != nil {
+		log.Printf("apiForwardMessagesHandler: storage.GetChatMemberRole: %v", err)
+		sendJSONResponse(w, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Message: "Error checking chat membership",
+		})
+		return nil, "", false
+	}
+
+	return chat, role, true
+}
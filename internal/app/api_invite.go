@@ -0,0 +1,225 @@
+package app
+
+import (
+	"chat/internal/domain"
+	"chat/internal/utils"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// maxInviteExpiry is the longest time an invite link may stay valid
+const maxInviteExpiry = 365 * 24 * 60 * 60
+
+// Create invite request structure. Zero values mean no expiry and no usage
+// limit respectively.
+type CreateInviteRequest struct {
+	ExpiresIn int `json:"expires_in"` // seconds
+	MaxUses   int `json:"max_uses"`
+}
+
+// API Create Chat Invite handler
+func (a *App) apiCreateChatInviteHandler(w http.ResponseWriter, r *http.Request) {
+	chat, user, ok := a.permittedChat(w, r, "apiCreateChatInviteHandler", domain.PermissionManageMembers)
+	if !ok {
+		return
+	}
+
+	var req CreateInviteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendJSONResponse(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: "Invalid request format",
+		})
+		return
+	}
+
+	if chat.Type == domain.ChatTypePrivate {
+		sendJSONResponse(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: "Private chats have no invite links",
+		})
+		return
+	}
+
+	if req.ExpiresIn < 0 || req.MaxUses < 0 {
+		sendJSONResponse(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: "Expiry and usage limit can't be negative",
+		})
+		return
+	}
+
+	if req.ExpiresIn > maxInviteExpiry {
+		sendJSONResponse(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: "Invite links can expire in a year at most",
+		})
+		return
+	}
+
+	token, err := utils.RandomToken(16)
+	if err != nil {
+		log.Printf("apiCreateChatInviteHandler: utils.RandomToken: %v", err)
+		sendJSONResponse(w, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Message: "Error processing request",
+		})
+		return
+	}
+
+	invite := domain.ChatInvite{
+		ChatID:    chat.ID,
+		Token:     token,
+		CreatorID: user.ID,
+		MaxUses:   req.MaxUses,
+		CreatedAt: time.Now(),
+	}
+	if req.ExpiresIn > 0 {
+		expiresAt := time.Now().Add(time.Duration(req.ExpiresIn) * time.Second)
+		invite.ExpiresAt = &expiresAt
+	}
+
+	invite.ID, err = a.storage.InsertChatInvite(invite)
+	if err != nil {
+		log.Printf("apiCreateChatInviteHandler: storage.InsertChatInvite: %v", err)
+		sendJSONResponse(w, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Message: "Error creating invite",
+		})
+		return
+	}
+
+	sendJSONResponse(w, http.StatusCreated, APIResponse{
+		Success: true,
+		Message: "Invite created",
+		Data: map[string]interface{}{
+			"invite": invite,
+		},
+	})
+}
+
+// API List Chat Invites handler
+func (a *App) apiChatInvitesHandler(w http.ResponseWriter, r *http.Request) {
+	chat, _, ok := a.permittedChat(w, r, "apiChatInvitesHandler", domain.PermissionManageMembers)
+	if !ok {
+		return
+	}
+
+	invites, err := a.storage.GetChatInvitesByChatID(chat.ID)
+	if err != nil {
+		log.Printf("apiChatInvitesHandler: storage.GetChatInvitesByChatID: %v", err)
+		sendJSONResponse(w, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Message: "Error retrieving invites",
+		})
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, APIResponse{
+		Success: true,
+		Data: map[string]interface{}{
+			"invites": invites,
+		},
+	})
+}
+
+// API Revoke Chat Invite handler
+func (a *App) apiRevokeChatInviteHandler(w http.ResponseWriter, r *http.Request) {
+	chat, _, ok := a.permittedChat(w, r, "apiRevokeChatInviteHandler", domain.PermissionManageMembers)
+	if !ok {
+		return
+	}
+
+	inviteID := utils.Atoi(mux.Vars(r)["invite_id"])
+	err := a.storage.RevokeChatInvite(chat.ID, inviteID)
+	if errors.Is(err, sql.ErrNoRows) {
+		sendJSONResponse(w, http.StatusNotFound, APIResponse{
+			Success: false,
+			Message: "Invite not found",
+		})
+		return
+	}
+	if err != nil {
+		log.Printf("apiRevokeChatInviteHandler: storage.RevokeChatInvite: %v", err)
+		sendJSONResponse(w, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Message: "Error revoking invite",
+		})
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, APIResponse{
+		Success: true,
+		Message: "Invite revoked",
+	})
+}
+
+// API Join Chat By Invite handler
+func (a *App) apiJoinByInviteHandler(w http.ResponseWriter, r *http.Request) {
+	if !a.isAuthenticated(r) {
+		sendJSONResponse(w, http.StatusUnauthorized, APIResponse{
+			Success: false,
+			Message: "Not authenticated",
+		})
+		return
+	}
+
+	user, err := a.currentUser(r)
+	if err != nil {
+		log.Printf("apiJoinByInviteHandler: storage.GetUserByUsername: %v", err)
+		sendJSONResponse(w, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Message: "Error retrieving user",
+		})
+		return
+	}
+
+	chatID, joined, err := a.storage.RedeemChatInvite(mux.Vars(r)["token"], user.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		sendJSONResponse(w, http.StatusNotFound, APIResponse{
+			Success: false,
+			Message: "Invite link is invalid or has expired",
+		})
+		return
+	}
+	if err != nil {
+		log.Printf("apiJoinByInviteHandler: storage.RedeemChatInvite: %v", err)
+		sendJSONResponse(w, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Message: "Error joining chat",
+		})
+		return
+	}
+
+	if !joined {
+		sendJSONResponse(w, http.StatusOK, APIResponse{
+			Success: true,
+			Message: "You are already a member of this chat",
+			Data: map[string]interface{}{
+				"chat_id": chatID,
+			},
+		})
+		return
+	}
+
+	chat, err := a.storage.GetChatByID(chatID)
+	if err != nil || chat == nil {
+		log.Printf("apiJoinByInviteHandler: storage.GetChatByID: %v", err)
+	} else {
+		a.announceMemberAdded(chat, user, user)
+	}
+
+	sendJSONResponse(w, http.StatusOK, APIResponse{
+		Success: true,
+		Message: "Joined the chat",
+		Data: map[string]interface{}{
+			"chat_id": chatID,
+		},
+	})
+}
@@ -0,0 +1,164 @@
+package app
+
+import (
+	"chat/internal/domain"
+	"chat/internal/utils"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// Create legal hold request structure. Exactly one of user_id and chat_id
+// must be set.
+type CreateLegalHoldRequest struct {
+	UserID int    `json:"user_id"`
+	ChatID int    `json:"chat_id"`
+	Reason string `json:"reason"`
+}
+
+// API Legal Holds handler
+func (a *App) apiLegalHoldsHandler(w http.ResponseWriter, r *http.Request) {
+	if _, ok := a.adminUser(w, r, "apiLegalHoldsHandler"); !ok {
+		return
+	}
+
+	holds, err := a.storage.GetLegalHolds()
+	if err != nil {
+		log.Printf("apiLegalHoldsHandler: storage.GetLegalHolds: %v", err)
+		sendJSONResponse(w, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Message: "Error retrieving legal holds",
+		})
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, APIResponse{
+		Success: true,
+		Data: map[string]interface{}{
+			"legal_holds": holds,
+		},
+	})
+}
+
+// API Create Legal Hold handler
+func (a *App) apiCreateLegalHoldHandler(w http.ResponseWriter, r *http.Request) {
+	admin, ok := a.adminUser(w, r, "apiCreateLegalHoldHandler")
+	if !ok {
+		return
+	}
+
+	var req CreateLegalHoldRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendJSONResponse(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: "Invalid request format",
+		})
+		return
+	}
+
+	hold := domain.LegalHold{
+		UserID:    req.UserID,
+		ChatID:    req.ChatID,
+		Reason:    strings.TrimSpace(req.Reason),
+		CreatedBy: admin.ID,
+	}
+	if (hold.UserID == 0) == (hold.ChatID == 0) {
+		sendJSONResponse(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: "Either user_id or chat_id is required",
+		})
+		return
+	}
+	if hold.Reason == "" {
+		sendJSONResponse(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: "Reason is required",
+		})
+		return
+	}
+
+	if hold.UserID != 0 {
+		if _, err := a.storage.GetUserByID(hold.UserID); err != nil {
+			sendJSONResponse(w, http.StatusNotFound, APIResponse{
+				Success: false,
+				Message: "User not found",
+			})
+			return
+		}
+	} else {
+		chat, err := a.storage.GetChatByID(hold.ChatID)
+		if err != nil || chat == nil {
+			sendJSONResponse(w, http.StatusNotFound, APIResponse{
+				Success: false,
+				Message: "Chat not found",
+			})
+			return
+		}
+	}
+
+	var err error
+	hold.ID, err = a.storage.InsertLegalHold(hold)
+	if err != nil {
+		log.Printf("apiCreateLegalHoldHandler: storage.InsertLegalHold: %v", err)
+		sendJSONResponse(w, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Message: "Error creating legal hold",
+		})
+		return
+	}
+	event := actorEvent(admin, domain.AuditLegalHoldPlaced)
+	event.ChatID = hold.ChatID
+	event.Target = fmt.Sprintf("legal_hold:%d", hold.ID)
+	a.audit(r, event, map[string]interface{}{
+		"user_id": hold.UserID,
+		"reason":  hold.Reason,
+	})
+
+	sendJSONResponse(w, http.StatusCreated, APIResponse{
+		Success: true,
+		Message: "Legal hold placed",
+		Data: map[string]interface{}{
+			"hold_id": hold.ID,
+		},
+	})
+}
+
+// API Release Legal Hold handler
+func (a *App) apiReleaseLegalHoldHandler(w http.ResponseWriter, r *http.Request) {
+	admin, ok := a.adminUser(w, r, "apiReleaseLegalHoldHandler")
+	if !ok {
+		return
+	}
+
+	holdID := utils.Atoi(mux.Vars(r)["hold_id"])
+	err := a.storage.ReleaseLegalHold(holdID, admin.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		sendJSONResponse(w, http.StatusNotFound, APIResponse{
+			Success: false,
+			Message: "Active legal hold not found",
+		})
+		return
+	}
+	if err != nil {
+		log.Printf("apiReleaseLegalHoldHandler: storage.ReleaseLegalHold: %v", err)
+		sendJSONResponse(w, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Message: "Error releasing legal hold",
+		})
+		return
+	}
+	event := actorEvent(admin, domain.AuditLegalHoldReleased)
+	event.Target = fmt.Sprintf("legal_hold:%d", holdID)
+	a.audit(r, event, nil)
+
+	sendJSONResponse(w, http.StatusOK, APIResponse{
+		Success: true,
+		Message: "Legal hold released",
+	})
+}
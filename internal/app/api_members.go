@@ -0,0 +1,496 @@
+package app
+
+import (
+	"chat/internal/domain"
+	"chat/internal/utils"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// Add members request structure
+type AddMembersRequest struct {
+	UserIDs []int `json:"user_ids"`
+}
+
+// Set member role request structure
+type SetRoleRequest struct {
+	Role domain.ChatRole `json:"role"`
+}
+
+// Transfer ownership request structure
+type TransferOwnershipRequest struct {
+	UserID int `json:"user_id"`
+}
+
+// API Add Chat Members handler
+func (a *App) apiAddChatMembersHandler(w http.ResponseWriter, r *http.Request) {
+	chat, user, ok := a.permittedChat(w, r, "apiAddChatMembersHandler", domain.PermissionManageMembers)
+	if !ok {
+		return
+	}
+
+	var req AddMembersRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendJSONResponse(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: "Invalid request format",
+		})
+		return
+	}
+
+	if chat.Type == domain.ChatTypePrivate {
+		sendJSONResponse(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: "Members can't be added to private chats",
+		})
+		return
+	}
+
+	if len(req.UserIDs) == 0 {
+		sendJSONResponse(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: "At least one user must be selected",
+		})
+		return
+	}
+
+	added := make([]int, 0, len(req.UserIDs))
+	for _, userID := range req.UserIDs {
+		isMember, err := a.storage.IsChatMember(chat.ID, userID)
+		if err != nil {
+			log.Printf("apiAddChatMembersHandler: storage.IsChatMember (user %d): %v", userID, err)
+			continue
+		}
+		if isMember {
+			continue
+		}
+
+		member, err := a.storage.GetUserByID(userID)
+		if err != nil {
+			log.Printf("apiAddChatMembersHandler: storage.GetUserByID (user %d): %v", userID, err)
+			// Continue adding other users even if one fails
+			continue
+		}
+		// Deactivated and deleted accounts can't join chats
+		if member.DeactivatedAt != nil || member.DeletedAt != nil {
+			continue
+		}
+
+		err = a.addChatMember(chat, user.User, member)
+		if err != nil {
+			log.Printf("apiAddChatMembersHandler: addChatMember (user %d): %v", userID, err)
+			continue
+		}
+		added = append(added, userID)
+	}
+
+	sendJSONResponse(w, http.StatusOK, APIResponse{
+		Success: true,
+		Message: "Members added",
+		Data: map[string]interface{}{
+			"user_ids": added,
+		},
+	})
+}
+
+// API Remove Chat Member handler
+func (a *App) apiRemoveChatMemberHandler(w http.ResponseWriter, r *http.Request) {
+	chat, user, ok := a.memberChat(w, r, "apiRemoveChatMemberHandler")
+	if !ok {
+		return
+	}
+
+	if chat.Type == domain.ChatTypePrivate {
+		sendJSONResponse(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: "Members can't be removed from private chats",
+		})
+		return
+	}
+
+	memberID := utils.Atoi(mux.Vars(r)["user_id"])
+	if memberID == user.ID {
+		a.apiLeaveChatHandler(w, r)
+		return
+	}
+
+	if !user.Role.Can(domain.PermissionManageMembers) {
+		sendJSONResponse(w, http.StatusForbidden, APIResponse{
+			Success: false,
+			Message: "Only chat admins can remove members",
+		})
+		return
+	}
+
+	memberRole, err := a.storage.GetChatMemberRole(chat.ID, memberID)
+	if errors.Is(err, sql.ErrNoRows) {
+		sendJSONResponse(w, http.StatusNotFound, APIResponse{
+			Success: false,
+			Message: "User is not a member of this chat",
+		})
+		return
+	}
+	if err != nil {
+		log.Printf("apiRemoveChatMemberHandler: storage.GetChatMemberRole: %v", err)
+		sendJSONResponse(w, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Message: "Error checking chat membership",
+		})
+		return
+	}
+
+	if !user.Role.Outranks(memberRole) {
+		sendJSONResponse(w, http.StatusForbidden, APIResponse{
+			Success: false,
+			Message: "You can only remove members with a lower role",
+		})
+		return
+	}
+
+	member, err := a.storage.GetUserByID(memberID)
+	if err != nil {
+		log.Printf("apiRemoveChatMemberHandler: storage.GetUserByID: %v", err)
+		sendJSONResponse(w, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Message: "Error retrieving user",
+		})
+		return
+	}
+
+	err = a.removeChatMember(chat, user.User, member)
+	if err != nil {
+		log.Printf("apiRemoveChatMemberHandler: removeChatMember: %v", err)
+		sendJSONResponse(w, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Message: "Error removing member",
+		})
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, APIResponse{
+		Success: true,
+		Message: "Member removed",
+	})
+}
+
+// API Leave Chat handler
+func (a *App) apiLeaveChatHandler(w http.ResponseWriter, r *http.Request) {
+	chat, user, ok := a.memberChat(w, r, "apiLeaveChatHandler")
+	if !ok {
+		return
+	}
+
+	if chat.Type == domain.ChatTypePrivate {
+		sendJSONResponse(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: "You can't leave a private chat",
+		})
+		return
+	}
+
+	mustTransfer, err := a.mustTransferOwnership(chat, user)
+	if err != nil {
+		log.Printf("apiLeaveChatHandler: mustTransferOwnership: %v", err)
+		sendJSONResponse(w, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Message: "Error retrieving chat members",
+		})
+		return
+	}
+	if mustTransfer {
+		sendJSONResponse(w, http.StatusConflict, APIResponse{
+			Success: false,
+			Message: "Transfer ownership before leaving the chat",
+		})
+		return
+	}
+
+	err = a.removeChatMember(chat, user.User, user.User)
+	if err != nil {
+		log.Printf("apiLeaveChatHandler: removeChatMember: %v", err)
+		sendJSONResponse(w, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Message: "Error leaving chat",
+		})
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, APIResponse{
+		Success: true,
+		Message: "Left the chat",
+	})
+}
+
+// API Set Chat Member Role handler
+func (a *App) apiSetChatMemberRoleHandler(w http.ResponseWriter, r *http.Request) {
+	chat, user, ok := a.permittedChat(w, r, "apiSetChatMemberRoleHandler", domain.PermissionManageRoles)
+	if !ok {
+		return
+	}
+
+	var req SetRoleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendJSONResponse(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: "Invalid request format",
+		})
+		return
+	}
+
+	if req.Role != domain.ChatRoleAdmin && req.Role != domain.ChatRoleMember {
+		sendJSONResponse(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: "Role must be either admin or member, use ownership transfer to change the owner",
+		})
+		return
+	}
+
+	memberID := utils.Atoi(mux.Vars(r)["user_id"])
+	if memberID == user.ID {
+		sendJSONResponse(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: "You can't change your own role",
+		})
+		return
+	}
+
+	member, err := a.storage.GetUserByID(memberID)
+	if err != nil {
+		log.Printf("apiSetChatMemberRoleHandler: storage.GetUserByID: %v", err)
+		sendJSONResponse(w, http.StatusNotFound, APIResponse{
+			Success: false,
+			Message: "User not found",
+		})
+		return
+	}
+
+	err = a.storage.SetChatMemberRole(chat.ID, memberID, req.Role)
+	if errors.Is(err, sql.ErrNoRows) {
+		sendJSONResponse(w, http.StatusNotFound, APIResponse{
+			Success: false,
+			Message: "User is not a member of this chat",
+		})
+		return
+	}
+	if err != nil {
+		log.Printf("apiSetChatMemberRoleHandler: storage.SetChatMemberRole: %v", err)
+		sendJSONResponse(w, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Message: "Error changing role",
+		})
+		return
+	}
+
+	a.announceRoleChange(chat, user.User, member, req.Role)
+
+	sendJSONResponse(w, http.StatusOK, APIResponse{
+		Success: true,
+		Message: "Role changed",
+	})
+}
+
+// API Transfer Chat Ownership handler
+func (a *App) apiTransferChatOwnershipHandler(w http.ResponseWriter, r *http.Request) {
+	chat, user, ok := a.permittedChat(w, r, "apiTransferChatOwnershipHandler", domain.PermissionManageRoles)
+	if !ok {
+		return
+	}
+
+	var req TransferOwnershipRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendJSONResponse(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: "Invalid request format",
+		})
+		return
+	}
+
+	if req.UserID == user.ID {
+		sendJSONResponse(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: "You already own this chat",
+		})
+		return
+	}
+
+	newOwner, err := a.storage.GetUserByID(req.UserID)
+	if err != nil {
+		log.Printf("apiTransferChatOwnershipHandler: storage.GetUserByID: %v", err)
+		sendJSONResponse(w, http.StatusNotFound, APIResponse{
+			Success: false,
+			Message: "User not found",
+		})
+		return
+	}
+
+	err = a.storage.TransferChatOwnership(chat.ID, user.ID, newOwner.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		sendJSONResponse(w, http.StatusNotFound, APIResponse{
+			Success: false,
+			Message: "User is not a member of this chat",
+		})
+		return
+	}
+	if err != nil {
+		log.Printf("apiTransferChatOwnershipHandler: storage.TransferChatOwnership: %v", err)
+		sendJSONResponse(w, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Message: "Error transferring ownership",
+		})
+		return
+	}
+
+	a.announceRoleChange(chat, user.User, newOwner, domain.ChatRoleOwner)
+	a.broadcastToChat(chat.ID, map[string]interface{}{
+		"action":  "member_role_changed",
+		"chat_id": chat.ID,
+		"user_id": user.ID,
+		"role":    domain.ChatRoleAdmin,
+	})
+
+	sendJSONResponse(w, http.StatusOK, APIResponse{
+		Success: true,
+		Message: "Ownership transferred",
+	})
+}
+
+// addChatMember adds the user to the chat on behalf of actor and lets the
+// chat know about it.
+func (a *App) addChatMember(chat *domain.Chat, actor domain.User, user domain.User) error {
+	err := a.storage.AddUserToChat(chat.ID, user.ID, domain.ChatRoleMember)
+	if err != nil {
+		return fmt.Errorf("storage.AddUserToChat: %w", err)
+	}
+
+	a.announceMemberAdded(chat, actor, user)
+	return nil
+}
+
+// announceMemberAdded lets the chat know that actor added the user to it
+func (a *App) announceMemberAdded(chat *domain.Chat, actor domain.User, user domain.User) {
+	content := fmt.Sprintf("%s added %s", actor.Username, user.Username)
+	if actor.ID == user.ID {
+		content = fmt.Sprintf("%s joined the chat", user.Username)
+	}
+	// Подписчики каналов приходят и уходят слишком часто для служебных сообщений
+	if chat.Type != domain.ChatTypeChannel {
+		a.postSystemMessage(chat.ID, actor, content)
+	}
+
+	a.broadcastToChat(chat.ID, map[string]interface{}{
+		"action":  "member_added",
+		"chat_id": chat.ID,
+		"user":    memberInfo(user),
+	})
+
+	a.emitWebhookEvent(chat.ID, domain.WebhookEventMemberJoined, map[string]interface{}{
+		"user_id":  user.ID,
+		"username": user.Username,
+		"added_by": actor.Username,
+	})
+
+	a.audit(nil, memberEvent(chat, actor, user, domain.AuditMemberAdded), map[string]interface{}{
+		"username": user.Username,
+	})
+}
+
+// removeChatMember removes the user from the chat on behalf of actor. The
+// user's open connections to the chat are closed right away.
+func (a *App) removeChatMember(chat *domain.Chat, actor domain.User, user domain.User) error {
+	err := a.storage.RemoveUserFromChat(chat.ID, user.ID)
+	if err != nil {
+		return fmt.Errorf("storage.RemoveUserFromChat: %w", err)
+	}
+	a.memory.DisconnectUser(chat.ID, user.ID)
+
+	content := fmt.Sprintf("%s removed %s", actor.Username, user.Username)
+	if actor.ID == user.ID {
+		content = fmt.Sprintf("%s left the chat", user.Username)
+	}
+	if chat.Type != domain.ChatTypeChannel {
+		a.postSystemMessage(chat.ID, actor, content)
+	}
+
+	a.broadcastToChat(chat.ID, map[string]interface{}{
+		"action":  "member_removed",
+		"chat_id": chat.ID,
+		"user_id": user.ID,
+	})
+
+	a.audit(nil, memberEvent(chat, actor, user, domain.AuditMemberRemoved), map[string]interface{}{
+		"username": user.Username,
+	})
+	return nil
+}
+
+// mustTransferOwnership reports whether the member owns a chat that still has
+// other members, which would be left without an owner.
+func (a *App) mustTransferOwnership(chat *domain.Chat, member domain.ChatMember) (bool, error) {
+	if member.Role != domain.ChatRoleOwner {
+		return false, nil
+	}
+	members, err := a.storage.GetChatMembersByChatID(chat.ID)
+	if err != nil {
+		return false, fmt.Errorf("storage.GetChatMembersByChatID: %w", err)
+	}
+	return len(members) > 1, nil
+}
+
+// announceRoleChange tells the chat that actor gave the user a new role
+func (a *App) announceRoleChange(chat *domain.Chat, actor domain.User, user domain.User, role domain.ChatRole) {
+	content := fmt.Sprintf("%s made %s a chat %s", actor.Username, user.Username, role)
+	if role == domain.ChatRoleOwner {
+		content = fmt.Sprintf("%s transferred chat ownership to %s", actor.Username, user.Username)
+	}
+	a.postSystemMessage(chat.ID, actor, content)
+
+	a.broadcastToChat(chat.ID, map[string]interface{}{
+		"action":  "member_role_changed",
+		"chat_id": chat.ID,
+		"user_id": user.ID,
+		"role":    role,
+	})
+
+	a.audit(nil, memberEvent(chat, actor, user, domain.AuditMemberRoleChanged), map[string]interface{}{
+		"username": user.Username,
+		"role":     role,
+	})
+}
+
+// memberEvent starts an audit event for a change actor made to the
+// membership of the user
+func memberEvent(chat *domain.Chat, actor domain.User, user domain.User, action string) domain.AuditEvent {
+	event := actorEvent(actor, action)
+	event.ChatID = chat.ID
+	event.Target = fmt.Sprintf("user:%d", user.ID)
+	return event
+}
+
+// postSystemMessage stores and delivers a notice about a change in the chat
+func (a *App) postSystemMessage(chatID int, actor domain.User, content string) {
+	_, err := a.postMessage(domain.Message{
+		ChatID:   chatID,
+		UserID:   actor.ID,
+		Username: actor.Username,
+		Content:  content,
+		IsSystem: true,
+	})
+	if err != nil {
+		log.Printf("postSystemMessage: postMessage: %v", err)
+	}
+}
+
+// memberInfo is the public part of a user shown to other chat members
+func memberInfo(user domain.User) map[string]interface{} {
+	return map[string]interface{}{
+		"id":        user.ID,
+		"username":  user.Username,
+		"full_name": user.Surname + " " + user.Name + " " + user.Patronymic,
+	}
+}
@@ -0,0 +1,119 @@
+package app
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+)
+
+const (
+	mentionsDefaultLimit = 50
+	mentionsMaxLimit     = 200
+)
+
+// Mute chat request structure
+type MuteChatRequest struct {
+	Muted bool `json:"muted"`
+}
+
+// API Mentions handler returns the feed of messages mentioning the current user
+func (a *App) apiMentionsHandler(w http.ResponseWriter, r *http.Request) {
+	if !a.isAuthenticated(r) {
+		sendJSONResponse(w, http.StatusUnauthorized, APIResponse{
+			Success: false,
+			Message: "Not authenticated",
+		})
+		return
+	}
+
+	user, err := a.currentUser(r)
+	if err != nil {
+		log.Printf("apiMentionsHandler: storage.GetUserByUsername: %v", err)
+		sendJSONResponse(w, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Message: "Error retrieving user",
+		})
+		return
+	}
+
+	limit, offset := pagination(r, mentionsDefaultLimit, mentionsMaxLimit)
+	mentions, err := a.storage.GetMentionsByUserID(user.ID, limit, offset)
+	if err != nil {
+		log.Printf("apiMentionsHandler: storage.GetMentionsByUserID: %v", err)
+		sendJSONResponse(w, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Message: "Error retrieving mentions",
+		})
+		return
+	}
+
+	for i := range mentions {
+		// Файлы в ленте не нужны, их можно получить через /api/files/{id}
+		mentions[i].File.Data = ""
+
+		decryptedContent, err := a.cipher.Decrypt(mentions[i].Content)
+		if err != nil {
+			log.Printf("apiMentionsHandler: cipher.Decrypt: %v", err)
+			continue
+		}
+		mentions[i].Content = decryptedContent
+	}
+
+	sendJSONResponse(w, http.StatusOK, APIResponse{
+		Success: true,
+		Data: map[string]interface{}{
+			"mentions": mentions,
+			"limit":    limit,
+			"offset":   offset,
+		},
+	})
+}
+
+// API Mute Chat handler turns regular notifications of the chat off or on
+// for the current user. Mentions are delivered either way.
+func (a *App) apiMuteChatHandler(w http.ResponseWriter, r *http.Request) {
+	chat, user, ok := a.memberChat(w, r, "apiMuteChatHandler")
+	if !ok {
+		return
+	}
+
+	var req MuteChatRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendJSONResponse(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: "Invalid request format",
+		})
+		return
+	}
+
+	err := a.storage.SetChatMuted(chat.ID, user.ID, req.Muted)
+	if errors.Is(err, sql.ErrNoRows) {
+		sendJSONResponse(w, http.StatusForbidden, APIResponse{
+			Success: false,
+			Message: "You are not a member of this chat",
+		})
+		return
+	}
+	if err != nil {
+		log.Printf("apiMuteChatHandler: storage.SetChatMuted: %v", err)
+		sendJSONResponse(w, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Message: "Error updating chat notifications",
+		})
+		return
+	}
+
+	message := "Chat unmuted"
+	if req.Muted {
+		message = "Chat muted"
+	}
+	sendJSONResponse(w, http.StatusOK, APIResponse{
+		Success: true,
+		Message: message,
+		Data: map[string]interface{}{
+			"muted": req.Muted,
+		},
+	})
+}
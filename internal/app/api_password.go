@@ -0,0 +1,188 @@
+package app
+
+import (
+	"chat/internal/domain"
+	"chat/internal/service/password"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Change password request structure
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+// Reset password request structure
+type ResetPasswordRequest struct {
+	Token       string `json:"token"`
+	NewPassword string `json:"new_password"`
+}
+
+// validatePassword checks a new password against the policy. On failure the
+// response is already written.
+func (a *App) validatePassword(w http.ResponseWriter, newPassword string) bool {
+	err := a.passwords.Validate(newPassword)
+	if err == nil {
+		return true
+	}
+
+	message := "Password is too common, it was found in a list of breached passwords"
+	switch {
+	case errors.Is(err, password.ErrTooShort):
+		message = fmt.Sprintf("Password must be at least %d characters long", a.passwords.MinLength)
+	case errors.Is(err, password.ErrTooLong):
+		message = fmt.Sprintf("Password must be at most %d bytes long", password.MaxLength)
+	}
+	sendJSONResponse(w, http.StatusBadRequest, APIResponse{
+		Success: false,
+		Message: message,
+	})
+	return false
+}
+
+// API Change Password handler sets a new password of the current user after
+// checking the current one. Other sessions of the user end.
+func (a *App) apiChangePasswordHandler(w http.ResponseWriter, r *http.Request) {
+	if !a.isAuthenticated(r) {
+		sendJSONResponse(w, http.StatusUnauthorized, APIResponse{
+			Success: false,
+			Message: "Not authenticated",
+		})
+		return
+	}
+
+	var req ChangePasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendJSONResponse(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: "Invalid request format",
+		})
+		return
+	}
+
+	user, err := a.currentUser(r)
+	if err != nil {
+		log.Printf("apiChangePasswordHandler: storage.GetUserByUsername: %v", err)
+		sendJSONResponse(w, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Message: "Error retrieving user",
+		})
+		return
+	}
+
+	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.CurrentPassword))
+	if err != nil {
+		sendJSONResponse(w, http.StatusForbidden, APIResponse{
+			Success: false,
+			Message: "Current password is incorrect",
+		})
+		return
+	}
+
+	if !a.validatePassword(w, req.NewPassword) {
+		return
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		log.Printf("apiChangePasswordHandler: bcrypt.GenerateFromPassword: %v", err)
+		sendJSONResponse(w, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Message: "Error processing request",
+		})
+		return
+	}
+
+	version, err := a.storage.UpdateUserPassword(user.ID, string(hashedPassword))
+	if err != nil {
+		log.Printf("apiChangePasswordHandler: storage.UpdateUserPassword: %v", err)
+		sendJSONResponse(w, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Message: "Error updating password",
+		})
+		return
+	}
+	a.audit(r, actorEvent(user, domain.AuditPasswordChanged), nil)
+
+	// Keep this session while the others end
+	session, _ := a.memory.GetSession(r, "session-name")
+	session.Values["user_id"] = user.ID
+	session.Values["session_version"] = version
+	if err := session.Save(r, w); err != nil {
+		log.Printf("apiChangePasswordHandler: session.Save: %v", err)
+	}
+	sessionID, _ := session.Values["session_id"].(string)
+	a.memory.DisconnectOtherUserClients(user.ID, sessionID)
+
+	sendJSONResponse(w, http.StatusOK, APIResponse{
+		Success: true,
+		Message: "Password changed",
+	})
+}
+
+// API Reset Password handler sets a new password with a one-time token
+// issued by an administrator. All sessions of the user end.
+func (a *App) apiResetPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var req ResetPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendJSONResponse(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: "Invalid request format",
+		})
+		return
+	}
+
+	if !a.validatePassword(w, req.NewPassword) {
+		return
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		log.Printf("apiResetPasswordHandler: bcrypt.GenerateFromPassword: %v", err)
+		sendJSONResponse(w, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Message: "Error processing request",
+		})
+		return
+	}
+
+	userID, err := a.storage.RedeemPasswordResetToken(req.Token, string(hashedPassword))
+	if errors.Is(err, sql.ErrNoRows) {
+		sendJSONResponse(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: "Reset token is invalid or expired",
+		})
+		return
+	}
+	if err != nil {
+		log.Printf("apiResetPasswordHandler: storage.RedeemPasswordResetToken: %v", err)
+		sendJSONResponse(w, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Message: "Error updating password",
+		})
+		return
+	}
+	a.memory.DisconnectUserClients(userID)
+
+	user, err := a.storage.GetUserByID(userID)
+	if err != nil {
+		log.Printf("apiResetPasswordHandler: storage.GetUserByID: %v", err)
+	}
+	event := actorEvent(user, domain.AuditPasswordChanged)
+	event.ActorID = userID
+	a.audit(r, event, map[string]interface{}{
+		"reset": true,
+	})
+
+	sendJSONResponse(w, http.StatusOK, APIResponse{
+		Success: true,
+		Message: "Password changed",
+	})
+}
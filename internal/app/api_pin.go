@@ -0,0 +1,166 @@
+package app
+
+import (
+	"chat/internal/domain"
+	"chat/internal/utils"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// Pin message request structure
+type PinMessageRequest struct {
+	MessageID int `json:"message_id"`
+}
+
+// API Pinned Messages handler
+func (a *App) apiPinnedMessagesHandler(w http.ResponseWriter, r *http.Request) {
+	chat, _, ok := a.memberChat(w, r, "apiPinnedMessagesHandler")
+	if !ok {
+		return
+	}
+
+	pins, err := a.storage.GetPinnedMessages(chat.ID)
+	if err != nil {
+		log.Printf("apiPinnedMessagesHandler: storage.GetPinnedMessages: %v", err)
+		sendJSONResponse(w, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Message: "Error retrieving pinned messages",
+		})
+		return
+	}
+
+	for i := range pins {
+		decryptedContent, err := a.cipher.Decrypt(pins[i].Content)
+		if err != nil {
+			log.Printf("apiPinnedMessagesHandler: cipher.Decrypt: %v", err)
+			continue
+		}
+		pins[i].Content = decryptedContent
+	}
+
+	sendJSONResponse(w, http.StatusOK, APIResponse{
+		Success: true,
+		Data: map[string]interface{}{
+			"pins": pins,
+		},
+	})
+}
+
+// API Pin Message handler
+func (a *App) apiPinMessageHandler(w http.ResponseWriter, r *http.Request) {
+	chat, user, ok := a.memberChat(w, r, "apiPinMessageHandler")
+	if !ok {
+		return
+	}
+
+	if !canPin(chat, user.Role) {
+		sendJSONResponse(w, http.StatusForbidden, APIResponse{
+			Success: false,
+			Message: "You don't have permission to pin messages",
+		})
+		return
+	}
+
+	var req PinMessageRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendJSONResponse(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: "Invalid request format",
+		})
+		return
+	}
+
+	var message domain.Message
+	err := a.storage.GetMessageByID(strconv.Itoa(req.MessageID), &message)
+	if err != nil || message.ChatID != chat.ID || message.DeletedAt != nil {
+		sendJSONResponse(w, http.StatusNotFound, APIResponse{
+			Success: false,
+			Message: "Message not found",
+		})
+		return
+	}
+
+	pinned, err := a.storage.PinMessage(chat.ID, message.ID, user.ID)
+	if err != nil {
+		log.Printf("apiPinMessageHandler: storage.PinMessage: %v", err)
+		sendJSONResponse(w, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Message: "Error pinning message",
+		})
+		return
+	}
+
+	if pinned {
+		a.broadcastToChat(chat.ID, map[string]interface{}{
+			"action":     "pin",
+			"chat_id":    chat.ID,
+			"message_id": message.ID,
+			"pinned":     true,
+			"pinned_by":  user.Username,
+		})
+	}
+
+	sendJSONResponse(w, http.StatusOK, APIResponse{
+		Success: true,
+		Message: "Message pinned",
+	})
+}
+
+// API Unpin Message handler
+func (a *App) apiUnpinMessageHandler(w http.ResponseWriter, r *http.Request) {
+	chat, user, ok := a.memberChat(w, r, "apiUnpinMessageHandler")
+	if !ok {
+		return
+	}
+
+	if !canPin(chat, user.Role) {
+		sendJSONResponse(w, http.StatusForbidden, APIResponse{
+			Success: false,
+			Message: "You don't have permission to unpin messages",
+		})
+		return
+	}
+
+	messageID := utils.Atoi(mux.Vars(r)["message_id"])
+	err := a.storage.UnpinMessage(chat.ID, messageID)
+	if errors.Is(err, sql.ErrNoRows) {
+		sendJSONResponse(w, http.StatusNotFound, APIResponse{
+			Success: false,
+			Message: "Message is not pinned",
+		})
+		return
+	}
+	if err != nil {
+		log.Printf("apiUnpinMessageHandler: storage.UnpinMessage: %v", err)
+		sendJSONResponse(w, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Message: "Error unpinning message",
+		})
+		return
+	}
+
+	a.broadcastToChat(chat.ID, map[string]interface{}{
+		"action":     "pin",
+		"chat_id":    chat.ID,
+		"message_id": messageID,
+		"pinned":     false,
+		"pinned_by":  user.Username,
+	})
+
+	sendJSONResponse(w, http.StatusOK, APIResponse{
+		Success: true,
+		Message: "Message unpinned",
+	})
+}
+
+// canPin reports whether a member with the role may pin messages. Both
+// members of a private chat can pin.
+func canPin(chat *domain.Chat, role domain.ChatRole) bool {
+	return chat.Type == domain.ChatTypePrivate || role.Can(domain.PermissionPinMessages)
+}
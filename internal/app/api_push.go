@@ -0,0 +1,224 @@
+package app
+
+import (
+	"chat/internal/domain"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+)
+
+// maxPushPreviewLength limits the message text carried by a push
+// notification, in runes
+const maxPushPreviewLength = 200
+
+// Push subscription request structure, as PushSubscription.toJSON() in the
+// browser returns it
+type PushSubscriptionRequest struct {
+	Endpoint string `json:"endpoint"`
+	Keys     struct {
+		P256dh string `json:"p256dh"`
+		Auth   string `json:"auth"`
+	} `json:"keys"`
+}
+
+// PushNotification is the payload the service worker receives
+type PushNotification struct {
+	ChatID    int    `json:"chat_id"`
+	MessageID int    `json:"message_id"`
+	Username  string `json:"username"`
+	Content   string `json:"content"`
+	FileName  string `json:"file_name,omitempty"`
+	Mention   bool   `json:"mention"`
+}
+
+// pushConfigured writes the response for a server without Web Push
+func (a *App) pushConfigured(w http.ResponseWriter) bool {
+	if a.push != nil {
+		return true
+	}
+	sendJSONResponse(w, http.StatusNotFound, APIResponse{
+		Success: false,
+		Message: "Push notifications are not configured",
+	})
+	return false
+}
+
+// API Push Public Key handler returns the VAPID key browsers subscribe with
+func (a *App) apiPushPublicKeyHandler(w http.ResponseWriter, r *http.Request) {
+	if !a.pushConfigured(w) {
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, APIResponse{
+		Success: true,
+		Data: map[string]interface{}{
+			"public_key": a.push.PublicKey(),
+		},
+	})
+}
+
+// API Create Push Subscription handler subscribes the browser of the current
+// user to push notifications
+func (a *App) apiCreatePushSubscriptionHandler(w http.ResponseWriter, r *http.Request) {
+	if !a.isAuthenticated(r) {
+		sendJSONResponse(w, http.StatusUnauthorized, APIResponse{
+			Success: false,
+			Message: "Not authenticated",
+		})
+		return
+	}
+	if !a.pushConfigured(w) {
+		return
+	}
+
+	var req PushSubscriptionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendJSONResponse(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: "Invalid request format",
+		})
+		return
+	}
+
+	sub := domain.PushSubscription{
+		Endpoint: req.Endpoint,
+		P256dh:   req.Keys.P256dh,
+		Auth:     req.Keys.Auth,
+	}
+	if err := a.push.ValidateSubscription(sub); err != nil {
+		sendJSONResponse(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: "Invalid subscription: " + err.Error(),
+		})
+		return
+	}
+
+	user, err := a.currentUser(r)
+	if err != nil {
+		log.Printf("apiCreatePushSubscriptionHandler: storage.GetUserByUsername: %v", err)
+		sendJSONResponse(w, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Message: "Error retrieving user",
+		})
+		return
+	}
+	sub.UserID = user.ID
+
+	sub.ID, err = a.storage.UpsertPushSubscription(sub)
+	if err != nil {
+		log.Printf("apiCreatePushSubscriptionHandler: storage.UpsertPushSubscription: %v", err)
+		sendJSONResponse(w, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Message: "Error saving subscription",
+		})
+		return
+	}
+
+	sendJSONResponse(w, http.StatusCreated, APIResponse{
+		Success: true,
+		Message: "Subscribed to push notifications",
+		Data: map[string]interface{}{
+			"subscription_id": sub.ID,
+		},
+	})
+}
+
+// API Delete Push Subscription handler unsubscribes a browser of the current
+// user
+func (a *App) apiDeletePushSubscriptionHandler(w http.ResponseWriter, r *http.Request) {
+	if !a.isAuthenticated(r) {
+		sendJSONResponse(w, http.StatusUnauthorized, APIResponse{
+			Success: false,
+			Message: "Not authenticated",
+		})
+		return
+	}
+
+	var req PushSubscriptionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendJSONResponse(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: "Invalid request format",
+		})
+		return
+	}
+
+	user, err := a.currentUser(r)
+	if err != nil {
+		log.Printf("apiDeletePushSubscriptionHandler: storage.GetUserByUsername: %v", err)
+		sendJSONResponse(w, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Message: "Error retrieving user",
+		})
+		return
+	}
+
+	err = a.storage.DeletePushSubscription(user.ID, req.Endpoint)
+	if errors.Is(err, sql.ErrNoRows) {
+		sendJSONResponse(w, http.StatusNotFound, APIResponse{
+			Success: false,
+			Message: "Subscription not found",
+		})
+		return
+	}
+	if err != nil {
+		log.Printf("apiDeletePushSubscriptionHandler: storage.DeletePushSubscription: %v", err)
+		sendJSONResponse(w, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Message: "Error deleting subscription",
+		})
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, APIResponse{
+		Success: true,
+		Message: "Unsubscribed from push notifications",
+	})
+}
+
+// pushMessage sends the message to the browsers of the members who have no
+// open connection and haven't muted the chat, or are mentioned. Sending runs
+// in the background so that slow push services don't hold up the sender.
+func (a *App) pushMessage(msg domain.Message, mentioned []int) {
+	if a.push == nil {
+		return
+	}
+
+	go func() {
+		userIDs, err := a.storage.GetPushRecipientIDs(msg.ChatID, msg.UserID, mentioned)
+		if err != nil {
+			log.Printf("pushMessage: storage.GetPushRecipientIDs: %v", err)
+			return
+		}
+
+		content := []rune(msg.Content)
+		if len(content) > maxPushPreviewLength {
+			content = append(content[:maxPushPreviewLength-1], '…')
+		}
+		notification := PushNotification{
+			ChatID:    msg.ChatID,
+			MessageID: msg.ID,
+			Username:  msg.Username,
+			Content:   string(content),
+			FileName:  msg.File.Name,
+		}
+
+		for _, userID := range userIDs {
+			if len(a.memory.GetClientsByUserID(userID)) > 0 {
+				continue
+			}
+
+			notification.Mention = slices.Contains(mentioned, userID)
+			payload, err := json.Marshal(notification)
+			if err != nil {
+				log.Printf("pushMessage: json.Marshal: %v", err)
+				return
+			}
+			a.push.Notify(context.Background(), userID, payload)
+		}
+	}()
+}
@@ -0,0 +1,127 @@
+package app
+
+import (
+	"chat/internal/domain"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+)
+
+// Set retention request structure. A null value makes the chat follow the
+// default policy, zero keeps its history forever.
+type SetRetentionRequest struct {
+	RetentionDays *int `json:"retention_days"`
+}
+
+// API Chat Retention handler returns the retention policy of the chat and
+// a dry-run report of the messages the next purge would remove
+func (a *App) apiChatRetentionHandler(w http.ResponseWriter, r *http.Request) {
+	chat, _, ok := a.permittedChat(w, r, "apiChatRetentionHandler", domain.PermissionEditChat)
+	if !ok {
+		return
+	}
+
+	reports, err := a.storage.GetRetentionReport(a.cfg.Retention.DefaultDays, chat.ID)
+	if err != nil {
+		log.Printf("apiChatRetentionHandler: storage.GetRetentionReport: %v", err)
+		sendJSONResponse(w, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Message: "Error building retention report",
+		})
+		return
+	}
+
+	report := domain.RetentionReport{
+		ChatID:        chat.ID,
+		ChatName:      chat.Name,
+		RetentionDays: a.retentionDays(chat),
+	}
+	if len(reports) > 0 {
+		report = reports[0]
+	}
+
+	sendJSONResponse(w, http.StatusOK, APIResponse{
+		Success: true,
+		Data: map[string]interface{}{
+			"retention_days": chat.RetentionDays,
+			"default_days":   a.cfg.Retention.DefaultDays,
+			"archive":        a.cfg.Retention.Archive,
+			"report":         report,
+		},
+	})
+}
+
+// API Set Chat Retention handler
+func (a *App) apiSetChatRetentionHandler(w http.ResponseWriter, r *http.Request) {
+	chat, user, ok := a.permittedChat(w, r, "apiSetChatRetentionHandler", domain.PermissionEditChat)
+	if !ok {
+		return
+	}
+
+	var req SetRetentionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendJSONResponse(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: "Invalid request format",
+		})
+		return
+	}
+
+	if chat.Type == domain.ChatTypePrivate {
+		sendJSONResponse(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: "Private chats follow the default retention policy",
+		})
+		return
+	}
+
+	if req.RetentionDays != nil && *req.RetentionDays < 0 {
+		sendJSONResponse(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: "Retention period can't be negative",
+		})
+		return
+	}
+
+	err := a.storage.SetChatRetention(chat.ID, req.RetentionDays)
+	if err != nil {
+		log.Printf("apiSetChatRetentionHandler: storage.SetChatRetention: %v", err)
+		sendJSONResponse(w, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Message: "Error updating chat",
+		})
+		return
+	}
+
+	updated := *chat
+	updated.RetentionDays = req.RetentionDays
+	if days := a.retentionDays(&updated); days != a.retentionDays(chat) {
+		if days > 0 {
+			a.postSystemMessage(chat.ID, user.User, fmt.Sprintf("%s set the chat to keep messages for %d days", user.Username, days))
+		} else {
+			a.postSystemMessage(chat.ID, user.User, user.Username+" set the chat to keep messages forever")
+		}
+	}
+	a.broadcastToChat(chat.ID, map[string]interface{}{
+		"action": "chat_updated",
+		"chat":   updated,
+	})
+
+	sendJSONResponse(w, http.StatusOK, APIResponse{
+		Success: true,
+		Message: "Retention policy updated",
+		Data: map[string]interface{}{
+			"retention_days": req.RetentionDays,
+		},
+	})
+}
+
+// retentionDays returns how many days the chat keeps its history, 0 meaning
+// forever
+func (a *App) retentionDays(chat *domain.Chat) int {
+	if chat.RetentionDays != nil {
+		return *chat.RetentionDays
+	}
+	return a.cfg.Retention.DefaultDays
+}
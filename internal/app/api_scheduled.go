@@ -0,0 +1,182 @@
+package app
+
+import (
+	"chat/internal/domain"
+	"chat/internal/utils"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const maxScheduleAhead = 365 * 24 * time.Hour
+
+// Schedule message request structure
+type ScheduleMessageRequest struct {
+	Content string      `json:"content"`
+	File    domain.File `json:"file"`
+	SendAt  time.Time   `json:"send_at"` // RFC 3339
+}
+
+// API Schedule Message handler
+func (a *App) apiScheduleMessageHandler(w http.ResponseWriter, r *http.Request) {
+	chat, user, ok := a.memberChat(w, r, "apiScheduleMessageHandler")
+	if !ok {
+		return
+	}
+
+	if !canPost(chat, user.Role) {
+		sendJSONResponse(w, http.StatusForbidden, APIResponse{
+			Success: false,
+			Message: "Only channel admins can post here",
+		})
+		return
+	}
+
+	var req ScheduleMessageRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendJSONResponse(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: "Invalid request format",
+		})
+		return
+	}
+
+	if req.Content == "" && req.File.Data == "" {
+		sendJSONResponse(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: "Message is empty",
+		})
+		return
+	}
+
+	now := time.Now()
+	if !req.SendAt.After(now) || req.SendAt.After(now.Add(maxScheduleAhead)) {
+		sendJSONResponse(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: "Send time must be in the future and within a year",
+		})
+		return
+	}
+
+	encryptedContent, err := a.cipher.Encrypt(req.Content)
+	if err != nil {
+		log.Printf("apiScheduleMessageHandler: cipher.Encrypt: %v", err)
+		sendJSONResponse(w, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Message: "Error scheduling message",
+		})
+		return
+	}
+
+	scheduled := domain.ScheduledMessage{
+		ChatID:    chat.ID,
+		UserID:    user.ID,
+		Content:   encryptedContent,
+		SendAt:    req.SendAt.UTC(),
+		Status:    domain.ScheduledMessageStatusPending,
+		CreatedAt: now,
+	}
+
+	if req.File.Data != "" {
+		scheduled.AttachmentID, err = a.storage.InsertAttachment(req.File, user.ID)
+		if err != nil {
+			log.Printf("apiScheduleMessageHandler: storage.InsertAttachment: %v", err)
+			sendJSONResponse(w, http.StatusInternalServerError, APIResponse{
+				Success: false,
+				Message: "Error saving file",
+			})
+			return
+		}
+		scheduled.File.Name = req.File.Name
+	}
+
+	scheduled.ID, err = a.storage.InsertScheduledMessage(scheduled)
+	if err != nil {
+		log.Printf("apiScheduleMessageHandler: storage.InsertScheduledMessage: %v", err)
+		sendJSONResponse(w, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Message: "Error scheduling message",
+		})
+		return
+	}
+	scheduled.Content = req.Content
+
+	sendJSONResponse(w, http.StatusCreated, APIResponse{
+		Success: true,
+		Message: "Message scheduled",
+		Data: map[string]interface{}{
+			"scheduled_message": scheduled,
+		},
+	})
+}
+
+// API Scheduled Messages handler lists pending messages the current user
+// scheduled in the chat
+func (a *App) apiScheduledMessagesHandler(w http.ResponseWriter, r *http.Request) {
+	chat, user, ok := a.memberChat(w, r, "apiScheduledMessagesHandler")
+	if !ok {
+		return
+	}
+
+	messages, err := a.storage.GetScheduledMessages(chat.ID, user.ID)
+	if err != nil {
+		log.Printf("apiScheduledMessagesHandler: storage.GetScheduledMessages: %v", err)
+		sendJSONResponse(w, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Message: "Error retrieving scheduled messages",
+		})
+		return
+	}
+
+	for i := range messages {
+		decryptedContent, err := a.cipher.Decrypt(messages[i].Content)
+		if err != nil {
+			log.Printf("apiScheduledMessagesHandler: cipher.Decrypt: %v", err)
+			continue
+		}
+		messages[i].Content = decryptedContent
+	}
+
+	sendJSONResponse(w, http.StatusOK, APIResponse{
+		Success: true,
+		Data: map[string]interface{}{
+			"scheduled_messages": messages,
+		},
+	})
+}
+
+// API Cancel Scheduled Message handler
+func (a *App) apiCancelScheduledMessageHandler(w http.ResponseWriter, r *http.Request) {
+	chat, user, ok := a.memberChat(w, r, "apiCancelScheduledMessageHandler")
+	if !ok {
+		return
+	}
+
+	scheduledID := utils.Atoi(mux.Vars(r)["scheduled_id"])
+	err := a.storage.CancelScheduledMessage(chat.ID, user.ID, scheduledID)
+	if errors.Is(err, sql.ErrNoRows) {
+		sendJSONResponse(w, http.StatusNotFound, APIResponse{
+			Success: false,
+			Message: "Scheduled message not found or already sent",
+		})
+		return
+	}
+	if err != nil {
+		log.Printf("apiCancelScheduledMessageHandler: storage.CancelScheduledMessage: %v", err)
+		sendJSONResponse(w, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Message: "Error canceling scheduled message",
+		})
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, APIResponse{
+		Success: true,
+		Message: "Scheduled message canceled",
+	})
+}
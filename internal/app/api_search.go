@@ -0,0 +1,141 @@
+package app
+
+import (
+	"chat/internal/domain"
+	"chat/internal/utils"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	searchDefaultLimit = 20
+	searchMaxLimit     = 100
+)
+
+// API Search handler looks for messages containing all words of the query
+// in the chats of the current user. Optional filters: chat_id, author, from
+// and to (RFC 3339 or YYYY-MM-DD), has_attachment.
+func (a *App) apiSearchHandler(w http.ResponseWriter, r *http.Request) {
+	if !a.isAuthenticated(r) {
+		sendJSONResponse(w, http.StatusUnauthorized, APIResponse{
+			Success: false,
+			Message: "Not authenticated",
+		})
+		return
+	}
+
+	if a.search == nil {
+		sendJSONResponse(w, http.StatusNotFound, APIResponse{
+			Success: false,
+			Message: "Search is not enabled on this server",
+		})
+		return
+	}
+
+	query := r.URL.Query()
+	tokens := a.search.QueryTokens(query.Get("q"))
+	if len(tokens) == 0 {
+		sendJSONResponse(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: "Search query must contain at least one word of two or more characters",
+		})
+		return
+	}
+
+	filter := domain.SearchFilter{
+		ChatID:         utils.Atoi(query.Get("chat_id")),
+		AuthorUsername: query.Get("author"),
+	}
+
+	var err error
+	filter.From, err = parseSearchTime(query.Get("from"), false)
+	if err != nil {
+		sendJSONResponse(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: "Invalid from date",
+		})
+		return
+	}
+	filter.To, err = parseSearchTime(query.Get("to"), true)
+	if err != nil {
+		sendJSONResponse(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: "Invalid to date",
+		})
+		return
+	}
+
+	if value := query.Get("has_attachment"); value != "" {
+		hasAttachment, err := strconv.ParseBool(value)
+		if err != nil {
+			sendJSONResponse(w, http.StatusBadRequest, APIResponse{
+				Success: false,
+				Message: "Invalid has_attachment value",
+			})
+			return
+		}
+		filter.HasAttachment = &hasAttachment
+	}
+
+	user, err := a.currentUser(r)
+	if err != nil {
+		log.Printf("apiSearchHandler: storage.GetUserByUsername: %v", err)
+		sendJSONResponse(w, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Message: "Error retrieving user",
+		})
+		return
+	}
+
+	limit, offset := pagination(r, searchDefaultLimit, searchMaxLimit)
+	results, err := a.storage.SearchMessages(user.ID, tokens, filter, limit, offset)
+	if err != nil {
+		log.Printf("apiSearchHandler: storage.SearchMessages: %v", err)
+		sendJSONResponse(w, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Message: "Error searching messages",
+		})
+		return
+	}
+
+	for i := range results {
+		results[i].File.Data = ""
+
+		decryptedContent, err := a.cipher.Decrypt(results[i].Content)
+		if err != nil {
+			log.Printf("apiSearchHandler: cipher.Decrypt: %v", err)
+			continue
+		}
+		results[i].Content = decryptedContent
+	}
+
+	sendJSONResponse(w, http.StatusOK, APIResponse{
+		Success: true,
+		Data: map[string]interface{}{
+			"results": results,
+			"limit":   limit,
+			"offset":  offset,
+		},
+	})
+}
+
+// parseSearchTime accepts an RFC 3339 timestamp or a date. A date used as
+// the upper bound includes the whole day. An empty value means no bound.
+func parseSearchTime(value string, upper bool) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		t, err = time.Parse(time.DateOnly, value)
+		if err != nil {
+			return nil, err
+		}
+		if upper {
+			t = t.AddDate(0, 0, 1)
+		}
+	}
+	return &t, nil
+}
@@ -0,0 +1,229 @@
+package app
+
+import (
+	"chat/internal/domain"
+	"chat/internal/utils"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const webhookDeliveriesLimit = 100
+
+// Create webhook request structure
+type CreateWebhookRequest struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+}
+
+// Webhook event envelope posted to webhook targets
+type WebhookEvent struct {
+	Event      string      `json:"event"`
+	ChatID     int         `json:"chat_id"`
+	OccurredAt time.Time   `json:"occurred_at"`
+	Data       interface{} `json:"data"`
+}
+
+// API List Webhooks handler
+func (a *App) apiWebhooksHandler(w http.ResponseWriter, r *http.Request) {
+	chat, _, ok := a.permittedChat(w, r, "apiWebhooksHandler", domain.PermissionManageWebhooks)
+	if !ok {
+		return
+	}
+
+	webhooks, err := a.storage.GetWebhooksByChatID(chat.ID)
+	if err != nil {
+		log.Printf("apiWebhooksHandler: storage.GetWebhooksByChatID: %v", err)
+		sendJSONResponse(w, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Message: "Error retrieving webhooks",
+		})
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, APIResponse{
+		Success: true,
+		Data: map[string]interface{}{
+			"webhooks": webhooks,
+		},
+	})
+}
+
+// API Create Webhook handler
+func (a *App) apiCreateWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	chat, user, ok := a.permittedChat(w, r, "apiCreateWebhookHandler", domain.PermissionManageWebhooks)
+	if !ok {
+		return
+	}
+
+	var req CreateWebhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendJSONResponse(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: "Invalid request format",
+		})
+		return
+	}
+
+	target, err := url.Parse(req.URL)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		sendJSONResponse(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: "Webhook URL must be an absolute http(s) URL",
+		})
+		return
+	}
+	// Names resolving to the server network are refused when delivering
+	if !a.cfg.Webhooks.AllowPrivateEndpoints && utils.PrivateHost(target.Hostname()) {
+		sendJSONResponse(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: "Webhook URL must point to a public host",
+		})
+		return
+	}
+
+	if len(req.Events) == 0 {
+		sendJSONResponse(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: "At least one event must be selected",
+		})
+		return
+	}
+	for _, event := range req.Events {
+		if !slices.Contains(domain.WebhookEvents, event) {
+			sendJSONResponse(w, http.StatusBadRequest, APIResponse{
+				Success: false,
+				Message: "Unknown event: " + event,
+			})
+			return
+		}
+	}
+
+	secret, err := utils.RandomToken(32)
+	if err != nil {
+		log.Printf("apiCreateWebhookHandler: utils.RandomToken: %v", err)
+		sendJSONResponse(w, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Message: "Error processing request",
+		})
+		return
+	}
+
+	webhook := domain.Webhook{
+		ChatID:    chat.ID,
+		CreatorID: user.ID,
+		URL:       target.String(),
+		Secret:    secret,
+		Events:    req.Events,
+		CreatedAt: time.Now(),
+	}
+	webhook.ID, err = a.storage.InsertWebhook(webhook)
+	if err != nil {
+		log.Printf("apiCreateWebhookHandler: storage.InsertWebhook: %v", err)
+		sendJSONResponse(w, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Message: "Error creating webhook",
+		})
+		return
+	}
+
+	// The secret is only ever shown once, right after creation
+	sendJSONResponse(w, http.StatusCreated, APIResponse{
+		Success: true,
+		Message: "Webhook created",
+		Data: map[string]interface{}{
+			"webhook": webhook,
+			"secret":  secret,
+		},
+	})
+}
+
+// API Delete Webhook handler
+func (a *App) apiDeleteWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	chat, _, ok := a.permittedChat(w, r, "apiDeleteWebhookHandler", domain.PermissionManageWebhooks)
+	if !ok {
+		return
+	}
+
+	webhookID := utils.Atoi(mux.Vars(r)["webhook_id"])
+	err := a.storage.DeleteWebhook(chat.ID, webhookID)
+	if errors.Is(err, sql.ErrNoRows) {
+		sendJSONResponse(w, http.StatusNotFound, APIResponse{
+			Success: false,
+			Message: "Webhook not found",
+		})
+		return
+	}
+	if err != nil {
+		log.Printf("apiDeleteWebhookHandler: storage.DeleteWebhook: %v", err)
+		sendJSONResponse(w, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Message: "Error deleting webhook",
+		})
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, APIResponse{
+		Success: true,
+		Message: "Webhook deleted",
+	})
+}
+
+// API Webhook Delivery Log handler
+func (a *App) apiWebhookDeliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	chat, _, ok := a.permittedChat(w, r, "apiWebhookDeliveriesHandler", domain.PermissionManageWebhooks)
+	if !ok {
+		return
+	}
+
+	webhookID := utils.Atoi(mux.Vars(r)["webhook_id"])
+	deliveries, err := a.storage.GetWebhookDeliveries(chat.ID, webhookID, webhookDeliveriesLimit)
+	if err != nil {
+		log.Printf("apiWebhookDeliveriesHandler: storage.GetWebhookDeliveries: %v", err)
+		sendJSONResponse(w, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Message: "Error retrieving deliveries",
+		})
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, APIResponse{
+		Success: true,
+		Data: map[string]interface{}{
+			"deliveries": deliveries,
+		},
+	})
+}
+
+// emitWebhookEvent queues the event for every webhook of the chat subscribed
+// to it. The payload is stored encrypted, like message content.
+func (a *App) emitWebhookEvent(chatID int, event string, data interface{}) {
+	payload, err := json.Marshal(WebhookEvent{
+		Event:      event,
+		ChatID:     chatID,
+		OccurredAt: time.Now().UTC(),
+		Data:       data,
+	})
+	if err != nil {
+		log.Printf("emitWebhookEvent: json.Marshal: %v", err)
+		return
+	}
+
+	encryptedPayload, err := a.cipher.Encrypt(string(payload))
+	if err != nil {
+		log.Printf("emitWebhookEvent: cipher.Encrypt: %v", err)
+		return
+	}
+
+	err = a.storage.EnqueueWebhookEvent(chatID, event, encryptedPayload)
+	if err != nil {
+		log.Printf("emitWebhookEvent: storage.EnqueueWebhookEvent: %v", err)
+	}
+}
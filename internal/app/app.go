@@ -3,9 +3,19 @@ package app
 import (
 	"chat/internal/config"
 	"chat/internal/domain"
+	"chat/internal/service/archive"
+	"chat/internal/service/password"
+	"chat/internal/service/search"
+	"chat/internal/service/webpush"
+	"database/sql"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/gorilla/mux"
@@ -16,16 +26,83 @@ import (
 type Storage interface {
 	GetChatByID(chatID int) (*domain.Chat, error)
 	GetMessagesByChatID(chatID int) ([]domain.Message, error)
-	GetChatMembersByChatID(chatID int) ([]domain.User, error)
+	GetChatMessagesAfter(chatID int, afterTime time.Time, afterID int, limit int) ([]domain.Message, error)
+	GetChatMembersByChatID(chatID int) ([]domain.ChatMember, error)
+	IsChatMember(chatID int, userID int) (bool, error)
+	GetChatMemberRole(chatID int, userID int) (domain.ChatRole, error)
+	SetChatMemberRole(chatID int, userID int, role domain.ChatRole) error
+	TransferChatOwnership(chatID int, ownerID int, newOwnerID int) error
 	GetUserIDByUsername(username string) (int, error)
 	GetUserByUsername(username string) (domain.User, error)
 	GetChatsByUserID(userID int) ([]domain.UserChat, error)
 	GetAllOtherUsers(username string) ([]domain.User, error)
+	SearchUsers(query string, limit int, offset int) ([]domain.User, error)
+	SetUserDeactivated(userID int, deactivated bool) error
+	SetUserAdmin(userID int, isAdmin bool) error
+	PromoteAdmins(usernames []string) ([]string, error)
+	UsernameReserved(username string) (bool, error)
+	UpdateUserPassword(userID int, password string) (int, error)
+	InsertPasswordResetToken(token domain.PasswordResetToken) (int, error)
+	RedeemPasswordResetToken(token string, password string) (int, error)
+	GetEmailSettings(userID int) (domain.EmailSettings, error)
+	UpsertEmailSettings(settings domain.EmailSettings) error
+	UnsubscribeTokenExists(token string) (bool, error)
+	UnsubscribeEmail(token string) error
+	UpsertPushSubscription(sub domain.PushSubscription) (int, error)
+	DeletePushSubscription(userID int, endpoint string) error
+	DeletePushSubscriptionByEndpoint(endpoint string) error
+	GetPushSubscriptionsByUserID(userID int) ([]domain.PushSubscription, error)
+	GetPushRecipientIDs(chatID int, authorID int, mentioned []int) ([]int, error)
+	DeleteUser(userID int, purge bool, deletedBy int) ([]domain.Message, error)
+	GetInstanceStats() (domain.InstanceStats, error)
 	InsertChat(chat domain.Chat) (int, error)
-	AddUserToChat(chatID int, userID int) error
+	SearchPublicChats(userID int, chatType domain.ChatType, query string, limit int, offset int) ([]domain.DirectoryChat, error)
+	AddUserToChat(chatID int, userID int, role domain.ChatRole) error
+	RemoveUserFromChat(chatID int, userID int) error
+	UpdateChat(chat domain.Chat) error
+	SetChatMessageTTL(chatID int, ttl int) error
+	SetChatRetention(chatID int, days *int) error
+	PurgeExpiredMessages(defaultDays int, archive bool, limit int) ([]domain.Message, error)
+	GetRetentionReport(defaultDays int, chatID int) ([]domain.RetentionReport, error)
+	InsertAttachment(file domain.File, uploaderID int) (int, error)
+	GetAttachmentByID(attachmentID int) (domain.Attachment, error)
+	DeleteAttachment(attachmentID int) error
+	InsertMessageMentions(chatID int, messageID int, authorID int, usernames []string, all bool) ([]int, error)
+	GetMentionsByUserID(userID int, limit int, offset int) ([]domain.Mention, error)
+	CountUnreadMentions(chatID int, userID int, timepoint time.Time) (int, error)
+	SetChatMuted(chatID int, userID int, muted bool) error
+	IsChatMuted(chatID int, userID int) (bool, error)
+	IndexMessage(messageID int, tokens []string) error
+	SearchMessages(userID int, tokens []string, filter domain.SearchFilter, limit int, offset int) ([]domain.SearchResult, error)
+	InsertScheduledMessage(message domain.ScheduledMessage) (int, error)
+	GetScheduledMessages(chatID int, userID int) ([]domain.ScheduledMessage, error)
+	CancelScheduledMessage(chatID int, userID int, scheduledID int) error
+	SendDueScheduledMessages(limit int, lease time.Duration, allowed func(domain.ScheduledMessage) bool) ([]int, error)
+	ClaimUndeliveredScheduledMessages(limit int, lease time.Duration) ([]int, error)
+	MarkScheduledMessageDelivered(messageID int) error
+	PinMessage(chatID int, messageID int, userID int) (bool, error)
+	UnpinMessage(chatID int, messageID int) error
+	GetPinnedMessages(chatID int) ([]domain.PinnedMessage, error)
+	InsertChatInvite(invite domain.ChatInvite) (int, error)
+	GetChatInvitesByChatID(chatID int) ([]domain.ChatInvite, error)
+	RevokeChatInvite(chatID int, inviteID int) error
+	RedeemChatInvite(token string, userID int) (int, bool, error)
 	GetUserByID(id int) (domain.User, error)
 	GetChatIDByUserIDs(firstID int, secondID int) (int, error)
-	DeleteMessage(messageID string) error
+	DeleteMessage(messageID string, deletedBy int) error
+	GetDeletedMessages(chatID int, limit int, offset int) ([]domain.Message, error)
+	InsertAuditEvent(event domain.AuditEvent) (domain.AuditEvent, error)
+	GetAuditEvents(filter domain.AuditFilter, limit int, offset int) ([]domain.AuditEvent, error)
+	GetAuditEventsAfter(afterID int64, limit int) ([]domain.AuditEvent, error)
+	InsertLegalHold(hold domain.LegalHold) (int, error)
+	GetLegalHolds() ([]domain.LegalHold, error)
+	ReleaseLegalHold(holdID int, releasedBy int) error
+	InsertEDiscoveryExport(export domain.EDiscoveryExport) (int, error)
+	FinishEDiscoveryExport(export domain.EDiscoveryExport) error
+	GetEDiscoveryExports() ([]domain.EDiscoveryExport, error)
+	GetEDiscoveryExport(exportID int) (domain.EDiscoveryExport, error)
+	ForEachEDiscoveryMessage(export domain.EDiscoveryExport, fn func(domain.Message, string) error) error
+	DeleteExpiredMessages(limit int) ([]domain.Message, error)
 	UpdateMessageContent(messageID string, content string) error
 	GetUsernameByMessageID(messageID int) (string, error)
 	UpdateUserStatus(username string, status string) error
@@ -34,13 +111,22 @@ type Storage interface {
 	UpdateLastChatVisitTime(chatID int, userID int) error
 	CountUnreadMessages(chatID int, userID int, timepoint time.Time) (int, error)
 	GetMessageByID(messageID string, message *domain.Message) error
+	InsertWebhook(webhook domain.Webhook) (int, error)
+	GetWebhooksByChatID(chatID int) ([]domain.Webhook, error)
+	DeleteWebhook(chatID int, webhookID int) error
+	EnqueueWebhookEvent(chatID int, event string, payload string) error
+	GetWebhookDeliveries(chatID int, webhookID int, limit int) ([]domain.WebhookDelivery, error)
 }
 
 type Memory interface {
 	GetSession(r *http.Request, name string) (*sessions.Session, error)
-	GetClientsByChatID(chatID int) []domain.Client
-	DeleteClient(client domain.Client)
-	AddClient(client domain.Client)
+	GetClientsByChatID(chatID int) []*domain.Client
+	GetClientsByUserID(userID int) []*domain.Client
+	DeleteClient(client *domain.Client)
+	AddClient(client *domain.Client)
+	DisconnectUser(chatID int, userID int)
+	DisconnectUserClients(userID int)
+	DisconnectOtherUserClients(userID int, sessionID string)
 }
 
 type Cipher interface {
@@ -49,15 +135,32 @@ type Cipher interface {
 }
 
 type App struct {
-	cfg      *config.Config
-	router   *mux.Router
-	upgrader websocket.Upgrader
-	storage  Storage
-	memory   Memory
-	cipher   Cipher
+	cfg       *config.Config
+	router    *mux.Router
+	upgrader  websocket.Upgrader
+	storage   Storage
+	memory    Memory
+	cipher    Cipher
+	search    *search.Service // nil if search is disabled
+	signer    *archive.Signer
+	passwords *password.Policy
+	push      *webpush.Service // nil if Web Push is not configured
+	commands  map[string]Command
+
+	auditMu     sync.Mutex
+	auditExport *os.File // nil if audit events are not exported
 }
 
 func NewApp(cfg *config.Config, storage Storage, memory Memory, cipher Cipher) (*App, error) {
+	signer, err := archive.NewSigner(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("archive.NewSigner: %w", err)
+	}
+	push, err := webpush.NewService(cfg, storage)
+	if err != nil {
+		return nil, fmt.Errorf("webpush.NewService: %w", err)
+	}
+
 	r := mux.NewRouter()
 	app := App{
 		cfg:    cfg,
@@ -67,9 +170,36 @@ func NewApp(cfg *config.Config, storage Storage, memory Memory, cipher Cipher) (
 				return true
 			},
 		},
-		storage: storage,
-		memory:  memory,
-		cipher:  cipher,
+		storage:   storage,
+		memory:    memory,
+		cipher:    cipher,
+		search:    search.NewService(cfg),
+		signer:    signer,
+		passwords: password.NewPolicy(cfg),
+		push:      push,
+		commands:  make(map[string]Command),
+	}
+	app.registerCommands()
+
+	// Accounts listed in the config become administrators once, at startup,
+	// so that a listed name grants nothing to whoever registers it later
+	if len(cfg.Admins) > 0 {
+		promoted, err := storage.PromoteAdmins(cfg.Admins)
+		if err != nil {
+			return nil, fmt.Errorf("storage.PromoteAdmins: %w", err)
+		}
+		for _, username := range cfg.Admins {
+			if !slices.Contains(promoted, username) {
+				log.Printf("NewApp: admin %q has no account", username)
+			}
+		}
+	}
+
+	if cfg.Audit.ExportPath != "" {
+		app.auditExport, err = os.OpenFile(cfg.Audit.ExportPath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+		if err != nil {
+			return nil, fmt.Errorf("os.OpenFile: %w", err)
+		}
 	}
 
 	// API routes will be handled by the API subrouter
@@ -83,15 +213,76 @@ func NewApp(cfg *config.Config, storage Storage, memory Memory, cipher Cipher) (
 	api.HandleFunc("/login", app.apiLoginHandler).Methods("POST")
 	api.HandleFunc("/register", app.apiRegisterHandler).Methods("POST")
 	api.HandleFunc("/logout", app.apiLogoutHandler).Methods("POST")
+	api.HandleFunc("/change-password", app.apiChangePasswordHandler).Methods("POST")
+	api.HandleFunc("/reset-password", app.apiResetPasswordHandler).Methods("POST")
+	api.HandleFunc("/email/settings", app.apiEmailSettingsHandler).Methods("GET")
+	api.HandleFunc("/email/settings", app.apiUpdateEmailSettingsHandler).Methods("PUT")
+	api.HandleFunc("/email/unsubscribe", app.emailUnsubscribePageHandler).Methods("GET")
+	api.HandleFunc("/email/unsubscribe", app.apiEmailUnsubscribeHandler).Methods("POST")
+	api.HandleFunc("/push/vapid-public-key", app.apiPushPublicKeyHandler).Methods("GET")
+	api.HandleFunc("/push/subscriptions", app.apiCreatePushSubscriptionHandler).Methods("POST")
+	api.HandleFunc("/push/subscriptions", app.apiDeletePushSubscriptionHandler).Methods("DELETE")
 	api.HandleFunc("/chats", app.apiChatsHandler).Methods("GET")
 	api.HandleFunc("/chat/{id:[0-9]+}", app.apiChatHandler).Methods("GET")
+	api.HandleFunc("/chat/{id:[0-9]+}", app.apiUpdateChatHandler).Methods("PUT")
+	api.HandleFunc("/chat/{id:[0-9]+}/avatar", app.apiChatAvatarHandler).Methods("GET")
+	api.HandleFunc("/chat/{id:[0-9]+}/export", app.apiExportChatHandler).Methods("GET")
 	api.HandleFunc("/create_private_chat", app.apiGetUsersForChatHandler).Methods("GET")
 	api.HandleFunc("/create_private_chat", app.apiCreatePrivateChatHandler).Methods("POST")
 	api.HandleFunc("/create_group_chat", app.apiGetUsersForChatHandler).Methods("GET")
 	api.HandleFunc("/create_group_chat", app.apiCreateGroupChatHandler).Methods("POST")
+	api.HandleFunc("/create_channel", app.apiCreateChannelHandler).Methods("POST")
+	api.HandleFunc("/directory", app.apiDirectoryHandler).Methods("GET")
 	api.HandleFunc("/edit-message", app.apiEditMessageHandler).Methods("POST")
 	api.HandleFunc("/delete-message", app.apiDeleteMessageHandler).Methods("POST")
+	api.HandleFunc("/chat/{id:[0-9]+}/deleted", app.apiDeletedMessagesHandler).Methods("GET")
+	api.HandleFunc("/forward", app.apiForwardMessagesHandler).Methods("POST")
 	api.HandleFunc("/files/{id:[0-9]+}", app.apiFileHandler).Methods("GET")
+	api.HandleFunc("/chat/{id:[0-9]+}/members", app.apiAddChatMembersHandler).Methods("POST")
+	api.HandleFunc("/chat/{id:[0-9]+}/members/{user_id:[0-9]+}", app.apiRemoveChatMemberHandler).Methods("DELETE")
+	api.HandleFunc("/chat/{id:[0-9]+}/members/{user_id:[0-9]+}/role", app.apiSetChatMemberRoleHandler).Methods("POST")
+	api.HandleFunc("/chat/{id:[0-9]+}/join", app.apiJoinChatHandler).Methods("POST")
+	api.HandleFunc("/chat/{id:[0-9]+}/leave", app.apiLeaveChatHandler).Methods("POST")
+	api.HandleFunc("/chat/{id:[0-9]+}/transfer-ownership", app.apiTransferChatOwnershipHandler).Methods("POST")
+	api.HandleFunc("/chat/{id:[0-9]+}/mute", app.apiMuteChatHandler).Methods("POST")
+	api.HandleFunc("/chat/{id:[0-9]+}/ttl", app.apiSetMessageTTLHandler).Methods("PUT")
+	api.HandleFunc("/chat/{id:[0-9]+}/retention", app.apiChatRetentionHandler).Methods("GET")
+	api.HandleFunc("/chat/{id:[0-9]+}/retention", app.apiSetChatRetentionHandler).Methods("PUT")
+	api.HandleFunc("/mentions", app.apiMentionsHandler).Methods("GET")
+	api.HandleFunc("/search", app.apiSearchHandler).Methods("GET")
+	api.HandleFunc("/chat/{id:[0-9]+}/scheduled", app.apiScheduledMessagesHandler).Methods("GET")
+	api.HandleFunc("/chat/{id:[0-9]+}/scheduled", app.apiScheduleMessageHandler).Methods("POST")
+	api.HandleFunc("/chat/{id:[0-9]+}/scheduled/{scheduled_id:[0-9]+}", app.apiCancelScheduledMessageHandler).Methods("DELETE")
+	api.HandleFunc("/chat/{id:[0-9]+}/pins", app.apiPinnedMessagesHandler).Methods("GET")
+	api.HandleFunc("/chat/{id:[0-9]+}/pins", app.apiPinMessageHandler).Methods("POST")
+	api.HandleFunc("/chat/{id:[0-9]+}/pins/{message_id:[0-9]+}", app.apiUnpinMessageHandler).Methods("DELETE")
+	api.HandleFunc("/chat/{id:[0-9]+}/invites", app.apiChatInvitesHandler).Methods("GET")
+	api.HandleFunc("/chat/{id:[0-9]+}/invites", app.apiCreateChatInviteHandler).Methods("POST")
+	api.HandleFunc("/chat/{id:[0-9]+}/invites/{invite_id:[0-9]+}", app.apiRevokeChatInviteHandler).Methods("DELETE")
+	api.HandleFunc("/invites/{token:[0-9a-f]+}/join", app.apiJoinByInviteHandler).Methods("POST")
+	api.HandleFunc("/chat/{id:[0-9]+}/webhooks", app.apiWebhooksHandler).Methods("GET")
+	api.HandleFunc("/chat/{id:[0-9]+}/webhooks", app.apiCreateWebhookHandler).Methods("POST")
+	api.HandleFunc("/chat/{id:[0-9]+}/webhooks/{webhook_id:[0-9]+}", app.apiDeleteWebhookHandler).Methods("DELETE")
+	api.HandleFunc("/chat/{id:[0-9]+}/webhooks/{webhook_id:[0-9]+}/deliveries", app.apiWebhookDeliveriesHandler).Methods("GET")
+
+	// Server administration, available to administrators only
+	admin := api.PathPrefix("/admin").Subrouter()
+	admin.HandleFunc("/users", app.apiAdminUsersHandler).Methods("GET")
+	admin.HandleFunc("/users/{user_id:[0-9]+}", app.apiDeleteUserHandler).Methods("DELETE")
+	admin.HandleFunc("/users/{user_id:[0-9]+}/deactivate", app.apiDeactivateUserHandler).Methods("POST")
+	admin.HandleFunc("/users/{user_id:[0-9]+}/reactivate", app.apiReactivateUserHandler).Methods("POST")
+	admin.HandleFunc("/users/{user_id:[0-9]+}/admin", app.apiSetUserAdminHandler).Methods("PUT")
+	admin.HandleFunc("/users/{user_id:[0-9]+}/reset-password", app.apiResetUserPasswordHandler).Methods("POST")
+	admin.HandleFunc("/messages/{message_id:[0-9]+}", app.apiAdminDeleteMessageHandler).Methods("DELETE")
+	admin.HandleFunc("/stats", app.apiInstanceStatsHandler).Methods("GET")
+	admin.HandleFunc("/audit", app.apiAuditEventsHandler).Methods("GET")
+	admin.HandleFunc("/audit/verify", app.apiVerifyAuditHandler).Methods("GET")
+	admin.HandleFunc("/legal-holds", app.apiLegalHoldsHandler).Methods("GET")
+	admin.HandleFunc("/legal-holds", app.apiCreateLegalHoldHandler).Methods("POST")
+	admin.HandleFunc("/legal-holds/{hold_id:[0-9]+}", app.apiReleaseLegalHoldHandler).Methods("DELETE")
+	admin.HandleFunc("/ediscovery/exports", app.apiEDiscoveryExportsHandler).Methods("GET")
+	admin.HandleFunc("/ediscovery/exports", app.apiCreateEDiscoveryExportHandler).Methods("POST")
+	admin.HandleFunc("/ediscovery/exports/{export_id:[0-9]+}/download", app.apiDownloadEDiscoveryExportHandler).Methods("GET")
 
 	return &app, nil
 }
@@ -109,8 +300,163 @@ func (a *App) GetRouter() *mux.Router {
 	return a.router
 }
 
+// isAuthenticated reports whether the request has a session of an active
+// user. Sessions end when the account is deactivated or its session version
+// is increased.
 func (a *App) isAuthenticated(r *http.Request) bool {
 	session, _ := a.memory.GetSession(r, "session-name")
-	_, ok := session.Values["username"].(string)
-	return ok
+	username, ok := session.Values["username"].(string)
+	if !ok {
+		return false
+	}
+
+	user, err := a.storage.GetUserByUsername(username)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("isAuthenticated: storage.GetUserByUsername: %v", err)
+		}
+		return false
+	}
+
+	// Sessions from before user IDs were stored carry only the username
+	userID, _ := session.Values["user_id"].(int)
+	version, _ := session.Values["session_version"].(int)
+	return (userID == 0 || userID == user.ID) && version == user.SessionVersion && user.DeactivatedAt == nil
+}
+
+// currentUser loads the user of an authenticated request
+func (a *App) currentUser(r *http.Request) (domain.User, error) {
+	session, _ := a.memory.GetSession(r, "session-name")
+	username, _ := session.Values["username"].(string)
+	return a.storage.GetUserByUsername(username)
+}
+
+// adminUser makes sure the current user is a server administrator. On
+// failure the response is already written.
+func (a *App) adminUser(w http.ResponseWriter, r *http.Request, caller string) (domain.User, bool) {
+	if !a.isAuthenticated(r) {
+		sendJSONResponse(w, http.StatusUnauthorized, APIResponse{
+			Success: false,
+			Message: "Not authenticated",
+		})
+		return domain.User{}, false
+	}
+
+	user, err := a.currentUser(r)
+	if err != nil {
+		log.Printf("%s: storage.GetUserByUsername: %v", caller, err)
+		sendJSONResponse(w, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Message: "Error retrieving user",
+		})
+		return domain.User{}, false
+	}
+
+	if !user.IsAdmin {
+		sendJSONResponse(w, http.StatusForbidden, APIResponse{
+			Success: false,
+			Message: "Administrator access required",
+		})
+		return domain.User{}, false
+	}
+
+	return user, true
+}
+
+// memberChat resolves the chat of a "/chat/{id}/..." request and makes sure
+// the current user is its member. On failure the response is already written.
+func (a *App) memberChat(w http.ResponseWriter, r *http.Request, caller string) (*domain.Chat, domain.ChatMember, bool) {
+	if !a.isAuthenticated(r) {
+		sendJSONResponse(w, http.StatusUnauthorized, APIResponse{
+			Success: false,
+			Message: "Not authenticated",
+		})
+		return nil, domain.ChatMember{}, false
+	}
+
+	chatID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		sendJSONResponse(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: "Invalid chat ID",
+		})
+		return nil, domain.ChatMember{}, false
+	}
+
+	user, err := a.currentUser(r)
+	if err != nil {
+		log.Printf("%s: storage.GetUserByUsername: %v", caller, err)
+		sendJSONResponse(w, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Message: "Error retrieving user",
+		})
+		return nil, domain.ChatMember{}, false
+	}
+
+	chat, err := a.storage.GetChatByID(chatID)
+	if err != nil || chat == nil {
+		log.Printf("%s: storage.GetChatByID: %v", caller, err)
+		sendJSONResponse(w, http.StatusNotFound, APIResponse{
+			Success: false,
+			Message: "Chat not found",
+		})
+		return nil, domain.ChatMember{}, false
+	}
+
+	role, err := a.storage.GetChatMemberRole(chat.ID, user.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		sendJSONResponse(w, http.StatusForbidden, APIResponse{
+			Success: false,
+			Message: "You are not a member of this chat",
+		})
+		return nil, domain.ChatMember{}, false
+	}
+	if err != nil {
+		log.Printf("%s: storage.GetChatMemberRole: %v", caller, err)
+		sendJSONResponse(w, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Message: "Error checking chat membership",
+		})
+		return nil, domain.ChatMember{}, false
+	}
+
+	return chat, domain.ChatMember{User: user, Role: role}, true
+}
+
+// permittedChat is memberChat that also requires the member's role to grant
+// the permission.
+func (a *App) permittedChat(w http.ResponseWriter, r *http.Request, caller string, permission domain.ChatPermission) (*domain.Chat, domain.ChatMember, bool) {
+	chat, member, ok := a.memberChat(w, r, caller)
+	if !ok {
+		return nil, domain.ChatMember{}, false
+	}
+
+	if !member.Role.Can(permission) {
+		sendJSONResponse(w, http.StatusForbidden, APIResponse{
+			Success: false,
+			Message: "You don't have permission to do this in this chat",
+		})
+		return nil, domain.ChatMember{}, false
+	}
+
+	return chat, member, true
+}
+
+// hasChatPermission reports whether the user's role in the chat grants the
+// permission. Users outside the chat have no permissions in it.
+func (a *App) hasChatPermission(chatID int, username string, permission domain.ChatPermission) bool {
+	userID, err := a.storage.GetUserIDByUsername(username)
+	if err != nil {
+		log.Printf("hasChatPermission: storage.GetUserIDByUsername: %v", err)
+		return false
+	}
+
+	role, err := a.storage.GetChatMemberRole(chatID, userID)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("hasChatPermission: storage.GetChatMemberRole: %v", err)
+		}
+		return false
+	}
+	return role.Can(permission)
 }
@@ -0,0 +1,68 @@
+package app
+
+import (
+	"chat/internal/domain"
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+)
+
+// audit appends a security-relevant action to the audit log and to the
+// export file if there is one. r gives the client address and is nil for
+// actions not made over HTTP, like slash commands.
+func (a *App) audit(r *http.Request, event domain.AuditEvent, details map[string]interface{}) {
+	if details != nil {
+		data, err := json.Marshal(details)
+		if err != nil {
+			log.Printf("audit: json.Marshal: %v", err)
+		}
+		event.Details = data
+	}
+	if r != nil {
+		event.IP = a.clientIP(r)
+	}
+
+	event, err := a.storage.InsertAuditEvent(event)
+	if err != nil {
+		log.Printf("audit: storage.InsertAuditEvent: %s by %q: %v", event.Action, event.ActorUsername, err)
+		return
+	}
+
+	if a.auditExport == nil {
+		return
+	}
+	line, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("audit: json.Marshal: %v", err)
+		return
+	}
+	a.auditMu.Lock()
+	defer a.auditMu.Unlock()
+	if _, err := a.auditExport.Write(append(line, '\n')); err != nil {
+		log.Printf("audit: export: %v", err)
+	}
+}
+
+// actorEvent starts an audit event for an action of the user
+func actorEvent(user domain.User, action string) domain.AuditEvent {
+	return domain.AuditEvent{
+		ActorID:       user.ID,
+		ActorUsername: user.Username,
+		Action:        action,
+	}
+}
+
+// clientIP returns the address of the client that made the request
+func (a *App) clientIP(r *http.Request) string {
+	if a.cfg.Audit.TrustProxy {
+		if ip := r.Header.Get("X-Real-IP"); ip != "" {
+			return ip
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
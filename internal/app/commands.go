@@ -0,0 +1,351 @@
+package app
+
+import (
+	"bytes"
+	"chat/internal/domain"
+	"chat/internal/service/webhook"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const externalCommandTimeout = 5 * time.Second
+
+// Command is a slash command that can be typed in any chat
+type Command struct {
+	Name        string
+	Usage       string
+	Description string
+	Handler     CommandHandler
+}
+
+// CommandHandler executes a command. Errors are reported to the caller as a
+// generic failure, so problems meant for the user belong in the reply.
+type CommandHandler func(ctx CommandContext) (CommandReply, error)
+
+// CommandContext describes a single command invocation
+type CommandContext struct {
+	Client   *domain.Client
+	Username string
+	Chat     *domain.Chat
+	Role     domain.ChatRole
+	Name     string
+	Args     string
+}
+
+func (ctx CommandContext) caller() domain.ChatMember {
+	return domain.ChatMember{
+		User: domain.User{ID: ctx.Client.UserID, Username: ctx.Username},
+		Role: ctx.Role,
+	}
+}
+
+// CommandReply is either shown to the caller only or posted to the chat on
+// behalf of the caller when Public is set.
+type CommandReply struct {
+	Content    string
+	Public     bool
+	Disconnect bool
+}
+
+// External command request structure, posted to the bot URL
+type ExternalCommandRequest struct {
+	Command  string `json:"command"`
+	Args     string `json:"args"`
+	ChatID   int    `json:"chat_id"`
+	UserID   int    `json:"user_id"`
+	Username string `json:"username"`
+}
+
+// External command response structure, returned by the bot
+type ExternalCommandResponse struct {
+	ResponseType string `json:"response_type"`
+	Text         string `json:"text"`
+}
+
+// RegisterCommand makes a command available in chats, replacing any command
+// with the same name.
+func (a *App) RegisterCommand(command Command) {
+	a.commands[strings.ToLower(command.Name)] = command
+}
+
+func (a *App) registerCommands() {
+	a.RegisterCommand(Command{
+		Name:        "help",
+		Usage:       "/help",
+		Description: "List available commands",
+		Handler:     a.helpCommand,
+	})
+	a.RegisterCommand(Command{
+		Name:        "me",
+		Usage:       "/me <action>",
+		Description: "Post an action in the third person",
+		Handler:     a.meCommand,
+	})
+	a.RegisterCommand(Command{
+		Name:        "topic",
+		Usage:       "/topic [text]",
+		Description: "Show or change the chat topic",
+		Handler:     a.topicCommand,
+	})
+	a.RegisterCommand(Command{
+		Name:        "invite",
+		Usage:       "/invite @user",
+		Description: "Add a user to the chat",
+		Handler:     a.inviteCommand,
+	})
+	a.RegisterCommand(Command{
+		Name:        "leave",
+		Usage:       "/leave",
+		Description: "Leave the chat",
+		Handler:     a.leaveCommand,
+	})
+
+	for _, external := range a.cfg.Commands {
+		a.RegisterCommand(Command{
+			Name:        external.Name,
+			Usage:       "/" + external.Name + " [args]",
+			Description: external.Description,
+			Handler:     a.externalCommand(external.URL, external.Secret),
+		})
+	}
+}
+
+// parseCommand splits "/name args" into its parts. Content without a name
+// right after the slash, like "/ text", is a plain message.
+func parseCommand(content string) (string, string, bool) {
+	if len(content) < 2 || content[0] != '/' {
+		return "", "", false
+	}
+	name, args, _ := strings.Cut(content[1:], " ")
+	if name == "" {
+		return "", "", false
+	}
+	for _, r := range name {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_' && r != '-' {
+			return "", "", false
+		}
+	}
+	return strings.ToLower(name), strings.TrimSpace(args), true
+}
+
+// runCommand executes a command sent by the client and reports whether its
+// connection should stay open.
+func (a *App) runCommand(client *domain.Client, username string, name string, args string) bool {
+	command, ok := a.commands[name]
+	if !ok {
+		a.sendEphemeral(client, fmt.Sprintf("Unknown command /%s. Type /help for the list of commands", name))
+		return true
+	}
+
+	chat, err := a.storage.GetChatByID(client.ChatID)
+	if err != nil || chat == nil {
+		log.Printf("runCommand: storage.GetChatByID: %v", err)
+		a.sendEphemeral(client, "Chat not found")
+		return true
+	}
+
+	role, err := a.storage.GetChatMemberRole(client.ChatID, client.UserID)
+	if err != nil {
+		log.Printf("runCommand: storage.GetChatMemberRole: %v", err)
+		return false
+	}
+
+	reply, err := command.Handler(CommandContext{
+		Client:   client,
+		Username: username,
+		Chat:     chat,
+		Role:     role,
+		Name:     name,
+		Args:     args,
+	})
+	if err != nil {
+		log.Printf("runCommand: /%s: %v", name, err)
+		a.sendEphemeral(client, fmt.Sprintf("Command /%s failed", name))
+		return true
+	}
+
+	if reply.Content != "" {
+		if reply.Public && !canPost(chat, role) {
+			a.sendEphemeral(client, "Only channel admins can post here")
+		} else if reply.Public {
+			_, err = a.postMessage(domain.Message{
+				ChatID:   client.ChatID,
+				UserID:   client.UserID,
+				Username: username,
+				Content:  reply.Content,
+			})
+			if err != nil {
+				log.Printf("runCommand: postMessage: %v", err)
+				return false
+			}
+		} else {
+			a.sendEphemeral(client, reply.Content)
+		}
+	}
+	return !reply.Disconnect
+}
+
+// sendEphemeral shows a message to a single client without storing it
+func (a *App) sendEphemeral(client *domain.Client, content string) {
+	a.sendToClient(client, map[string]interface{}{
+		"action":  "ephemeral",
+		"content": content,
+	})
+}
+
+func (a *App) helpCommand(ctx CommandContext) (CommandReply, error) {
+	names := make([]string, 0, len(a.commands))
+	for name := range a.commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var help strings.Builder
+	help.WriteString("Available commands:")
+	for _, name := range names {
+		command := a.commands[name]
+		fmt.Fprintf(&help, "\n%s - %s", command.Usage, command.Description)
+	}
+	return CommandReply{Content: help.String()}, nil
+}
+
+func (a *App) meCommand(ctx CommandContext) (CommandReply, error) {
+	if ctx.Args == "" {
+		return CommandReply{Content: "Usage: /me <action>"}, nil
+	}
+	return CommandReply{Content: fmt.Sprintf("* %s %s", ctx.Username, ctx.Args), Public: true}, nil
+}
+
+func (a *App) topicCommand(ctx CommandContext) (CommandReply, error) {
+	if ctx.Args == "" {
+		if ctx.Chat.Topic == "" {
+			return CommandReply{Content: "No topic is set"}, nil
+		}
+		return CommandReply{Content: "Topic: " + ctx.Chat.Topic}, nil
+	}
+
+	if ctx.Chat.Type == domain.ChatTypePrivate {
+		return CommandReply{Content: "Private chats have no topic"}, nil
+	}
+
+	if !ctx.Role.Can(domain.PermissionEditChat) {
+		return CommandReply{Content: "Only chat admins can change the topic"}, nil
+	}
+
+	updated := *ctx.Chat
+	updated.Topic = ctx.Args
+	if message := validateChatSettings(updated); message != "" {
+		return CommandReply{Content: message}, nil
+	}
+
+	err := a.updateChat(ctx.Chat, &updated, ctx.caller().User)
+	if err != nil {
+		return CommandReply{}, err
+	}
+	return CommandReply{}, nil
+}
+
+func (a *App) inviteCommand(ctx CommandContext) (CommandReply, error) {
+	invitee := strings.TrimPrefix(ctx.Args, "@")
+	if invitee == "" || strings.ContainsRune(invitee, ' ') {
+		return CommandReply{Content: "Usage: /invite @user"}, nil
+	}
+
+	if ctx.Chat.Type == domain.ChatTypePrivate {
+		return CommandReply{Content: "Users can't be invited to private chats"}, nil
+	}
+
+	if !ctx.Role.Can(domain.PermissionManageMembers) {
+		return CommandReply{Content: "Only chat admins can invite users"}, nil
+	}
+
+	user, err := a.storage.GetUserByUsername(invitee)
+	if err != nil || user.DeactivatedAt != nil || user.DeletedAt != nil {
+		return CommandReply{Content: fmt.Sprintf("User @%s not found", invitee)}, nil
+	}
+
+	isMember, err := a.storage.IsChatMember(ctx.Chat.ID, user.ID)
+	if err != nil {
+		return CommandReply{}, fmt.Errorf("storage.IsChatMember: %w", err)
+	}
+	if isMember {
+		return CommandReply{Content: fmt.Sprintf("@%s is already a member of this chat", invitee)}, nil
+	}
+
+	err = a.addChatMember(ctx.Chat, ctx.caller().User, user)
+	if err != nil {
+		return CommandReply{}, err
+	}
+	return CommandReply{}, nil
+}
+
+func (a *App) leaveCommand(ctx CommandContext) (CommandReply, error) {
+	if ctx.Chat.Type == domain.ChatTypePrivate {
+		return CommandReply{Content: "You can't leave a private chat"}, nil
+	}
+
+	mustTransfer, err := a.mustTransferOwnership(ctx.Chat, ctx.caller())
+	if err != nil {
+		return CommandReply{}, err
+	}
+	if mustTransfer {
+		return CommandReply{Content: "Transfer ownership before leaving the chat"}, nil
+	}
+
+	err = a.removeChatMember(ctx.Chat, ctx.caller().User, ctx.caller().User)
+	if err != nil {
+		return CommandReply{}, err
+	}
+	return CommandReply{Disconnect: true}, nil
+}
+
+// externalCommand routes a command to a bot. The request is signed the same
+// way as webhook deliveries, using the secret configured for the command.
+func (a *App) externalCommand(url string, secret string) CommandHandler {
+	client := &http.Client{Timeout: externalCommandTimeout}
+
+	return func(ctx CommandContext) (CommandReply, error) {
+		body, err := json.Marshal(ExternalCommandRequest{
+			Command:  ctx.Name,
+			Args:     ctx.Args,
+			ChatID:   ctx.Chat.ID,
+			UserID:   ctx.Client.UserID,
+			Username: ctx.Username,
+		})
+		if err != nil {
+			return CommandReply{}, fmt.Errorf("json.Marshal: %w", err)
+		}
+
+		req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+		if err != nil {
+			return CommandReply{}, fmt.Errorf("http.NewRequest: %w", err)
+		}
+		timestamp := time.Now().Unix()
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(webhook.TimestampHeader, strconv.FormatInt(timestamp, 10))
+		req.Header.Set(webhook.SignatureHeader, webhook.Sign(secret, timestamp, body))
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return CommandReply{}, fmt.Errorf("client.Do: %w", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return CommandReply{}, fmt.Errorf("unexpected response status %d", resp.StatusCode)
+		}
+
+		var res ExternalCommandResponse
+		if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+			return CommandReply{}, fmt.Errorf("json.Decode: %w", err)
+		}
+		return CommandReply{Content: res.Text, Public: res.ResponseType == "in_channel"}, nil
+	}
+}
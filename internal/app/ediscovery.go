@@ -0,0 +1,204 @@
+package app
+
+import (
+	"chat/internal/domain"
+	"chat/internal/service/archive"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const defaultEDiscoveryExportDir = "./exports"
+
+// ediscoveryMessage is a message as written to messages.json of an export
+type ediscoveryMessage struct {
+	ID               int                   `json:"id"`
+	ChatID           int                   `json:"chat_id"`
+	ChatName         string                `json:"chat_name"`
+	UserID           int                   `json:"user_id"`
+	Username         string                `json:"username"`
+	Content          string                `json:"content"`
+	DecryptionFailed bool                  `json:"decryption_failed,omitempty"`
+	CreatedAt        time.Time             `json:"created_at"`
+	IsSystem         bool                  `json:"is_system,omitempty"`
+	Attachment       string                `json:"attachment,omitempty"` // Путь к файлу внутри архива
+	ForwardedFrom    *domain.MessageOrigin `json:"forwarded_from,omitempty"`
+	DeletedAt        *time.Time            `json:"deleted_at,omitempty"`
+	DeletedBy        string                `json:"deleted_by,omitempty"`
+}
+
+// ediscoveryManifest describes an export and lists the checksums of its
+// files. manifest.sig holds its Ed25519 signature.
+type ediscoveryManifest struct {
+	ExportID     int            `json:"export_id"`
+	RequestedBy  string         `json:"requested_by"`
+	CreatedAt    time.Time      `json:"created_at"`
+	UserIDs      []int          `json:"user_ids"`
+	ChatIDs      []int          `json:"chat_ids"`
+	From         *time.Time     `json:"from,omitempty"`
+	To           *time.Time     `json:"to,omitempty"`
+	MessageCount int            `json:"message_count"`
+	Files        []archive.File `json:"files"`
+	PublicKey    string         `json:"public_key"`
+}
+
+func (a *App) runEDiscoveryExport(export domain.EDiscoveryExport, requestedBy string) {
+	err := a.writeEDiscoveryExport(&export, requestedBy)
+	if err != nil {
+		log.Printf("runEDiscoveryExport: writeEDiscoveryExport: %v", err)
+		export.Status = domain.EDiscoveryExportStatusFailed
+		export.Error = err.Error()
+		os.Remove(a.ediscoveryExportPath(export.ID))
+	} else {
+		export.Status = domain.EDiscoveryExportStatusCompleted
+	}
+
+	err = a.storage.FinishEDiscoveryExport(export)
+	if err != nil {
+		log.Printf("runEDiscoveryExport: storage.FinishEDiscoveryExport: %v", err)
+	}
+}
+
+// writeEDiscoveryExport writes the decrypted messages in the export scope
+// and their attachments to a ZIP archive with a signed manifest, and sets
+// the message count and archive checksum of the export
+func (a *App) writeEDiscoveryExport(export *domain.EDiscoveryExport, requestedBy string) error {
+	exportPath := a.ediscoveryExportPath(export.ID)
+	if err := os.MkdirAll(filepath.Dir(exportPath), 0o700); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(exportPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	checksum := sha256.New()
+	zw := archive.NewWriter(io.MultiWriter(file, checksum))
+
+	messagesFile, err := zw.Create("messages.json")
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(messagesFile, "[\n"); err != nil {
+		return err
+	}
+
+	attachments := make(map[int]string)
+	encoder := json.NewEncoder(messagesFile)
+	err = a.storage.ForEachEDiscoveryMessage(*export, func(message domain.Message, chatName string) error {
+		entry := ediscoveryMessage{
+			ID:            message.ID,
+			ChatID:        message.ChatID,
+			ChatName:      chatName,
+			UserID:        message.UserID,
+			Username:      message.Username,
+			Content:       message.Content,
+			CreatedAt:     message.CreatedAt,
+			IsSystem:      message.IsSystem,
+			ForwardedFrom: message.ForwardedFrom,
+			DeletedAt:     message.DeletedAt,
+			DeletedBy:     message.DeletedByUsername,
+		}
+		if message.Content != "" {
+			content, err := a.cipher.Decrypt(message.Content)
+			if err != nil {
+				log.Printf("writeEDiscoveryExport: cipher.Decrypt: %v", err)
+				entry.DecryptionFailed = true
+			} else {
+				entry.Content = content
+			}
+		}
+		if message.AttachmentID != 0 {
+			entry.Attachment = attachmentPath(message.AttachmentID, message.File.Name)
+			attachments[message.AttachmentID] = entry.Attachment
+		}
+
+		if export.MessageCount > 0 {
+			if _, err := io.WriteString(messagesFile, ","); err != nil {
+				return err
+			}
+		}
+		export.MessageCount++
+		return encoder.Encode(entry)
+	})
+	if err != nil {
+		return fmt.Errorf("storage.ForEachEDiscoveryMessage: %w", err)
+	}
+	if _, err := io.WriteString(messagesFile, "]\n"); err != nil {
+		return err
+	}
+
+	if err := a.writeAttachments(zw, attachments); err != nil {
+		return err
+	}
+
+	manifest, err := json.MarshalIndent(ediscoveryManifest{
+		ExportID:     export.ID,
+		RequestedBy:  requestedBy,
+		CreatedAt:    time.Now().UTC(),
+		UserIDs:      export.UserIDs,
+		ChatIDs:      export.ChatIDs,
+		From:         export.From,
+		To:           export.To,
+		MessageCount: export.MessageCount,
+		Files:        zw.Files(),
+		PublicKey:    a.signer.PublicKey(),
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	for _, f := range []struct {
+		name string
+		data []byte
+	}{
+		{"manifest.json", manifest},
+		{"manifest.sig", []byte(a.signer.Sign(manifest) + "\n")},
+	} {
+		w, err := zw.Create(f.name)
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(f.data); err != nil {
+			return err
+		}
+	}
+
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	export.SHA256 = hex.EncodeToString(checksum.Sum(nil))
+	return nil
+}
+
+func (a *App) ediscoveryExportPath(exportID int) string {
+	dir := a.cfg.EDiscovery.ExportDir
+	if dir == "" {
+		dir = defaultEDiscoveryExportDir
+	}
+	return filepath.Join(dir, fmt.Sprintf("ediscovery-%d.zip", exportID))
+}
+
+// attachmentPath returns where an attachment is stored inside an archive.
+// The ID keeps files with the same name apart.
+func attachmentPath(attachmentID int, name string) string {
+	name = path.Base(strings.ReplaceAll(name, "\\", "/"))
+	if name == "." || name == "/" || name == ".." {
+		name = "file"
+	}
+	return fmt.Sprintf("attachments/%d/%s", attachmentID, name)
+}
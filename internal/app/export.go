@@ -0,0 +1,203 @@
+package app
+
+import (
+	"chat/internal/domain"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"strings"
+	"time"
+)
+
+// exportBatchSize is how many messages are loaded at a time while exporting
+const exportBatchSize = 500
+
+// undecryptablePlaceholder replaces the content of a message that fails to
+// decrypt, so that one broken message doesn't abort the whole export
+const undecryptablePlaceholder = "[Message could not be decrypted]"
+
+// exportedMessage is a message as written to a chat export
+type exportedMessage struct {
+	ID            int                   `json:"id"`
+	UserID        int                   `json:"user_id"`
+	Username      string                `json:"username"`
+	Content       string                `json:"content"`
+	CreatedAt     time.Time             `json:"created_at"`
+	IsSystem      bool                  `json:"is_system,omitempty"`
+	Attachment    string                `json:"attachment,omitempty"` // Путь к файлу внутри архива
+	ForwardedFrom *domain.MessageOrigin `json:"forwarded_from,omitempty"`
+	Deleted       bool                  `json:"deleted,omitempty"`
+}
+
+// transcriptWriter renders a chat history in one of the export formats.
+// Messages are written one by one as they are read from storage.
+type transcriptWriter interface {
+	Begin(chat *domain.Chat) error
+	Message(message exportedMessage) error
+	End() error
+}
+
+// transcriptFormats maps export formats to the transcript file name inside
+// the archive and the writer producing it
+var transcriptFormats = map[string]struct {
+	fileName  string
+	newWriter func(w io.Writer) transcriptWriter
+}{
+	"json": {"messages.json", func(w io.Writer) transcriptWriter { return &jsonTranscript{w: w} }},
+	"html": {"transcript.html", func(w io.Writer) transcriptWriter { return &htmlTranscript{w: w} }},
+	"txt":  {"transcript.txt", func(w io.Writer) transcriptWriter { return &textTranscript{w: w} }},
+}
+
+// forEachChatMessage calls fn for every message of the chat, oldest first,
+// loading them in batches and decrypting them along the way. Deleted
+// messages come as tombstones.
+func (a *App) forEachChatMessage(chatID int, fn func(domain.Message) error) error {
+	var (
+		afterTime time.Time
+		afterID   int
+	)
+	for {
+		messages, err := a.storage.GetChatMessagesAfter(chatID, afterTime, afterID, exportBatchSize)
+		if err != nil {
+			return fmt.Errorf("storage.GetChatMessagesAfter: %w", err)
+		}
+
+		for _, message := range messages {
+			if message.DeletedAt != nil {
+				redactTombstone(&message, false)
+			} else {
+				content, err := a.cipher.Decrypt(message.Content)
+				if err != nil {
+					log.Printf("forEachChatMessage: cipher.Decrypt: message %d: %v", message.ID, err)
+					content = undecryptablePlaceholder
+				}
+				message.Content = content
+			}
+			if err := fn(message); err != nil {
+				return err
+			}
+		}
+
+		if len(messages) < exportBatchSize {
+			return nil
+		}
+		last := messages[len(messages)-1]
+		afterTime, afterID = last.CreatedAt, last.ID
+	}
+}
+
+type jsonTranscript struct {
+	w       io.Writer
+	encoder *json.Encoder
+	count   int
+}
+
+func (t *jsonTranscript) Begin(chat *domain.Chat) error {
+	header, err := json.Marshal(map[string]interface{}{
+		"id":          chat.ID,
+		"name":        chat.Name,
+		"type":        chat.Type,
+		"topic":       chat.Topic,
+		"description": chat.Description,
+		"exported_at": time.Now().UTC(),
+	})
+	if err != nil {
+		return err
+	}
+	t.encoder = json.NewEncoder(t.w)
+	_, err = fmt.Fprintf(t.w, "{\"chat\": %s,\n\"messages\": [\n", header)
+	return err
+}
+
+func (t *jsonTranscript) Message(message exportedMessage) error {
+	if t.count > 0 {
+		if _, err := io.WriteString(t.w, ","); err != nil {
+			return err
+		}
+	}
+	t.count++
+	return t.encoder.Encode(message)
+}
+
+func (t *jsonTranscript) End() error {
+	_, err := io.WriteString(t.w, "]}\n")
+	return err
+}
+
+var htmlTranscriptTemplate = template.Must(template.New("transcript").Parse(`
+{{define "begin"}}<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Name}}</title>
+<style>
+body { font-family: sans-serif; max-width: 800px; margin: 2em auto; color: #222; }
+.message { padding: .4em 0; border-bottom: 1px solid #eee; }
+.meta { color: #888; font-size: .85em; }
+.system, .deleted { color: #888; font-style: italic; }
+.content { white-space: pre-wrap; }
+</style>
+</head>
+<body>
+<h1>{{.Name}}</h1>
+{{if .Topic}}<p>{{.Topic}}</p>{{end}}
+{{end}}
+{{define "message"}}<div class="message{{if .IsSystem}} system{{end}}{{if .Deleted}} deleted{{end}}">
+<div class="meta">{{.CreatedAt.Format "2006-01-02 15:04:05"}} · <b>{{.Username}}</b>{{with .ForwardedFrom}} · forwarded from {{.Username}}{{end}}</div>
+{{if .Deleted}}<div>Message deleted</div>{{else}}<div class="content">{{.Content}}</div>{{end}}
+{{with .Attachment}}<div><a href="{{.}}">{{.}}</a></div>{{end}}
+</div>
+{{end}}
+{{define "end"}}</body>
+</html>
+{{end}}`))
+
+type htmlTranscript struct {
+	w io.Writer
+}
+
+func (t *htmlTranscript) Begin(chat *domain.Chat) error {
+	return htmlTranscriptTemplate.ExecuteTemplate(t.w, "begin", chat)
+}
+
+func (t *htmlTranscript) Message(message exportedMessage) error {
+	return htmlTranscriptTemplate.ExecuteTemplate(t.w, "message", message)
+}
+
+func (t *htmlTranscript) End() error {
+	return htmlTranscriptTemplate.ExecuteTemplate(t.w, "end", nil)
+}
+
+type textTranscript struct {
+	w io.Writer
+}
+
+func (t *textTranscript) Begin(chat *domain.Chat) error {
+	_, err := fmt.Fprintf(t.w, "%s\n%s\n\n", chat.Name, strings.Repeat("=", len([]rune(chat.Name))))
+	return err
+}
+
+func (t *textTranscript) Message(message exportedMessage) error {
+	content := message.Content
+	if message.Deleted {
+		content = "(message deleted)"
+	} else if message.IsSystem {
+		content = "* " + content
+	}
+
+	line := fmt.Sprintf("[%s] %s: %s", message.CreatedAt.Format(time.DateTime), message.Username, content)
+	if message.ForwardedFrom != nil {
+		line += fmt.Sprintf(" (forwarded from %s)", message.ForwardedFrom.Username)
+	}
+	if message.Attachment != "" {
+		line += fmt.Sprintf(" [%s]", message.Attachment)
+	}
+	_, err := fmt.Fprintln(t.w, line)
+	return err
+}
+
+func (t *textTranscript) End() error {
+	return nil
+}
@@ -0,0 +1,189 @@
+package app
+
+import (
+	"chat/internal/domain"
+	"chat/internal/service/mention"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// postMessage encrypts and stores a message, then delivers it to everyone
+// connected to the chat. The returned message carries the plain content.
+// Unless ExpiresAt is already set, the message disappears after the chat
+// message lifetime.
+func (a *App) postMessage(msg domain.Message) (domain.Message, error) {
+	plainContent := msg.Content
+
+	if msg.ExpiresAt == nil && !msg.IsSystem {
+		expiresAt, err := a.messageExpiry(msg.ChatID, 0)
+		if err != nil {
+			return msg, fmt.Errorf("messageExpiry: %w", err)
+		}
+		msg.ExpiresAt = expiresAt
+	}
+
+	// Шифруем сообщение перед сохранением
+	encryptedContent, err := a.cipher.Encrypt(msg.Content)
+	if err != nil {
+		return msg, fmt.Errorf("cipher.Encrypt: %w", err)
+	}
+
+	msg.Content = encryptedContent
+	msg.ID, err = a.storage.InsertMessage(msg)
+	if err != nil {
+		return msg, fmt.Errorf("storage.InsertMessage: %w", err)
+	}
+	msg.Content = plainContent
+	msg.CreatedAt = time.Now()
+
+	a.deliverMessage(msg)
+	return msg, nil
+}
+
+// deliverMessage does everything that follows storing a new message: indexes
+// it, notifies webhooks, connected clients and mentioned users, and pushes it
+// to members who are offline.
+func (a *App) deliverMessage(msg domain.Message) {
+	a.indexMessage(msg.ID, msg.Content, msg.File.Name)
+
+	event := map[string]interface{}{
+		"message_id": msg.ID,
+		"user_id":    msg.UserID,
+		"username":   msg.Username,
+		"content":    msg.Content,
+		"file_name":  msg.File.Name,
+		"is_system":  msg.IsSystem,
+	}
+	if msg.ForwardedFrom != nil {
+		event["forwarded_from"] = map[string]interface{}{
+			"chat_id":  msg.ForwardedFrom.ChatID,
+			"user_id":  msg.ForwardedFrom.UserID,
+			"username": msg.ForwardedFrom.Username,
+		}
+	}
+	a.emitWebhookEvent(msg.ChatID, domain.WebhookEventMessageNew, event)
+
+	a.broadcastToChat(msg.ChatID, msg)
+
+	if msg.IsSystem {
+		return
+	}
+	// Упоминания в пересланных сообщениях адресованы участникам исходного чата
+	var mentioned []int
+	if msg.ForwardedFrom == nil {
+		mentioned = a.notifyMentions(msg)
+	}
+	a.pushMessage(msg, mentioned)
+}
+
+// announceMessageDeleted notifies webhooks and clients in the chat that the
+// message is gone. event is the payload of the message.delete webhook. A
+// soft-deleted message is sent along as its tombstone, without the deleter.
+func (a *App) announceMessageDeleted(chatID int, messageID int, event map[string]interface{}, tombstone *domain.Message) {
+	a.emitWebhookEvent(chatID, domain.WebhookEventMessageDelete, event)
+
+	notification := map[string]interface{}{
+		"action": "delete",
+		"id":     strconv.Itoa(messageID),
+	}
+	if tombstone != nil && tombstone.DeletedAt != nil {
+		redacted := *tombstone
+		redactTombstone(&redacted, false)
+		notification["message"] = redacted
+	}
+	a.broadcastToChat(chatID, notification)
+}
+
+// notifyMentions records the members mentioned in the message and notifies
+// all their open connections, whichever chat they are in. Muting the chat
+// doesn't silence mentions. It returns the mentioned members.
+func (a *App) notifyMentions(msg domain.Message) []int {
+	usernames, all := mention.Parse(msg.Content)
+	if len(usernames) == 0 && !all {
+		return nil
+	}
+
+	userIDs, err := a.storage.InsertMessageMentions(msg.ChatID, msg.ID, msg.UserID, usernames, all)
+	if err != nil {
+		log.Printf("notifyMentions: storage.InsertMessageMentions: %v", err)
+		return nil
+	}
+	if len(userIDs) == 0 {
+		return nil
+	}
+
+	notification, err := prepareMessage(map[string]interface{}{
+		"action":     "mention",
+		"chat_id":    msg.ChatID,
+		"message_id": msg.ID,
+		"username":   msg.Username,
+		"content":    msg.Content,
+	})
+	if err != nil {
+		log.Printf("notifyMentions: prepareMessage: %v", err)
+		return userIDs
+	}
+
+	for _, userID := range userIDs {
+		for _, client := range a.memory.GetClientsByUserID(userID) {
+			a.sendPrepared(client, notification)
+		}
+	}
+	return userIDs
+}
+
+// indexMessage updates the search index of the message if search is enabled
+func (a *App) indexMessage(messageID int, content string, fileName string) {
+	if a.search == nil {
+		return
+	}
+
+	err := a.storage.IndexMessage(messageID, a.search.IndexTokens(content+" "+fileName))
+	if err != nil {
+		log.Printf("indexMessage: storage.IndexMessage: %v", err)
+	}
+}
+
+// broadcastToChat sends v to all clients in the chat. The message is encoded
+// once and queued to every client, dropping the ones that can't keep up.
+func (a *App) broadcastToChat(chatID int, v interface{}) {
+	msg, err := prepareMessage(v)
+	if err != nil {
+		log.Printf("broadcastToChat: prepareMessage: %v", err)
+		return
+	}
+
+	for _, client := range a.memory.GetClientsByChatID(chatID) {
+		a.sendPrepared(client, msg)
+	}
+}
+
+// sendToClient sends v to a single client
+func (a *App) sendToClient(client *domain.Client, v interface{}) {
+	msg, err := prepareMessage(v)
+	if err != nil {
+		log.Printf("sendToClient: prepareMessage: %v", err)
+		return
+	}
+	a.sendPrepared(client, msg)
+}
+
+func (a *App) sendPrepared(client *domain.Client, msg *websocket.PreparedMessage) {
+	if !client.Send(msg) {
+		client.Close()
+		a.memory.DeleteClient(client)
+	}
+}
+
+func prepareMessage(v interface{}) (*websocket.PreparedMessage, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return websocket.NewPreparedMessage(websocket.TextMessage, data)
+}
@@ -0,0 +1,54 @@
+package app
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+const (
+	defaultReaperPollInterval = 5 * time.Second
+	expiredMessagesBatchSize  = 100
+)
+
+// RunReaper deletes disappearing messages once their lifetime is over until
+// ctx is cancelled. It is safe to run on several servers at once.
+func (a *App) RunReaper(ctx context.Context) {
+	pollInterval := a.cfg.Reaper.PollInterval
+	if pollInterval <= 0 {
+		pollInterval = defaultReaperPollInterval
+	}
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		a.deleteExpiredMessages(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (a *App) deleteExpiredMessages(ctx context.Context) {
+	for ctx.Err() == nil {
+		messages, err := a.storage.DeleteExpiredMessages(expiredMessagesBatchSize)
+		if err != nil {
+			log.Printf("deleteExpiredMessages: storage.DeleteExpiredMessages: %v", err)
+			return
+		}
+
+		for _, message := range messages {
+			a.announceMessageDeleted(message.ChatID, message.ID, map[string]interface{}{
+				"message_id": message.ID,
+				"expired":    true,
+			}, nil)
+		}
+
+		if len(messages) < expiredMessagesBatchSize {
+			return
+		}
+	}
+}
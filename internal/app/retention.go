@@ -0,0 +1,78 @@
+package app
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+const (
+	defaultRetentionPollInterval = time.Hour
+	defaultRetentionBatchSize    = 500
+)
+
+// RunRetention purges messages that are past the retention period of their
+// chat until ctx is cancelled. In dry-run mode it only logs what would be
+// purged. It is safe to run on several servers at once.
+func (a *App) RunRetention(ctx context.Context) {
+	pollInterval := a.cfg.Retention.PollInterval
+	if pollInterval <= 0 {
+		pollInterval = defaultRetentionPollInterval
+	}
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		if a.cfg.Retention.DryRun {
+			a.logRetentionReport()
+		} else {
+			a.purgeExpiredMessages(ctx)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (a *App) purgeExpiredMessages(ctx context.Context) {
+	batchSize := a.cfg.Retention.BatchSize
+	if batchSize <= 0 {
+		batchSize = defaultRetentionBatchSize
+	}
+
+	for ctx.Err() == nil {
+		messages, err := a.storage.PurgeExpiredMessages(a.cfg.Retention.DefaultDays, a.cfg.Retention.Archive, batchSize)
+		if err != nil {
+			log.Printf("purgeExpiredMessages: storage.PurgeExpiredMessages: %v", err)
+			return
+		}
+
+		for _, message := range messages {
+			a.announceMessageDeleted(message.ChatID, message.ID, map[string]interface{}{
+				"message_id": message.ID,
+				"retention":  true,
+			}, nil)
+		}
+
+		if len(messages) < batchSize {
+			return
+		}
+	}
+}
+
+func (a *App) logRetentionReport() {
+	reports, err := a.storage.GetRetentionReport(a.cfg.Retention.DefaultDays, 0)
+	if err != nil {
+		log.Printf("logRetentionReport: storage.GetRetentionReport: %v", err)
+		return
+	}
+
+	for _, report := range reports {
+		log.Printf("retention dry run: chat %d %q: %d messages older than %d days would be purged, oldest from %s",
+			report.ChatID, report.ChatName, report.MessageCount, report.RetentionDays,
+			report.OldestMessageAt.Format(time.RFC3339))
+	}
+}
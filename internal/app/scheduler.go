@@ -0,0 +1,123 @@
+package app
+
+import (
+	"chat/internal/domain"
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultSchedulerPollInterval = 5 * time.Second
+	scheduledMessagesBatchSize   = 50
+	// scheduledDeliveryLease is how long a server has to deliver the messages
+	// it sent before another server delivers them again
+	scheduledDeliveryLease = time.Minute
+)
+
+// RunScheduler sends scheduled messages when they are due until ctx is
+// cancelled. It is safe to run on several servers at once. Messages sent but
+// not delivered before a crash are delivered on the next poll once their
+// lease runs out.
+func (a *App) RunScheduler(ctx context.Context) {
+	pollInterval := a.cfg.Scheduler.PollInterval
+	if pollInterval <= 0 {
+		pollInterval = defaultSchedulerPollInterval
+	}
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		a.sendDueScheduledMessages(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (a *App) sendDueScheduledMessages(ctx context.Context) {
+	for ctx.Err() == nil {
+		messageIDs, err := a.storage.ClaimUndeliveredScheduledMessages(scheduledMessagesBatchSize, scheduledDeliveryLease)
+		if err != nil {
+			log.Printf("sendDueScheduledMessages: storage.ClaimUndeliveredScheduledMessages: %v", err)
+			return
+		}
+		a.deliverScheduledMessages(messageIDs)
+		if len(messageIDs) < scheduledMessagesBatchSize {
+			break
+		}
+	}
+
+	for ctx.Err() == nil {
+		messageIDs, err := a.storage.SendDueScheduledMessages(scheduledMessagesBatchSize, scheduledDeliveryLease, a.canSendScheduled)
+		if err != nil {
+			log.Printf("sendDueScheduledMessages: storage.SendDueScheduledMessages: %v", err)
+			return
+		}
+		a.deliverScheduledMessages(messageIDs)
+		if len(messageIDs) < scheduledMessagesBatchSize {
+			return
+		}
+	}
+}
+
+// deliverScheduledMessages delivers sent scheduled messages and records it,
+// so that they aren't delivered again
+func (a *App) deliverScheduledMessages(messageIDs []int) {
+	for _, messageID := range messageIDs {
+		var msg domain.Message
+		err := a.storage.GetMessageByID(strconv.Itoa(messageID), &msg)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			// Left for the next attempt once the lease runs out
+			log.Printf("deliverScheduledMessages: storage.GetMessageByID: %v", err)
+			continue
+		}
+
+		// A message deleted meanwhile has nothing left to deliver, and one
+		// that can't be decrypted never will
+		if err == nil && msg.DeletedAt == nil {
+			msg.Content, err = a.cipher.Decrypt(msg.Content)
+			if err != nil {
+				log.Printf("deliverScheduledMessages: cipher.Decrypt: %v", err)
+			} else {
+				a.deliverMessage(msg)
+			}
+		}
+
+		if err := a.storage.MarkScheduledMessageDelivered(messageID); err != nil {
+			log.Printf("deliverScheduledMessages: storage.MarkScheduledMessageDelivered: %v", err)
+		}
+	}
+}
+
+// canSendScheduled reports whether the author may still post the message:
+// they could have left the chat, lost admin rights in a channel or been
+// deactivated since scheduling it
+func (a *App) canSendScheduled(message domain.ScheduledMessage) bool {
+	role, ok := a.memberRole(message.ChatID, message.UserID)
+	if !ok {
+		return false
+	}
+
+	author, err := a.storage.GetUserByID(message.UserID)
+	if err != nil {
+		log.Printf("canSendScheduled: storage.GetUserByID: %v", err)
+		return false
+	}
+	if author.DeactivatedAt != nil {
+		return false
+	}
+
+	chat, err := a.storage.GetChatByID(message.ChatID)
+	if err != nil || chat == nil {
+		log.Printf("canSendScheduled: storage.GetChatByID: %v", err)
+		return false
+	}
+	return canPost(chat, role)
+}
@@ -3,14 +3,29 @@ package app
 import (
 	"chat/internal/domain"
 	"chat/internal/utils"
+	"database/sql"
+	"errors"
 	"log"
 	"net/http"
-	"strconv"
+	"strings"
 
 	"github.com/gorilla/mux"
 )
 
+// incomingMessage is a message sent by a client over the chat socket. TTL
+// makes it disappear after that many seconds.
+type incomingMessage struct {
+	Content string
+	File    domain.File
+	TTL     int
+}
+
 func (a *App) wsChatHandler(w http.ResponseWriter, r *http.Request) {
+	if !a.isAuthenticated(r) {
+		http.Error(w, "Not authenticated", http.StatusUnauthorized)
+		return
+	}
+
 	chatID := mux.Vars(r)["id"]
 	conn, err := a.upgrader.Upgrade(w, r, nil)
 	if err != nil {
@@ -28,52 +43,94 @@ func (a *App) wsChatHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	client := domain.Client{Conn: conn, UserID: userID, ChatID: utils.Atoi(chatID)}
+	client := domain.NewClient(conn, userID, utils.Atoi(chatID))
+	client.SessionID, _ = session.Values["session_id"].(string)
+	if _, ok := a.memberRole(client.ChatID, client.UserID); !ok {
+		return
+	}
+
+	chat, err := a.storage.GetChatByID(client.ChatID)
+	if err != nil || chat == nil {
+		log.Printf("wsChatHandler: storage.GetChatByID: %v", err)
+		return
+	}
+
+	go client.WritePump()
+	defer client.Close()
 	a.memory.AddClient(client)
 	defer a.memory.DeleteClient(client)
 
 	for {
-		var msg domain.Message
-		err := conn.ReadJSON(&msg)
+		var in incomingMessage
+		err := conn.ReadJSON(&in)
 		if err != nil {
 			log.Printf("wsChatHandler: conn.ReadJSON: %v", err)
 			break
 		}
-		msg.ChatID, _ = strconv.Atoi(chatID)
-		msg.UserID = userID
-		msg.Username = username
 
-		// Шифруем сообщение перед сохранением
-		encryptedContent, err := a.cipher.Encrypt(msg.Content)
-		if err != nil {
-			log.Printf("wsChatHandler: cipher.Encrypt: %v", err)
+		// Участник мог быть удален из чата, пока соединение было открыто
+		role, ok := a.memberRole(client.ChatID, client.UserID)
+		if !ok {
 			break
 		}
 
-		// Используем RETURNING для получения ID вставленного сообщения
-		msg.Content = encryptedContent
-		msg.ID, err = a.storage.InsertMessage(msg)
-		if err != nil {
-			log.Printf("wsChatHandler: storage.InsertMessage: %v", err)
-			break
+		msg := domain.Message{
+			ChatID:   client.ChatID,
+			UserID:   userID,
+			Username: username,
+			Content:  in.Content,
+			File:     in.File,
 		}
 
-		// Отправляем сообщение всем клиентам в чате
-		clients := a.memory.GetClientsByChatID(msg.ChatID)
-		for _, client := range clients {
-			// Дешифруем сообщение перед отправкой
-			decryptedContent, err := a.cipher.Decrypt(encryptedContent)
-			if err != nil {
-				log.Printf("wsChatHandler: cipher.Decrypt: %v", err)
+		// Сообщения, начинающиеся с "/", обрабатываются как команды,
+		// а "//" позволяет отправить текст с ведущим слешем
+		if strings.HasPrefix(msg.Content, "//") {
+			msg.Content = msg.Content[1:]
+		} else if name, args, ok := parseCommand(msg.Content); ok && msg.File.Data == "" {
+			if !a.runCommand(client, username, name, args) {
 				break
 			}
+			continue
+		}
 
-			msg.Content = decryptedContent
-			if err := client.Conn.WriteJSON(msg); err != nil {
-				log.Printf("wsChatHandler: client.Conn.WriteJSON: %v", err)
-				client.Conn.Close()
-				a.memory.DeleteClient(client)
+		if !canPost(chat, role) {
+			a.sendEphemeral(client, "Only channel admins can post here")
+			continue
+		}
+
+		if in.TTL != 0 {
+			if message := validateMessageTTL(in.TTL); message != "" {
+				a.sendEphemeral(client, message)
+				continue
+			}
+			msg.ExpiresAt, err = a.messageExpiry(msg.ChatID, in.TTL)
+			if err != nil {
+				log.Printf("wsChatHandler: messageExpiry: %v", err)
+				break
 			}
 		}
+
+		if _, err := a.postMessage(msg); err != nil {
+			log.Printf("wsChatHandler: postMessage: %v", err)
+			break
+		}
 	}
 }
+
+// memberRole returns the role of the user in the chat. It reports false if
+// the user is no longer a member.
+func (a *App) memberRole(chatID int, userID int) (domain.ChatRole, bool) {
+	role, err := a.storage.GetChatMemberRole(chatID, userID)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("memberRole: storage.GetChatMemberRole: %v", err)
+		}
+		return "", false
+	}
+	return role, true
+}
+
+// canPost reports whether a member with the role may post in the chat
+func canPost(chat *domain.Chat, role domain.ChatRole) bool {
+	return chat.Type != domain.ChatTypeChannel || role.Can(domain.PermissionPostInChannel)
+}
@@ -3,6 +3,7 @@ package config
 import (
 	"fmt"
 	"os"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
@@ -13,8 +14,9 @@ const (
 )
 
 type Config struct {
-	CookiesSecretKey string `yaml:"cookies_secret_key"`
-	EncryptionKey    string `yaml:"encryption_key"`
+	CookiesSecretKey string   `yaml:"cookies_secret_key"`
+	EncryptionKey    string   `yaml:"encryption_key"`
+	Admins           []string `yaml:"admins"` // Учетные записи, получающие роль администратора при запуске сервера
 	Server           struct {
 		Host string `yaml:"host"`
 		Port string `yaml:"port"`
@@ -25,6 +27,69 @@ type Config struct {
 		Name     string `yaml:"name"`
 		Host     string `yaml:"host"`
 	} `yaml:"db"`
+	Webhooks struct {
+		PollInterval time.Duration `yaml:"poll_interval"`
+		Timeout      time.Duration `yaml:"timeout"`
+		MaxAttempts  int           `yaml:"max_attempts"`
+		RetryBackoff time.Duration `yaml:"retry_backoff"`
+		// Разрешить адреса вебхуков в локальной сети, только для тестов
+		AllowPrivateEndpoints bool `yaml:"allow_private_endpoints"`
+	} `yaml:"webhooks"`
+	Scheduler struct {
+		PollInterval time.Duration `yaml:"poll_interval"`
+	} `yaml:"scheduler"`
+	Reaper struct {
+		PollInterval time.Duration `yaml:"poll_interval"`
+	} `yaml:"reaper"`
+	Retention struct {
+		DefaultDays  int           `yaml:"default_days"`
+		Archive      bool          `yaml:"archive"`
+		DryRun       bool          `yaml:"dry_run"`
+		PollInterval time.Duration `yaml:"poll_interval"`
+		BatchSize    int           `yaml:"batch_size"`
+	} `yaml:"retention"`
+	Email struct {
+		SMTPHost     string        `yaml:"smtp_host"` // Пустое значение отключает уведомления по почте
+		SMTPPort     string        `yaml:"smtp_port"`
+		Username     string        `yaml:"username"`
+		Password     string        `yaml:"password"`
+		From         string        `yaml:"from"`
+		BaseURL      string        `yaml:"base_url"` // Адрес сервера для ссылок в письмах
+		Delay        time.Duration `yaml:"delay"`    // Сколько сообщение должно оставаться непрочитанным
+		PollInterval time.Duration `yaml:"poll_interval"`
+	} `yaml:"email"`
+	WebPush struct {
+		VAPIDPublicKey  string        `yaml:"vapid_public_key"`  // Необязателен, сверяется с закрытым ключом
+		VAPIDPrivateKey string        `yaml:"vapid_private_key"` // Пустое значение отключает push-уведомления
+		Subject         string        `yaml:"subject"`           // Контакт для сервиса push: mailto: или https:
+		TTL             time.Duration `yaml:"ttl"`               // Сколько сервис push хранит недоставленное уведомление
+		Timeout         time.Duration `yaml:"timeout"`
+		// Разрешить адреса сервиса push в локальной сети, только для тестов
+		AllowPrivateEndpoints bool `yaml:"allow_private_endpoints"`
+	} `yaml:"web_push"`
+	Password struct {
+		MinLength     int           `yaml:"min_length"`
+		CheckBreached bool          `yaml:"check_breached"`  // Отклонять пароли из встроенного списка утекших
+		ResetTokenTTL time.Duration `yaml:"reset_token_ttl"` // Срок действия токена сброса пароля
+	} `yaml:"password"`
+	Audit struct {
+		ExportPath string `yaml:"export_path"` // JSONL-файл, в который дублируются события аудита
+		TrustProxy bool   `yaml:"trust_proxy"` // Брать адрес клиента из заголовка X-Real-IP
+	} `yaml:"audit"`
+	EDiscovery struct {
+		ExportDir  string `yaml:"export_dir"`
+		SigningKey string `yaml:"signing_key"`
+	} `yaml:"ediscovery"`
+	Search struct {
+		Enabled  bool   `yaml:"enabled"`
+		IndexKey string `yaml:"index_key"`
+	} `yaml:"search"`
+	Commands []struct {
+		Name        string `yaml:"name"`
+		Description string `yaml:"description"`
+		URL         string `yaml:"url"`
+		Secret      string `yaml:"secret"`
+	} `yaml:"commands"`
 }
 
 func NewConfig() (*Config, error) {
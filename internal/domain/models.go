@@ -1,34 +1,190 @@
 package domain
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
 )
 
 type User struct {
-	ID         int
-	Username   string
-	Name       string
-	Surname    string
-	Patronymic string
-	Password   string
-	Status     string
-	LastActive time.Time
+	ID             int
+	Username       string
+	Name           string
+	Surname        string
+	Patronymic     string
+	Password       string
+	Status         string
+	LastActive     time.Time
+	IsAdmin        bool       // Администратор сервера
+	DeactivatedAt  *time.Time // Отключенная учетная запись не может войти
+	DeletedAt      *time.Time // Удаленная учетная запись обезличена
+	SessionVersion int        // Увеличивается, чтобы завершить все сессии пользователя
 }
 
 type Chat struct {
-	ID        int
-	Name      string
-	IsPrivate bool
-	CreatorID int
-	CreatedAt time.Time
+	ID            int
+	Name          string
+	Type          ChatType
+	IsPublic      bool // Групповой чат или канал виден в каталоге и открыт для вступления
+	Topic         string
+	Description   string
+	AvatarID      int
+	MessageTTL    int  // Время жизни новых сообщений в секундах, 0 — без ограничения
+	RetentionDays *int // Срок хранения истории в днях, nil — глобальное значение, 0 — бессрочно
+	CreatorID     int
+	CreatedAt     time.Time
+}
+
+type ChatType string
+
+const (
+	ChatTypePrivate ChatType = "private"
+	ChatTypeGroup   ChatType = "group"
+	ChatTypeChannel ChatType = "channel" // Читать могут все подписчики, писать - только администраторы
+)
+
+type ChatRole string
+
+const (
+	ChatRoleOwner  ChatRole = "owner"
+	ChatRoleAdmin  ChatRole = "admin"
+	ChatRoleMember ChatRole = "member"
+)
+
+type ChatPermission int
+
+const (
+	PermissionEditChat ChatPermission = iota
+	PermissionManageMembers
+	PermissionPinMessages
+	PermissionDeleteMessages
+	PermissionManageWebhooks
+	PermissionManageRoles
+	PermissionPostInChannel
+)
+
+var chatRoleRanks = map[ChatRole]int{
+	ChatRoleMember: 1,
+	ChatRoleAdmin:  2,
+	ChatRoleOwner:  3,
+}
+
+var chatRolePermissions = map[ChatRole][]ChatPermission{
+	ChatRoleOwner: {
+		PermissionEditChat,
+		PermissionManageMembers,
+		PermissionPinMessages,
+		PermissionDeleteMessages,
+		PermissionManageWebhooks,
+		PermissionManageRoles,
+		PermissionPostInChannel,
+	},
+	ChatRoleAdmin: {
+		PermissionEditChat,
+		PermissionManageMembers,
+		PermissionPinMessages,
+		PermissionDeleteMessages,
+		PermissionManageWebhooks,
+		PermissionPostInChannel,
+	},
+}
+
+func (r ChatRole) IsValid() bool {
+	_, ok := chatRoleRanks[r]
+	return ok
+}
+
+func (r ChatRole) Can(permission ChatPermission) bool {
+	for _, p := range chatRolePermissions[r] {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// Outranks reports whether r is strictly higher than other
+func (r ChatRole) Outranks(other ChatRole) bool {
+	return chatRoleRanks[r] > chatRoleRanks[other]
+}
+
+// DirectoryChat describes a public chat in the chat directory
+type DirectoryChat struct {
+	Chat
+	MemberCount int
+	IsMember    bool
+}
+
+type ChatMember struct {
+	User
+	Role ChatRole
 }
 
 type UserChat struct {
 	Chat
 	LastVisit          time.Time
 	UnreadMessageCount int
+	UnreadMentionCount int
+	Muted              bool
+}
+
+type ChatInvite struct {
+	ID        int
+	ChatID    int
+	Token     string
+	CreatorID int
+	MaxUses   int // 0 means unlimited
+	Uses      int
+	ExpiresAt *time.Time
+	RevokedAt *time.Time
+	CreatedAt time.Time
+}
+
+// PasswordResetToken lets the user set a new password once without knowing
+// the current one. Only a hash of the token is stored.
+type PasswordResetToken struct {
+	ID        int
+	UserID    int
+	Token     string
+	CreatedBy int
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+	CreatedAt time.Time
+}
+
+// EmailSettings are the email notification preferences of a user
+type EmailSettings struct {
+	UserID           int
+	Username         string
+	Email            string
+	Enabled          bool
+	MentionsOnly     bool // Сообщать только об упоминаниях
+	DelayMinutes     int  // 0 means the server default
+	UnsubscribeToken string
+	LastSentAt       *time.Time
+}
+
+// EmailDigest lists the chats with messages the user missed
+type EmailDigest struct {
+	Settings EmailSettings
+	Chats    []UserChat
+}
+
+// PushSubscription is a browser subscribed to Web Push notifications
+type PushSubscription struct {
+	ID        int
+	UserID    int
+	Endpoint  string // URL of the push service
+	P256dh    string // Public key of the browser, base64url
+	Auth      string // Authentication secret, base64url
+	CreatedAt time.Time
 }
 
 type File struct {
@@ -36,18 +192,350 @@ type File struct {
 	Data string
 }
 
+type Attachment struct {
+	ID         int
+	File       File
+	UploaderID int
+	CreatedAt  time.Time
+}
+
 type Message struct {
-	ID        int
+	ID            int
+	ChatID        int
+	UserID        int
+	Content       string
+	CreatedAt     time.Time
+	Username      string // Добавлено поле для имени пользователя
+	File          File
+	AttachmentID  int
+	IsSystem      bool           // Служебное сообщение об изменениях в чате
+	ForwardedFrom *MessageOrigin // Автор и чат пересланного сообщения
+	ExpiresAt     *time.Time     // Исчезающее сообщение удаляется после этого момента
+
+	// Удаленное сообщение остается в истории заглушкой без содержимого
+	DeletedAt         *time.Time
+	DeletedBy         int
+	DeletedByUsername string
+}
+
+// MessageOrigin points to the original of a forwarded message
+type MessageOrigin struct {
+	MessageID int
 	ChatID    int
+	ChatName  string
 	UserID    int
-	Content   string
-	CreatedAt time.Time
-	Username  string // Добавлено поле для имени пользователя
-	File      File
+	Username  string
+}
+
+// Mention is a message mentioning the user, shown in their mentions feed
+type Mention struct {
+	Message
+	ChatName string
+}
+
+// SearchFilter narrows message search results. Zero values don't filter.
+type SearchFilter struct {
+	ChatID         int
+	AuthorUsername string
+	From           *time.Time
+	To             *time.Time
+	HasAttachment  *bool
+}
+
+// SearchResult is a message found by search
+type SearchResult struct {
+	Message
+	ChatName string
+}
+
+const (
+	ScheduledMessageStatusPending  = "pending"
+	ScheduledMessageStatusSent     = "sent"
+	ScheduledMessageStatusCanceled = "canceled"
+	ScheduledMessageStatusFailed   = "failed" // Автор больше не мог писать в чат
+)
+
+type ScheduledMessage struct {
+	ID           int
+	ChatID       int
+	UserID       int
+	Content      string
+	File         File
+	AttachmentID int
+	SendAt       time.Time
+	Status       string
+	MessageID    int
+	CreatedAt    time.Time
+}
+
+// RetentionReport describes the messages of a chat that are past its
+// retention period
+type RetentionReport struct {
+	ChatID          int
+	ChatName        string
+	RetentionDays   int
+	MessageCount    int
+	OldestMessageAt time.Time
+}
+
+// LegalHold keeps the messages of a user or a chat from being deleted by
+// retention, disappearing message timers or members. Exactly one of UserID
+// and ChatID is set.
+type LegalHold struct {
+	ID         int
+	UserID     int
+	ChatID     int
+	Reason     string
+	CreatedBy  int
+	CreatedAt  time.Time
+	ReleasedBy int
+	ReleasedAt *time.Time
+}
+
+// ErrMessageHeld is returned for edits of a message under legal hold
+var ErrMessageHeld = errors.New("message is under legal hold")
+
+const (
+	EDiscoveryExportStatusRunning   = "running"
+	EDiscoveryExportStatusCompleted = "completed"
+	EDiscoveryExportStatusFailed    = "failed"
+)
+
+// EDiscoveryExport is an export of the messages written by UserIDs or in
+// ChatIDs between From and To
+type EDiscoveryExport struct {
+	ID           int
+	RequestedBy  int
+	UserIDs      []int
+	ChatIDs      []int
+	From         *time.Time
+	To           *time.Time
+	Status       string
+	MessageCount int
+	SHA256       string // Контрольная сумма готового архива
+	Error        string
+	CreatedAt    time.Time
+	CompletedAt  *time.Time
+}
+
+type PinnedMessage struct {
+	Message
+	PinnedBy         int
+	PinnedByUsername string
+	PinnedAt         time.Time
 }
 
+// Client is a websocket connection of a user to a chat. Messages are written
+// by a single goroutine running WritePump, so broadcasting to thousands of
+// clients never waits for a slow reader.
 type Client struct {
-	Conn   *websocket.Conn
-	UserID int
-	ChatID int
+	Conn      *websocket.Conn
+	UserID    int
+	ChatID    int
+	SessionID string // Сессия, открывшая соединение
+
+	send      chan *websocket.PreparedMessage
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+const (
+	clientSendBuffer   = 64
+	clientWriteTimeout = 10 * time.Second
+)
+
+func NewClient(conn *websocket.Conn, userID int, chatID int) *Client {
+	return &Client{
+		Conn:   conn,
+		UserID: userID,
+		ChatID: chatID,
+		send:   make(chan *websocket.PreparedMessage, clientSendBuffer),
+		closed: make(chan struct{}),
+	}
+}
+
+// Send queues the message without blocking. It reports false if the client
+// is closed or can't keep up with incoming messages.
+func (c *Client) Send(msg *websocket.PreparedMessage) bool {
+	select {
+	case <-c.closed:
+		return false
+	default:
+	}
+
+	select {
+	case c.send <- msg:
+		return true
+	default:
+		return false
+	}
+}
+
+// WritePump writes queued messages to the connection until the client is
+// closed or a write fails.
+func (c *Client) WritePump() {
+	for {
+		select {
+		case msg := <-c.send:
+			c.Conn.SetWriteDeadline(time.Now().Add(clientWriteTimeout))
+			if err := c.Conn.WritePreparedMessage(msg); err != nil {
+				c.Close()
+				return
+			}
+		case <-c.closed:
+			return
+		}
+	}
+}
+
+// Close closes the connection. It is safe to call more than once.
+func (c *Client) Close() {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		c.Conn.Close()
+	})
+}
+
+const (
+	WebhookEventMessageNew    = "message.new"
+	WebhookEventMessageEdit   = "message.edit"
+	WebhookEventMessageDelete = "message.delete"
+	WebhookEventMemberJoined  = "member.joined"
+)
+
+var WebhookEvents = []string{
+	WebhookEventMessageNew,
+	WebhookEventMessageEdit,
+	WebhookEventMessageDelete,
+	WebhookEventMemberJoined,
+}
+
+const (
+	WebhookDeliveryPending   = "pending"
+	WebhookDeliveryDelivered = "delivered"
+	WebhookDeliveryFailed    = "failed"
+)
+
+type Webhook struct {
+	ID        int
+	ChatID    int
+	CreatorID int
+	URL       string
+	Secret    string `json:"-"`
+	Events    []string
+	CreatedAt time.Time
+}
+
+type WebhookDelivery struct {
+	ID             int
+	WebhookID      int
+	Event          string
+	Payload        string `json:"-"`
+	Status         string
+	Attempts       int
+	ResponseStatus int
+	LastError      string
+	CreatedAt      time.Time
+	DeliveredAt    *time.Time
+	Webhook        Webhook `json:"-"` // URL and secret of the target, filled when claimed
+}
+
+// Audit event actions
+const (
+	AuditLoginSucceeded             = "login.succeeded"
+	AuditLoginFailed                = "login.failed"
+	AuditLogout                     = "logout"
+	AuditUserRegistered             = "user.registered"
+	AuditUserDeactivated            = "user.deactivated"
+	AuditUserReactivated            = "user.reactivated"
+	AuditUserDeleted                = "user.deleted"
+	AuditUserAdminChanged           = "user.admin_changed"
+	AuditPasswordReset              = "user.password_reset"
+	AuditPasswordChanged            = "user.password_changed"
+	AuditChatCreated                = "chat.created"
+	AuditChatExported               = "chat.exported"
+	AuditMemberAdded                = "member.added"
+	AuditMemberRemoved              = "member.removed"
+	AuditMemberRoleChanged          = "member.role_changed"
+	AuditMessageEdited              = "message.edited"
+	AuditMessageDeleted             = "message.deleted"
+	AuditFileDownloaded             = "file.downloaded"
+	AuditLegalHoldPlaced            = "legal_hold.placed"
+	AuditLegalHoldReleased          = "legal_hold.released"
+	AuditEDiscoveryExportRequested  = "ediscovery.export_requested"
+	AuditEDiscoveryExportDownloaded = "ediscovery.export_downloaded"
+)
+
+// AuditEvent is an entry of the append-only audit log. Every entry carries
+// the hash of the previous one, so changing or removing an entry breaks the
+// chain after it.
+type AuditEvent struct {
+	ID            int64
+	CreatedAt     time.Time
+	ActorID       int
+	ActorUsername string
+	Action        string
+	ChatID        int
+	Target        string          // Объект действия, например "message:42" или "user:7"
+	Details       json.RawMessage // JSON-объект с подробностями
+	IP            string
+	PrevHash      string
+	Hash          string
+}
+
+// ComputeHash returns the hash of the event chained to PrevHash. CreatedAt
+// must already be truncated to the microseconds stored by the database.
+func (e AuditEvent) ComputeHash() string {
+	h := sha256.New()
+	// Каждое поле с длиной впереди, чтобы границы между полями были однозначны
+	for _, field := range []string{
+		e.PrevHash,
+		e.CreatedAt.UTC().Format(time.RFC3339Nano),
+		strconv.Itoa(e.ActorID),
+		e.ActorUsername,
+		e.Action,
+		strconv.Itoa(e.ChatID),
+		e.Target,
+		string(e.Details),
+		e.IP,
+	} {
+		fmt.Fprintf(h, "%d:%s,", len(field), field)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// VerifyAuditChain checks that the events, in the order they were appended,
+// are chained to prevHash and to each other. It returns the index of the
+// first event that doesn't match.
+func VerifyAuditChain(prevHash string, events []AuditEvent) (int, bool) {
+	for i, event := range events {
+		if event.PrevHash != prevHash || event.ComputeHash() != event.Hash {
+			return i, false
+		}
+		prevHash = event.Hash
+	}
+	return 0, true
+}
+
+// AuditFilter narrows audit log queries. Zero values don't filter.
+type AuditFilter struct {
+	ActorUsername string
+	Action        string
+	ChatID        int
+	Target        string
+	From          *time.Time
+	To            *time.Time
+}
+
+// InstanceStats is an overview of the server shown to administrators
+type InstanceStats struct {
+	Users            int
+	OnlineUsers      int
+	DeactivatedUsers int
+	Admins           int
+	Chats            map[ChatType]int
+	Messages         int
+	MessagesLastDay  int
+	Attachments      int
+	AttachmentBytes  int64 // Размер вложений в виде data URL
 }
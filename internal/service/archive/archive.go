@@ -0,0 +1,117 @@
+package archive
+
+import (
+	"archive/zip"
+	"chat/internal/config"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"time"
+)
+
+// File describes a file written to an archive
+type File struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// Writer writes a ZIP archive and records the size and SHA-256 checksum of
+// every file, so a manifest can be built once all files are written.
+type Writer struct {
+	zw      *zip.Writer
+	entries []*entry
+}
+
+type entry struct {
+	path string
+	w    io.Writer
+	hash hash.Hash
+	size int64
+}
+
+func (e *entry) Write(p []byte) (int, error) {
+	n, err := e.w.Write(p)
+	e.hash.Write(p[:n])
+	e.size += int64(n)
+	return n, err
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{zw: zip.NewWriter(w)}
+}
+
+// Create adds a file to the archive. The returned writer is valid until
+// the next call to Create or Close.
+func (w *Writer) Create(path string) (io.Writer, error) {
+	zipFile, err := w.zw.CreateHeader(&zip.FileHeader{
+		Name:     path,
+		Method:   zip.Deflate,
+		Modified: time.Now(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	e := &entry{path: path, w: zipFile, hash: sha256.New()}
+	w.entries = append(w.entries, e)
+	return e, nil
+}
+
+// Files returns the files written so far
+func (w *Writer) Files() []File {
+	files := make([]File, len(w.entries))
+	for i, e := range w.entries {
+		files[i] = File{
+			Path:   e.path,
+			Size:   e.size,
+			SHA256: hex.EncodeToString(e.hash.Sum(nil)),
+		}
+	}
+	return files
+}
+
+// Close finishes the archive. It doesn't close the underlying writer.
+func (w *Writer) Close() error {
+	return w.zw.Close()
+}
+
+// Signer signs archive manifests with Ed25519, so anyone holding the public
+// key can check an archive hasn't been altered
+type Signer struct {
+	key ed25519.PrivateKey
+}
+
+// NewSigner uses the configured base64 signing key seed, or derives one from
+// the encryption key when none is set
+func NewSigner(cfg *config.Config) (*Signer, error) {
+	if cfg.EDiscovery.SigningKey == "" {
+		mac := hmac.New(sha256.New, []byte(cfg.EncryptionKey))
+		mac.Write([]byte("archive-signing"))
+		return &Signer{key: ed25519.NewKeyFromSeed(mac.Sum(nil))}, nil
+	}
+
+	seed, err := base64.StdEncoding.DecodeString(cfg.EDiscovery.SigningKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid signing key: %w", err)
+	}
+	if len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("signing key must be %d bytes long", ed25519.SeedSize)
+	}
+	return &Signer{key: ed25519.NewKeyFromSeed(seed)}, nil
+}
+
+// Sign returns the base64 signature of data
+func (s *Signer) Sign(data []byte) string {
+	return base64.StdEncoding.EncodeToString(ed25519.Sign(s.key, data))
+}
+
+// PublicKey returns the base64 public key that verifies the signatures
+func (s *Signer) PublicKey() string {
+	return base64.StdEncoding.EncodeToString(s.key.Public().(ed25519.PublicKey))
+}
@@ -0,0 +1,222 @@
+package email
+
+import (
+	"bytes"
+	"chat/internal/config"
+	"chat/internal/domain"
+	"context"
+	"fmt"
+	"log"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	UnsubscribePath = "/api/email/unsubscribe"
+
+	defaultDelay        = 15 * time.Minute
+	defaultPollInterval = time.Minute
+	batchSize           = 50
+)
+
+type Storage interface {
+	GetEmailDigestRecipients(defaultDelay time.Duration, skip []int, limit int) ([]domain.EmailSettings, time.Time, error)
+	GetChatsByUserID(userID int) ([]domain.UserChat, error)
+	CountUnreadMessages(chatID int, userID int, timepoint time.Time) (int, error)
+	CountUnreadMentions(chatID int, userID int, timepoint time.Time) (int, error)
+	MarkEmailDigestSent(userID int, sentAt time.Time) error
+}
+
+// Presence tells which users have an open connection
+type Presence interface {
+	GetClientsByUserID(userID int) []*domain.Client
+}
+
+// Service emails users digests of the unread messages and mentions they
+// missed while having no open connection
+type Service struct {
+	storage      Storage
+	presence     Presence
+	addr         string
+	auth         smtp.Auth // nil if the server doesn't require authentication
+	from         string
+	baseURL      string
+	delay        time.Duration
+	pollInterval time.Duration
+}
+
+// NewService returns nil when no SMTP server is configured
+func NewService(cfg *config.Config, storage Storage, presence Presence) *Service {
+	if cfg.Email.SMTPHost == "" {
+		return nil
+	}
+
+	s := &Service{
+		storage:      storage,
+		presence:     presence,
+		addr:         net.JoinHostPort(cfg.Email.SMTPHost, cfg.Email.SMTPPort),
+		from:         cfg.Email.From,
+		baseURL:      strings.TrimSuffix(cfg.Email.BaseURL, "/"),
+		delay:        cfg.Email.Delay,
+		pollInterval: cfg.Email.PollInterval,
+	}
+	if cfg.Email.Username != "" {
+		s.auth = smtp.PlainAuth("", cfg.Email.Username, cfg.Email.Password, cfg.Email.SMTPHost)
+	}
+	if s.delay <= 0 {
+		s.delay = defaultDelay
+	}
+	if s.pollInterval <= 0 {
+		s.pollInterval = defaultPollInterval
+	}
+	return s
+}
+
+// Run sends digests to users with messages left unread for long enough
+// until ctx is cancelled.
+func (s *Service) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		s.sendDigests(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Service) sendDigests(ctx context.Context) {
+	// Users that are online or failed are left for the next poll
+	var skip []int
+	for ctx.Err() == nil {
+		recipients, now, err := s.storage.GetEmailDigestRecipients(s.delay, skip, batchSize)
+		if err != nil {
+			log.Printf("email.sendDigests: storage.GetEmailDigestRecipients: %v", err)
+			return
+		}
+
+		for _, settings := range recipients {
+			if len(s.presence.GetClientsByUserID(settings.UserID)) > 0 {
+				skip = append(skip, settings.UserID)
+				continue
+			}
+			if err := s.sendDigest(settings); err != nil {
+				log.Printf("email.sendDigests: digest to user %d: %v", settings.UserID, err)
+				skip = append(skip, settings.UserID)
+				continue
+			}
+			if err := s.storage.MarkEmailDigestSent(settings.UserID, now); err != nil {
+				log.Printf("email.sendDigests: storage.MarkEmailDigestSent: %v", err)
+				skip = append(skip, settings.UserID)
+			}
+		}
+
+		if len(recipients) < batchSize {
+			return
+		}
+	}
+}
+
+// sendDigest counts what the user missed since visiting each chat or the
+// last digest, whichever is later, and emails it. Muted chats are reported
+// only if the user is mentioned there.
+func (s *Service) sendDigest(settings domain.EmailSettings) error {
+	chats, err := s.storage.GetChatsByUserID(settings.UserID)
+	if err != nil {
+		return fmt.Errorf("storage.GetChatsByUserID: %w", err)
+	}
+
+	digest := domain.EmailDigest{Settings: settings}
+	for _, chat := range chats {
+		since := chat.LastVisit
+		if settings.LastSentAt != nil && settings.LastSentAt.After(since) {
+			since = *settings.LastSentAt
+		}
+
+		chat.UnreadMentionCount, err = s.storage.CountUnreadMentions(chat.ID, settings.UserID, since)
+		if err != nil {
+			return fmt.Errorf("storage.CountUnreadMentions: %w", err)
+		}
+		if !chat.Muted && !settings.MentionsOnly {
+			chat.UnreadMessageCount, err = s.storage.CountUnreadMessages(chat.ID, settings.UserID, since)
+			if err != nil {
+				return fmt.Errorf("storage.CountUnreadMessages: %w", err)
+			}
+		}
+
+		if chat.UnreadMessageCount > 0 || chat.UnreadMentionCount > 0 {
+			digest.Chats = append(digest.Chats, chat)
+		}
+	}
+
+	// Everything could have been read or deleted meanwhile
+	if len(digest.Chats) == 0 {
+		return nil
+	}
+	return s.Send(digest)
+}
+
+// Send emails the digest to the address in its settings
+func (s *Service) Send(digest domain.EmailDigest) error {
+	to := digest.Settings.Email
+	if strings.ContainsAny(to, "\r\n") {
+		return fmt.Errorf("invalid address %q", to)
+	}
+
+	message, err := s.compose(digest)
+	if err != nil {
+		return err
+	}
+	return smtp.SendMail(s.addr, s.auth, s.from, []string{to}, message)
+}
+
+func (s *Service) compose(digest domain.EmailDigest) ([]byte, error) {
+	unsubscribeURL := s.baseURL + UnsubscribePath + "?token=" + url.QueryEscape(digest.Settings.UnsubscribeToken)
+
+	var messages, mentions int
+	for _, chat := range digest.Chats {
+		messages += chat.UnreadMessageCount
+		mentions += chat.UnreadMentionCount
+	}
+	subject := fmt.Sprintf("Непрочитанные сообщения: %d, упоминания: %d", messages, mentions)
+
+	var body bytes.Buffer
+	fmt.Fprintf(&body, "Здравствуйте, %s!\n\n", digest.Settings.Username)
+	fmt.Fprintf(&body, "Пока вас не было в сети, в чатах появились непрочитанные сообщения:\n\n")
+	for _, chat := range digest.Chats {
+		// Names may contain line breaks
+		name := strings.Join(strings.Fields(chat.Name), " ")
+		fmt.Fprintf(&body, "- %s: сообщений %d, упоминаний %d\n", name, chat.UnreadMessageCount, chat.UnreadMentionCount)
+	}
+	fmt.Fprintf(&body, "\nОткрыть чат: %s\n\n", s.baseURL)
+	fmt.Fprintf(&body, "Отписаться от уведомлений: %s\n", unsubscribeURL)
+
+	var message bytes.Buffer
+	fmt.Fprintf(&message, "From: %s\r\n", s.from)
+	fmt.Fprintf(&message, "To: %s\r\n", digest.Settings.Email)
+	fmt.Fprintf(&message, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&message, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&message, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&message, "Content-Type: text/plain; charset=utf-8\r\n")
+	fmt.Fprintf(&message, "Content-Transfer-Encoding: quoted-printable\r\n")
+	fmt.Fprintf(&message, "List-Unsubscribe: <%s>\r\n", unsubscribeURL)
+	fmt.Fprintf(&message, "List-Unsubscribe-Post: List-Unsubscribe=One-Click\r\n")
+	fmt.Fprintf(&message, "\r\n")
+
+	writer := quotedprintable.NewWriter(&message)
+	if _, err := writer.Write(bytes.ReplaceAll(body.Bytes(), []byte("\n"), []byte("\r\n"))); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return message.Bytes(), nil
+}
@@ -0,0 +1,394 @@
+package importer
+
+import (
+	"chat/internal/domain"
+	"chat/internal/service/search"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// User is a message author or chat member as identified by the source
+type User struct {
+	ID       string // Идентификатор в источнике
+	Username string // Имя пользователя в источнике, если оно известно
+	Name     string // Отображаемое имя
+}
+
+// Attachment is a file of an imported message. Open is nil when the content
+// is not available in the export.
+type Attachment struct {
+	Name string
+	Open func() ([]byte, error)
+}
+
+// Message is a message read from an export. Key identifies it within the
+// source and makes repeated imports skip it.
+type Message struct {
+	Key         string
+	Author      User
+	Text        string
+	Time        time.Time
+	Attachments []Attachment
+}
+
+// Chat is a conversation read from an export with all of its messages
+type Chat struct {
+	Key         string
+	Name        string
+	Type        domain.ChatType
+	IsPublic    bool
+	Description string
+	Members     []User
+	Messages    []Message
+}
+
+type Storage interface {
+	GetUserIDByUsername(username string) (int, error)
+	GetChatIDByUserIDs(firstID int, secondID int) (int, error)
+	ImportChat(chat domain.Chat, importKey string, memberIDs []int) (int, error)
+	ImportMessage(message domain.Message, importKey string) (int, error)
+	MessageImported(importKey string) (bool, error)
+	IndexMessage(messageID int, tokens []string) error
+}
+
+type Cipher interface {
+	Encrypt(plainText string) (string, error)
+}
+
+// Stats counts what an import did
+type Stats struct {
+	Chats        int
+	Messages     int
+	Duplicates   int // Сообщения, импортированные ранее
+	Unmapped     int // Сообщения авторов без учётной записи
+	Files        int
+	MissingFiles int
+}
+
+// Importer writes chats read from exports to storage. Authors are matched to
+// accounts by username; messages of authors without an account are posted on
+// behalf of the owner with the original name in front of the text.
+type Importer struct {
+	storage   Storage
+	cipher    Cipher
+	search    *search.Service
+	ownerID   int
+	usernames map[string]string
+	userIDs   map[string]int
+	Stats     Stats
+}
+
+// New returns an importer acting as the owner account. usernames maps source
+// user ids or display names to usernames when they differ from the source
+// usernames.
+func New(storage Storage, cipher Cipher, search *search.Service, owner string, usernames map[string]string) (*Importer, error) {
+	im := &Importer{
+		storage:   storage,
+		cipher:    cipher,
+		search:    search,
+		usernames: usernames,
+		userIDs:   make(map[string]int),
+	}
+
+	ownerID, err := im.userID(owner)
+	if err != nil {
+		return nil, err
+	}
+	if ownerID == 0 {
+		return nil, fmt.Errorf("user %q not found", owner)
+	}
+	im.ownerID = ownerID
+	return im, nil
+}
+
+// Import creates the chat unless an earlier import did and adds the messages
+// that are not in it yet
+func (im *Importer) Import(chat Chat) error {
+	chatID, err := im.importChat(chat)
+	if err != nil {
+		return err
+	}
+	im.Stats.Chats++
+
+	sort.SliceStable(chat.Messages, func(i, j int) bool {
+		return chat.Messages[i].Time.Before(chat.Messages[j].Time)
+	})
+	for _, message := range chat.Messages {
+		if err := im.importMessage(chatID, message); err != nil {
+			return fmt.Errorf("message %s: %w", message.Key, err)
+		}
+	}
+	return nil
+}
+
+// importChat returns the id of the chat to import into. A conversation of
+// two users with accounts goes to their private chat, any other becomes a
+// group or channel owned by the owner.
+func (im *Importer) importChat(chat Chat) (int, error) {
+	var memberIDs []int
+	usernames := make(map[int]string)
+	addMember := func(user User) error {
+		userID, username, err := im.resolve(user)
+		if err != nil {
+			return err
+		}
+		if _, ok := usernames[userID]; userID != 0 && !ok {
+			usernames[userID] = username
+			memberIDs = append(memberIDs, userID)
+		}
+		return nil
+	}
+	for _, user := range chat.Members {
+		if err := addMember(user); err != nil {
+			return 0, err
+		}
+	}
+	for _, message := range chat.Messages {
+		if err := addMember(message.Author); err != nil {
+			return 0, err
+		}
+	}
+
+	created := domain.Chat{
+		Name:        chat.Name,
+		Type:        chat.Type,
+		IsPublic:    chat.IsPublic,
+		Description: chat.Description,
+		CreatorID:   im.ownerID,
+		CreatedAt:   time.Now().UTC(),
+	}
+	if len(chat.Messages) > 0 {
+		created.CreatedAt = chat.Messages[0].Time
+		for _, message := range chat.Messages {
+			if message.Time.Before(created.CreatedAt) {
+				created.CreatedAt = message.Time
+			}
+		}
+	}
+
+	if chat.Type == domain.ChatTypePrivate {
+		if len(memberIDs) == 2 {
+			chatID, err := im.storage.GetChatIDByUserIDs(memberIDs[0], memberIDs[1])
+			if err == nil {
+				return chatID, nil
+			}
+			if !errors.Is(err, sql.ErrNoRows) {
+				return 0, fmt.Errorf("storage.GetChatIDByUserIDs: %w", err)
+			}
+
+			// Как и при создании личного чата, он называется по имени
+			// собеседника создателя
+			created.CreatorID = memberIDs[0]
+			created.Name = usernames[memberIDs[1]]
+		} else {
+			created.Type = domain.ChatTypeGroup
+		}
+	}
+	if created.Type != domain.ChatTypePrivate && usernames[im.ownerID] == "" {
+		memberIDs = append(memberIDs, im.ownerID)
+	}
+	if created.Name == "" {
+		var names []string
+		for _, user := range chat.Members {
+			names = append(names, displayName(user))
+		}
+		created.Name = strings.Join(names, ", ")
+	}
+	if created.Name == "" {
+		created.Name = chat.Key
+	}
+
+	chatID, err := im.storage.ImportChat(created, chat.Key, memberIDs)
+	if err != nil {
+		return 0, fmt.Errorf("storage.ImportChat: %w", err)
+	}
+	return chatID, nil
+}
+
+// importMessage stores the message with its first attachment. Further
+// attachments are stored as separate messages right after it. Every part has
+// its own import key, so a repeated import adds the attachments an
+// interrupted one didn't get to.
+func (im *Importer) importMessage(chatID int, message Message) error {
+	// Проверка до загрузки вложений, чтобы повторный импорт не скачивал их
+	// заново
+	pending := make([]bool, max(len(message.Attachments), 1))
+	done := true
+	for i := range pending {
+		imported, err := im.storage.MessageImported(partKey(message.Key, i))
+		if err != nil {
+			return fmt.Errorf("storage.MessageImported: %w", err)
+		}
+		pending[i] = !imported
+		done = done && imported
+	}
+	if done {
+		im.Stats.Duplicates++
+		return nil
+	}
+
+	userID, _, err := im.resolve(message.Author)
+	if err != nil {
+		return err
+	}
+	text := message.Text
+	if userID == 0 {
+		userID = im.ownerID
+		if pending[0] {
+			im.Stats.Unmapped++
+			text = displayName(message.Author) + ": " + text
+		}
+	}
+
+	files := make([]domain.File, len(message.Attachments))
+	for i, attachment := range message.Attachments {
+		if !pending[i] {
+			continue
+		}
+		files[i], err = loadFile(attachment)
+		if err != nil {
+			log.Printf("importMessage: message %s: %v", message.Key, err)
+		}
+		if files[i].Data == "" {
+			im.Stats.MissingFiles++
+			text = strings.TrimSpace(text + "\n[" + attachment.Name + "]")
+		}
+	}
+
+	if pending[0] {
+		var file domain.File
+		if len(files) > 0 {
+			file = files[0]
+		}
+		if err := im.insert(chatID, userID, message.Key, text, file, message.Time); err != nil {
+			return err
+		}
+	}
+	for i := 1; i < len(files); i++ {
+		if !pending[i] || files[i].Data == "" {
+			continue
+		}
+		if err := im.insert(chatID, userID, partKey(message.Key, i), "", files[i], message.Time); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// partKey returns the import key of the message part carrying the i-th
+// attachment. The first one is stored with the message itself.
+func partKey(key string, i int) string {
+	if i == 0 {
+		return key
+	}
+	return fmt.Sprintf("%s:%d", key, i)
+}
+
+func (im *Importer) insert(chatID int, userID int, key string, text string, file domain.File, createdAt time.Time) error {
+	content, err := im.cipher.Encrypt(text)
+	if err != nil {
+		return fmt.Errorf("cipher.Encrypt: %w", err)
+	}
+
+	messageID, err := im.storage.ImportMessage(domain.Message{
+		ChatID:    chatID,
+		UserID:    userID,
+		Content:   content,
+		File:      file,
+		CreatedAt: createdAt.UTC(),
+	}, key)
+	if err != nil {
+		return fmt.Errorf("storage.ImportMessage: %w", err)
+	}
+	if messageID == 0 {
+		im.Stats.Duplicates++
+		return nil
+	}
+	im.Stats.Messages++
+	if file.Data != "" {
+		im.Stats.Files++
+	}
+
+	if im.search != nil {
+		err = im.storage.IndexMessage(messageID, im.search.IndexTokens(text+" "+file.Name))
+		if err != nil {
+			return fmt.Errorf("storage.IndexMessage: %w", err)
+		}
+	}
+	return nil
+}
+
+// resolve returns the account id and username of the source user or 0 if
+// there is no such account
+func (im *Importer) resolve(user User) (int, string, error) {
+	username, ok := im.username(user)
+	if !ok {
+		return 0, "", nil
+	}
+	userID, err := im.userID(username)
+	return userID, username, err
+}
+
+// username returns the username the source user is mapped to: an explicit
+// mapping of the id or name, otherwise the source username
+func (im *Importer) username(user User) (string, bool) {
+	for _, key := range []string{user.ID, user.Username, user.Name} {
+		if username, ok := im.usernames[key]; ok && key != "" {
+			return username, true
+		}
+	}
+	return user.Username, user.Username != ""
+}
+
+func (im *Importer) userID(username string) (int, error) {
+	if userID, ok := im.userIDs[username]; ok {
+		return userID, nil
+	}
+
+	userID, err := im.storage.GetUserIDByUsername(username)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return 0, fmt.Errorf("storage.GetUserIDByUsername: %w", err)
+	}
+	im.userIDs[username] = userID
+	return userID, nil
+}
+
+// loadFile reads the attachment into a data URL like the ones uploaded from
+// the browser
+func loadFile(attachment Attachment) (domain.File, error) {
+	file := domain.File{Name: attachment.Name}
+	if attachment.Open == nil {
+		return file, nil
+	}
+
+	data, err := attachment.Open()
+	if err != nil {
+		return file, fmt.Errorf("%s: %w", attachment.Name, err)
+	}
+
+	mimeType := mime.TypeByExtension(filepath.Ext(attachment.Name))
+	if mimeType == "" {
+		mimeType = http.DetectContentType(data)
+	}
+	file.Data = "data:" + mimeType + ";base64," + base64.StdEncoding.EncodeToString(data)
+	return file, nil
+}
+
+func displayName(user User) string {
+	if user.Name != "" {
+		return user.Name
+	}
+	if user.Username != "" {
+		return user.Username
+	}
+	return user.ID
+}
@@ -0,0 +1,283 @@
+package importer
+
+import (
+	"archive/zip"
+	"chat/internal/domain"
+	"chat/internal/service/mention"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"io/fs"
+	"net/http"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// slackDownloadTimeout limits downloading one file of a Slack export
+const slackDownloadTimeout = time.Minute
+
+type slackUser struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	RealName string `json:"real_name"`
+	Profile  struct {
+		DisplayName string `json:"display_name"`
+		RealName    string `json:"real_name"`
+	} `json:"profile"`
+}
+
+type slackConversation struct {
+	ID      string   `json:"id"`
+	Name    string   `json:"name"`
+	Members []string `json:"members"`
+	Purpose struct {
+		Value string `json:"value"`
+	} `json:"purpose"`
+}
+
+type slackMessage struct {
+	Type     string      `json:"type"`
+	Subtype  string      `json:"subtype"`
+	User     string      `json:"user"`
+	BotID    string      `json:"bot_id"`
+	Username string      `json:"username"`
+	Text     string      `json:"text"`
+	Ts       string      `json:"ts"`
+	Files    []slackFile `json:"files"`
+}
+
+type slackFile struct {
+	ID                 string `json:"id"`
+	Name               string `json:"name"`
+	Mode               string `json:"mode"`
+	URLPrivateDownload string `json:"url_private_download"`
+}
+
+// slackSubtypes are the kinds of Slack messages worth importing; the rest
+// are joins, topic changes and the like
+var slackSubtypes = map[string]bool{
+	"":                 true,
+	"bot_message":      true,
+	"file_share":       true,
+	"me_message":       true,
+	"thread_broadcast": true,
+}
+
+var (
+	slackUserMention    = regexp.MustCompile(`<@([A-Z0-9]+)(?:\|[^>]*)?>`)
+	slackChannelMention = regexp.MustCompile(`<#[A-Z0-9]+\|([^>]*)>`)
+	slackSpecialMention = regexp.MustCompile(`<!(?:channel|here|everyone)(?:\|[^>]*)?>`)
+	slackLink           = regexp.MustCompile(`<([^<>|]+)(?:\|([^<>]*))?>`)
+)
+
+// ReadSlack calls fn for every conversation of a Slack workspace export:
+// public and private channels, group and direct messages. Slack exports
+// don't contain files, so they are downloaded with the token if one is
+// given.
+func ReadSlack(exportPath string, token string, fn func(Chat) error) error {
+	archive, err := zip.OpenReader(exportPath)
+	if err != nil {
+		return err
+	}
+	defer archive.Close()
+
+	var users []slackUser
+	if err := readSlackJSON(archive, "users.json", &users); err != nil {
+		return err
+	}
+	usersByID := make(map[string]User, len(users))
+	for _, user := range users {
+		name := user.Profile.RealName
+		if name == "" {
+			name = user.RealName
+		}
+		if name == "" {
+			name = user.Profile.DisplayName
+		}
+		usersByID[user.ID] = User{ID: user.ID, Username: user.Name, Name: name}
+	}
+
+	client := &http.Client{Timeout: slackDownloadTimeout}
+	kinds := []struct {
+		file     string
+		chatType domain.ChatType
+		isPublic bool
+	}{
+		{"channels.json", domain.ChatTypeGroup, true},
+		{"groups.json", domain.ChatTypeGroup, false},
+		{"mpims.json", domain.ChatTypeGroup, false},
+		{"dms.json", domain.ChatTypePrivate, false},
+	}
+	for _, kind := range kinds {
+		var conversations []slackConversation
+		if err := readSlackJSON(archive, kind.file, &conversations); err != nil {
+			return err
+		}
+
+		for _, conversation := range conversations {
+			chat := Chat{
+				Key:         "slack:" + conversation.ID,
+				Name:        conversation.Name,
+				Type:        kind.chatType,
+				IsPublic:    kind.isPublic,
+				Description: conversation.Purpose.Value,
+			}
+			for _, memberID := range conversation.Members {
+				chat.Members = append(chat.Members, slackAuthor(usersByID, memberID, ""))
+			}
+
+			// Сообщения личных переписок лежат в каталоге с id, остальных -
+			// с названием канала
+			dir := conversation.Name
+			if dir == "" {
+				dir = conversation.ID
+			}
+			chat.Messages, err = readSlackMessages(archive, dir, conversation.ID, usersByID, client, token)
+			if err != nil {
+				return fmt.Errorf("%s: %w", dir, err)
+			}
+
+			if err := fn(chat); err != nil {
+				return fmt.Errorf("%s: %w", dir, err)
+			}
+		}
+	}
+	return nil
+}
+
+// readSlackJSON decodes a file of the export, leaving v empty if the export
+// has no such file
+func readSlackJSON(archive *zip.ReadCloser, name string, v interface{}) error {
+	file, err := archive.Open(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if err := json.NewDecoder(file).Decode(v); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return nil
+}
+
+// readSlackMessages reads the daily message files of a conversation
+func readSlackMessages(archive *zip.ReadCloser, dir string, conversationID string, users map[string]User, client *http.Client, token string) ([]Message, error) {
+	var names []string
+	for _, file := range archive.File {
+		if path.Dir(file.Name) == dir && path.Ext(file.Name) == ".json" {
+			names = append(names, file.Name)
+		}
+	}
+	sort.Strings(names)
+
+	var messages []Message
+	for _, name := range names {
+		var day []slackMessage
+		if err := readSlackJSON(archive, name, &day); err != nil {
+			return nil, err
+		}
+
+		for _, message := range day {
+			if message.Type != "message" || !slackSubtypes[message.Subtype] {
+				continue
+			}
+			createdAt, err := parseSlackTs(message.Ts)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", name, err)
+			}
+
+			imported := Message{
+				Key:    "slack:" + conversationID + ":" + message.Ts,
+				Author: slackAuthor(users, message.User, message.Username),
+				Text:   slackText(message.Text, users),
+				Time:   createdAt,
+			}
+			if message.User == "" && message.BotID != "" {
+				imported.Author.ID = message.BotID
+			}
+			for _, file := range message.Files {
+				// Удалённые файлы и файлы сверх лимита бесплатного тарифа
+				if file.Mode == "tombstone" || file.Mode == "hidden_by_limit" {
+					continue
+				}
+				attachment := Attachment{Name: file.Name}
+				if token != "" && file.URLPrivateDownload != "" {
+					attachment.Open = slackDownload(client, file.URLPrivateDownload, token)
+				}
+				imported.Attachments = append(imported.Attachments, attachment)
+			}
+			messages = append(messages, imported)
+		}
+	}
+	return messages, nil
+}
+
+func slackAuthor(users map[string]User, userID string, username string) User {
+	if user, ok := users[userID]; ok {
+		return user
+	}
+	return User{ID: userID, Name: username}
+}
+
+// parseSlackTs converts a Slack message timestamp like "1503435956.000247"
+func parseSlackTs(ts string) (time.Time, error) {
+	seconds, micros, _ := strings.Cut(ts, ".")
+	sec, err := strconv.ParseInt(seconds, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid ts %q", ts)
+	}
+	usec, _ := strconv.ParseInt(micros, 10, 64)
+	return time.Unix(sec, usec*int64(time.Microsecond)).UTC(), nil
+}
+
+// slackText turns Slack markup into plain text: mentions become @username or
+// @all, links show their label next to the address
+func slackText(text string, users map[string]User) string {
+	text = slackUserMention.ReplaceAllStringFunc(text, func(match string) string {
+		user, ok := users[slackUserMention.FindStringSubmatch(match)[1]]
+		if !ok {
+			return match
+		}
+		return "@" + user.Username
+	})
+	text = slackChannelMention.ReplaceAllString(text, "#$1")
+	text = slackSpecialMention.ReplaceAllString(text, "@"+mention.All)
+	text = slackLink.ReplaceAllStringFunc(text, func(link string) string {
+		parts := slackLink.FindStringSubmatch(link)
+		if parts[2] == "" || parts[2] == parts[1] {
+			return parts[1]
+		}
+		return parts[2] + " (" + parts[1] + ")"
+	})
+	return html.UnescapeString(text)
+}
+
+func slackDownload(client *http.Client, url string, token string) func() ([]byte, error) {
+	return func() ([]byte, error) {
+		req, err := http.NewRequest(http.MethodGet, url, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("download: %s", resp.Status)
+		}
+		return io.ReadAll(resp.Body)
+	}
+}
@@ -0,0 +1,199 @@
+package importer
+
+import (
+	"bytes"
+	"chat/internal/domain"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type telegramChat struct {
+	ID       int64             `json:"id"`
+	Name     string            `json:"name"`
+	Type     string            `json:"type"`
+	Messages []telegramMessage `json:"messages"`
+}
+
+// telegramExport is either an export of a single chat or of the whole
+// account with the chats listed inside
+type telegramExport struct {
+	telegramChat
+	Chats struct {
+		List []telegramChat `json:"list"`
+	} `json:"chats"`
+}
+
+type telegramMessage struct {
+	ID            int64        `json:"id"`
+	Type          string       `json:"type"`
+	Date          string       `json:"date"`
+	DateUnixtime  string       `json:"date_unixtime"`
+	From          string       `json:"from"`
+	FromID        string       `json:"from_id"`
+	ForwardedFrom string       `json:"forwarded_from"`
+	Text          telegramText `json:"text"`
+	File          string       `json:"file"`
+	FileName      string       `json:"file_name"`
+	Photo         string       `json:"photo"`
+}
+
+// telegramText is the text of a message: a plain string or, when it has
+// formatting, a list of strings and entities with their own text
+type telegramText string
+
+func (t *telegramText) UnmarshalJSON(data []byte) error {
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("[")) {
+		var parts []json.RawMessage
+		if err := json.Unmarshal(data, &parts); err != nil {
+			return err
+		}
+
+		var text strings.Builder
+		for _, part := range parts {
+			var entity struct {
+				Text string `json:"text"`
+			}
+			var plain string
+			if err := json.Unmarshal(part, &plain); err == nil {
+				text.WriteString(plain)
+			} else if err := json.Unmarshal(part, &entity); err == nil {
+				text.WriteString(entity.Text)
+			} else {
+				return err
+			}
+		}
+		*t = telegramText(text.String())
+		return nil
+	}
+
+	var plain string
+	if err := json.Unmarshal(data, &plain); err != nil {
+		return err
+	}
+	*t = telegramText(plain)
+	return nil
+}
+
+// telegramChatTypes maps Telegram chat types to the imported ones. Saved
+// messages are not a conversation and are skipped.
+var telegramChatTypes = map[string]struct {
+	chatType domain.ChatType
+	isPublic bool
+}{
+	"personal_chat":      {domain.ChatTypePrivate, false},
+	"bot_chat":           {domain.ChatTypePrivate, false},
+	"private_group":      {domain.ChatTypeGroup, false},
+	"private_supergroup": {domain.ChatTypeGroup, false},
+	"public_supergroup":  {domain.ChatTypeGroup, true},
+	"private_channel":    {domain.ChatTypeChannel, false},
+	"public_channel":     {domain.ChatTypeChannel, true},
+}
+
+// ReadTelegram calls fn for every chat of a Telegram Desktop export in JSON
+// format. Files are read from the export directory next to result.json.
+// Telegram doesn't export usernames of the authors, so they have to be
+// mapped by id or name.
+func ReadTelegram(resultPath string, fn func(Chat) error) error {
+	data, err := os.ReadFile(resultPath)
+	if err != nil {
+		return err
+	}
+
+	var export telegramExport
+	if err := json.Unmarshal(data, &export); err != nil {
+		return err
+	}
+	chats := export.Chats.List
+	if export.Type != "" {
+		chats = append(chats, export.telegramChat)
+	}
+
+	dir := filepath.Dir(resultPath)
+	for _, source := range chats {
+		kind, ok := telegramChatTypes[source.Type]
+		if !ok {
+			continue
+		}
+
+		chat := Chat{
+			Key:      fmt.Sprintf("telegram:%d", source.ID),
+			Name:     source.Name,
+			Type:     kind.chatType,
+			IsPublic: kind.isPublic,
+		}
+		for _, message := range source.Messages {
+			if message.Type != "message" {
+				continue
+			}
+			imported, err := telegramMessageToImport(dir, chat.Key, message)
+			if err != nil {
+				return fmt.Errorf("%s: %w", source.Name, err)
+			}
+			chat.Messages = append(chat.Messages, imported)
+		}
+
+		if err := fn(chat); err != nil {
+			return fmt.Errorf("%s: %w", source.Name, err)
+		}
+	}
+	return nil
+}
+
+func telegramMessageToImport(dir string, chatKey string, message telegramMessage) (Message, error) {
+	createdAt, err := parseTelegramDate(message)
+	if err != nil {
+		return Message{}, fmt.Errorf("message %d: %w", message.ID, err)
+	}
+
+	imported := Message{
+		Key:    fmt.Sprintf("%s:%d", chatKey, message.ID),
+		Author: User{ID: message.FromID, Name: message.From},
+		Text:   string(message.Text),
+		Time:   createdAt,
+	}
+	if message.ForwardedFrom != "" {
+		imported.Text = "Forwarded from " + message.ForwardedFrom + "\n" + imported.Text
+	}
+
+	for _, file := range []string{message.Photo, message.File} {
+		if file == "" {
+			continue
+		}
+		name := filepath.Base(file)
+		if file == message.File && message.FileName != "" {
+			name = message.FileName
+		}
+		attachment := Attachment{Name: name}
+		// Файлы, не выбранные при экспорте, отмечены строкой вида
+		// "(File not included. Change data exporting settings to download.)"
+		if !strings.HasPrefix(file, "(") {
+			path := filepath.Join(dir, filepath.FromSlash(file))
+			attachment.Open = func() ([]byte, error) { return os.ReadFile(path) }
+		}
+		imported.Attachments = append(imported.Attachments, attachment)
+	}
+	return imported, nil
+}
+
+// parseTelegramDate returns the time of the message. Older exports have only
+// the local time of the exporting computer.
+func parseTelegramDate(message telegramMessage) (time.Time, error) {
+	if message.DateUnixtime != "" {
+		sec, err := strconv.ParseInt(message.DateUnixtime, 10, 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid date_unixtime %q", message.DateUnixtime)
+		}
+		return time.Unix(sec, 0).UTC(), nil
+	}
+
+	createdAt, err := time.ParseInLocation("2006-01-02T15:04:05", message.Date, time.Local)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date %q", message.Date)
+	}
+	return createdAt.UTC(), nil
+}
@@ -4,13 +4,16 @@ import (
 	"chat/internal/config"
 	"chat/internal/domain"
 	"net/http"
+	"sync"
 
 	"github.com/gorilla/sessions"
 )
 
 type Service struct {
 	cookies *sessions.CookieStore
-	clients map[domain.Client]bool
+	mu      sync.RWMutex
+	clients map[int]map[*domain.Client]struct{} // chat ID -> clients
+	users   map[int]map[*domain.Client]struct{} // user ID -> clients
 }
 
 func NewService(cfg *config.Config) *Service {
@@ -24,7 +27,8 @@ func NewService(cfg *config.Config) *Service {
 	}
 	return &Service{
 		cookies: store,
-		clients: make(map[domain.Client]bool),
+		clients: make(map[int]map[*domain.Client]struct{}),
+		users:   make(map[int]map[*domain.Client]struct{}),
 	}
 }
 
@@ -32,20 +36,101 @@ func (s *Service) GetSession(r *http.Request, name string) (*sessions.Session, e
 	return s.cookies.Get(r, name)
 }
 
-func (s *Service) GetClientsByChatID(chatID int) []domain.Client {
-	res := make([]domain.Client, 0)
-	for client, present := range s.clients {
-		if client.ChatID == chatID && present {
-			res = append(res, client)
-		}
+func (s *Service) GetClientsByChatID(chatID int) []*domain.Client {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	res := make([]*domain.Client, 0, len(s.clients[chatID]))
+	for client := range s.clients[chatID] {
+		res = append(res, client)
+	}
+	return res
+}
+
+// GetClientsByUserID returns connections of the user to any chat
+func (s *Service) GetClientsByUserID(userID int) []*domain.Client {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	res := make([]*domain.Client, 0, len(s.users[userID]))
+	for client := range s.users[userID] {
+		res = append(res, client)
 	}
 	return res
 }
 
-func (s *Service) DeleteClient(client domain.Client) {
-	delete(s.clients, client)
+func (s *Service) DeleteClient(client *domain.Client) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	removeFromIndex(s.clients, client.ChatID, client)
+	removeFromIndex(s.users, client.UserID, client)
 }
 
-func (s *Service) AddClient(client domain.Client) {
-	s.clients[client] = true
+func (s *Service) AddClient(client *domain.Client) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	addToIndex(s.clients, client.ChatID, client)
+	addToIndex(s.users, client.UserID, client)
+}
+
+// DisconnectUser closes all connections of the user to the chat. Their
+// handlers notice the closed connection and stop reading from it.
+func (s *Service) DisconnectUser(chatID int, userID int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for client := range s.users[userID] {
+		if client.ChatID == chatID {
+			client.Close()
+			removeFromIndex(s.clients, chatID, client)
+			removeFromIndex(s.users, userID, client)
+		}
+	}
+}
+
+// DisconnectUserClients closes all connections of the user to any chat
+func (s *Service) DisconnectUserClients(userID int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for client := range s.users[userID] {
+		client.Close()
+		removeFromIndex(s.clients, client.ChatID, client)
+		removeFromIndex(s.users, userID, client)
+	}
+}
+
+// DisconnectOtherUserClients closes the connections of the user opened by
+// sessions other than sessionID
+func (s *Service) DisconnectOtherUserClients(userID int, sessionID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for client := range s.users[userID] {
+		if sessionID != "" && client.SessionID == sessionID {
+			continue
+		}
+		client.Close()
+		removeFromIndex(s.clients, client.ChatID, client)
+		removeFromIndex(s.users, userID, client)
+	}
+}
+
+func addToIndex(index map[int]map[*domain.Client]struct{}, key int, client *domain.Client) {
+	clients, ok := index[key]
+	if !ok {
+		clients = make(map[*domain.Client]struct{})
+		index[key] = clients
+	}
+	clients[client] = struct{}{}
+}
+
+func removeFromIndex(index map[int]map[*domain.Client]struct{}, key int, client *domain.Client) {
+	clients := index[key]
+	delete(clients, client)
+	if len(clients) == 0 {
+		delete(index, key)
+	}
 }
@@ -0,0 +1,63 @@
+package mention
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// All is the mention addressing every member of the chat
+const All = "all"
+
+// MaxMentions caps the number of distinct usernames taken from one message
+const MaxMentions = 50
+
+// Parse returns the distinct usernames mentioned as "@username" in the
+// content, in order of appearance and lowercased, and whether it mentions
+// "@all". An "@" preceded by a letter or digit, like in an e-mail address,
+// doesn't start a mention.
+func Parse(content string) (usernames []string, all bool) {
+	seen := make(map[string]bool)
+	prev := ' '
+	for i := 0; i < len(content); {
+		r, size := utf8.DecodeRuneInString(content[i:])
+		if r != '@' || isNameRune(prev) {
+			prev = r
+			i += size
+			continue
+		}
+
+		start := i + size
+		end := start
+		for end < len(content) {
+			nr, nsize := utf8.DecodeRuneInString(content[end:])
+			if !isNameRune(nr) && nr != '.' && nr != '-' {
+				break
+			}
+			end += nsize
+		}
+
+		// Точка или дефис в конце - это знак препинания, а не часть имени
+		name := strings.TrimRight(content[start:end], ".-")
+		if name != "" {
+			name = strings.ToLower(name)
+			if name == All {
+				all = true
+			} else if !seen[name] && len(usernames) < MaxMentions {
+				seen[name] = true
+				usernames = append(usernames, name)
+			}
+		}
+
+		prev = '@'
+		if end > start {
+			prev, _ = utf8.DecodeLastRuneInString(content[start:end])
+		}
+		i = end
+	}
+	return usernames, all
+}
+
+func isNameRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_'
+}
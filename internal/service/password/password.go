@@ -0,0 +1,68 @@
+package password
+
+import (
+	"bufio"
+	"chat/internal/config"
+	_ "embed"
+	"errors"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	DefaultMinLength = 8
+	MaxLength        = 72 // bcrypt uses only the first 72 bytes
+)
+
+var (
+	ErrTooShort = errors.New("password is too short")
+	ErrTooLong  = errors.New("password is too long")
+	ErrBreached = errors.New("password is found in a list of breached passwords")
+)
+
+//go:embed breached.txt
+var breachedList string
+
+// Policy decides which passwords users may set
+type Policy struct {
+	MinLength int
+	breached  map[string]struct{} // nil if the check is disabled
+}
+
+// NewPolicy builds the policy from the config, loading the bundled list of
+// breached passwords if the check is enabled
+func NewPolicy(cfg *config.Config) *Policy {
+	policy := &Policy{MinLength: cfg.Password.MinLength}
+	if policy.MinLength <= 0 {
+		policy.MinLength = DefaultMinLength
+	}
+
+	if cfg.Password.CheckBreached {
+		policy.breached = make(map[string]struct{})
+		scanner := bufio.NewScanner(strings.NewReader(breachedList))
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			policy.breached[strings.ToLower(line)] = struct{}{}
+		}
+	}
+	return policy
+}
+
+// Validate returns ErrTooShort, ErrTooLong or ErrBreached if the password
+// doesn't meet the policy. Length is counted in characters, the upper limit
+// in bytes.
+func (p *Policy) Validate(password string) error {
+	if utf8.RuneCountInString(password) < p.MinLength {
+		return ErrTooShort
+	}
+	if len(password) > MaxLength {
+		return ErrTooLong
+	}
+	if _, ok := p.breached[strings.ToLower(password)]; ok {
+		return ErrBreached
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package search
+
+import (
+	"chat/internal/config"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	MinTokenLength   = 2
+	MaxTokenLength   = 64
+	MaxMessageTokens = 256
+	MaxQueryTokens   = 10
+)
+
+// Service turns message text into blind index tokens: keyed hashes of the
+// words, so the index can be matched against without storing plaintext.
+type Service struct {
+	key []byte
+}
+
+// NewService returns nil when search is not enabled in the config. Without
+// an explicit index key one is derived from the encryption key.
+func NewService(cfg *config.Config) *Service {
+	if !cfg.Search.Enabled {
+		return nil
+	}
+
+	key := []byte(cfg.Search.IndexKey)
+	if len(key) == 0 {
+		mac := hmac.New(sha256.New, []byte(cfg.EncryptionKey))
+		mac.Write([]byte("search-index"))
+		key = mac.Sum(nil)
+	}
+	return &Service{key: key}
+}
+
+// IndexTokens returns the blind tokens of the words in the text
+func (s *Service) IndexTokens(text string) []string {
+	return s.blind(Tokenize(text, MaxMessageTokens))
+}
+
+// QueryTokens returns the blind tokens a message must contain to match the
+// query
+func (s *Service) QueryTokens(query string) []string {
+	return s.blind(Tokenize(query, MaxQueryTokens))
+}
+
+func (s *Service) blind(words []string) []string {
+	tokens := make([]string, len(words))
+	for i, word := range words {
+		mac := hmac.New(sha256.New, s.key)
+		mac.Write([]byte(word))
+		tokens[i] = hex.EncodeToString(mac.Sum(nil)[:16])
+	}
+	return tokens
+}
+
+// Tokenize splits the text into distinct lowercased words of letters and
+// digits, keeping at most limit of them. Words shorter than MinTokenLength or
+// longer than MaxTokenLength are skipped.
+func Tokenize(text string, limit int) []string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	seen := make(map[string]bool)
+	var tokens []string
+	for _, word := range words {
+		if len(tokens) >= limit {
+			break
+		}
+		length := utf8.RuneCountInString(word)
+		if length < MinTokenLength || length > MaxTokenLength || seen[word] {
+			continue
+		}
+		seen[word] = true
+		tokens = append(tokens, word)
+	}
+	return tokens
+}
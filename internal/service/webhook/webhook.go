@@ -0,0 +1,193 @@
+package webhook
+
+import (
+	"bytes"
+	"chat/internal/config"
+	"chat/internal/domain"
+	"chat/internal/utils"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	SignatureHeader = "X-Webhook-Signature"
+	TimestampHeader = "X-Webhook-Timestamp"
+	EventHeader     = "X-Webhook-Event"
+	DeliveryHeader  = "X-Webhook-Delivery"
+
+	defaultPollInterval = 2 * time.Second
+	defaultTimeout      = 10 * time.Second
+	defaultMaxAttempts  = 8
+	defaultRetryBackoff = 30 * time.Second
+	maxRetryBackoff     = 6 * time.Hour
+	batchSize           = 20
+)
+
+type Storage interface {
+	ClaimWebhookDeliveries(limit int, lease time.Duration) ([]domain.WebhookDelivery, error)
+	MarkWebhookDeliveryDelivered(deliveryID int, responseStatus int) error
+	MarkWebhookDeliveryFailed(deliveryID int, responseStatus int, lastError string, nextAttempt time.Time, final bool) error
+}
+
+type Cipher interface {
+	Decrypt(cipherText string) (string, error)
+}
+
+type Service struct {
+	storage      Storage
+	cipher       Cipher
+	client       *http.Client
+	pollInterval time.Duration
+	timeout      time.Duration
+	maxAttempts  int
+	retryBackoff time.Duration
+}
+
+func NewService(cfg *config.Config, storage Storage, cipher Cipher) *Service {
+	s := &Service{
+		storage:      storage,
+		cipher:       cipher,
+		pollInterval: cfg.Webhooks.PollInterval,
+		timeout:      cfg.Webhooks.Timeout,
+		maxAttempts:  cfg.Webhooks.MaxAttempts,
+		retryBackoff: cfg.Webhooks.RetryBackoff,
+	}
+	if s.pollInterval <= 0 {
+		s.pollInterval = defaultPollInterval
+	}
+	if s.timeout <= 0 {
+		s.timeout = defaultTimeout
+	}
+	if s.maxAttempts <= 0 {
+		s.maxAttempts = defaultMaxAttempts
+	}
+	if s.retryBackoff <= 0 {
+		s.retryBackoff = defaultRetryBackoff
+	}
+	// Webhook URLs come from chat owners, so they are only reached in the
+	// public network
+	transport := utils.PublicTransport(cfg.Webhooks.AllowPrivateEndpoints)
+	s.client = &http.Client{Transport: transport, Timeout: s.timeout}
+	return s
+}
+
+// Sign returns the value of the signature header for a request body sent at
+// the given unix timestamp.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Run delivers queued events until ctx is cancelled.
+func (s *Service) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		s.deliverDue(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Service) deliverDue(ctx context.Context) {
+	for {
+		// The lease must outlive a whole batch of timed out requests
+		deliveries, err := s.storage.ClaimWebhookDeliveries(batchSize, s.timeout*(batchSize+1))
+		if err != nil {
+			log.Printf("webhook.deliverDue: storage.ClaimWebhookDeliveries: %v", err)
+			return
+		}
+		for _, delivery := range deliveries {
+			if ctx.Err() != nil {
+				return
+			}
+			s.deliver(ctx, delivery)
+		}
+		if len(deliveries) < batchSize {
+			return
+		}
+	}
+}
+
+func (s *Service) deliver(ctx context.Context, delivery domain.WebhookDelivery) {
+	body, err := s.cipher.Decrypt(delivery.Payload)
+	if err != nil {
+		log.Printf("webhook.deliver: cipher.Decrypt: %v", err)
+		s.markFailed(delivery, 0, err, true)
+		return
+	}
+
+	status, err := s.Send(ctx, delivery, []byte(body))
+	if err != nil {
+		log.Printf("webhook.deliver: delivery %d to webhook %d failed (attempt %d): %v",
+			delivery.ID, delivery.WebhookID, delivery.Attempts, err)
+		s.markFailed(delivery, status, err, delivery.Attempts >= s.maxAttempts)
+		return
+	}
+
+	err = s.storage.MarkWebhookDeliveryDelivered(delivery.ID, status)
+	if err != nil {
+		log.Printf("webhook.deliver: storage.MarkWebhookDeliveryDelivered: %v", err)
+	}
+}
+
+func (s *Service) markFailed(delivery domain.WebhookDelivery, status int, deliveryErr error, final bool) {
+	err := s.storage.MarkWebhookDeliveryFailed(
+		delivery.ID, status, deliveryErr.Error(), time.Now().Add(s.backoff(delivery.Attempts)), final,
+	)
+	if err != nil {
+		log.Printf("webhook.markFailed: storage.MarkWebhookDeliveryFailed: %v", err)
+	}
+}
+
+// backoff doubles the retry delay with every failed attempt.
+func (s *Service) backoff(attempts int) time.Duration {
+	delay := s.retryBackoff
+	for i := 1; i < attempts && delay < maxRetryBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, maxRetryBackoff)
+}
+
+// Send POSTs a signed event body to the webhook target and returns the
+// response status. Any non-2xx response is reported as an error.
+func (s *Service) Send(ctx context.Context, delivery domain.WebhookDelivery, body []byte) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.Webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, delivery.Event)
+	req.Header.Set(DeliveryHeader, strconv.Itoa(delivery.ID))
+	req.Header.Set(TimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(SignatureHeader, Sign(delivery.Webhook.Secret, timestamp, body))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected response status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
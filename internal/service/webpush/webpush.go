@@ -0,0 +1,339 @@
+package webpush
+
+import (
+	"bytes"
+	"chat/internal/config"
+	"chat/internal/domain"
+	"chat/internal/utils"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	// MaxPayloadSize keeps the encrypted body within the 4096 bytes push
+	// services accept: a single record with its header, padding delimiter
+	// and authentication tag
+	MaxPayloadSize = 4096 - headerSize - 1 - 16
+
+	headerSize     = 16 + 4 + 1 + 65
+	recordSize     = 4096
+	defaultTTL     = 24 * time.Hour
+	defaultTimeout = 10 * time.Second
+	jwtLifetime    = 12 * time.Hour
+)
+
+type Storage interface {
+	GetPushSubscriptionsByUserID(userID int) ([]domain.PushSubscription, error)
+	DeletePushSubscriptionByEndpoint(endpoint string) error
+}
+
+// Service sends Web Push messages encrypted for the subscribed browser
+// (RFC 8291) and signed with the server VAPID key (RFC 8292)
+type Service struct {
+	storage    Storage
+	client     *http.Client
+	privateKey *ecdsa.PrivateKey
+	publicKey  string // base64url encoded uncompressed point
+	subject    string
+	ttl        time.Duration
+	// allowPrivate lets subscriptions point to the server network
+	allowPrivate bool
+}
+
+// NewService returns nil when no VAPID key is configured
+func NewService(cfg *config.Config, storage Storage) (*Service, error) {
+	if cfg.WebPush.VAPIDPrivateKey == "" {
+		return nil, nil
+	}
+
+	privateKey, err := parsePrivateKey(cfg.WebPush.VAPIDPrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid VAPID private key: %w", err)
+	}
+	publicKey := base64.RawURLEncoding.EncodeToString(
+		elliptic.Marshal(elliptic.P256(), privateKey.X, privateKey.Y), //nolint:staticcheck // uncompressed point
+	)
+	if cfg.WebPush.VAPIDPublicKey != "" && strings.TrimRight(cfg.WebPush.VAPIDPublicKey, "=") != publicKey {
+		return nil, errors.New("VAPID public key doesn't match the private key")
+	}
+
+	s := &Service{
+		storage:      storage,
+		privateKey:   privateKey,
+		publicKey:    publicKey,
+		subject:      cfg.WebPush.Subject,
+		ttl:          cfg.WebPush.TTL,
+		allowPrivate: cfg.WebPush.AllowPrivateEndpoints,
+	}
+	if s.ttl <= 0 {
+		s.ttl = defaultTTL
+	}
+
+	// Endpoints come from browsers, so they are only reached in the public
+	// network
+	s.client = &http.Client{Transport: utils.PublicTransport(s.allowPrivate), Timeout: cfg.WebPush.Timeout}
+	if s.client.Timeout <= 0 {
+		s.client.Timeout = defaultTimeout
+	}
+	return s, nil
+}
+
+// GenerateVAPIDKeys returns a new base64url encoded key pair
+func GenerateVAPIDKeys() (publicKey string, privateKey string, err error) {
+	key, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		return "", "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(key.PublicKey().Bytes()),
+		base64.RawURLEncoding.EncodeToString(key.Bytes()), nil
+}
+
+func parsePrivateKey(encoded string) (*ecdsa.PrivateKey, error) {
+	raw, err := decodeKey(encoded)
+	if err != nil {
+		return nil, err
+	}
+	key, err := ecdh.P256().NewPrivateKey(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	point := key.PublicKey().Bytes()
+	return &ecdsa.PrivateKey{
+		PublicKey: ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(point[1:33]),
+			Y:     new(big.Int).SetBytes(point[33:]),
+		},
+		D: new(big.Int).SetBytes(raw),
+	}, nil
+}
+
+// decodeKey accepts base64url with or without padding, as browsers and key
+// generators differ
+func decodeKey(encoded string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(encoded, "="))
+}
+
+// PublicKey returns the VAPID public key browsers subscribe with
+func (s *Service) PublicKey() string {
+	return s.publicKey
+}
+
+// ValidateSubscription checks that the subscription has a push service URL
+// and keys a payload can be encrypted with. Endpoints naming a host of the
+// server network are rejected right away; names resolving to one are
+// rejected when sending.
+func (s *Service) ValidateSubscription(sub domain.PushSubscription) error {
+	endpoint, err := url.Parse(sub.Endpoint)
+	if err != nil || endpoint.Scheme != "https" || endpoint.Host == "" {
+		return errors.New("endpoint must be an https URL")
+	}
+	if !s.allowPrivate {
+		if utils.PrivateHost(endpoint.Hostname()) {
+			return errors.New("endpoint must be a public push service")
+		}
+	}
+	if _, err := subscriptionKeys(sub); err != nil {
+		return err
+	}
+	return nil
+}
+
+type keys struct {
+	public *ecdh.PublicKey
+	auth   []byte
+}
+
+func subscriptionKeys(sub domain.PushSubscription) (keys, error) {
+	raw, err := decodeKey(sub.P256dh)
+	if err != nil {
+		return keys{}, fmt.Errorf("invalid p256dh key: %w", err)
+	}
+	public, err := ecdh.P256().NewPublicKey(raw)
+	if err != nil {
+		return keys{}, fmt.Errorf("invalid p256dh key: %w", err)
+	}
+	auth, err := decodeKey(sub.Auth)
+	if err != nil || len(auth) != 16 {
+		return keys{}, errors.New("invalid auth secret")
+	}
+	return keys{public: public, auth: auth}, nil
+}
+
+// Notify sends the payload to all subscriptions of the user. Subscriptions
+// the push service reports as gone are deleted.
+func (s *Service) Notify(ctx context.Context, userID int, payload []byte) {
+	subs, err := s.storage.GetPushSubscriptionsByUserID(userID)
+	if err != nil {
+		log.Printf("webpush.Notify: storage.GetPushSubscriptionsByUserID: %v", err)
+		return
+	}
+
+	for _, sub := range subs {
+		status, err := s.Send(ctx, sub, payload)
+		if status == http.StatusNotFound || status == http.StatusGone {
+			if err := s.storage.DeletePushSubscriptionByEndpoint(sub.Endpoint); err != nil {
+				log.Printf("webpush.Notify: storage.DeletePushSubscriptionByEndpoint: %v", err)
+			}
+			continue
+		}
+		if err != nil {
+			log.Printf("webpush.Notify: subscription %d of user %d: %v", sub.ID, userID, err)
+		}
+	}
+}
+
+// Send encrypts the payload for the subscription and POSTs it to the push
+// service, returning the response status. Any non-2xx response is reported
+// as an error.
+func (s *Service) Send(ctx context.Context, sub domain.PushSubscription, payload []byte) (int, error) {
+	body, err := Encrypt(sub, payload)
+	if err != nil {
+		return 0, err
+	}
+
+	endpoint, err := url.Parse(sub.Endpoint)
+	if err != nil {
+		return 0, err
+	}
+	token, err := s.vapidToken(endpoint.Scheme + "://" + endpoint.Host)
+	if err != nil {
+		return 0, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sub.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("Content-Encoding", "aes128gcm")
+	req.Header.Set("TTL", strconv.Itoa(int(s.ttl.Seconds())))
+	req.Header.Set("Authorization", "vapid t="+token+", k="+s.publicKey)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected response status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// vapidToken returns a JWT signed with ES256 that identifies the server to
+// the push service at audience
+func (s *Service) vapidToken(audience string) (string, error) {
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"typ":"JWT","alg":"ES256"}`))
+	claims, err := json.Marshal(map[string]interface{}{
+		"aud": audience,
+		"exp": time.Now().Add(jwtLifetime).Unix(),
+		"sub": s.subject,
+	})
+	if err != nil {
+		return "", err
+	}
+	unsigned := header + "." + base64.RawURLEncoding.EncodeToString(claims)
+
+	hash := sha256.Sum256([]byte(unsigned))
+	r, sig, err := ecdsa.Sign(rand.Reader, s.privateKey, hash[:])
+	if err != nil {
+		return "", err
+	}
+	signature := make([]byte, 64)
+	r.FillBytes(signature[:32])
+	sig.FillBytes(signature[32:])
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// Encrypt returns the aes128gcm encoded body carrying the payload to the
+// browser of the subscription
+func Encrypt(sub domain.PushSubscription, payload []byte) ([]byte, error) {
+	if len(payload) > MaxPayloadSize {
+		return nil, fmt.Errorf("payload of %d bytes exceeds %d", len(payload), MaxPayloadSize)
+	}
+	keys, err := subscriptionKeys(sub)
+	if err != nil {
+		return nil, err
+	}
+
+	// A fresh key pair of the server for every message
+	local, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := local.ECDH(keys.public)
+	if err != nil {
+		return nil, err
+	}
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+
+	keyInfo := append([]byte("WebPush: info\x00"), keys.public.Bytes()...)
+	keyInfo = append(keyInfo, local.PublicKey().Bytes()...)
+	ikm, err := expand(secret, keys.auth, keyInfo, 32)
+	if err != nil {
+		return nil, err
+	}
+	cek, err := expand(ikm, salt, []byte("Content-Encoding: aes128gcm\x00"), 16)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := expand(ikm, salt, []byte("Content-Encoding: nonce\x00"), 12)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	body := make([]byte, headerSize, headerSize+len(payload)+1+gcm.Overhead())
+	copy(body, salt)
+	binary.BigEndian.PutUint32(body[16:20], recordSize)
+	body[20] = 65
+	copy(body[21:], local.PublicKey().Bytes())
+
+	// The only record ends with the last record delimiter and no padding
+	plaintext := append(append([]byte(nil), payload...), 2)
+	return gcm.Seal(body, nonce, plaintext, nil), nil
+}
+
+func expand(secret []byte, salt []byte, info []byte, length int) ([]byte, error) {
+	out := make([]byte, length)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, secret, salt, info), out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
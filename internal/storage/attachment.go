@@ -0,0 +1,35 @@
+package storage
+
+import "chat/internal/domain"
+
+func (s *Storage) InsertAttachment(file domain.File, uploaderID int) (int, error) {
+	var attachmentID int
+	err := s.db.QueryRow(
+		"INSERT INTO attachments (name, data, uploader_id) VALUES ($1, $2, $3) RETURNING id",
+		file.Name, file.Data, uploaderID,
+	).Scan(&attachmentID)
+	if err != nil {
+		return 0, err
+	}
+	return attachmentID, nil
+}
+
+func (s *Storage) GetAttachmentByID(attachmentID int) (domain.Attachment, error) {
+	var attachment domain.Attachment
+	err := s.db.QueryRow(
+		"SELECT id, name, data, COALESCE(uploader_id, 0), created_at FROM attachments WHERE id = $1",
+		attachmentID,
+	).Scan(&attachment.ID, &attachment.File.Name, &attachment.File.Data, &attachment.UploaderID, &attachment.CreatedAt)
+	if err != nil {
+		return domain.Attachment{}, err
+	}
+	return attachment, nil
+}
+
+func (s *Storage) DeleteAttachment(attachmentID int) error {
+	_, err := s.db.Exec("DELETE FROM attachments WHERE id = $1", attachmentID)
+	if err != nil {
+		return err
+	}
+	return nil
+}
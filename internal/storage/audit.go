@@ -0,0 +1,120 @@
+package storage
+
+import (
+	"chat/internal/domain"
+	"database/sql"
+	"time"
+)
+
+// auditChainLock is the advisory lock key serializing appends to the audit
+// log, so that every event is chained to the one stored right before it
+const auditChainLock = 0x61756469
+
+const auditEventColumns = `id, created_at, COALESCE(actor_id, 0), actor_username, action, COALESCE(chat_id, 0),
+	target, details, ip, prev_hash, hash`
+
+// InsertAuditEvent appends the event to the audit log, chaining it to the
+// last stored event. It returns the event as stored.
+func (s *Storage) InsertAuditEvent(event domain.AuditEvent) (domain.AuditEvent, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return event, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("SELECT pg_advisory_xact_lock($1)", auditChainLock); err != nil {
+		return event, err
+	}
+
+	err = tx.QueryRow("SELECT hash FROM audit_events ORDER BY id DESC LIMIT 1").Scan(&event.PrevHash)
+	if err != nil && err != sql.ErrNoRows {
+		return event, err
+	}
+
+	event.CreatedAt = time.Now().UTC().Truncate(time.Microsecond)
+	if len(event.Details) == 0 {
+		event.Details = []byte("{}")
+	}
+	event.Hash = event.ComputeHash()
+
+	err = tx.QueryRow(
+		`INSERT INTO audit_events (created_at, actor_id, actor_username, action, chat_id, target, details, ip, prev_hash, hash)
+		 VALUES ($1, NULLIF($2, 0), $3, $4, NULLIF($5, 0), $6, $7, $8, $9, $10)
+		 RETURNING id`,
+		event.CreatedAt, event.ActorID, event.ActorUsername, event.Action, event.ChatID,
+		event.Target, string(event.Details), event.IP, event.PrevHash, event.Hash,
+	).Scan(&event.ID)
+	if err != nil {
+		return event, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return event, err
+	}
+	return event, nil
+}
+
+// GetAuditEvents returns the events matching the filter, newest first
+func (s *Storage) GetAuditEvents(filter domain.AuditFilter, limit int, offset int) ([]domain.AuditEvent, error) {
+	rows, err := s.db.Query(
+		`SELECT `+auditEventColumns+`
+		 FROM audit_events
+		 WHERE ($1 = '' OR actor_username = $1)
+		   AND ($2 = '' OR action = $2)
+		   AND ($3 = 0 OR chat_id = $3)
+		   AND ($4 = '' OR target = $4)
+		   AND ($5::timestamp IS NULL OR created_at >= $5)
+		   AND ($6::timestamp IS NULL OR created_at < $6)
+		 ORDER BY id DESC
+		 LIMIT $7 OFFSET $8`,
+		filter.ActorUsername, filter.Action, filter.ChatID, filter.Target, filter.From, filter.To,
+		limit, offset,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return scanAuditEvents(rows)
+}
+
+// GetAuditEventsAfter returns up to limit events following afterID in the
+// order they were appended
+func (s *Storage) GetAuditEventsAfter(afterID int64, limit int) ([]domain.AuditEvent, error) {
+	rows, err := s.db.Query(
+		"SELECT "+auditEventColumns+" FROM audit_events WHERE id > $1 ORDER BY id LIMIT $2",
+		afterID, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return scanAuditEvents(rows)
+}
+
+func scanAuditEvents(rows *sql.Rows) ([]domain.AuditEvent, error) {
+	defer rows.Close()
+
+	events := []domain.AuditEvent{}
+	for rows.Next() {
+		var (
+			event   domain.AuditEvent
+			details []byte
+		)
+		if err := rows.Scan(
+			&event.ID,
+			&event.CreatedAt,
+			&event.ActorID,
+			&event.ActorUsername,
+			&event.Action,
+			&event.ChatID,
+			&event.Target,
+			&details,
+			&event.IP,
+			&event.PrevHash,
+			&event.Hash,
+		); err != nil {
+			return nil, err
+		}
+		event.Details = details
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
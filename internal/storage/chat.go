@@ -2,12 +2,14 @@ package storage
 
 import (
 	"chat/internal/domain"
+	"database/sql"
+	"strings"
 	"time"
 )
 
 func (s *Storage) GetChatByID(chatID int) (*domain.Chat, error) {
 	rows, err := s.db.Query(
-		"SELECT id, name, is_private, creator_id, created_at FROM chats WHERE id = $1",
+		"SELECT id, name, type, is_public, topic, description, COALESCE(avatar_id, 0), message_ttl, retention_days, creator_id, created_at FROM chats WHERE id = $1",
 		chatID,
 	)
 	if err != nil {
@@ -15,12 +17,21 @@ func (s *Storage) GetChatByID(chatID int) (*domain.Chat, error) {
 	}
 	defer rows.Close()
 
-	var chat domain.Chat
+	var (
+		chat          domain.Chat
+		retentionDays sql.NullInt64
+	)
 	if rows.Next() {
 		if err := rows.Scan(
 			&chat.ID,
 			&chat.Name,
-			&chat.IsPrivate,
+			&chat.Type,
+			&chat.IsPublic,
+			&chat.Topic,
+			&chat.Description,
+			&chat.AvatarID,
+			&chat.MessageTTL,
+			&retentionDays,
 			&chat.CreatorID,
 			&chat.CreatedAt,
 		); err != nil {
@@ -29,12 +40,17 @@ func (s *Storage) GetChatByID(chatID int) (*domain.Chat, error) {
 	} else {
 		return nil, nil
 	}
+	if retentionDays.Valid {
+		days := int(retentionDays.Int64)
+		chat.RetentionDays = &days
+	}
 	return &chat, nil
 }
 
 func (s *Storage) GetMessagesByChatID(chatID int) ([]domain.Message, error) {
 	messageRows, err := s.db.Query(
-		"SELECT m.id, m.user_id, m.content, m.created_at, u.username, m.file_name, m.file_content FROM messages m JOIN users u ON m.user_id = u.id WHERE chat_id = $1 ORDER BY m.created_at",
+		"SELECT "+messageColumns+" FROM messages m "+messageJoins+
+			" WHERE m.chat_id = $1 AND (m.expires_at IS NULL OR m.expires_at > NOW()) ORDER BY m.created_at",
 		chatID,
 	)
 	if err != nil {
@@ -45,15 +61,7 @@ func (s *Storage) GetMessagesByChatID(chatID int) ([]domain.Message, error) {
 	var messages []domain.Message
 	for messageRows.Next() {
 		var message domain.Message
-		if err := messageRows.Scan(
-			&message.ID,
-			&message.UserID,
-			&message.Content,
-			&message.CreatedAt,
-			&message.Username,
-			&message.File.Name,
-			&message.File.Data,
-		); err != nil {
+		if err := scanMessage(messageRows, &message); err != nil {
 			return nil, err
 		}
 		messages = append(messages, message)
@@ -61,9 +69,37 @@ func (s *Storage) GetMessagesByChatID(chatID int) ([]domain.Message, error) {
 	return messages, nil
 }
 
-func (s *Storage) GetChatMembersByChatID(chatID int) ([]domain.User, error) {
+// GetChatMessagesAfter returns up to limit messages of the chat that follow
+// the message created at afterTime with afterID, oldest first. Paging by
+// this key lets callers walk the whole history in constant memory.
+func (s *Storage) GetChatMessagesAfter(chatID int, afterTime time.Time, afterID int, limit int) ([]domain.Message, error) {
+	rows, err := s.db.Query(
+		"SELECT "+messageColumns+" FROM messages m "+messageJoins+
+			` WHERE m.chat_id = $1 AND (m.expires_at IS NULL OR m.expires_at > NOW())
+			    AND (m.created_at, m.id) > ($2, $3)
+			  ORDER BY m.created_at, m.id
+			  LIMIT $4`,
+		chatID, afterTime, afterID, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []domain.Message
+	for rows.Next() {
+		var message domain.Message
+		if err := scanMessage(rows, &message); err != nil {
+			return nil, err
+		}
+		messages = append(messages, message)
+	}
+	return messages, rows.Err()
+}
+
+func (s *Storage) GetChatMembersByChatID(chatID int) ([]domain.ChatMember, error) {
 	membersRows, err := s.db.Query(
-		`SELECT u.id, u.username, u.surname, u.name, u.patronymic, u.status, u.last_active
+		`SELECT u.id, u.username, u.surname, u.name, u.patronymic, u.status, u.last_active, cu.role
 		 FROM chat_users cu
 		 JOIN users u ON cu.user_id = u.id
 		 WHERE cu.chat_id = $1`, chatID)
@@ -73,9 +109,9 @@ func (s *Storage) GetChatMembersByChatID(chatID int) ([]domain.User, error) {
 	}
 	defer membersRows.Close()
 
-	var members []domain.User
+	var members []domain.ChatMember
 	for membersRows.Next() {
-		var member domain.User
+		var member domain.ChatMember
 		if err := membersRows.Scan(
 			&member.ID,
 			&member.Username,
@@ -84,6 +120,7 @@ func (s *Storage) GetChatMembersByChatID(chatID int) ([]domain.User, error) {
 			&member.Patronymic,
 			&member.Status,
 			&member.LastActive,
+			&member.Role,
 		); err != nil {
 			return nil, err
 		}
@@ -96,15 +133,21 @@ func (s *Storage) GetChatsByUserID(userID int) ([]domain.UserChat, error) {
 	rows, err := s.db.Query(`
 	SELECT c.id, 
 	       CASE 
-	           WHEN c.is_private THEN 
+	           WHEN c.type = 'private' THEN 
 	               (SELECT surname || ' ' || name || ' ' || patronymic 
 	                FROM users 
 	                WHERE id != $1 AND id IN (SELECT user_id FROM chat_users WHERE chat_id = c.id))
 	           ELSE 
 	               c.name
 	       END AS name,
-	       c.is_private,
-		   cu.last_chat_visit
+	       c.type,
+	       c.is_public,
+	       c.topic,
+	       c.description,
+	       COALESCE(c.avatar_id, 0),
+	       c.message_ttl,
+		   cu.last_chat_visit,
+		   cu.muted
 	FROM chats c
 	JOIN chat_users cu ON c.id = cu.chat_id
 	WHERE cu.user_id = $1`, userID)
@@ -116,7 +159,18 @@ func (s *Storage) GetChatsByUserID(userID int) ([]domain.UserChat, error) {
 	var chats []domain.UserChat
 	for rows.Next() {
 		var chat domain.UserChat
-		if err := rows.Scan(&chat.ID, &chat.Name, &chat.IsPrivate, &chat.LastVisit); err != nil {
+		if err := rows.Scan(
+			&chat.ID,
+			&chat.Name,
+			&chat.Type,
+			&chat.IsPublic,
+			&chat.Topic,
+			&chat.Description,
+			&chat.AvatarID,
+			&chat.MessageTTL,
+			&chat.LastVisit,
+			&chat.Muted,
+		); err != nil {
 			return nil, err
 		}
 		chats = append(chats, chat)
@@ -127,7 +181,7 @@ func (s *Storage) GetChatsByUserID(userID int) ([]domain.UserChat, error) {
 
 func (s *Storage) CountUnreadMessages(chatID int, userID int, timepoint time.Time) (int, error) {
 	rows, err := s.db.Query(
-		"SELECT count(*) FROM messages WHERE chat_id=$1 AND user_id!=$2 AND created_at > $3",
+		"SELECT count(*) FROM messages WHERE chat_id=$1 AND user_id!=$2 AND created_at > $3 AND deleted_at IS NULL",
 		chatID, userID, timepoint,
 	)
 	if err != nil {
@@ -149,8 +203,8 @@ func (s *Storage) CountUnreadMessages(chatID int, userID int, timepoint time.Tim
 
 func (s *Storage) InsertChat(chat domain.Chat) (int, error) {
 	err := s.db.QueryRow(
-		"INSERT INTO chats (name, is_private, creator_id) VALUES ($1, $2, $3) RETURNING id",
-		chat.Name, chat.IsPrivate, chat.CreatorID,
+		"INSERT INTO chats (name, type, is_public, description, creator_id) VALUES ($1, $2, $3, $4, $5) RETURNING id",
+		chat.Name, chat.Type, chat.IsPublic, chat.Description, chat.CreatorID,
 	).Scan(&chat.ID)
 	if err != nil {
 		return 0, err
@@ -158,8 +212,159 @@ func (s *Storage) InsertChat(chat domain.Chat) (int, error) {
 	return chat.ID, nil
 }
 
-func (s *Storage) AddUserToChat(chatID int, userID int) error {
-	_, err := s.db.Exec("INSERT INTO chat_users (chat_id, user_id) VALUES ($1, $2)", chatID, userID)
+// SearchPublicChats returns public group chats and channels whose name, topic
+// or description contains query, the most populated first. A chat type limits
+// the results to chats of that type.
+func (s *Storage) SearchPublicChats(userID int, chatType domain.ChatType, query string, limit int, offset int) ([]domain.DirectoryChat, error) {
+	pattern := "%" + likeEscaper.Replace(query) + "%"
+	rows, err := s.db.Query(`
+	SELECT c.id, c.name, c.type, c.is_public, c.topic, c.description, COALESCE(c.avatar_id, 0), c.creator_id, c.created_at,
+	       (SELECT count(*) FROM chat_users WHERE chat_id = c.id) AS member_count,
+	       EXISTS (SELECT 1 FROM chat_users WHERE chat_id = c.id AND user_id = $1)
+	FROM chats c
+	WHERE c.is_public AND c.type <> 'private'
+	  AND ($2 = '' OR c.type = $2)
+	  AND (c.name ILIKE $3 OR c.topic ILIKE $3 OR c.description ILIKE $3)
+	ORDER BY member_count DESC, c.name, c.id
+	LIMIT $4 OFFSET $5`, userID, chatType, pattern, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var chats []domain.DirectoryChat
+	for rows.Next() {
+		var chat domain.DirectoryChat
+		if err := rows.Scan(
+			&chat.ID,
+			&chat.Name,
+			&chat.Type,
+			&chat.IsPublic,
+			&chat.Topic,
+			&chat.Description,
+			&chat.AvatarID,
+			&chat.CreatorID,
+			&chat.CreatedAt,
+			&chat.MemberCount,
+			&chat.IsMember,
+		); err != nil {
+			return nil, err
+		}
+		chats = append(chats, chat)
+	}
+	return chats, nil
+}
+
+// likeEscaper escapes LIKE wildcards so user input matches literally
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+func (s *Storage) AddUserToChat(chatID int, userID int, role domain.ChatRole) error {
+	_, err := s.db.Exec("INSERT INTO chat_users (chat_id, user_id, role) VALUES ($1, $2, $3)", chatID, userID, role)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// GetChatMemberRole returns sql.ErrNoRows if the user is not a member of the chat
+func (s *Storage) GetChatMemberRole(chatID int, userID int) (domain.ChatRole, error) {
+	var role domain.ChatRole
+	err := s.db.QueryRow(
+		"SELECT role FROM chat_users WHERE chat_id = $1 AND user_id = $2",
+		chatID, userID,
+	).Scan(&role)
+	if err != nil {
+		return "", err
+	}
+	return role, nil
+}
+
+func (s *Storage) SetChatMemberRole(chatID int, userID int, role domain.ChatRole) error {
+	res, err := s.db.Exec("UPDATE chat_users SET role = $1 WHERE chat_id = $2 AND user_id = $3", role, chatID, userID)
+	if err != nil {
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// TransferChatOwnership makes newOwnerID the owner of the chat and demotes the
+// previous owner to admin.
+func (s *Storage) TransferChatOwnership(chatID int, ownerID int, newOwnerID int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(
+		"UPDATE chat_users SET role = 'owner' WHERE chat_id = $1 AND user_id = $2",
+		chatID, newOwnerID,
+	)
+	if err != nil {
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+
+	_, err = tx.Exec(
+		"UPDATE chat_users SET role = 'admin' WHERE chat_id = $1 AND user_id = $2 AND role = 'owner'",
+		chatID, ownerID,
+	)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *Storage) IsChatMember(chatID int, userID int) (bool, error) {
+	var isMember bool
+	err := s.db.QueryRow(
+		"SELECT EXISTS (SELECT 1 FROM chat_users WHERE chat_id = $1 AND user_id = $2)",
+		chatID, userID,
+	).Scan(&isMember)
+	if err != nil {
+		return false, err
+	}
+	return isMember, nil
+}
+
+func (s *Storage) RemoveUserFromChat(chatID int, userID int) error {
+	_, err := s.db.Exec("DELETE FROM chat_users WHERE chat_id = $1 AND user_id = $2", chatID, userID)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// UpdateChat saves the editable settings of the chat
+func (s *Storage) UpdateChat(chat domain.Chat) error {
+	_, err := s.db.Exec(
+		"UPDATE chats SET name = $1, topic = $2, description = $3, avatar_id = NULLIF($4, 0), is_public = $5 WHERE id = $6",
+		chat.Name, chat.Topic, chat.Description, chat.AvatarID, chat.IsPublic, chat.ID,
+	)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// SetChatMessageTTL sets the lifetime of new messages in the chat, 0 turns
+// disappearing messages off
+func (s *Storage) SetChatMessageTTL(chatID int, ttl int) error {
+	_, err := s.db.Exec("UPDATE chats SET message_ttl = $1 WHERE id = $2", ttl, chatID)
 	if err != nil {
 		return err
 	}
@@ -181,7 +386,7 @@ func (s *Storage) GetChatIDByUserIDs(firstID int, secondID int) (int, error) {
 			SELECT c.id FROM chats c
 			JOIN chat_users cu1 ON c.id = cu1.chat_id
 			JOIN chat_users cu2 ON c.id = cu2.chat_id
-			WHERE cu1.user_id = $1 AND cu2.user_id = $2 AND c.is_private = true
+			WHERE cu1.user_id = $1 AND cu2.user_id = $2 AND c.type = 'private'
 		`,
 		firstID, secondID,
 	).Scan(&chatID)
@@ -0,0 +1,176 @@
+package storage
+
+import (
+	"chat/internal/domain"
+	"database/sql"
+
+	"github.com/lib/pq"
+)
+
+func (s *Storage) InsertEDiscoveryExport(export domain.EDiscoveryExport) (int, error) {
+	var id int
+	err := s.db.QueryRow(
+		`INSERT INTO ediscovery_exports (requested_by, user_ids, chat_ids, from_time, to_time)
+		 VALUES ($1, $2, $3, $4, $5)
+		 RETURNING id`,
+		export.RequestedBy, int64Array(export.UserIDs), int64Array(export.ChatIDs), export.From, export.To,
+	).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// FinishEDiscoveryExport records the outcome of an export: the checksum of
+// the archive on success or the error on failure
+func (s *Storage) FinishEDiscoveryExport(export domain.EDiscoveryExport) error {
+	_, err := s.db.Exec(
+		`UPDATE ediscovery_exports
+		 SET status = $1, message_count = $2, sha256 = $3, error = $4, completed_at = NOW()
+		 WHERE id = $5`,
+		export.Status, export.MessageCount, export.SHA256, export.Error, export.ID,
+	)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+const ediscoveryExportColumns = `
+	id, COALESCE(requested_by, 0), user_ids, chat_ids, from_time, to_time,
+	status, message_count, sha256, error, created_at, completed_at`
+
+func scanEDiscoveryExport(row rowScanner, export *domain.EDiscoveryExport) error {
+	var (
+		userIDs     pq.Int64Array
+		chatIDs     pq.Int64Array
+		from        sql.NullTime
+		to          sql.NullTime
+		completedAt sql.NullTime
+	)
+	err := row.Scan(
+		&export.ID,
+		&export.RequestedBy,
+		&userIDs,
+		&chatIDs,
+		&from,
+		&to,
+		&export.Status,
+		&export.MessageCount,
+		&export.SHA256,
+		&export.Error,
+		&export.CreatedAt,
+		&completedAt,
+	)
+	if err != nil {
+		return err
+	}
+
+	export.UserIDs = intSlice(userIDs)
+	export.ChatIDs = intSlice(chatIDs)
+	export.From, export.To, export.CompletedAt = nil, nil, nil
+	if from.Valid {
+		export.From = &from.Time
+	}
+	if to.Valid {
+		export.To = &to.Time
+	}
+	if completedAt.Valid {
+		export.CompletedAt = &completedAt.Time
+	}
+	return nil
+}
+
+func (s *Storage) GetEDiscoveryExports() ([]domain.EDiscoveryExport, error) {
+	rows, err := s.db.Query("SELECT " + ediscoveryExportColumns + " FROM ediscovery_exports ORDER BY id DESC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	exports := []domain.EDiscoveryExport{}
+	for rows.Next() {
+		var export domain.EDiscoveryExport
+		if err := scanEDiscoveryExport(rows, &export); err != nil {
+			return nil, err
+		}
+		exports = append(exports, export)
+	}
+	return exports, rows.Err()
+}
+
+func (s *Storage) GetEDiscoveryExport(exportID int) (domain.EDiscoveryExport, error) {
+	var export domain.EDiscoveryExport
+	row := s.db.QueryRow("SELECT "+ediscoveryExportColumns+" FROM ediscovery_exports WHERE id = $1", exportID)
+	err := scanEDiscoveryExport(row, &export)
+	return export, err
+}
+
+// ForEachEDiscoveryMessage calls fn for every message in the export scope,
+// archived and deleted ones included, in chronological order. A user in the
+// scope brings in their messages and the messages of others in their chats
+// while they were members. Rows are
+// streamed, so the scope may be larger than memory.
+func (s *Storage) ForEachEDiscoveryMessage(export domain.EDiscoveryExport, fn func(domain.Message, string) error) error {
+	rows, err := s.db.Query(
+		`SELECT `+messageColumns+`, c.name
+		 FROM (
+		     SELECT id, chat_id, user_id, content, created_at, attachment_id, is_system,
+		            forwarded_from_message_id, forwarded_from_chat_id, forwarded_from_user_id,
+		            expires_at, deleted_at, deleted_by
+		     FROM messages
+		     UNION ALL
+		     SELECT id, chat_id, user_id, content, created_at, attachment_id, is_system,
+		            forwarded_from_message_id, forwarded_from_chat_id, forwarded_from_user_id,
+		            NULL, deleted_at, deleted_by
+		     FROM messages_archive
+		 ) m
+		 `+messageJoins+`
+		 JOIN chats c ON m.chat_id = c.id
+		 WHERE (
+		     m.user_id = ANY($1) OR m.chat_id = ANY($2)
+		     OR EXISTS (
+		         SELECT 1 FROM chat_membership_periods p
+		         WHERE p.user_id = ANY($1) AND `+postedDuringMembership+`
+		     )
+		 )
+		   AND ($3::timestamp IS NULL OR m.created_at >= $3)
+		   AND ($4::timestamp IS NULL OR m.created_at < $4)
+		 ORDER BY m.created_at, m.id`,
+		int64Array(export.UserIDs), int64Array(export.ChatIDs), export.From, export.To,
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			message  domain.Message
+			chatName string
+		)
+		if err := scanMessage(chatNameRow{rows, &chatName}, &message); err != nil {
+			return err
+		}
+		if err := fn(message, chatName); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func int64Array(ids []int) pq.Int64Array {
+	array := make(pq.Int64Array, len(ids))
+	for i, id := range ids {
+		array[i] = int64(id)
+	}
+	return array
+}
+
+func intSlice(array pq.Int64Array) []int {
+	ids := make([]int, len(array))
+	for i, id := range array {
+		ids[i] = int(id)
+	}
+	return ids
+}
@@ -0,0 +1,158 @@
+package storage
+
+import (
+	"chat/internal/domain"
+	"database/sql"
+	"time"
+)
+
+// GetEmailSettings returns sql.ErrNoRows if the user hasn't set up email
+// notifications
+func (s *Storage) GetEmailSettings(userID int) (domain.EmailSettings, error) {
+	var (
+		settings   domain.EmailSettings
+		lastSentAt sql.NullTime
+	)
+	err := s.db.QueryRow(
+		`SELECT es.user_id, u.username, es.email, es.enabled, es.mentions_only, COALESCE(es.delay_minutes, 0),
+		        es.unsubscribe_token, es.last_sent_at
+		 FROM email_settings es
+		 JOIN users u ON u.id = es.user_id
+		 WHERE es.user_id = $1`,
+		userID,
+	).Scan(
+		&settings.UserID,
+		&settings.Username,
+		&settings.Email,
+		&settings.Enabled,
+		&settings.MentionsOnly,
+		&settings.DelayMinutes,
+		&settings.UnsubscribeToken,
+		&lastSentAt,
+	)
+	if err != nil {
+		return domain.EmailSettings{}, err
+	}
+	if lastSentAt.Valid {
+		settings.LastSentAt = &lastSentAt.Time
+	}
+	return settings, nil
+}
+
+// UpsertEmailSettings saves the preferences of the user. The unsubscribe
+// token is only set on the first save.
+func (s *Storage) UpsertEmailSettings(settings domain.EmailSettings) error {
+	_, err := s.db.Exec(
+		`INSERT INTO email_settings (user_id, email, enabled, mentions_only, delay_minutes, unsubscribe_token)
+		 VALUES ($1, $2, $3, $4, NULLIF($5, 0), $6)
+		 ON CONFLICT (user_id) DO UPDATE
+		 SET email = EXCLUDED.email,
+		     enabled = EXCLUDED.enabled,
+		     mentions_only = EXCLUDED.mentions_only,
+		     delay_minutes = EXCLUDED.delay_minutes`,
+		settings.UserID, settings.Email, settings.Enabled, settings.MentionsOnly, settings.DelayMinutes,
+		settings.UnsubscribeToken,
+	)
+	return err
+}
+
+// UnsubscribeTokenExists reports whether the unsubscribe token was issued
+func (s *Storage) UnsubscribeTokenExists(token string) (bool, error) {
+	var exists bool
+	err := s.db.QueryRow(
+		"SELECT EXISTS (SELECT 1 FROM email_settings WHERE unsubscribe_token = $1)", token,
+	).Scan(&exists)
+	if err != nil {
+		return false, err
+	}
+	return exists, nil
+}
+
+// UnsubscribeEmail turns off email notifications of the user the token was
+// issued to. It returns sql.ErrNoRows if the token is unknown.
+func (s *Storage) UnsubscribeEmail(token string) error {
+	res, err := s.db.Exec("UPDATE email_settings SET enabled = false WHERE unsubscribe_token = $1", token)
+	if err != nil {
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// GetEmailDigestRecipients returns up to limit users, except the skipped
+// ones, that have a message which stayed unread for their delay since their
+// last digest: a mention, or any message in a chat they haven't muted unless
+// they only want mentions. It also returns the database time they were
+// picked at, to be recorded as the time their digest was sent.
+func (s *Storage) GetEmailDigestRecipients(defaultDelay time.Duration, skip []int, limit int) ([]domain.EmailSettings, time.Time, error) {
+	rows, err := s.db.Query(
+		`SELECT es.user_id, u.username, es.email, es.enabled, es.mentions_only, COALESCE(es.delay_minutes, 0),
+		        es.unsubscribe_token, es.last_sent_at, NOW()
+		 FROM email_settings es
+		 JOIN users u ON u.id = es.user_id
+		 WHERE es.enabled AND u.deactivated_at IS NULL AND NOT es.user_id = ANY($2)
+		   AND EXISTS (
+		       SELECT 1
+		       FROM chat_users cu
+		       JOIN messages m ON m.chat_id = cu.chat_id
+		       WHERE cu.user_id = es.user_id
+		         AND m.user_id != es.user_id
+		         AND m.deleted_at IS NULL
+		         AND m.created_at > GREATEST(cu.last_chat_visit, es.last_sent_at)
+		         AND m.created_at <= NOW() - make_interval(secs => COALESCE(es.delay_minutes * 60, $1::float8))
+		         AND (
+		             (NOT cu.muted AND NOT es.mentions_only)
+		             OR EXISTS (SELECT 1 FROM message_mentions mm WHERE mm.message_id = m.id AND mm.user_id = es.user_id)
+		         )
+		   )
+		 ORDER BY es.last_sent_at NULLS FIRST, es.user_id
+		 LIMIT $3`,
+		defaultDelay.Seconds(), int64Array(skip), limit,
+	)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	defer rows.Close()
+
+	var (
+		recipients []domain.EmailSettings
+		now        time.Time
+	)
+	for rows.Next() {
+		var (
+			settings   domain.EmailSettings
+			lastSentAt sql.NullTime
+		)
+		if err := rows.Scan(
+			&settings.UserID,
+			&settings.Username,
+			&settings.Email,
+			&settings.Enabled,
+			&settings.MentionsOnly,
+			&settings.DelayMinutes,
+			&settings.UnsubscribeToken,
+			&lastSentAt,
+			&now,
+		); err != nil {
+			return nil, time.Time{}, err
+		}
+		if lastSentAt.Valid {
+			settings.LastSentAt = &lastSentAt.Time
+		}
+		recipients = append(recipients, settings)
+	}
+	return recipients, now, rows.Err()
+}
+
+// MarkEmailDigestSent records that the user was told about everything
+// before sentAt
+func (s *Storage) MarkEmailDigestSent(userID int, sentAt time.Time) error {
+	_, err := s.db.Exec("UPDATE email_settings SET last_sent_at = $2 WHERE user_id = $1", userID, sentAt)
+	return err
+}
@@ -0,0 +1,101 @@
+package storage
+
+import (
+	"chat/internal/domain"
+	"database/sql"
+)
+
+// ImportChat returns the id of the chat created by an earlier import with
+// the same key, creating it otherwise, and adds the members that are not in
+// it yet. The creator becomes the owner of a new chat.
+func (s *Storage) ImportChat(chat domain.Chat, importKey string, memberIDs []int) (int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// DO UPDATE вместо DO NOTHING, чтобы RETURNING вернул id уже
+	// существующего чата
+	err = tx.QueryRow(
+		`INSERT INTO chats (name, type, is_public, description, creator_id, created_at, import_key)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7)
+		 ON CONFLICT (import_key) DO UPDATE SET import_key = EXCLUDED.import_key
+		 RETURNING id`,
+		chat.Name, chat.Type, chat.IsPublic, chat.Description, chat.CreatorID, chat.CreatedAt, importKey,
+	).Scan(&chat.ID)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, userID := range memberIDs {
+		role := domain.ChatRoleMember
+		if userID == chat.CreatorID && chat.Type != domain.ChatTypePrivate {
+			role = domain.ChatRoleOwner
+		}
+		_, err = tx.Exec(
+			"INSERT INTO chat_users (chat_id, user_id, role) VALUES ($1, $2, $3) ON CONFLICT DO NOTHING",
+			chat.ID, userID, role,
+		)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return chat.ID, nil
+}
+
+// MessageImported reports whether a message with the import key exists
+func (s *Storage) MessageImported(importKey string) (bool, error) {
+	var exists bool
+	err := s.db.QueryRow("SELECT EXISTS (SELECT 1 FROM messages WHERE import_key = $1)", importKey).Scan(&exists)
+	if err != nil {
+		return false, err
+	}
+	return exists, nil
+}
+
+// ImportMessage stores a message with its original time and attachment
+// unless a message with the same import key exists. It returns the id of the
+// new message or 0 if it was imported before.
+func (s *Storage) ImportMessage(message domain.Message, importKey string) (int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if message.File.Data != "" {
+		err = tx.QueryRow(
+			"INSERT INTO attachments (name, data, uploader_id, created_at) VALUES ($1, $2, $3, $4) RETURNING id",
+			message.File.Name, message.File.Data, message.UserID, message.CreatedAt,
+		).Scan(&message.AttachmentID)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	// Если сообщение с тем же ключом уже есть, транзакция откатывается
+	// вместе с вложением
+	err = tx.QueryRow(
+		`INSERT INTO messages (chat_id, user_id, content, attachment_id, created_at, import_key)
+		 VALUES ($1, $2, $3, NULLIF($4, 0), $5, $6)
+		 ON CONFLICT (import_key) DO NOTHING
+		 RETURNING id`,
+		message.ChatID, message.UserID, message.Content, message.AttachmentID, message.CreatedAt, importKey,
+	).Scan(&message.ID)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return message.ID, nil
+}
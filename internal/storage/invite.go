@@ -0,0 +1,119 @@
+package storage
+
+import (
+	"chat/internal/domain"
+	"database/sql"
+)
+
+func (s *Storage) InsertChatInvite(invite domain.ChatInvite) (int, error) {
+	err := s.db.QueryRow(
+		`INSERT INTO chat_invites (chat_id, token, creator_id, max_uses, expires_at)
+		 VALUES ($1, $2, $3, NULLIF($4, 0), $5) RETURNING id`,
+		invite.ChatID, invite.Token, invite.CreatorID, invite.MaxUses, invite.ExpiresAt,
+	).Scan(&invite.ID)
+	if err != nil {
+		return 0, err
+	}
+	return invite.ID, nil
+}
+
+func (s *Storage) GetChatInvitesByChatID(chatID int) ([]domain.ChatInvite, error) {
+	rows, err := s.db.Query(
+		`SELECT id, chat_id, token, creator_id, COALESCE(max_uses, 0), uses, expires_at, revoked_at, created_at
+		 FROM chat_invites WHERE chat_id = $1 ORDER BY id DESC`,
+		chatID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var invites []domain.ChatInvite
+	for rows.Next() {
+		var invite domain.ChatInvite
+		if err := rows.Scan(
+			&invite.ID,
+			&invite.ChatID,
+			&invite.Token,
+			&invite.CreatorID,
+			&invite.MaxUses,
+			&invite.Uses,
+			&invite.ExpiresAt,
+			&invite.RevokedAt,
+			&invite.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		invites = append(invites, invite)
+	}
+	return invites, nil
+}
+
+func (s *Storage) RevokeChatInvite(chatID int, inviteID int) error {
+	res, err := s.db.Exec(
+		"UPDATE chat_invites SET revoked_at = NOW() WHERE id = $1 AND chat_id = $2 AND revoked_at IS NULL",
+		inviteID, chatID,
+	)
+	if err != nil {
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// RedeemChatInvite adds the user to the chat of a valid invite and counts the
+// use. It returns sql.ErrNoRows if the invite is unknown, revoked, expired or
+// used up, and joined is false if the user was already a member, in which
+// case the use is not counted.
+func (s *Storage) RedeemChatInvite(token string, userID int) (int, bool, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, false, err
+	}
+	defer tx.Rollback()
+
+	var inviteID, chatID int
+	err = tx.QueryRow(
+		`SELECT id, chat_id FROM chat_invites
+		 WHERE token = $1
+		   AND revoked_at IS NULL
+		   AND (expires_at IS NULL OR expires_at > NOW())
+		   AND (max_uses IS NULL OR uses < max_uses)
+		 FOR UPDATE`,
+		token,
+	).Scan(&inviteID, &chatID)
+	if err != nil {
+		return 0, false, err
+	}
+
+	res, err := tx.Exec(
+		"INSERT INTO chat_users (chat_id, user_id, role) VALUES ($1, $2, $3) ON CONFLICT DO NOTHING",
+		chatID, userID, domain.ChatRoleMember,
+	)
+	if err != nil {
+		return 0, false, err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return 0, false, err
+	}
+	if affected == 0 {
+		return chatID, false, nil
+	}
+
+	_, err = tx.Exec("UPDATE chat_invites SET uses = uses + 1 WHERE id = $1", inviteID)
+	if err != nil {
+		return 0, false, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, false, err
+	}
+	return chatID, true, nil
+}
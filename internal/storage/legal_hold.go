@@ -0,0 +1,99 @@
+package storage
+
+import (
+	"chat/internal/domain"
+	"database/sql"
+)
+
+// messageHeld matches messages m covered by an active legal hold on their
+// chat or on a user involved in them: the author, or a member of the chat
+// when the message was posted
+const messageHeld = `
+	EXISTS (
+		SELECT 1 FROM legal_holds h
+		WHERE h.released_at IS NULL AND (
+		    h.user_id = m.user_id OR h.chat_id = m.chat_id
+		    OR EXISTS (
+		        SELECT 1 FROM chat_membership_periods p
+		        WHERE p.user_id = h.user_id AND ` + postedDuringMembership + `
+		    )
+		)
+	)`
+
+// postedDuringMembership matches messages m posted to the chat of the
+// membership period p while it lasted
+const postedDuringMembership = `p.chat_id = m.chat_id AND m.created_at >= p.joined_at
+		          AND (p.left_at IS NULL OR m.created_at <= p.left_at)`
+
+func (s *Storage) InsertLegalHold(hold domain.LegalHold) (int, error) {
+	var id int
+	err := s.db.QueryRow(
+		`INSERT INTO legal_holds (user_id, chat_id, reason, created_by)
+		 VALUES (NULLIF($1, 0), NULLIF($2, 0), $3, $4)
+		 RETURNING id`,
+		hold.UserID, hold.ChatID, hold.Reason, hold.CreatedBy,
+	).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// GetLegalHolds returns all holds, active ones first
+func (s *Storage) GetLegalHolds() ([]domain.LegalHold, error) {
+	rows, err := s.db.Query(
+		`SELECT id, COALESCE(user_id, 0), COALESCE(chat_id, 0), reason, COALESCE(created_by, 0), created_at,
+		        COALESCE(released_by, 0), released_at
+		 FROM legal_holds
+		 ORDER BY released_at IS NOT NULL, created_at DESC`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	holds := []domain.LegalHold{}
+	for rows.Next() {
+		var (
+			hold       domain.LegalHold
+			releasedAt sql.NullTime
+		)
+		if err := rows.Scan(
+			&hold.ID,
+			&hold.UserID,
+			&hold.ChatID,
+			&hold.Reason,
+			&hold.CreatedBy,
+			&hold.CreatedAt,
+			&hold.ReleasedBy,
+			&releasedAt,
+		); err != nil {
+			return nil, err
+		}
+		if releasedAt.Valid {
+			hold.ReleasedAt = &releasedAt.Time
+		}
+		holds = append(holds, hold)
+	}
+	return holds, rows.Err()
+}
+
+// ReleaseLegalHold ends an active hold. Returns sql.ErrNoRows if there is no
+// such active hold.
+func (s *Storage) ReleaseLegalHold(holdID int, releasedBy int) error {
+	res, err := s.db.Exec(
+		"UPDATE legal_holds SET released_by = $1, released_at = NOW() WHERE id = $2 AND released_at IS NULL",
+		releasedBy, holdID,
+	)
+	if err != nil {
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package storage
+
+import (
+	"chat/internal/domain"
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// InsertMessageMentions records mentions of the chat members among usernames,
+// or of every member if all is set. The author is never mentioned. It
+// returns the IDs of mentioned users.
+func (s *Storage) InsertMessageMentions(chatID int, messageID int, authorID int, usernames []string, all bool) ([]int, error) {
+	rows, err := s.db.Query(
+		`INSERT INTO message_mentions (message_id, chat_id, user_id)
+		 SELECT $1, cu.chat_id, cu.user_id
+		 FROM chat_users cu
+		 JOIN users u ON cu.user_id = u.id
+		 WHERE cu.chat_id = $2 AND cu.user_id <> $3 AND ($4 OR LOWER(u.username) = ANY($5))
+		 ON CONFLICT DO NOTHING
+		 RETURNING user_id`,
+		messageID, chatID, authorID, all, pq.Array(usernames),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var userIDs []int
+	for rows.Next() {
+		var userID int
+		if err := rows.Scan(&userID); err != nil {
+			return nil, err
+		}
+		userIDs = append(userIDs, userID)
+	}
+	return userIDs, rows.Err()
+}
+
+// GetMentionsByUserID returns messages mentioning the user in chats they are
+// still a member of, the newest first
+func (s *Storage) GetMentionsByUserID(userID int, limit int, offset int) ([]domain.Mention, error) {
+	rows, err := s.db.Query(
+		`SELECT `+messageColumns+`, c.name
+		 FROM message_mentions mm
+		 JOIN messages m ON mm.message_id = m.id
+		 JOIN chats c ON m.chat_id = c.id
+		 JOIN chat_users cu ON cu.chat_id = mm.chat_id AND cu.user_id = mm.user_id`+messageJoins+`
+		 WHERE mm.user_id = $1
+		 ORDER BY mm.created_at DESC, mm.message_id DESC
+		 LIMIT $2 OFFSET $3`,
+		userID, limit, offset,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var mentions []domain.Mention
+	for rows.Next() {
+		var mention domain.Mention
+		if err := scanMessage(chatNameRow{rows, &mention.ChatName}, &mention.Message); err != nil {
+			return nil, err
+		}
+		mentions = append(mentions, mention)
+	}
+	return mentions, nil
+}
+
+func (s *Storage) CountUnreadMentions(chatID int, userID int, timepoint time.Time) (int, error) {
+	var count int
+	err := s.db.QueryRow(
+		"SELECT count(*) FROM message_mentions WHERE chat_id = $1 AND user_id = $2 AND created_at > $3",
+		chatID, userID, timepoint,
+	).Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// SetChatMuted returns sql.ErrNoRows if the user is not a member of the chat
+func (s *Storage) SetChatMuted(chatID int, userID int, muted bool) error {
+	res, err := s.db.Exec("UPDATE chat_users SET muted = $1 WHERE chat_id = $2 AND user_id = $3", muted, chatID, userID)
+	if err != nil {
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// IsChatMuted returns sql.ErrNoRows if the user is not a member of the chat
+func (s *Storage) IsChatMuted(chatID int, userID int) (bool, error) {
+	var muted bool
+	err := s.db.QueryRow("SELECT muted FROM chat_users WHERE chat_id = $1 AND user_id = $2", chatID, userID).Scan(&muted)
+	return muted, err
+}
@@ -1,21 +1,275 @@
 package storage
 
-import "chat/internal/domain"
+import (
+	"chat/internal/domain"
+	"database/sql"
+)
 
-func (s *Storage) DeleteMessage(messageID string) error {
-	_, err := s.db.Exec("DELETE FROM messages WHERE id = $1", messageID)
+// messageColumns is the select list read by scanMessage
+const messageColumns = `
+	m.id, m.chat_id, m.user_id, m.content, m.created_at, u.username,
+	COALESCE(a.name, ''), COALESCE(a.data, ''), COALESCE(m.attachment_id, 0), m.is_system,
+	m.forwarded_from_message_id, m.forwarded_from_chat_id, fc.name, m.forwarded_from_user_id, fu.username,
+	m.expires_at, m.deleted_at, COALESCE(m.deleted_by, 0), COALESCE(du.username, '')`
+
+// messageJoins are the joins messageColumns relies on
+const messageJoins = `
+	JOIN users u ON m.user_id = u.id
+	LEFT JOIN attachments a ON m.attachment_id = a.id
+	LEFT JOIN chats fc ON m.forwarded_from_chat_id = fc.id
+	LEFT JOIN users fu ON m.forwarded_from_user_id = fu.id
+	LEFT JOIN users du ON m.deleted_by = du.id`
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// chatNameRow scans the chat name following the message columns
+type chatNameRow struct {
+	rows     *sql.Rows
+	chatName *string
+}
+
+func (r chatNameRow) Scan(dest ...interface{}) error {
+	return r.rows.Scan(append(dest, r.chatName)...)
+}
+
+func scanMessage(row rowScanner, message *domain.Message) error {
+	var (
+		originMessageID sql.NullInt64
+		originChatID    sql.NullInt64
+		originChatName  sql.NullString
+		originUserID    sql.NullInt64
+		originUsername  sql.NullString
+		expiresAt       sql.NullTime
+		deletedAt       sql.NullTime
+	)
+	err := row.Scan(
+		&message.ID,
+		&message.ChatID,
+		&message.UserID,
+		&message.Content,
+		&message.CreatedAt,
+		&message.Username,
+		&message.File.Name,
+		&message.File.Data,
+		&message.AttachmentID,
+		&message.IsSystem,
+		&originMessageID,
+		&originChatID,
+		&originChatName,
+		&originUserID,
+		&originUsername,
+		&expiresAt,
+		&deletedAt,
+		&message.DeletedBy,
+		&message.DeletedByUsername,
+	)
 	if err != nil {
 		return err
 	}
+
+	message.ExpiresAt = nil
+	if expiresAt.Valid {
+		message.ExpiresAt = &expiresAt.Time
+	}
+
+	message.DeletedAt = nil
+	if deletedAt.Valid {
+		message.DeletedAt = &deletedAt.Time
+	}
+
+	message.ForwardedFrom = nil
+	if originChatID.Valid || originUserID.Valid {
+		message.ForwardedFrom = &domain.MessageOrigin{
+			MessageID: int(originMessageID.Int64),
+			ChatID:    int(originChatID.Int64),
+			ChatName:  originChatName.String,
+			UserID:    int(originUserID.Int64),
+			Username:  originUsername.String,
+		}
+	}
 	return nil
 }
 
+// DeleteMessage turns the message into a tombstone: its content, search
+// index, mentions and pins are removed and the attachment is deleted unless
+// it is shared with a forwarded copy. The row itself stays to record who
+// deleted it and when. Content and attachment of a message under legal hold
+// are kept for eDiscovery. Returns sql.ErrNoRows if there is no such message
+// or it is already deleted.
+func (s *Storage) DeleteMessage(messageID string, deletedBy int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var attachmentID, keptAttachmentID sql.NullInt64
+	err = tx.QueryRow(
+		`UPDATE messages m
+		 SET content = CASE WHEN `+messageHeld+` THEN m.content ELSE '' END,
+		     attachment_id = CASE WHEN `+messageHeld+` THEN m.attachment_id END,
+		     deleted_at = NOW(),
+		     deleted_by = $2
+		 FROM (SELECT id, attachment_id FROM messages WHERE id = $1 FOR UPDATE) old
+		 WHERE m.id = old.id AND m.deleted_at IS NULL
+		 RETURNING old.attachment_id, m.attachment_id`,
+		messageID, deletedBy,
+	).Scan(&attachmentID, &keptAttachmentID)
+	if err != nil {
+		return err
+	}
+
+	for _, query := range []string{
+		"DELETE FROM message_search_tokens WHERE message_id = $1",
+		"DELETE FROM message_mentions WHERE message_id = $1",
+		"DELETE FROM pinned_messages WHERE message_id = $1",
+	} {
+		if _, err := tx.Exec(query, messageID); err != nil {
+			return err
+		}
+	}
+
+	if attachmentID.Valid && !keptAttachmentID.Valid {
+		if err := deleteOrphanAttachment(tx, attachmentID.Int64); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetDeletedMessages returns the tombstones of the chat, most recently
+// deleted first
+func (s *Storage) GetDeletedMessages(chatID int, limit int, offset int) ([]domain.Message, error) {
+	rows, err := s.db.Query(
+		"SELECT "+messageColumns+" FROM messages m "+messageJoins+
+			" WHERE m.chat_id = $1 AND m.deleted_at IS NOT NULL ORDER BY m.deleted_at DESC, m.id DESC LIMIT $2 OFFSET $3",
+		chatID, limit, offset,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	messages := []domain.Message{}
+	for rows.Next() {
+		var message domain.Message
+		if err := scanMessage(rows, &message); err != nil {
+			return nil, err
+		}
+		messages = append(messages, message)
+	}
+	return messages, rows.Err()
+}
+
+// DeleteExpiredMessages deletes up to limit messages whose lifetime is over
+// together with their attachments and returns them with ID and ChatID set.
+// Messages under legal hold are kept. Locked rows are skipped, so several
+// servers may purge at the same time.
+func (s *Storage) DeleteExpiredMessages(limit int) ([]domain.Message, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(
+		`DELETE FROM messages
+		 WHERE id IN (
+		     SELECT id FROM messages m
+		     WHERE expires_at <= NOW() AND NOT `+messageHeld+`
+		     ORDER BY expires_at
+		     LIMIT $1
+		     FOR UPDATE SKIP LOCKED
+		 )
+		 RETURNING id, chat_id, attachment_id`,
+		limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		messages      []domain.Message
+		attachmentIDs []int64
+	)
+	for rows.Next() {
+		var (
+			message      domain.Message
+			attachmentID sql.NullInt64
+		)
+		if err := rows.Scan(&message.ID, &message.ChatID, &attachmentID); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		messages = append(messages, message)
+		if attachmentID.Valid {
+			attachmentIDs = append(attachmentIDs, attachmentID.Int64)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, attachmentID := range attachmentIDs {
+		if err := deleteOrphanAttachment(tx, attachmentID); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+// deleteOrphanAttachment deletes the attachment unless another message, an
+// archived message or a chat avatar still refers to it
+func deleteOrphanAttachment(tx *sql.Tx, attachmentID int64) error {
+	_, err := tx.Exec(
+		`DELETE FROM attachments
+		 WHERE id = $1
+		   AND NOT EXISTS (SELECT 1 FROM messages WHERE attachment_id = $1)
+		   AND NOT EXISTS (SELECT 1 FROM messages_archive WHERE attachment_id = $1)
+		   AND NOT EXISTS (SELECT 1 FROM chats WHERE avatar_id = $1)`,
+		attachmentID,
+	)
+	return err
+}
+
+// UpdateMessageContent replaces the content of the message. Returns
+// sql.ErrNoRows if there is no such message or it is deleted, and
+// domain.ErrMessageHeld if it is under legal hold, which keeps its content.
 func (s *Storage) UpdateMessageContent(messageID string, content string) error {
-	_, err := s.db.Exec("UPDATE messages SET content = $1 WHERE id = $2", content, messageID)
+	res, err := s.db.Exec(
+		"UPDATE messages m SET content = $1 WHERE m.id = $2 AND m.deleted_at IS NULL AND NOT "+messageHeld,
+		content, messageID,
+	)
 	if err != nil {
 		return err
 	}
-	return nil
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected > 0 {
+		return nil
+	}
+
+	var held bool
+	err = s.db.QueryRow(
+		"SELECT "+messageHeld+" FROM messages m WHERE m.id = $1 AND m.deleted_at IS NULL", messageID,
+	).Scan(&held)
+	if err != nil {
+		return err
+	}
+	if held {
+		return domain.ErrMessageHeld
+	}
+	return sql.ErrNoRows
 }
 
 func (s *Storage) GetUsernameByMessageID(messageID int) (string, error) {
@@ -30,20 +284,53 @@ func (s *Storage) GetUsernameByMessageID(messageID int) (string, error) {
 }
 
 func (s *Storage) GetMessageByID(messageID string, message *domain.Message) error {
-	err := s.db.QueryRow(
-		"SELECT id, chat_id, user_id, content, file_name, file_content FROM messages WHERE id = $1",
+	row := s.db.QueryRow(
+		"SELECT "+messageColumns+" FROM messages m "+messageJoins+
+			" WHERE m.id = $1 AND (m.expires_at IS NULL OR m.expires_at > NOW())",
 		messageID,
-	).Scan(&message.ID, &message.ChatID, &message.UserID, &message.Content, &message.File.Name, &message.File.Data)
-	return err
+	)
+	return scanMessage(row, message)
 }
 
+// InsertMessage stores the message. A new file is saved as an attachment,
+// while a message with AttachmentID set reuses the stored one.
 func (s *Storage) InsertMessage(message domain.Message) (int, error) {
-	err := s.db.QueryRow(
-		"INSERT INTO messages (chat_id, user_id, content, file_name, file_content) VALUES ($1, $2, $3, $4, $5) RETURNING id",
-		message.ChatID, message.UserID, message.Content, message.File.Name, message.File.Data,
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if message.AttachmentID == 0 && message.File.Data != "" {
+		err = tx.QueryRow(
+			"INSERT INTO attachments (name, data, uploader_id) VALUES ($1, $2, $3) RETURNING id",
+			message.File.Name, message.File.Data, message.UserID,
+		).Scan(&message.AttachmentID)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	var origin domain.MessageOrigin
+	if message.ForwardedFrom != nil {
+		origin = *message.ForwardedFrom
+	}
+
+	err = tx.QueryRow(
+		`INSERT INTO messages (chat_id, user_id, content, attachment_id, is_system,
+		                       forwarded_from_message_id, forwarded_from_chat_id, forwarded_from_user_id,
+		                       expires_at)
+		 VALUES ($1, $2, $3, NULLIF($4, 0), $5, NULLIF($6, 0), NULLIF($7, 0), NULLIF($8, 0), $9)
+		 RETURNING id`,
+		message.ChatID, message.UserID, message.Content, message.AttachmentID, message.IsSystem,
+		origin.MessageID, origin.ChatID, origin.UserID, message.ExpiresAt,
 	).Scan(&message.ID)
 	if err != nil {
 		return 0, err
 	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
 	return message.ID, nil
 }
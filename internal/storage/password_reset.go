@@ -0,0 +1,89 @@
+package storage
+
+import (
+	"chat/internal/domain"
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// resetTokenHash is what is stored instead of the token itself
+func resetTokenHash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// InsertPasswordResetToken stores a new reset token of the user, replacing
+// the unused ones. It returns sql.ErrNoRows if there is no such user or it
+// is deleted.
+func (s *Storage) InsertPasswordResetToken(token domain.PasswordResetToken) (int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var userID int
+	err = tx.QueryRow("SELECT id FROM users WHERE id = $1 AND deleted_at IS NULL FOR UPDATE", token.UserID).Scan(&userID)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.Exec("DELETE FROM password_reset_tokens WHERE user_id = $1 AND used_at IS NULL", token.UserID)
+	if err != nil {
+		return 0, err
+	}
+
+	var id int
+	err = tx.QueryRow(
+		`INSERT INTO password_reset_tokens (user_id, token_hash, created_by, expires_at)
+		 VALUES ($1, $2, NULLIF($3, 0), $4)
+		 RETURNING id`,
+		token.UserID, resetTokenHash(token.Token), token.CreatedBy, token.ExpiresAt,
+	).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// RedeemPasswordResetToken uses up the token to set a new password hash of
+// its user, ending all sessions of the user. It returns the user ID or
+// sql.ErrNoRows if the token is unknown, used or expired, or the user is
+// deactivated.
+func (s *Storage) RedeemPasswordResetToken(token string, password string) (int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var userID int
+	err = tx.QueryRow(
+		`UPDATE password_reset_tokens SET used_at = NOW()
+		 WHERE token_hash = $1 AND used_at IS NULL AND expires_at > NOW()
+		 RETURNING user_id`,
+		resetTokenHash(token),
+	).Scan(&userID)
+	if err != nil {
+		return 0, err
+	}
+
+	err = tx.QueryRow(
+		`UPDATE users SET password = $2, session_version = session_version + 1
+		 WHERE id = $1 AND deactivated_at IS NULL AND deleted_at IS NULL
+		 RETURNING id`,
+		userID, password,
+	).Scan(&userID)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return userID, nil
+}
@@ -0,0 +1,84 @@
+package storage
+
+import (
+	"chat/internal/domain"
+	"database/sql"
+)
+
+// PinMessage pins the message in the chat and reports whether it wasn't
+// pinned before
+func (s *Storage) PinMessage(chatID int, messageID int, userID int) (bool, error) {
+	res, err := s.db.Exec(
+		`INSERT INTO pinned_messages (chat_id, message_id, pinned_by)
+		 VALUES ($1, $2, $3)
+		 ON CONFLICT (chat_id, message_id) DO NOTHING`,
+		chatID, messageID, userID,
+	)
+	if err != nil {
+		return false, err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected > 0, nil
+}
+
+// UnpinMessage returns sql.ErrNoRows if the message is not pinned
+func (s *Storage) UnpinMessage(chatID int, messageID int) error {
+	res, err := s.db.Exec("DELETE FROM pinned_messages WHERE chat_id = $1 AND message_id = $2", chatID, messageID)
+	if err != nil {
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// GetPinnedMessages returns the pinned messages of the chat, the most recently
+// pinned first. File contents are left out.
+func (s *Storage) GetPinnedMessages(chatID int) ([]domain.PinnedMessage, error) {
+	rows, err := s.db.Query(
+		`SELECT m.id, m.chat_id, m.user_id, m.content, m.created_at, u.username, COALESCE(a.name, ''), m.is_system,
+		        p.pinned_by, pu.username, p.pinned_at
+		 FROM pinned_messages p
+		 JOIN messages m ON p.message_id = m.id
+		 JOIN users u ON m.user_id = u.id
+		 LEFT JOIN attachments a ON m.attachment_id = a.id
+		 JOIN users pu ON p.pinned_by = pu.id
+		 WHERE p.chat_id = $1
+		 ORDER BY p.pinned_at DESC`,
+		chatID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var pins []domain.PinnedMessage
+	for rows.Next() {
+		var pin domain.PinnedMessage
+		if err := rows.Scan(
+			&pin.ID,
+			&pin.ChatID,
+			&pin.UserID,
+			&pin.Content,
+			&pin.CreatedAt,
+			&pin.Username,
+			&pin.File.Name,
+			&pin.IsSystem,
+			&pin.PinnedBy,
+			&pin.PinnedByUsername,
+			&pin.PinnedAt,
+		); err != nil {
+			return nil, err
+		}
+		pins = append(pins, pin)
+	}
+	return pins, nil
+}
@@ -0,0 +1,101 @@
+package storage
+
+import (
+	"chat/internal/domain"
+	"database/sql"
+)
+
+// UpsertPushSubscription saves the subscription of the browser. A browser
+// subscribing again keeps its endpoint, which moves to the current user.
+func (s *Storage) UpsertPushSubscription(sub domain.PushSubscription) (int, error) {
+	var id int
+	err := s.db.QueryRow(
+		`INSERT INTO push_subscriptions (user_id, endpoint, p256dh, auth)
+		 VALUES ($1, $2, $3, $4)
+		 ON CONFLICT (endpoint) DO UPDATE
+		 SET user_id = EXCLUDED.user_id,
+		     p256dh = EXCLUDED.p256dh,
+		     auth = EXCLUDED.auth
+		 RETURNING id`,
+		sub.UserID, sub.Endpoint, sub.P256dh, sub.Auth,
+	).Scan(&id)
+	return id, err
+}
+
+// DeletePushSubscription returns sql.ErrNoRows if the user has no
+// subscription with the endpoint
+func (s *Storage) DeletePushSubscription(userID int, endpoint string) error {
+	res, err := s.db.Exec("DELETE FROM push_subscriptions WHERE user_id = $1 AND endpoint = $2", userID, endpoint)
+	if err != nil {
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// DeletePushSubscriptionByEndpoint removes a subscription the push service
+// no longer knows
+func (s *Storage) DeletePushSubscriptionByEndpoint(endpoint string) error {
+	_, err := s.db.Exec("DELETE FROM push_subscriptions WHERE endpoint = $1", endpoint)
+	return err
+}
+
+func (s *Storage) GetPushSubscriptionsByUserID(userID int) ([]domain.PushSubscription, error) {
+	rows, err := s.db.Query(
+		`SELECT id, user_id, endpoint, p256dh, auth, created_at
+		 FROM push_subscriptions
+		 WHERE user_id = $1
+		 ORDER BY id`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var subs []domain.PushSubscription
+	for rows.Next() {
+		var sub domain.PushSubscription
+		if err := rows.Scan(&sub.ID, &sub.UserID, &sub.Endpoint, &sub.P256dh, &sub.Auth, &sub.CreatedAt); err != nil {
+			return nil, err
+		}
+		subs = append(subs, sub)
+	}
+	return subs, rows.Err()
+}
+
+// GetPushRecipientIDs returns the members of the chat with a push
+// subscription, except the author, who should hear about a new message:
+// those who haven't muted the chat and the mentioned ones
+func (s *Storage) GetPushRecipientIDs(chatID int, authorID int, mentioned []int) ([]int, error) {
+	rows, err := s.db.Query(
+		`SELECT cu.user_id
+		 FROM chat_users cu
+		 JOIN users u ON u.id = cu.user_id
+		 WHERE cu.chat_id = $1 AND cu.user_id != $2 AND u.deactivated_at IS NULL
+		   AND (NOT cu.muted OR cu.user_id = ANY($3))
+		   AND EXISTS (SELECT 1 FROM push_subscriptions ps WHERE ps.user_id = cu.user_id)
+		 ORDER BY cu.user_id`,
+		chatID, authorID, int64Array(mentioned),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
@@ -0,0 +1,136 @@
+package storage
+
+import (
+	"chat/internal/domain"
+	"database/sql"
+)
+
+// messageExpired matches messages m older than the retention period of their
+// chat c, unless they are under legal hold. $1 is the default period in days
+// for chats without their own.
+const messageExpired = `
+	COALESCE(c.retention_days, $1) > 0
+	AND m.created_at < NOW() - COALESCE(c.retention_days, $1) * INTERVAL '1 day'
+	AND NOT ` + messageHeld
+
+// SetChatRetention sets how many days the chat keeps its history. nil makes
+// the chat follow the default policy, 0 keeps messages forever.
+func (s *Storage) SetChatRetention(chatID int, days *int) error {
+	_, err := s.db.Exec("UPDATE chats SET retention_days = $1 WHERE id = $2", days, chatID)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// PurgeExpiredMessages deletes up to limit messages that are past their
+// chat retention period and returns them with ID and ChatID set. With
+// archive the messages are moved to messages_archive along with their
+// attachments. Rows are locked one by one with SKIP LOCKED, so the purge
+// never blocks writers and may run on several servers at once.
+func (s *Storage) PurgeExpiredMessages(defaultDays int, archive bool, limit int) ([]domain.Message, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(
+		`WITH expired AS (
+		     SELECT m.id FROM messages m
+		     JOIN chats c ON m.chat_id = c.id
+		     WHERE `+messageExpired+`
+		     ORDER BY m.id
+		     LIMIT $2
+		     FOR UPDATE OF m SKIP LOCKED
+		 ),
+		 purged AS (
+		     DELETE FROM messages WHERE id IN (SELECT id FROM expired)
+		     RETURNING id, chat_id, user_id, content, created_at, attachment_id, is_system,
+		               forwarded_from_message_id, forwarded_from_chat_id, forwarded_from_user_id,
+		               deleted_at, deleted_by
+		 ),
+		 archived AS (
+		     INSERT INTO messages_archive (id, chat_id, user_id, content, created_at, attachment_id, is_system,
+		                                   forwarded_from_message_id, forwarded_from_chat_id, forwarded_from_user_id,
+		                                   deleted_at, deleted_by)
+		     SELECT * FROM purged WHERE $3::boolean
+		 )
+		 SELECT id, chat_id, attachment_id FROM purged`,
+		defaultDays, limit, archive,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		messages      []domain.Message
+		attachmentIDs []int64
+	)
+	for rows.Next() {
+		var (
+			message      domain.Message
+			attachmentID sql.NullInt64
+		)
+		if err := rows.Scan(&message.ID, &message.ChatID, &attachmentID); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		messages = append(messages, message)
+		if attachmentID.Valid {
+			attachmentIDs = append(attachmentIDs, attachmentID.Int64)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if !archive {
+		for _, attachmentID := range attachmentIDs {
+			if err := deleteOrphanAttachment(tx, attachmentID); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+// GetRetentionReport counts the messages the retention job would purge,
+// per chat. A zero chatID reports on all chats.
+func (s *Storage) GetRetentionReport(defaultDays int, chatID int) ([]domain.RetentionReport, error) {
+	rows, err := s.db.Query(
+		`SELECT c.id, c.name, COALESCE(c.retention_days, $1), count(*), MIN(m.created_at)
+		 FROM messages m
+		 JOIN chats c ON m.chat_id = c.id
+		 WHERE `+messageExpired+`
+		   AND ($2 = 0 OR c.id = $2)
+		 GROUP BY c.id
+		 ORDER BY c.id`,
+		defaultDays, chatID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	reports := []domain.RetentionReport{}
+	for rows.Next() {
+		var report domain.RetentionReport
+		if err := rows.Scan(
+			&report.ChatID,
+			&report.ChatName,
+			&report.RetentionDays,
+			&report.MessageCount,
+			&report.OldestMessageAt,
+		); err != nil {
+			return nil, err
+		}
+		reports = append(reports, report)
+	}
+	return reports, rows.Err()
+}
@@ -0,0 +1,228 @@
+package storage
+
+import (
+	"chat/internal/domain"
+	"database/sql"
+	"time"
+)
+
+func (s *Storage) InsertScheduledMessage(message domain.ScheduledMessage) (int, error) {
+	err := s.db.QueryRow(
+		`INSERT INTO scheduled_messages (chat_id, user_id, content, attachment_id, send_at)
+		 VALUES ($1, $2, $3, NULLIF($4, 0), $5)
+		 RETURNING id`,
+		message.ChatID, message.UserID, message.Content, message.AttachmentID, message.SendAt,
+	).Scan(&message.ID)
+	if err != nil {
+		return 0, err
+	}
+	return message.ID, nil
+}
+
+// GetScheduledMessages returns pending messages the user scheduled in the
+// chat, the soonest first. File contents are left out.
+func (s *Storage) GetScheduledMessages(chatID int, userID int) ([]domain.ScheduledMessage, error) {
+	rows, err := s.db.Query(
+		`SELECT sm.id, sm.chat_id, sm.user_id, sm.content, COALESCE(a.name, ''), COALESCE(sm.attachment_id, 0),
+		        sm.send_at, sm.status, sm.created_at
+		 FROM scheduled_messages sm
+		 LEFT JOIN attachments a ON sm.attachment_id = a.id
+		 WHERE sm.chat_id = $1 AND sm.user_id = $2 AND sm.status = 'pending'
+		 ORDER BY sm.send_at, sm.id`,
+		chatID, userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []domain.ScheduledMessage
+	for rows.Next() {
+		var message domain.ScheduledMessage
+		if err := rows.Scan(
+			&message.ID,
+			&message.ChatID,
+			&message.UserID,
+			&message.Content,
+			&message.File.Name,
+			&message.AttachmentID,
+			&message.SendAt,
+			&message.Status,
+			&message.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		messages = append(messages, message)
+	}
+	return messages, nil
+}
+
+// CancelScheduledMessage cancels a pending message of the user together with
+// its attachment. It returns sql.ErrNoRows if there is no such message or it
+// has already been sent.
+func (s *Storage) CancelScheduledMessage(chatID int, userID int, scheduledID int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var attachmentID sql.NullInt64
+	err = tx.QueryRow(
+		`UPDATE scheduled_messages SET status = 'canceled'
+		 WHERE id = $1 AND chat_id = $2 AND user_id = $3 AND status = 'pending'
+		 RETURNING attachment_id`,
+		scheduledID, chatID, userID,
+	).Scan(&attachmentID)
+	if err != nil {
+		return err
+	}
+
+	if attachmentID.Valid {
+		_, err = tx.Exec("DELETE FROM attachments WHERE id = $1", attachmentID.Int64)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// SendDueScheduledMessages turns up to limit due scheduled messages into
+// regular messages and returns their IDs. Rows are locked with SKIP LOCKED
+// and marked sent in the same transaction as the insert, so each message is
+// sent exactly once however many servers run the scheduler. Messages for
+// which allowed returns false are marked failed instead. Sent messages stay
+// undelivered until MarkScheduledMessageDelivered; the caller has lease to
+// deliver them before ClaimUndeliveredScheduledMessages hands them out again.
+func (s *Storage) SendDueScheduledMessages(limit int, lease time.Duration, allowed func(domain.ScheduledMessage) bool) ([]int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(
+		`SELECT id, chat_id, user_id, content, COALESCE(attachment_id, 0), send_at
+		 FROM scheduled_messages
+		 WHERE status = 'pending' AND send_at <= NOW()
+		 ORDER BY send_at, id
+		 LIMIT $1
+		 FOR UPDATE SKIP LOCKED`,
+		limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	var due []domain.ScheduledMessage
+	for rows.Next() {
+		var message domain.ScheduledMessage
+		if err := rows.Scan(
+			&message.ID,
+			&message.ChatID,
+			&message.UserID,
+			&message.Content,
+			&message.AttachmentID,
+			&message.SendAt,
+		); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		due = append(due, message)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var messageIDs []int
+	for _, message := range due {
+		if !allowed(message) {
+			_, err = tx.Exec("UPDATE scheduled_messages SET status = 'failed' WHERE id = $1", message.ID)
+			if err != nil {
+				return nil, err
+			}
+			if message.AttachmentID != 0 {
+				_, err = tx.Exec("DELETE FROM attachments WHERE id = $1", message.AttachmentID)
+				if err != nil {
+					return nil, err
+				}
+			}
+			continue
+		}
+
+		var messageID int
+		err = tx.QueryRow(
+			`INSERT INTO messages (chat_id, user_id, content, attachment_id, expires_at)
+			 VALUES ($1, $2, $3, NULLIF($4, 0),
+			         (SELECT NOW() + message_ttl * INTERVAL '1 second' FROM chats WHERE id = $1 AND message_ttl > 0))
+			 RETURNING id`,
+			message.ChatID, message.UserID, message.Content, message.AttachmentID,
+		).Scan(&messageID)
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = tx.Exec(
+			`UPDATE scheduled_messages
+			 SET status = 'sent', message_id = $1, delivery_lease_until = NOW() + $3 * INTERVAL '1 millisecond'
+			 WHERE id = $2`,
+			messageID, message.ID, lease.Milliseconds(),
+		)
+		if err != nil {
+			return nil, err
+		}
+		messageIDs = append(messageIDs, messageID)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return messageIDs, nil
+}
+
+// ClaimUndeliveredScheduledMessages returns the IDs of up to limit sent
+// messages whose delivery was never confirmed, as after a crash between
+// sending and delivering, and leases them to the caller. Messages deleted
+// meanwhile are left out.
+func (s *Storage) ClaimUndeliveredScheduledMessages(limit int, lease time.Duration) ([]int, error) {
+	rows, err := s.db.Query(
+		`UPDATE scheduled_messages
+		 SET delivery_lease_until = NOW() + $2 * INTERVAL '1 millisecond'
+		 WHERE id IN (
+		     SELECT id FROM scheduled_messages
+		     WHERE status = 'sent' AND delivered_at IS NULL AND delivery_lease_until <= NOW()
+		       AND message_id IS NOT NULL
+		     ORDER BY delivery_lease_until
+		     LIMIT $1
+		     FOR UPDATE SKIP LOCKED
+		 )
+		 RETURNING message_id`,
+		limit, lease.Milliseconds(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messageIDs []int
+	for rows.Next() {
+		var messageID int
+		if err := rows.Scan(&messageID); err != nil {
+			return nil, err
+		}
+		messageIDs = append(messageIDs, messageID)
+	}
+	return messageIDs, rows.Err()
+}
+
+// MarkScheduledMessageDelivered records that the sent message reached
+// clients, webhooks and the search index
+func (s *Storage) MarkScheduledMessageDelivered(messageID int) error {
+	_, err := s.db.Exec(
+		"UPDATE scheduled_messages SET delivered_at = NOW(), delivery_lease_until = NULL WHERE message_id = $1",
+		messageID,
+	)
+	return err
+}
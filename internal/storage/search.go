@@ -0,0 +1,76 @@
+package storage
+
+import (
+	"chat/internal/domain"
+
+	"github.com/lib/pq"
+)
+
+// IndexMessage replaces the search tokens of the message
+func (s *Storage) IndexMessage(messageID int, tokens []string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("DELETE FROM message_search_tokens WHERE message_id = $1", messageID)
+	if err != nil {
+		return err
+	}
+
+	if len(tokens) > 0 {
+		_, err = tx.Exec(
+			`INSERT INTO message_search_tokens (message_id, token)
+			 SELECT $1, unnest($2::text[])
+			 ON CONFLICT DO NOTHING`,
+			messageID, pq.Array(tokens),
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// SearchMessages returns messages containing all the tokens from the chats
+// userID is a member of, the newest first
+func (s *Storage) SearchMessages(userID int, tokens []string, filter domain.SearchFilter, limit int, offset int) ([]domain.SearchResult, error) {
+	rows, err := s.db.Query(
+		`SELECT `+messageColumns+`, c.name
+		 FROM messages m
+		 JOIN chats c ON m.chat_id = c.id
+		 JOIN chat_users cu ON cu.chat_id = m.chat_id AND cu.user_id = $1`+messageJoins+`
+		 WHERE m.id IN (
+		           SELECT message_id FROM message_search_tokens
+		           WHERE token = ANY($2)
+		           GROUP BY message_id
+		           HAVING count(*) = $3
+		       )
+		   AND ($4 = 0 OR m.chat_id = $4)
+		   AND ($5 = '' OR LOWER(u.username) = LOWER($5))
+		   AND ($6::timestamp IS NULL OR m.created_at >= $6)
+		   AND ($7::timestamp IS NULL OR m.created_at < $7)
+		   AND ($8::boolean IS NULL OR (m.attachment_id IS NOT NULL) = $8)
+		 ORDER BY m.created_at DESC, m.id DESC
+		 LIMIT $9 OFFSET $10`,
+		userID, pq.Array(tokens), len(tokens),
+		filter.ChatID, filter.AuthorUsername, filter.From, filter.To, filter.HasAttachment,
+		limit, offset,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []domain.SearchResult
+	for rows.Next() {
+		var result domain.SearchResult
+		if err := scanMessage(chatNameRow{rows, &result.ChatName}, &result.Message); err != nil {
+			return nil, err
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
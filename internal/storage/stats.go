@@ -0,0 +1,57 @@
+package storage
+
+import (
+	"chat/internal/domain"
+)
+
+// GetInstanceStats counts users, chats, messages and attachments of the server
+func (s *Storage) GetInstanceStats() (domain.InstanceStats, error) {
+	stats := domain.InstanceStats{Chats: map[domain.ChatType]int{}}
+
+	err := s.db.QueryRow(
+		`SELECT COUNT(*),
+		        COUNT(*) FILTER (WHERE status = 'online' AND deactivated_at IS NULL),
+		        COUNT(*) FILTER (WHERE deactivated_at IS NOT NULL),
+		        COUNT(*) FILTER (WHERE is_admin)
+		 FROM users`,
+	).Scan(&stats.Users, &stats.OnlineUsers, &stats.DeactivatedUsers, &stats.Admins)
+	if err != nil {
+		return stats, err
+	}
+
+	rows, err := s.db.Query("SELECT type, COUNT(*) FROM chats GROUP BY type")
+	if err != nil {
+		return stats, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			chatType domain.ChatType
+			count    int
+		)
+		if err := rows.Scan(&chatType, &count); err != nil {
+			return stats, err
+		}
+		stats.Chats[chatType] = count
+	}
+	if err := rows.Err(); err != nil {
+		return stats, err
+	}
+
+	err = s.db.QueryRow(
+		`SELECT COUNT(*), COUNT(*) FILTER (WHERE created_at > NOW() - INTERVAL '1 day')
+		 FROM messages
+		 WHERE deleted_at IS NULL`,
+	).Scan(&stats.Messages, &stats.MessagesLastDay)
+	if err != nil {
+		return stats, err
+	}
+
+	err = s.db.QueryRow("SELECT COUNT(*), COALESCE(SUM(length(data)), 0) FROM attachments").
+		Scan(&stats.Attachments, &stats.AttachmentBytes)
+	if err != nil {
+		return stats, err
+	}
+
+	return stats, nil
+}
@@ -2,8 +2,43 @@ package storage
 
 import (
 	"chat/internal/domain"
+	"database/sql"
+
+	"github.com/lib/pq"
 )
 
+const userColumns = `id, username, name, surname, patronymic, password, status, last_active, is_admin,
+	deactivated_at, deleted_at, session_version`
+
+func scanUser(row rowScanner, user *domain.User) error {
+	var lastActive, deactivatedAt, deletedAt sql.NullTime
+	err := row.Scan(
+		&user.ID,
+		&user.Username,
+		&user.Name,
+		&user.Surname,
+		&user.Patronymic,
+		&user.Password,
+		&user.Status,
+		&lastActive,
+		&user.IsAdmin,
+		&deactivatedAt,
+		&deletedAt,
+		&user.SessionVersion,
+	)
+	if err != nil {
+		return err
+	}
+	user.LastActive = lastActive.Time
+	if deactivatedAt.Valid {
+		user.DeactivatedAt = &deactivatedAt.Time
+	}
+	if deletedAt.Valid {
+		user.DeletedAt = &deletedAt.Time
+	}
+	return nil
+}
+
 func (s *Storage) GetUserIDByUsername(username string) (int, error) {
 	var userID int
 	err := s.db.QueryRow("SELECT id FROM users WHERE username = $1", username).Scan(&userID)
@@ -15,8 +50,7 @@ func (s *Storage) GetUserIDByUsername(username string) (int, error) {
 
 func (s *Storage) GetUserByUsername(username string) (domain.User, error) {
 	var user domain.User
-	err := s.db.QueryRow("SELECT id, username, name, surname, patronymic, password FROM users WHERE username = $1", username).
-		Scan(&user.ID, &user.Username, &user.Name, &user.Surname, &user.Patronymic, &user.Password)
+	err := scanUser(s.db.QueryRow("SELECT "+userColumns+" FROM users WHERE username = $1", username), &user)
 	if err != nil {
 		return domain.User{}, err
 	}
@@ -25,11 +59,11 @@ func (s *Storage) GetUserByUsername(username string) (domain.User, error) {
 
 func (s *Storage) GetUserByID(id int) (domain.User, error) {
 	var user domain.User
-	err := s.db.QueryRow("SELECT id, username, name, surname, patronymic FROM users WHERE id = $1", id).
-		Scan(&user.ID, &user.Username, &user.Name, &user.Surname, &user.Patronymic)
+	err := scanUser(s.db.QueryRow("SELECT "+userColumns+" FROM users WHERE id = $1", id), &user)
 	if err != nil {
 		return domain.User{}, err
 	}
+	user.Password = ""
 	return user, nil
 }
 
@@ -37,7 +71,7 @@ func (s *Storage) GetAllOtherUsers(username string) ([]domain.User, error) {
 	rows, err := s.db.Query(`
 	SELECT id, username, name, surname, patronymic 
 	FROM users 
-	WHERE id != (SELECT id FROM users WHERE username = $1)`, username)
+	WHERE id != (SELECT id FROM users WHERE username = $1) AND deactivated_at IS NULL`, username)
 	if err != nil {
 		return nil, err
 	}
@@ -55,6 +89,36 @@ func (s *Storage) GetAllOtherUsers(username string) ([]domain.User, error) {
 	return users, nil
 }
 
+// SearchUsers returns users whose username or full name contains query,
+// all users if it is empty, ordered by username
+func (s *Storage) SearchUsers(query string, limit int, offset int) ([]domain.User, error) {
+	rows, err := s.db.Query(
+		`SELECT `+userColumns+`
+		 FROM users
+		 WHERE $1 = ''
+		    OR username ILIKE '%' || $1 || '%'
+		    OR (surname || ' ' || name || ' ' || patronymic) ILIKE '%' || $1 || '%'
+		 ORDER BY username
+		 LIMIT $2 OFFSET $3`,
+		query, limit, offset,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []domain.User{}
+	for rows.Next() {
+		var user domain.User
+		if err := scanUser(rows, &user); err != nil {
+			return nil, err
+		}
+		user.Password = ""
+		users = append(users, user)
+	}
+	return users, nil
+}
+
 func (s *Storage) UpdateUserStatus(username string, status string) error {
 	_, err := s.db.Exec("UPDATE users SET status = $1, last_active = NOW() WHERE username = $2", status, username)
 	if err != nil {
@@ -63,6 +127,277 @@ func (s *Storage) UpdateUserStatus(username string, status string) error {
 	return nil
 }
 
+// SetUserDeactivated deactivates or reactivates the user. Deactivation ends
+// all sessions of the user. It returns sql.ErrNoRows if there is no such
+// user or it is deleted.
+func (s *Storage) SetUserDeactivated(userID int, deactivated bool) error {
+	res, err := s.db.Exec(
+		`UPDATE users
+		 SET deactivated_at = CASE WHEN $2 THEN COALESCE(deactivated_at, NOW()) END,
+		     session_version = session_version + CASE WHEN $2 THEN 1 ELSE 0 END,
+		     status = CASE WHEN $2 THEN 'offline' ELSE status END
+		 WHERE id = $1 AND deleted_at IS NULL`,
+		userID, deactivated,
+	)
+	if err != nil {
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// PromoteAdmins grants server administration to the accounts with the
+// usernames and returns the usernames that have an account
+func (s *Storage) PromoteAdmins(usernames []string) ([]string, error) {
+	rows, err := s.db.Query(
+		"UPDATE users SET is_admin = true WHERE username = ANY($1) AND deleted_at IS NULL RETURNING username",
+		pq.StringArray(usernames),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var promoted []string
+	for rows.Next() {
+		var username string
+		if err := rows.Scan(&username); err != nil {
+			return nil, err
+		}
+		promoted = append(promoted, username)
+	}
+	return promoted, rows.Err()
+}
+
+// UsernameReserved reports whether the username belonged to a deleted user
+func (s *Storage) UsernameReserved(username string) (bool, error) {
+	var reserved bool
+	err := s.db.QueryRow(
+		"SELECT EXISTS (SELECT 1 FROM reserved_usernames WHERE username = $1)", username,
+	).Scan(&reserved)
+	if err != nil {
+		return false, err
+	}
+	return reserved, nil
+}
+
+// SetUserAdmin grants or revokes server administration. It returns
+// sql.ErrNoRows if there is no such user or it is deleted.
+func (s *Storage) SetUserAdmin(userID int, isAdmin bool) error {
+	res, err := s.db.Exec("UPDATE users SET is_admin = $2 WHERE id = $1 AND deleted_at IS NULL", userID, isAdmin)
+	if err != nil {
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// UpdateUserPassword stores a new password hash and ends all sessions of the
+// user. It returns the new session version or sql.ErrNoRows if there is no
+// such user or it is deleted.
+func (s *Storage) UpdateUserPassword(userID int, password string) (int, error) {
+	var version int
+	err := s.db.QueryRow(
+		`UPDATE users SET password = $2, session_version = session_version + 1
+		 WHERE id = $1 AND deleted_at IS NULL
+		 RETURNING session_version`,
+		userID, password,
+	).Scan(&version)
+	if err != nil {
+		return 0, err
+	}
+	return version, nil
+}
+
+// DeleteUser anonymizes the account: personal details are replaced, the
+// username is reserved against new registrations, all sessions end, the user
+// leaves group chats and channels, passing ownership to an admin or another
+// member, and pending scheduled messages with their attachments, mentions,
+// password reset tokens and email settings are dropped. Past messages stay
+// attributed to the anonymized account. With purge its messages are also
+// turned into tombstones deleted by deletedBy, archived ones are removed and
+// uploaded files are deleted unless still in use, except for content under
+// legal hold. It returns the purged messages or sql.ErrNoRows if there is no
+// such user or it is already deleted.
+func (s *Storage) DeleteUser(userID int, purge bool, deletedBy int) ([]domain.Message, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// The name stays taken, so that nobody can register it and pass for the
+	// deleted user in chats named after them
+	_, err = tx.Exec(
+		`INSERT INTO reserved_usernames (username)
+		 SELECT username FROM users WHERE id = $1 AND deleted_at IS NULL
+		 ON CONFLICT DO NOTHING`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := tx.Exec(
+		`UPDATE users
+		 SET username = 'deleted-' || id,
+		     name = 'Deleted user',
+		     surname = '',
+		     patronymic = '',
+		     password = '',
+		     status = 'offline',
+		     is_admin = false,
+		     deactivated_at = COALESCE(deactivated_at, NOW()),
+		     deleted_at = NOW(),
+		     session_version = session_version + 1
+		 WHERE id = $1 AND deleted_at IS NULL`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if affected == 0 {
+		return nil, sql.ErrNoRows
+	}
+
+	for _, query := range []string{
+		// Ownership passes to an admin of the chat, or any member if there is none
+		`UPDATE chat_users c
+		 SET role = 'owner'
+		 FROM (
+		     SELECT DISTINCT ON (n.chat_id) n.chat_id, n.user_id
+		     FROM chat_users n
+		     JOIN chat_users o ON o.chat_id = n.chat_id AND o.user_id = $1 AND o.role = 'owner'
+		     WHERE n.user_id != $1
+		     ORDER BY n.chat_id, n.role = 'admin' DESC, n.user_id
+		 ) heir
+		 WHERE c.chat_id = heir.chat_id AND c.user_id = heir.user_id`,
+		`DELETE FROM chat_users c
+		 USING chats
+		 WHERE chats.id = c.chat_id AND chats.type != 'private' AND c.user_id = $1`,
+		// Files of pending scheduled messages belong to nothing else yet
+		`DELETE FROM attachments
+		 WHERE id IN (SELECT attachment_id FROM scheduled_messages WHERE user_id = $1 AND status = 'pending')`,
+		"DELETE FROM scheduled_messages WHERE user_id = $1 AND status = 'pending'",
+		"DELETE FROM message_mentions WHERE user_id = $1",
+		"DELETE FROM password_reset_tokens WHERE user_id = $1",
+		"DELETE FROM email_settings WHERE user_id = $1",
+		"DELETE FROM push_subscriptions WHERE user_id = $1",
+	} {
+		if _, err := tx.Exec(query, userID); err != nil {
+			return nil, err
+		}
+	}
+
+	var messages []domain.Message
+	if purge {
+		messages, err = purgeUserMessages(tx, userID, deletedBy)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+// purgeUserMessages turns messages of the user into tombstones and deletes
+// archived ones, then deletes files of the user nothing refers to anymore
+func purgeUserMessages(tx *sql.Tx, userID int, deletedBy int) ([]domain.Message, error) {
+	rows, err := tx.Query(
+		`UPDATE messages m
+		 SET content = CASE WHEN `+messageHeld+` THEN m.content ELSE '' END,
+		     attachment_id = CASE WHEN `+messageHeld+` THEN m.attachment_id END,
+		     deleted_at = NOW(),
+		     deleted_by = $2
+		 FROM (SELECT id, attachment_id FROM messages WHERE user_id = $1 AND deleted_at IS NULL FOR UPDATE) old
+		 WHERE m.id = old.id
+		 RETURNING m.id, m.chat_id`,
+		userID, deletedBy,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		messages   []domain.Message
+		messageIDs []int
+	)
+	for rows.Next() {
+		var message domain.Message
+		if err := rows.Scan(&message.ID, &message.ChatID); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		messages = append(messages, message)
+		messageIDs = append(messageIDs, message.ID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, query := range []string{
+		"DELETE FROM message_search_tokens WHERE message_id = ANY($1)",
+		"DELETE FROM message_mentions WHERE message_id = ANY($1)",
+		"DELETE FROM pinned_messages WHERE message_id = ANY($1)",
+	} {
+		if _, err := tx.Exec(query, int64Array(messageIDs)); err != nil {
+			return nil, err
+		}
+	}
+
+	_, err = tx.Exec("DELETE FROM messages_archive m WHERE user_id = $1 AND NOT "+messageHeld, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Files of tombstones and deleted archived messages are among the uploads
+	// of the user, unless they were forwarded by others
+	rows, err = tx.Query("SELECT id FROM attachments WHERE uploader_id = $1", userID)
+	if err != nil {
+		return nil, err
+	}
+	var attachmentIDs []int64
+	for rows.Next() {
+		var attachmentID int64
+		if err := rows.Scan(&attachmentID); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		attachmentIDs = append(attachmentIDs, attachmentID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, attachmentID := range attachmentIDs {
+		if err := deleteOrphanAttachment(tx, attachmentID); err != nil {
+			return nil, err
+		}
+	}
+	return messages, nil
+}
+
 func (s *Storage) InsertUser(user domain.User) error {
 	_, err := s.db.Exec(
 		"INSERT INTO users (username, name, surname, patronymic, password, status) VALUES ($1, $2, $3, $4, $5, 'offline')",
@@ -0,0 +1,178 @@
+package storage
+
+import (
+	"chat/internal/domain"
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+func (s *Storage) InsertWebhook(webhook domain.Webhook) (int, error) {
+	err := s.db.QueryRow(
+		"INSERT INTO webhooks (chat_id, creator_id, url, secret, events) VALUES ($1, $2, $3, $4, $5) RETURNING id",
+		webhook.ChatID, webhook.CreatorID, webhook.URL, webhook.Secret, pq.Array(webhook.Events),
+	).Scan(&webhook.ID)
+	if err != nil {
+		return 0, err
+	}
+	return webhook.ID, nil
+}
+
+func (s *Storage) GetWebhooksByChatID(chatID int) ([]domain.Webhook, error) {
+	rows, err := s.db.Query(
+		"SELECT id, chat_id, creator_id, url, events, created_at FROM webhooks WHERE chat_id = $1 ORDER BY id",
+		chatID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var webhooks []domain.Webhook
+	for rows.Next() {
+		var webhook domain.Webhook
+		if err := rows.Scan(
+			&webhook.ID,
+			&webhook.ChatID,
+			&webhook.CreatorID,
+			&webhook.URL,
+			pq.Array(&webhook.Events),
+			&webhook.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, webhook)
+	}
+	return webhooks, nil
+}
+
+func (s *Storage) DeleteWebhook(chatID int, webhookID int) error {
+	res, err := s.db.Exec("DELETE FROM webhooks WHERE id = $1 AND chat_id = $2", webhookID, chatID)
+	if err != nil {
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// EnqueueWebhookEvent creates a pending delivery for every webhook of the chat
+// subscribed to the event.
+func (s *Storage) EnqueueWebhookEvent(chatID int, event string, payload string) error {
+	_, err := s.db.Exec(
+		`INSERT INTO webhook_deliveries (webhook_id, event, payload)
+		 SELECT id, $2, $3 FROM webhooks WHERE chat_id = $1 AND $2 = ANY(events)`,
+		chatID, event, payload,
+	)
+	return err
+}
+
+// ClaimWebhookDeliveries picks up to limit due deliveries and postpones them by
+// lease, so that a delivery claimed by a worker that dies is retried later
+// instead of being lost.
+func (s *Storage) ClaimWebhookDeliveries(limit int, lease time.Duration) ([]domain.WebhookDelivery, error) {
+	rows, err := s.db.Query(
+		`UPDATE webhook_deliveries d
+		 SET attempts = d.attempts + 1, next_attempt_at = NOW() + $2 * INTERVAL '1 millisecond'
+		 FROM webhooks w
+		 WHERE w.id = d.webhook_id AND d.id IN (
+		     SELECT id FROM webhook_deliveries
+		     WHERE status = 'pending' AND next_attempt_at <= NOW()
+		     ORDER BY next_attempt_at
+		     LIMIT $1
+		     FOR UPDATE SKIP LOCKED
+		 )
+		 RETURNING d.id, d.webhook_id, d.event, d.payload, d.status, d.attempts, d.created_at, w.url, w.secret`,
+		limit, lease.Milliseconds(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deliveries []domain.WebhookDelivery
+	for rows.Next() {
+		var delivery domain.WebhookDelivery
+		if err := rows.Scan(
+			&delivery.ID,
+			&delivery.WebhookID,
+			&delivery.Event,
+			&delivery.Payload,
+			&delivery.Status,
+			&delivery.Attempts,
+			&delivery.CreatedAt,
+			&delivery.Webhook.URL,
+			&delivery.Webhook.Secret,
+		); err != nil {
+			return nil, err
+		}
+		delivery.Webhook.ID = delivery.WebhookID
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, nil
+}
+
+func (s *Storage) MarkWebhookDeliveryDelivered(deliveryID int, responseStatus int) error {
+	_, err := s.db.Exec(
+		"UPDATE webhook_deliveries SET status = 'delivered', response_status = $2, last_error = NULL, delivered_at = NOW() WHERE id = $1",
+		deliveryID, responseStatus,
+	)
+	return err
+}
+
+// MarkWebhookDeliveryFailed records a failed attempt. The delivery is retried
+// at nextAttempt unless final is set.
+func (s *Storage) MarkWebhookDeliveryFailed(deliveryID int, responseStatus int, lastError string, nextAttempt time.Time, final bool) error {
+	status := domain.WebhookDeliveryPending
+	if final {
+		status = domain.WebhookDeliveryFailed
+	}
+	_, err := s.db.Exec(
+		"UPDATE webhook_deliveries SET status = $2, response_status = NULLIF($3, 0), last_error = $4, next_attempt_at = $5 WHERE id = $1",
+		deliveryID, status, responseStatus, lastError, nextAttempt,
+	)
+	return err
+}
+
+func (s *Storage) GetWebhookDeliveries(chatID int, webhookID int, limit int) ([]domain.WebhookDelivery, error) {
+	rows, err := s.db.Query(
+		`SELECT d.id, d.webhook_id, d.event, d.status, d.attempts, COALESCE(d.response_status, 0),
+		        COALESCE(d.last_error, ''), d.created_at, d.delivered_at
+		 FROM webhook_deliveries d
+		 JOIN webhooks w ON w.id = d.webhook_id
+		 WHERE d.webhook_id = $1 AND w.chat_id = $2
+		 ORDER BY d.id DESC
+		 LIMIT $3`,
+		webhookID, chatID, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deliveries []domain.WebhookDelivery
+	for rows.Next() {
+		var delivery domain.WebhookDelivery
+		if err := rows.Scan(
+			&delivery.ID,
+			&delivery.WebhookID,
+			&delivery.Event,
+			&delivery.Status,
+			&delivery.Attempts,
+			&delivery.ResponseStatus,
+			&delivery.LastError,
+			&delivery.CreatedAt,
+			&delivery.DeliveredAt,
+		); err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, nil
+}
@@ -0,0 +1,72 @@
+package utils
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ErrPrivateAddress rejects connections to addresses inside the server
+// network
+var ErrPrivateAddress = errors.New("address is not public")
+
+// nonPublicPrefixes are ranges not covered by the netip.Addr predicates in
+// PublicAddress: "this network" and the carrier-grade NAT shared space
+var nonPublicPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+}
+
+// PublicAddress reports whether the address is outside the server network:
+// loopback, private, link-local, multicast and unspecified addresses are
+// internal to it
+func PublicAddress(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if addr.IsLoopback() || addr.IsPrivate() || addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() ||
+		addr.IsInterfaceLocalMulticast() || addr.IsMulticast() || addr.IsUnspecified() {
+		return false
+	}
+	for _, prefix := range nonPublicPrefixes {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// PrivateHost reports whether the host of a URL names the server network
+// outright: a non-public IP address or localhost. Names resolving to such an
+// address are only caught when connecting.
+func PrivateHost(host string) bool {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if addr, err := netip.ParseAddr(host); err == nil {
+		return !PublicAddress(addr)
+	}
+	return host == "localhost" || strings.HasSuffix(host, ".localhost")
+}
+
+// PublicTransport returns a transport for requests to URLs users gave, which
+// could otherwise make the server send requests to its own network. Unless
+// allowPrivate is set the address is checked once resolved, which covers
+// names resolving to private addresses and redirects. Targets are reached
+// directly, as a proxy would hide the address.
+func PublicTransport(allowPrivate bool) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	if !allowPrivate {
+		dialer.Control = func(network string, address string, _ syscall.RawConn) error {
+			addrPort, err := netip.ParseAddrPort(address)
+			if err != nil || !PublicAddress(addrPort.Addr()) {
+				return ErrPrivateAddress
+			}
+			return nil
+		}
+	}
+	transport.DialContext = dialer.DialContext
+	return transport
+}
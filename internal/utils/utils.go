@@ -1,8 +1,21 @@
 package utils
 
-import "strconv"
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strconv"
+)
 
 func Atoi(s string) int {
 	i, _ := strconv.Atoi(s)
 	return i
 }
+
+// RandomToken returns a hex-encoded string built from n random bytes.
+func RandomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
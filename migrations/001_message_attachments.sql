@@ -0,0 +1,48 @@
+-- Moves files stored in messages.file_name and messages.file_content into
+-- attachments, referenced by messages.attachment_id. init.sql only runs on an
+-- empty database, so existing deployments apply this script once:
+--   psql -U admin -d chatdb -f migrations/001_message_attachments.sql
+-- It does nothing on a database that has no file_content column.
+
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS attachments (
+    id SERIAL PRIMARY KEY,
+    name TEXT NOT NULL,
+    data TEXT NOT NULL,
+    uploader_id INT REFERENCES users(id),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+DO $$
+DECLARE
+    message RECORD;
+    new_id INT;
+BEGIN
+    IF NOT EXISTS (
+        SELECT 1 FROM information_schema.columns
+        WHERE table_schema = current_schema() AND table_name = 'messages' AND column_name = 'file_content'
+    ) THEN
+        RETURN;
+    END IF;
+
+    ALTER TABLE messages ADD COLUMN IF NOT EXISTS attachment_id INT REFERENCES attachments(id) ON DELETE SET NULL;
+
+    FOR message IN
+        SELECT id, user_id, file_name, file_content, created_at
+        FROM messages
+        WHERE file_content IS NOT NULL AND file_content != '' AND attachment_id IS NULL
+        ORDER BY id
+    LOOP
+        INSERT INTO attachments (name, data, uploader_id, created_at)
+        VALUES (COALESCE(message.file_name, ''), message.file_content, message.user_id, message.created_at)
+        RETURNING id INTO new_id;
+
+        UPDATE messages SET attachment_id = new_id WHERE id = message.id;
+    END LOOP;
+
+    ALTER TABLE messages DROP COLUMN file_name, DROP COLUMN file_content;
+END;
+$$;
+
+COMMIT;
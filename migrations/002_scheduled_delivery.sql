@@ -0,0 +1,28 @@
+-- Tracks delivery of sent scheduled messages, so that messages stored but not
+-- delivered before a crash are delivered on restart. Scheduled messages sent
+-- before this script count as delivered. It does nothing on a database
+-- without scheduled messages, which 008_scheduled_messages.sql creates with
+-- these columns.
+--   psql -U admin -d chatdb -f migrations/002_scheduled_delivery.sql
+
+BEGIN;
+
+DO $$
+BEGIN
+    IF to_regclass('scheduled_messages') IS NULL THEN
+        RETURN;
+    END IF;
+
+    ALTER TABLE scheduled_messages ADD COLUMN IF NOT EXISTS delivered_at TIMESTAMP;
+    ALTER TABLE scheduled_messages ADD COLUMN IF NOT EXISTS delivery_lease_until TIMESTAMP;
+
+    UPDATE scheduled_messages
+    SET delivered_at = COALESCE(delivered_at, created_at)
+    WHERE status = 'sent' AND delivered_at IS NULL AND delivery_lease_until IS NULL;
+
+    CREATE INDEX IF NOT EXISTS scheduled_messages_undelivered_idx ON scheduled_messages (delivery_lease_until)
+        WHERE status = 'sent' AND delivered_at IS NULL;
+END;
+$$;
+
+COMMIT;
@@ -0,0 +1,42 @@
+-- Keeps the membership history legal holds and eDiscovery exports use to
+-- cover messages other people posted in the chats of a held user. Join times
+-- of current members are unknown, so their membership covers the whole
+-- history of the chat.
+--   psql -U admin -d chatdb -f migrations/003_chat_membership_periods.sql
+
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS chat_membership_periods (
+    chat_id INT NOT NULL REFERENCES chats(id) ON DELETE CASCADE,
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    joined_at TIMESTAMP NOT NULL,
+    left_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS chat_membership_periods_user_idx ON chat_membership_periods (user_id, chat_id);
+
+INSERT INTO chat_membership_periods (chat_id, user_id, joined_at)
+SELECT cu.chat_id, cu.user_id, '-infinity'
+FROM chat_users cu
+WHERE NOT EXISTS (
+    SELECT 1 FROM chat_membership_periods p
+    WHERE p.chat_id = cu.chat_id AND p.user_id = cu.user_id AND p.left_at IS NULL
+);
+
+CREATE OR REPLACE FUNCTION chat_users_track_membership() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'INSERT' THEN
+        INSERT INTO chat_membership_periods (chat_id, user_id, joined_at) VALUES (NEW.chat_id, NEW.user_id, NOW());
+        RETURN NEW;
+    END IF;
+    UPDATE chat_membership_periods SET left_at = NOW()
+    WHERE chat_id = OLD.chat_id AND user_id = OLD.user_id AND left_at IS NULL;
+    RETURN OLD;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE TRIGGER chat_users_membership
+    AFTER INSERT OR DELETE ON chat_users
+    FOR EACH ROW EXECUTE FUNCTION chat_users_track_membership();
+
+COMMIT;
@@ -0,0 +1,13 @@
+-- Keeps usernames of deleted users from being registered again. Names of
+-- users deleted before this script are already replaced and can't be
+-- reserved.
+--   psql -U admin -d chatdb -f migrations/004_reserved_usernames.sql
+
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS reserved_usernames (
+    username TEXT PRIMARY KEY,
+    reserved_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+COMMIT;
@@ -0,0 +1,55 @@
+-- Replaces chats.is_private with chats.type and adds chat roles, settings,
+-- invites and the public directory flag. Chats that were private become
+-- 'private', all others 'group'. Members of existing group chats are members,
+-- except the creator, who becomes the owner; if the creator has left, the
+-- member with the lowest ID does.
+--   psql -U admin -d chatdb -f migrations/006_chat_types_and_roles.sql
+
+BEGIN;
+
+ALTER TABLE chats ADD COLUMN IF NOT EXISTS type TEXT NOT NULL DEFAULT 'group' CHECK (type IN ('private', 'group', 'channel'));
+ALTER TABLE chats ADD COLUMN IF NOT EXISTS is_public BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE chats ADD COLUMN IF NOT EXISTS topic TEXT NOT NULL DEFAULT '';
+ALTER TABLE chats ADD COLUMN IF NOT EXISTS description TEXT NOT NULL DEFAULT '';
+ALTER TABLE chats ADD COLUMN IF NOT EXISTS avatar_id INT REFERENCES attachments(id) ON DELETE SET NULL;
+
+DO $$
+BEGIN
+    IF EXISTS (
+        SELECT 1 FROM information_schema.columns
+        WHERE table_schema = current_schema() AND table_name = 'chats' AND column_name = 'is_private'
+    ) THEN
+        UPDATE chats SET type = CASE WHEN is_private THEN 'private' ELSE 'group' END;
+        ALTER TABLE chats DROP COLUMN is_private;
+    END IF;
+END;
+$$;
+
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS is_system BOOLEAN NOT NULL DEFAULT false;
+
+ALTER TABLE chat_users ADD COLUMN IF NOT EXISTS role TEXT NOT NULL DEFAULT 'member' CHECK (role IN ('owner', 'admin', 'member'));
+
+UPDATE chat_users c
+SET role = 'owner'
+FROM (
+    SELECT DISTINCT ON (cu.chat_id) cu.chat_id, cu.user_id
+    FROM chat_users cu
+    JOIN chats ON chats.id = cu.chat_id AND chats.type != 'private'
+    WHERE NOT EXISTS (SELECT 1 FROM chat_users o WHERE o.chat_id = cu.chat_id AND o.role = 'owner')
+    ORDER BY cu.chat_id, cu.user_id = chats.creator_id DESC, cu.user_id
+) heir
+WHERE c.chat_id = heir.chat_id AND c.user_id = heir.user_id;
+
+CREATE TABLE IF NOT EXISTS chat_invites (
+    id SERIAL PRIMARY KEY,
+    chat_id INT REFERENCES chats(id) ON DELETE CASCADE,
+    token TEXT NOT NULL UNIQUE,
+    creator_id INT REFERENCES users(id),
+    max_uses INT,
+    uses INT NOT NULL DEFAULT 0,
+    expires_at TIMESTAMP,
+    revoked_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+COMMIT;
@@ -0,0 +1,39 @@
+-- Adds pinned messages, forwarding, mentions with chat muting and the search
+-- index. Messages posted before this script are not in the search index.
+--   psql -U admin -d chatdb -f migrations/007_message_features.sql
+
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS pinned_messages (
+    chat_id INT REFERENCES chats(id) ON DELETE CASCADE,
+    message_id INT REFERENCES messages(id) ON DELETE CASCADE,
+    pinned_by INT REFERENCES users(id),
+    pinned_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (chat_id, message_id)
+);
+
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS forwarded_from_message_id INT REFERENCES messages(id) ON DELETE SET NULL;
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS forwarded_from_chat_id INT REFERENCES chats(id) ON DELETE SET NULL;
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS forwarded_from_user_id INT REFERENCES users(id) ON DELETE SET NULL;
+
+ALTER TABLE chat_users ADD COLUMN IF NOT EXISTS muted BOOLEAN NOT NULL DEFAULT false;
+
+CREATE TABLE IF NOT EXISTS message_mentions (
+    message_id INT REFERENCES messages(id) ON DELETE CASCADE,
+    chat_id INT REFERENCES chats(id) ON DELETE CASCADE,
+    user_id INT REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (message_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS message_mentions_user_idx ON message_mentions (user_id, created_at);
+
+CREATE TABLE IF NOT EXISTS message_search_tokens (
+    message_id INT REFERENCES messages(id) ON DELETE CASCADE,
+    token TEXT NOT NULL,
+    PRIMARY KEY (token, message_id)
+);
+
+CREATE INDEX IF NOT EXISTS message_search_tokens_message_idx ON message_search_tokens (message_id);
+
+COMMIT;
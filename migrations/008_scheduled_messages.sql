@@ -0,0 +1,25 @@
+-- Adds scheduled messages with their delivery tracking, which
+-- 002_scheduled_delivery.sql skips on databases without them.
+--   psql -U admin -d chatdb -f migrations/008_scheduled_messages.sql
+
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS scheduled_messages (
+    id SERIAL PRIMARY KEY,
+    chat_id INT REFERENCES chats(id) ON DELETE CASCADE,
+    user_id INT REFERENCES users(id),
+    content TEXT NOT NULL,
+    attachment_id INT REFERENCES attachments(id) ON DELETE SET NULL,
+    send_at TIMESTAMP NOT NULL,
+    status TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'sent', 'canceled', 'failed')),
+    message_id INT REFERENCES messages(id) ON DELETE SET NULL,
+    delivered_at TIMESTAMP,
+    delivery_lease_until TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS scheduled_messages_due_idx ON scheduled_messages (send_at) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS scheduled_messages_undelivered_idx ON scheduled_messages (delivery_lease_until)
+    WHERE status = 'sent' AND delivered_at IS NULL;
+
+COMMIT;
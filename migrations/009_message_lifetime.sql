@@ -0,0 +1,36 @@
+-- Adds disappearing messages, per-chat retention with the archive, and
+-- tombstones of deleted messages.
+--   psql -U admin -d chatdb -f migrations/009_message_lifetime.sql
+
+BEGIN;
+
+ALTER TABLE chats ADD COLUMN IF NOT EXISTS message_ttl INT NOT NULL DEFAULT 0 CHECK (message_ttl >= 0);
+ALTER TABLE chats ADD COLUMN IF NOT EXISTS retention_days INT CHECK (retention_days >= 0);
+
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS expires_at TIMESTAMP;
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP;
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS deleted_by INT REFERENCES users(id) ON DELETE SET NULL;
+
+CREATE INDEX IF NOT EXISTS messages_expires_idx ON messages (expires_at) WHERE expires_at IS NOT NULL;
+CREATE INDEX IF NOT EXISTS messages_chat_created_idx ON messages (chat_id, created_at);
+
+CREATE TABLE IF NOT EXISTS messages_archive (
+    id INT PRIMARY KEY,
+    chat_id INT REFERENCES chats(id),
+    user_id INT REFERENCES users(id),
+    content TEXT NOT NULL,
+    created_at TIMESTAMP,
+    attachment_id INT REFERENCES attachments(id) ON DELETE SET NULL,
+    is_system BOOLEAN NOT NULL DEFAULT false,
+    forwarded_from_message_id INT,
+    forwarded_from_chat_id INT REFERENCES chats(id) ON DELETE SET NULL,
+    forwarded_from_user_id INT REFERENCES users(id) ON DELETE SET NULL,
+    deleted_at TIMESTAMP,
+    deleted_by INT REFERENCES users(id) ON DELETE SET NULL,
+    archived_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+ALTER TABLE messages_archive ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP;
+ALTER TABLE messages_archive ADD COLUMN IF NOT EXISTS deleted_by INT REFERENCES users(id) ON DELETE SET NULL;
+
+COMMIT;
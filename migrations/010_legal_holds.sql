@@ -0,0 +1,35 @@
+-- Adds legal holds and the record of eDiscovery exports. Holds on a user
+-- cover chats they were a member of, as kept since
+-- 003_chat_membership_periods.sql.
+--   psql -U admin -d chatdb -f migrations/010_legal_holds.sql
+
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS legal_holds (
+    id SERIAL PRIMARY KEY,
+    user_id INT REFERENCES users(id),
+    chat_id INT REFERENCES chats(id),
+    reason TEXT NOT NULL,
+    created_by INT REFERENCES users(id),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    released_by INT REFERENCES users(id),
+    released_at TIMESTAMP,
+    CHECK ((user_id IS NULL) <> (chat_id IS NULL))
+);
+
+CREATE TABLE IF NOT EXISTS ediscovery_exports (
+    id SERIAL PRIMARY KEY,
+    requested_by INT REFERENCES users(id),
+    user_ids INT[] NOT NULL DEFAULT '{}',
+    chat_ids INT[] NOT NULL DEFAULT '{}',
+    from_time TIMESTAMP,
+    to_time TIMESTAMP,
+    status TEXT NOT NULL DEFAULT 'running' CHECK (status IN ('running', 'completed', 'failed')),
+    message_count INT NOT NULL DEFAULT 0,
+    sha256 TEXT NOT NULL DEFAULT '',
+    error TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    completed_at TIMESTAMP
+);
+
+COMMIT;
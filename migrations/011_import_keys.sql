@@ -0,0 +1,10 @@
+-- Remembers imported chats and messages, so that importing the same export
+-- again skips them.
+--   psql -U admin -d chatdb -f migrations/011_import_keys.sql
+
+BEGIN;
+
+ALTER TABLE chats ADD COLUMN IF NOT EXISTS import_key TEXT UNIQUE;
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS import_key TEXT UNIQUE;
+
+COMMIT;
@@ -0,0 +1,39 @@
+-- Adds the append-only audit log. The log starts empty: actions taken before
+-- this script were not recorded.
+--   psql -U admin -d chatdb -f migrations/012_audit_log.sql
+
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS audit_events (
+    id BIGSERIAL PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    actor_id INT,
+    actor_username TEXT NOT NULL DEFAULT '',
+    action TEXT NOT NULL,
+    chat_id INT,
+    target TEXT NOT NULL DEFAULT '',
+    details JSON NOT NULL DEFAULT '{}',
+    ip TEXT NOT NULL DEFAULT '',
+    prev_hash TEXT NOT NULL,
+    hash TEXT NOT NULL UNIQUE
+);
+
+CREATE INDEX IF NOT EXISTS audit_events_created_idx ON audit_events (created_at);
+CREATE INDEX IF NOT EXISTS audit_events_actor_idx ON audit_events (actor_username, created_at);
+CREATE INDEX IF NOT EXISTS audit_events_chat_idx ON audit_events (chat_id, created_at) WHERE chat_id IS NOT NULL;
+
+CREATE OR REPLACE FUNCTION audit_events_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_events is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE TRIGGER audit_events_no_update
+    BEFORE UPDATE OR DELETE ON audit_events
+    FOR EACH ROW EXECUTE FUNCTION audit_events_append_only();
+
+CREATE OR REPLACE TRIGGER audit_events_no_truncate
+    BEFORE TRUNCATE ON audit_events
+    FOR EACH STATEMENT EXECUTE FUNCTION audit_events_append_only();
+
+COMMIT;
@@ -0,0 +1,27 @@
+-- Adds email digest settings and Web Push subscriptions.
+--   psql -U admin -d chatdb -f migrations/014_notifications.sql
+
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS email_settings (
+    user_id INT PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    email TEXT NOT NULL,
+    enabled BOOLEAN NOT NULL DEFAULT true,
+    mentions_only BOOLEAN NOT NULL DEFAULT false,
+    delay_minutes INT CHECK (delay_minutes > 0),
+    unsubscribe_token TEXT NOT NULL UNIQUE,
+    last_sent_at TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS push_subscriptions (
+    id SERIAL PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    endpoint TEXT NOT NULL UNIQUE,
+    p256dh TEXT NOT NULL,
+    auth TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS push_subscriptions_user_idx ON push_subscriptions (user_id);
+
+COMMIT;